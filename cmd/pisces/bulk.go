@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pisces "github.com/cryptnode-software/pisces/lib"
+)
+
+// command a subcommand of the binary, it is run instead of the server
+type command func(ctx context.Context, services *pisces.Services, args []string) error
+
+// commands are the subcommands of the binary, i.e. `pisces export -format json`
+var commands = map[string]command{
+	"import": importproducts,
+	"export": exportproducts,
+}
+
+// importproducts imports the products of a csv or json file, or of stdin when the file is
+// "-". The result is written to stdout as json so scripts can read it, the command fails when
+// any record is invalid.
+//
+//	pisces import [-format csv|json] [-dry-run] FILE
+func importproducts(ctx context.Context, services *pisces.Services, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "csv or json, taken from the extension of the file when left out")
+	dryrun := flags.Bool("dry-run", false, "only validate the products, nothing is saved")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: pisces import [-format csv|json] [-dry-run] FILE")
+	}
+
+	path := flags.Arg(0)
+
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	result, err := services.BulkService.ImportProducts(ctx, bulkformat(*format, path), r, *dryrun)
+
+	if result != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// exportproducts writes every product to stdout, or to the file of -o
+//
+//	pisces export [-format csv|json] [-o FILE]
+func exportproducts(ctx context.Context, services *pisces.Services, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "", "csv or json, taken from the extension of -o when left out and csv otherwise")
+	output := flags.String("o", "-", "the file the products are written to")
+	flags.Parse(args)
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return services.BulkService.ExportProducts(ctx, bulkformat(*format, *output), w)
+}
+
+// bulkformat returns the format that was asked for, or else the one of the extension of the
+// file, csv when neither says
+func bulkformat(format, path string) pisces.BulkFormat {
+	if format != "" {
+		return pisces.BulkFormat(strings.ToLower(format))
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return pisces.BulkFormatJSON
+	}
+
+	return pisces.BulkFormatCSV
+}
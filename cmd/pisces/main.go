@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	commons "github.com/cryptnode-software/commons/pkg"
 	pisces "github.com/cryptnode-software/pisces/lib"
@@ -14,6 +20,8 @@ import (
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	proto "go.buf.build/grpc/go/thenewlebowski/pisces/general/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	_ "github.com/go-sql-driver/mysql"
 
@@ -46,24 +54,19 @@ const (
 	envS3Bucket    string = "S3_BUCKET"
 )
 
-var (
-	exempt = map[string]bool{
-		"/pisces.Pisces/GeneratePaypalClientToken": true,
-		"/pisces.Pisces/AuthorizeOrder":            true,
-		"/pisces.Pisces/GetTotalCost":              true,
-		"/pisces.Pisces/SaveInquiry":               true,
-		"/pisces.Pisces/GetInquires":               true,
-		"/pisces.Pisces/SaveOrder":                 true,
-		"/pisces.Pisces/GetOrders":                 true,
-		"/pisces.Pisces/SaveCart":                  true,
-		"/pisces.Pisces/Login":                     true,
-		"/pisces.Pisces/StartUpload":               true,
-	}
+const (
+	//healthinterval is how often the status of the grpc health service is brought up to
+	//date with the health checks of the gateway
+	healthinterval = 10 * time.Second
 
-	admin = map[string]bool{
-		"/pisces.Pisces/GetInquires": true,
-		"/pisces.Pisces/SaveProduct": true,
-	}
+	//draindelay is how long the replica keeps accepting requests after it reported itself
+	//as not ready, so the endpoints of the service stop routing to it first
+	draindelay = 5 * time.Second
+
+	//oidcstate is the cookie that binds a single sign-on login to the browser that
+	//started it, it lives as long as the login can be completed
+	oidcstate    = "pisces_oidc_state"
+	oidcstateage = 10 * time.Minute
 )
 
 func main() {
@@ -80,6 +83,15 @@ func main() {
 
 	environment := pisces.NewEnv(commons.NewLogger(environ))
 
+	//subcommands, i.e. `pisces import products.csv`, run against the services directly
+	//and exit instead of serving
+	if run, ok := commands[flag.Arg(0)]; ok {
+		if err := run(context.Background(), services.New(environment), flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	gw, err := pisces.NewGateway(environment, services.New(environment))
 	if err != nil {
 		panic(err)
@@ -88,6 +100,21 @@ func main() {
 	logger := environment.Log
 	logger.Info("starting container...")
 
+	//the context of the replica, it is done once kubernetes (or ctrl+c) tells it to stop
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	//background jobs, i.e. expiring abandoned orders, only run on the replica
+	//that holds the scheduler lease
+	scheduler := make(chan struct{})
+	go func() {
+		defer close(scheduler)
+
+		if err := gw.RunScheduler(ctx); err != nil && ctx.Err() == nil {
+			logger.Error(err.Error())
+		}
+	}()
+
 	opts := []grpc.ServerOption{
 		grpc.UnaryInterceptor(
 			grpc_middleware.ChainUnaryServer(
@@ -103,23 +130,16 @@ func main() {
 				),
 				func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
 					logger.Info(info.FullMethod)
-					if exempt[info.FullMethod] {
-						return handler(ctx, req)
-					}
-
-					if admin[info.FullMethod] {
-						if _, err := gw.AuthenticateAdmin(ctx); err != nil {
-							return nil, err
-						}
-
-						return handler(ctx, req)
-					}
-
-					if _, err := gw.AuthenticateToken(ctx); err != nil {
-						return nil, err
-					}
 					return handler(ctx, req)
 				},
+				//every error below is turned into a grpc status with a proper code
+				gw.StatusInterceptor,
+				gw.AuthorizeInterceptor,
+				gw.AuditInterceptor,
+				//requests that break the rules of their rpc never reach the handler
+				gw.ValidateInterceptor,
+				//it comes last so replays still get a request id of their own
+				gw.IdempotencyInterceptor,
 			),
 		),
 	}
@@ -127,14 +147,145 @@ func main() {
 	grpcServer := grpc.NewServer(opts...)
 	proto.RegisterPiscesServer(grpcServer, gw)
 
+	//the standard health service, for probes and load balancers that speak grpc
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	go watchHealth(ctx, gw, healthServer)
+
+	//every rpc must have an authorization policy, refuse to start rather
+	//than serve a route nobody decided the access of
+	if err := pisces.CheckPolicies(grpcServer.GetServiceInfo()); err != nil {
+		log.Fatal(err)
+	}
+
 	server := grpcweb.WrapServer(grpcServer,
 		grpcweb.WithOriginFunc(func(str string) bool {
 			return true // change this
 		}),
 	)
 
+	mux := http.NewServeMux()
+
+	//staff single sign-on is a browser redirect flow, so it is served over
+	//plain http next to the grpc-web server
+	mux.HandleFunc("/oidc/login", func(resp http.ResponseWriter, req *http.Request) {
+		location, state, err := gw.StartOIDCLogin(req.Context())
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(resp, "single sign-on is unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		//the login can only be completed by the browser that started it
+		http.SetCookie(resp, statecookie(req, state, int(oidcstateage/time.Second)))
+
+		http.Redirect(resp, req, location, http.StatusFound)
+	})
+
+	mux.HandleFunc("/oidc/callback", func(resp http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		state := query.Get("state")
+
+		cookie, err := req.Cookie(oidcstate)
+		if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+			http.Error(resp, "invalid single sign-on state", http.StatusBadRequest)
+			return
+		}
+
+		http.SetCookie(resp, statecookie(req, "", -1))
+
+		code, err := gw.CompleteOIDCLogin(req.Context(), state, query.Get("code"))
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(resp, "single sign-on failed", http.StatusUnauthorized)
+			return
+		}
+
+		//the app is only given a one-time code, it redeems it for the JWT at
+		///oidc/token so the JWT never ends up in the history of the browser
+		if app := environment.OIDCEnv.AppURL; app != "" {
+			location, err := url.Parse(app)
+			if err != nil {
+				logger.Error(err.Error())
+				http.Error(resp, "single sign-on failed", http.StatusInternalServerError)
+				return
+			}
+
+			values := location.Query()
+			values.Set("code", code)
+			location.RawQuery = values.Encode()
+
+			http.Redirect(resp, req, location.String(), http.StatusFound)
+			return
+		}
+
+		token, err := gw.RedeemOIDCCode(req.Context(), code)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(resp, "single sign-on failed", http.StatusUnauthorized)
+			return
+		}
+
+		resp.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(resp).Encode(map[string]string{
+			"jwt": token.Jwt,
+		})
+	})
+
+	mux.HandleFunc("/oidc/token", func(resp http.ResponseWriter, req *http.Request) {
+		//the app redeems the code from its own origin
+		if environment.OIDCEnv != nil {
+			if app, err := url.Parse(environment.OIDCEnv.AppURL); err == nil && app.Host != "" {
+				resp.Header().Set("Access-Control-Allow-Origin", app.Scheme+"://"+app.Host)
+			}
+		}
+
+		if req.Method != http.MethodPost {
+			http.Error(resp, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		token, err := gw.RedeemOIDCCode(req.Context(), req.PostFormValue("code"))
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(resp, "invalid single sign-on code", http.StatusUnauthorized)
+			return
+		}
+
+		resp.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(resp).Encode(map[string]string{
+			"jwt": token.Jwt,
+		})
+	})
+
+	//liveness only tells whether the process still serves requests, it doesn't check the
+	//database so an outage of it doesn't get every replica restarted at once
+	mux.HandleFunc("/healthz", func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(resp).Encode(map[string]string{
+			"status": "ok",
+		})
+	})
+
+	//readiness tells whether requests should be routed to the replica at all
+	mux.HandleFunc("/readyz", func(resp http.ResponseWriter, req *http.Request) {
+		report := gw.Ready(req.Context())
+
+		resp.Header().Set("Content-Type", "application/json")
+		if !report.Ready {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		json.NewEncoder(resp).Encode(report)
+	})
+
 	handler := func(resp http.ResponseWriter, req *http.Request) {
-		server.ServeHTTP(resp, req)
+		if server.IsGrpcWebRequest(req) || server.IsAcceptableGrpcCorsRequest(req) || server.IsGrpcWebSocketRequest(req) {
+			server.ServeHTTP(resp, req)
+			return
+		}
+
+		mux.ServeHTTP(resp, req)
 	}
 
 	httpServer := http.Server{
@@ -142,8 +293,105 @@ func main() {
 		Handler: http.HandlerFunc(handler),
 	}
 
-	logger.Info("listening on port :4081")
-	if err := httpServer.ListenAndServe(); err != nil {
-		panic(err)
+	serving := make(chan error, 1)
+	go func() {
+		logger.Info(fmt.Sprintf("listening on port :%d", *port))
+		serving <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serving:
+		//the server stopped without being told to, i.e. the port is taken
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	//a second signal kills the replica right away
+	stop()
+
+	logger.Info("shutting down...")
+	shutdown(gw, healthServer, grpcServer, &httpServer, scheduler)
+	logger.Info("shut down")
+}
+
+//statecookie returns the cookie that holds the state of a single sign-on login, it is only
+//ever read by the callback so scripts have no access to it
+func statecookie(req *http.Request, state string, age int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcstate,
+		Value:    state,
+		Path:     "/oidc/",
+		MaxAge:   age,
+		HttpOnly: true,
+		Secure:   req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https",
+		//the issuer redirects back with a top level navigation, which lax cookies
+		//are still sent with
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+//watchHealth keeps the status of the grpc health service up to date with the health checks
+//of the gateway until the context is done
+func watchHealth(ctx context.Context, gw *pisces.Gateway, server *health.Server) {
+	ticker := time.NewTicker(healthinterval)
+	defer ticker.Stop()
+
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		if !gw.Ready(ctx).Ready {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+
+		//the empty service is the health of the server as a whole
+		server.SetServingStatus("", status)
+		server.SetServingStatus(proto.Pisces_ServiceDesc.ServiceName, status)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//shutdown stops the replica gracefully. It reports itself as not ready, stops accepting
+//requests once the service had the time to notice and gives the ones in flight, along with
+//the scheduler, until the shutdown timeout of the env to finish before the database is closed.
+func shutdown(gw *pisces.Gateway, healthServer *health.Server, grpcServer *grpc.Server, httpServer *http.Server, scheduler <-chan struct{}) {
+	logger := gw.Env.Log
+
+	gw.Drain()
+	healthServer.Shutdown()
+
+	time.Sleep(draindelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), gw.Env.ShutdownTimeout)
+	defer cancel()
+
+	//grpc-web requests are plain http requests served through the http server, it drains
+	//them. GracefulStop isn't used since grpc servers that are served over http can't be
+	//drained by grpc itself.
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.Error(fmt.Sprintf("requests were still in flight after %s: %s", gw.Env.ShutdownTimeout, err))
+		httpServer.Close()
+	}
+
+	//whatever is left, i.e. grpc-web websockets, is cut off
+	grpcServer.Stop()
+
+	select {
+	case <-scheduler:
+	case <-ctx.Done():
+		logger.Error("the scheduler didn't stop within the shutdown timeout")
+	}
+
+	db, err := gw.Env.GormDB.DB()
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	if err := db.Close(); err != nil {
+		logger.Error(err.Error())
 	}
 }
@@ -0,0 +1,20 @@
+
+-- +migrate Up
+CREATE TABLE `api_keys` (
+    `id` VARCHAR(36) NOT NULL DEFAULT (UUID()),
+    `name` VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+    `prefix` VARCHAR(36) COLLATE utf8mb4_unicode_ci NOT NULL UNIQUE,
+    `hash` VARCHAR(64) COLLATE utf8mb4_unicode_ci NOT NULL,
+    `permissions` TEXT COLLATE utf8mb4_unicode_ci,
+    `allowed_ips` TEXT COLLATE utf8mb4_unicode_ci,
+    `expires_at` TIMESTAMP NULL DEFAULT NULL,
+    `last_used_at` TIMESTAMP NULL DEFAULT NULL,
+    `created_by` VARCHAR(36),
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    `deleted_at` TIMESTAMP NULL DEFAULT NULL,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- +migrate Down
+DROP TABLE `api_keys`;
@@ -0,0 +1,14 @@
+
+-- +migrate Up
+CREATE TABLE `oidc_logins` (
+    `state` VARCHAR(64) COLLATE utf8mb4_unicode_ci NOT NULL,
+    `nonce` VARCHAR(64) COLLATE utf8mb4_unicode_ci NOT NULL,
+    `verifier` VARCHAR(64) COLLATE utf8mb4_unicode_ci NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (state),
+    INDEX (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- +migrate Down
+DROP TABLE `oidc_logins`;
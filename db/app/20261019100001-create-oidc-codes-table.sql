@@ -0,0 +1,12 @@
+-- +migrate Up
+CREATE TABLE `oidc_codes` (
+    `hash` VARCHAR(64) COLLATE utf8mb4_unicode_ci NOT NULL,
+    `email` VARCHAR(255) COLLATE utf8mb4_unicode_ci NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (hash),
+    INDEX (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- +migrate Down
+DROP TABLE `oidc_codes`;
@@ -0,0 +1,26 @@
+
+-- +migrate Up
+CREATE TABLE `audit_entries` (
+    `id` VARCHAR(36) NOT NULL DEFAULT (UUID()),
+    `actor_id` VARCHAR(36) NOT NULL,
+    `actor_type` VARCHAR(36) COLLATE utf8mb4_unicode_ci NOT NULL,
+    `actor` VARCHAR(255) COLLATE utf8mb4_unicode_ci,
+    `action` VARCHAR(36) COLLATE utf8mb4_unicode_ci NOT NULL,
+    `method` VARCHAR(255) COLLATE utf8mb4_unicode_ci,
+    `entity_type` VARCHAR(36) COLLATE utf8mb4_unicode_ci NOT NULL,
+    `entity_id` VARCHAR(36) NOT NULL,
+    `before` LONGTEXT COLLATE utf8mb4_unicode_ci,
+    `after` LONGTEXT COLLATE utf8mb4_unicode_ci,
+    `diff` LONGTEXT COLLATE utf8mb4_unicode_ci,
+    `request_id` VARCHAR(64) COLLATE utf8mb4_unicode_ci,
+    `created_at` TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (id),
+    INDEX (actor_id, created_at),
+    INDEX (entity_type, entity_id, created_at),
+    INDEX (method, created_at),
+    INDEX (request_id),
+    INDEX (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- +migrate Down
+DROP TABLE `audit_entries`;
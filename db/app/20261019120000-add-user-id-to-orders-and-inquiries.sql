@@ -0,0 +1,17 @@
+-- +migrate Up
+ALTER TABLE `inquiries`
+  ADD COLUMN `user_id` VARCHAR(36) NULL DEFAULT NULL AFTER `number`,
+  ADD INDEX inq_user_id(user_id);
+
+ALTER TABLE `orders`
+  ADD COLUMN `user_id` VARCHAR(36) NULL DEFAULT NULL AFTER `inquiry_id`,
+  ADD INDEX ord_user_id(user_id);
+
+-- +migrate Down
+ALTER TABLE `orders`
+  DROP INDEX ord_user_id,
+  DROP COLUMN `user_id`;
+
+ALTER TABLE `inquiries`
+  DROP INDEX inq_user_id,
+  DROP COLUMN `user_id`;
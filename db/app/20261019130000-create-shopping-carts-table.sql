@@ -0,0 +1,31 @@
+-- +migrate Up
+CREATE TABLE `shopping_carts` (
+    `id` VARCHAR(36) NOT NULL DEFAULT (UUID()),
+    `user_id` VARCHAR(36) NULL DEFAULT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    `deleted_at` TIMESTAMP NULL DEFAULT NULL,
+    INDEX shopping_cart_user_id(user_id),
+    INDEX shopping_cart_expires_at(expires_at),
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- a line belongs to either an order or a shopping cart
+ALTER TABLE `carts`
+    MODIFY COLUMN `order_id` VARCHAR(36) NULL DEFAULT NULL,
+    ADD COLUMN `shopping_cart_id` VARCHAR(36) NULL DEFAULT NULL AFTER `order_id`,
+    ADD INDEX cart_shopping_cart_id(shopping_cart_id),
+    ADD CONSTRAINT cart_shopping_cart_fk FOREIGN KEY (shopping_cart_id) REFERENCES shopping_carts (id) ON DELETE CASCADE;
+
+-- +migrate Down
+DELETE FROM `carts` WHERE `order_id` IS NULL;
+
+ALTER TABLE `carts` DROP FOREIGN KEY `cart_shopping_cart_fk`;
+
+ALTER TABLE `carts`
+    DROP INDEX cart_shopping_cart_id,
+    DROP COLUMN `shopping_cart_id`,
+    MODIFY COLUMN `order_id` VARCHAR(36) NOT NULL DEFAULT (UUID());
+
+DROP TABLE `shopping_carts`;
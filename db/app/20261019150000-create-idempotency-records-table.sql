@@ -0,0 +1,19 @@
+-- +migrate Up
+CREATE TABLE `idempotency_records` (
+    `key` VARCHAR(64) NOT NULL,
+    `method` VARCHAR(255) NOT NULL,
+    `fingerprint` VARCHAR(64) NOT NULL,
+    `status` VARCHAR(16) NOT NULL DEFAULT 'PENDING',
+    `response` MEDIUMBLOB NULL DEFAULT NULL,
+    `code` INT UNSIGNED NOT NULL DEFAULT 0,
+    `message` TEXT NULL DEFAULT NULL,
+    `headers` TEXT NULL DEFAULT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idempotency_record_expires_at(expires_at),
+    PRIMARY KEY (`key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- +migrate Down
+DROP TABLE `idempotency_records`;
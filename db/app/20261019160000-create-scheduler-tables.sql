@@ -0,0 +1,38 @@
+-- +migrate Up
+CREATE TABLE `scheduler_leases` (
+    `name` VARCHAR(64) NOT NULL,
+    `holder` VARCHAR(255) NOT NULL,
+    `expires_at` TIMESTAMP NOT NULL,
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE `job_runs` (
+    `id` VARCHAR(36) NOT NULL DEFAULT (UUID()),
+    `job` VARCHAR(64) NOT NULL,
+    `holder` VARCHAR(255) NOT NULL,
+    `status` VARCHAR(16) NOT NULL DEFAULT 'RUNNING',
+    `affected` BIGINT NOT NULL DEFAULT 0,
+    `error` TEXT NULL DEFAULT NULL,
+    `started_at` TIMESTAMP NOT NULL,
+    `finished_at` TIMESTAMP NULL DEFAULT NULL,
+    INDEX job_run_job_started_at(job, started_at),
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- only orders placed through checkout take their stock out of the inventory, the payment
+-- of an expired order is voided once it has been expired
+ALTER TABLE `orders`
+    ADD COLUMN `stock_reserved` BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN `void_pending` BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD INDEX order_status_created_at(status, created_at);
+
+-- +migrate Down
+ALTER TABLE `orders`
+    DROP INDEX order_status_created_at,
+    DROP COLUMN `void_pending`,
+    DROP COLUMN `stock_reserved`;
+
+DROP TABLE `job_runs`;
+
+DROP TABLE `scheduler_leases`;
@@ -0,0 +1,10 @@
+-- +migrate Up
+-- archiving is kept apart from deleting, archived orders are only left out of listings
+ALTER TABLE `orders`
+    ADD COLUMN `archived_at` TIMESTAMP NULL DEFAULT NULL,
+    ADD INDEX order_archived_at(archived_at);
+
+-- +migrate Down
+ALTER TABLE `orders`
+    DROP INDEX order_archived_at,
+    DROP COLUMN `archived_at`;
@@ -0,0 +1,10 @@
+-- +migrate Up
+-- orders are paginated by their sort column with the id breaking ties
+ALTER TABLE `orders`
+    ADD INDEX order_created_at_id(created_at, id),
+    ADD INDEX order_due_id(due, id);
+
+-- +migrate Down
+ALTER TABLE `orders`
+    DROP INDEX order_due_id,
+    DROP INDEX order_created_at_id;
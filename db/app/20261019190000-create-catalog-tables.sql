@@ -0,0 +1,74 @@
+-- +migrate Up
+CREATE TABLE `categories` (
+    `id` VARCHAR(36) NOT NULL DEFAULT (UUID()),
+    `name` VARCHAR(255) NOT NULL,
+    -- slugs are kept unique by the catalog service, deleted categories give theirs up
+    `slug` VARCHAR(255) NOT NULL,
+    `parent_id` VARCHAR(36) NULL DEFAULT NULL,
+    `position` INT NOT NULL DEFAULT 0,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    `deleted_at` TIMESTAMP NULL DEFAULT NULL,
+    INDEX category_slug(slug),
+    INDEX category_parent_id(parent_id),
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE `product_categories` (
+    `product_id` VARCHAR(36) NOT NULL,
+    `category_id` VARCHAR(36) NOT NULL,
+    INDEX product_category_category_id(category_id),
+    PRIMARY KEY (product_id, category_id),
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE `tags` (
+    `name` VARCHAR(64) NOT NULL,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE `product_tags` (
+    `product_id` VARCHAR(36) NOT NULL,
+    `tag_name` VARCHAR(64) NOT NULL,
+    INDEX product_tag_tag_name(tag_name),
+    PRIMARY KEY (product_id, tag_name),
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_name) REFERENCES tags (name) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE `collections` (
+    `id` VARCHAR(36) NOT NULL DEFAULT (UUID()),
+    `name` VARCHAR(255) NOT NULL,
+    `slug` VARCHAR(255) NOT NULL,
+    `description` TEXT NULL,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    `deleted_at` TIMESTAMP NULL DEFAULT NULL,
+    INDEX collection_slug(slug),
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE `collection_products` (
+    `collection_id` VARCHAR(36) NOT NULL,
+    `product_id` VARCHAR(36) NOT NULL,
+    `position` INT NOT NULL DEFAULT 0,
+    INDEX collection_product_position(collection_id, position),
+    PRIMARY KEY (collection_id, product_id),
+    FOREIGN KEY (collection_id) REFERENCES collections (id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- +migrate Down
+DROP TABLE `collection_products`;
+
+DROP TABLE `collections`;
+
+DROP TABLE `product_tags`;
+
+DROP TABLE `tags`;
+
+DROP TABLE `product_categories`;
+
+DROP TABLE `categories`;
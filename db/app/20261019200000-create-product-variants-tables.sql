@@ -0,0 +1,53 @@
+-- +migrate Up
+CREATE TABLE `product_options` (
+    `id` VARCHAR(36) NOT NULL DEFAULT (UUID()),
+    `product_id` VARCHAR(36) NOT NULL,
+    `name` VARCHAR(255) NOT NULL,
+    -- a json array of the values of the option, i.e. ["S", "M", "L"]
+    `option_values` JSON NOT NULL,
+    `position` INT NOT NULL DEFAULT 0,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    `deleted_at` TIMESTAMP NULL DEFAULT NULL,
+    INDEX product_option_product_id(product_id),
+    PRIMARY KEY (id),
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE `product_variants` (
+    `id` VARCHAR(36) NOT NULL DEFAULT (UUID()),
+    `product_id` VARCHAR(36) NOT NULL,
+    -- skus are kept unique by the product service, deleted variants give theirs up
+    `sku` VARCHAR(64) NOT NULL,
+    -- a json object of the value the variant has for every option, i.e. {"Size": "M"}
+    `option_values` JSON NOT NULL,
+    -- the variant sells at the cost of its product when it doesn't have one of its own
+    `cost` DECIMAL(13,2) NULL DEFAULT NULL,
+    `inventory` INT NOT NULL DEFAULT 0,
+    -- in grams
+    `weight` DECIMAL(13,2) NOT NULL DEFAULT 0,
+    `position` INT NOT NULL DEFAULT 0,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    `deleted_at` TIMESTAMP NULL DEFAULT NULL,
+    INDEX product_variant_product_id(product_id),
+    INDEX product_variant_sku(sku),
+    PRIMARY KEY (id),
+    FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- lines of products with variants reference the variant that was picked
+ALTER TABLE `carts`
+    ADD COLUMN `variant_id` VARCHAR(36) NULL DEFAULT NULL,
+    ADD INDEX cart_variant_id(variant_id),
+    ADD CONSTRAINT cart_variant_fk FOREIGN KEY (variant_id) REFERENCES product_variants (id);
+
+-- +migrate Down
+ALTER TABLE `carts` DROP FOREIGN KEY `cart_variant_fk`;
+
+ALTER TABLE `carts`
+    DROP INDEX cart_variant_id,
+    DROP COLUMN `variant_id`;
+
+DROP TABLE `product_variants`;
+DROP TABLE `product_options`;
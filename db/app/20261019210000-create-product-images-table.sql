@@ -0,0 +1,30 @@
+-- +migrate Up
+-- the key of the object an attachment was uploaded as, attachments that were made before it
+-- was kept don't have one
+ALTER TABLE `attachments`
+    ADD COLUMN `object_key` VARCHAR(1024) NULL DEFAULT NULL,
+    ADD INDEX attachment_object_key(object_key(255));
+
+CREATE TABLE `product_images` (
+    `id` VARCHAR(36) NOT NULL DEFAULT (UUID()),
+    `product_id` VARCHAR(36) NOT NULL,
+    `attachment_id` VARCHAR(36) NOT NULL,
+    `alt` VARCHAR(255) NOT NULL DEFAULT '',
+    `position` INT NOT NULL DEFAULT 0,
+    -- the media service keeps a single primary image per product
+    `is_primary` BOOLEAN NOT NULL DEFAULT FALSE,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    `deleted_at` TIMESTAMP NULL DEFAULT NULL,
+    INDEX product_image_product_id(product_id),
+    PRIMARY KEY (id),
+    CONSTRAINT product_image_product_fk FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
+    CONSTRAINT product_image_attachment_fk FOREIGN KEY (attachment_id) REFERENCES attachments (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- +migrate Down
+DROP TABLE `product_images`;
+
+ALTER TABLE `attachments`
+    DROP INDEX attachment_object_key,
+    DROP COLUMN `object_key`;
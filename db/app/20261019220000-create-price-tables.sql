@@ -0,0 +1,50 @@
+-- +migrate Up
+CREATE TABLE `scheduled_prices` (
+    `id` VARCHAR(36) NOT NULL DEFAULT (UUID()),
+    `product_id` VARCHAR(36) NOT NULL,
+    -- the price is only for the variant when set, otherwise for the product
+    `variant_id` VARCHAR(36) NULL DEFAULT NULL,
+    `price` DECIMAL(13,2) NOT NULL,
+    `starts_at` TIMESTAMP NOT NULL,
+    -- a price with an end is a sale, one without is applied as the cost once it is due
+    `ends_at` TIMESTAMP NULL DEFAULT NULL,
+    `applied_at` TIMESTAMP NULL DEFAULT NULL,
+    `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    `deleted_at` TIMESTAMP NULL DEFAULT NULL,
+    INDEX scheduled_price_product_id(product_id, starts_at),
+    INDEX scheduled_price_due(applied_at, starts_at),
+    PRIMARY KEY (id),
+    CONSTRAINT scheduled_price_product_fk FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
+    CONSTRAINT scheduled_price_variant_fk FOREIGN KEY (variant_id) REFERENCES product_variants (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- the history is kept when a variant is removed for good, only its product takes it along
+CREATE TABLE `price_changes` (
+    `id` VARCHAR(36) NOT NULL,
+    `product_id` VARCHAR(36) NOT NULL,
+    `variant_id` VARCHAR(36) NULL DEFAULT NULL,
+    -- a null price is a variant that sells at the cost of its product, or a product that was
+    -- just created
+    `before` DECIMAL(13,2) NULL DEFAULT NULL,
+    `after` DECIMAL(13,2) NULL DEFAULT NULL,
+    `scheduled_price_id` VARCHAR(36) NULL DEFAULT NULL,
+    `actor_id` VARCHAR(36) NOT NULL,
+    `actor_type` VARCHAR(36) NOT NULL,
+    `actor` VARCHAR(255) NOT NULL DEFAULT '',
+    `created_at` TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX price_change_product_id(product_id, created_at),
+    PRIMARY KEY (id),
+    CONSTRAINT price_change_product_fk FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- what a line of an order sold for when the order was placed, lines of orders placed before
+-- it was kept are priced at the current cost
+ALTER TABLE `carts`
+    ADD COLUMN `unit_price` DECIMAL(13,2) NULL DEFAULT NULL;
+
+-- +migrate Down
+ALTER TABLE `carts` DROP COLUMN `unit_price`;
+
+DROP TABLE `price_changes`;
+DROP TABLE `scheduled_prices`;
@@ -0,0 +1,57 @@
+-- +migrate Up
+-- the ledger is kept when a variant is removed for good, only its product takes it along
+CREATE TABLE `inventory_movements` (
+    `id` VARCHAR(36) NOT NULL,
+    `product_id` VARCHAR(36) NOT NULL,
+    -- the movement is of the stock of the variant when set, otherwise of the product
+    `variant_id` VARCHAR(36) NULL DEFAULT NULL,
+    `kind` VARCHAR(36) NOT NULL,
+    -- positive for stock that comes in, negative for stock that goes out
+    `quantity` INT NOT NULL,
+    `on_hand` INT NOT NULL,
+    `reason` VARCHAR(255) NOT NULL DEFAULT '',
+    `order_id` VARCHAR(36) NULL DEFAULT NULL,
+    `actor_id` VARCHAR(36) NOT NULL,
+    `actor_type` VARCHAR(36) NOT NULL,
+    `actor` VARCHAR(255) NOT NULL DEFAULT '',
+    `created_at` TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX inventory_movement_product_id(product_id, created_at),
+    INDEX inventory_movement_variant_id(variant_id),
+    PRIMARY KEY (id),
+    CONSTRAINT inventory_movement_product_fk FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- stock is moved by adding to it, which a null inventory doesn't allow
+UPDATE `products` SET `inventory` = 0 WHERE `inventory` IS NULL;
+
+ALTER TABLE `products`
+    MODIFY COLUMN `inventory` INT NOT NULL DEFAULT 0,
+    ADD COLUMN `low_stock_threshold` INT NULL DEFAULT NULL,
+    ADD COLUMN `low_stock_alerted_at` TIMESTAMP NULL DEFAULT NULL;
+
+ALTER TABLE `product_variants`
+    ADD COLUMN `low_stock_threshold` INT NULL DEFAULT NULL,
+    ADD COLUMN `low_stock_alerted_at` TIMESTAMP NULL DEFAULT NULL;
+
+-- the stock that is already there opens the ledger, so the ledger adds up to it
+INSERT INTO `inventory_movements` (`id`, `product_id`, `kind`, `quantity`, `on_hand`, `reason`, `actor_id`, `actor_type`, `actor`)
+    SELECT UUID(), `id`, 'ADJUSTMENT', `inventory`, `inventory`, 'opening balance', '00000000-0000-0000-0000-000000000000', 'SYSTEM', 'pisces'
+    FROM `products`
+    WHERE `inventory` <> 0;
+
+INSERT INTO `inventory_movements` (`id`, `product_id`, `variant_id`, `kind`, `quantity`, `on_hand`, `reason`, `actor_id`, `actor_type`, `actor`)
+    SELECT UUID(), `product_id`, `id`, 'ADJUSTMENT', `inventory`, `inventory`, 'opening balance', '00000000-0000-0000-0000-000000000000', 'SYSTEM', 'pisces'
+    FROM `product_variants`
+    WHERE `inventory` <> 0;
+
+-- +migrate Down
+ALTER TABLE `product_variants`
+    DROP COLUMN `low_stock_alerted_at`,
+    DROP COLUMN `low_stock_threshold`;
+
+ALTER TABLE `products`
+    DROP COLUMN `low_stock_alerted_at`,
+    DROP COLUMN `low_stock_threshold`,
+    MODIFY COLUMN `inventory` INT;
+
+DROP TABLE `inventory_movements`;
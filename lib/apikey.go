@@ -0,0 +1,204 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	apikeyheader    = "api-key"
+	forwardedheader = "x-forwarded-for"
+)
+
+type apikeyctx struct{}
+
+// APIKeyService represents the service that manages the api keys our server to server
+// integrations (warehouse, accounting, etc.) use instead of a user JWT
+type APIKeyService interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) (*APIKey, string, error)
+	RevokeAPIKey(ctx context.Context, key *APIKey) error
+	GetAPIKeys(ctx context.Context) ([]*APIKey, error)
+	AuthenticateAPIKey(ctx context.Context) (*APIKey, error)
+}
+
+// Permission the primitive type for every scope that can be granted to an api key
+type Permission string
+
+const (
+	//PermissionReadOrders allows an api key to read any order
+	PermissionReadOrders Permission = "orders:read"
+	//PermissionWriteOrders allows an api key to create and update any order
+	PermissionWriteOrders Permission = "orders:write"
+	//PermissionReadInquiries allows an api key to read any inquiry
+	PermissionReadInquiries Permission = "inquiries:read"
+	//PermissionWriteInquiries allows an api key to create and update inquiries
+	PermissionWriteInquiries Permission = "inquiries:write"
+	//PermissionReadProducts allows an api key to read products
+	PermissionReadProducts Permission = "products:read"
+	//PermissionWriteProducts allows an api key to create and update products
+	PermissionWriteProducts Permission = "products:write"
+	//PermissionWriteCarts allows an api key to save the cart of an order
+	PermissionWriteCarts Permission = "carts:write"
+	//PermissionWriteUploads allows an api key to start an upload
+	PermissionWriteUploads Permission = "uploads:write"
+	//PermissionReadInventory allows an api key to read the inventory ledger
+	PermissionReadInventory Permission = "inventory:read"
+	//PermissionWriteInventory allows an api key to adjust and reconcile stock, and to set
+	//low stock thresholds
+	PermissionWriteInventory Permission = "inventory:write"
+)
+
+// Valid returns whether or not the permission is one that can be granted to an api key
+func (permission Permission) Valid() bool {
+	switch permission {
+	case PermissionReadOrders, PermissionWriteOrders, PermissionReadInquiries, PermissionWriteInquiries,
+		PermissionReadProducts, PermissionWriteProducts, PermissionWriteCarts, PermissionWriteUploads,
+		PermissionReadInventory, PermissionWriteInventory:
+		return true
+	}
+	return false
+}
+
+// APIKey represents a credential that is issued by an admin for a server to server
+// integration. Only the hash of the secret is ever stored, the prefix is used to look
+// the key up without having to scan every hash.
+type APIKey struct {
+	Name        string       `json:"name" gorm:"not null"`
+	Prefix      string       `json:"prefix" gorm:"not null"`
+	Hash        string       `json:"-" gorm:"not null"`
+	Permissions []Permission `json:"permissions" gorm:"serializer:json"`
+	//AllowedIPs is an optional list of CIDR ranges that the key can be used
+	//from, if it is empty the key can be used from anywhere. The ip of a caller
+	//behind a proxy is only known when the proxy is in TRUSTED_PROXIES.
+	AllowedIPs []string   `json:"allowed_ips" gorm:"serializer:json"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedBy  uuid.UUID  `json:"created_by"`
+	commons.Model
+}
+
+// HasPermission returns whether or not the key has been granted the provided permission
+func (key *APIKey) HasPermission(permission Permission) bool {
+	for _, p := range key.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired returns whether or not the key is expired at the provided time, keys without an
+// expiration never expire.
+func (key *APIKey) Expired(now time.Time) bool {
+	return key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)
+}
+
+// AllowsIP returns whether or not the key can be used from the provided ip. A key without
+// any allowed ranges can be used from any ip.
+func (key *APIKey) AllowsIP(ip net.IP) bool {
+	if len(key.AllowedIPs) == 0 {
+		return true
+	}
+
+	if ip == nil {
+		return false
+	}
+
+	for _, allowed := range key.AllowedIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if addr := net.ParseIP(allowed); addr != nil && addr.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the ip of the caller. It is the peer of the connection unless the peer is
+// one of the trusted proxies, then X-Forwarded-For is walked from the right for the first hop
+// that isn't trusted. Hops left of it can be forged by the caller so they're never read.
+func ClientIP(ctx context.Context, trusted []*net.IPNet) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !istrusted(ip, trusted) {
+		return ip
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var hops []string
+	for _, value := range md.Get(forwardedheader) {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return nil
+		}
+
+		ip = hop
+		if !istrusted(hop, trusted) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func istrusted(ip net.IP, trusted []*net.IPNet) bool {
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAPIKeyFromContext gets the api key that was provided through the `api-key` header. It
+// is read alongside the `auth` header, which is reserved for user JWTs.
+func GetAPIKeyFromContext(ctx context.Context) (string, error) {
+	return getHeaderFromContext(ctx, apikeyheader)
+}
+
+// SetAPIKeyContext sets the `api-key` header on the incoming context, mostly used in our tests
+func SetAPIKeyContext(ctx context.Context, key string) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	md.Set(apikeyheader, key)
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+// WithAPIKey stores an authenticated api key on the context so handlers further down the
+// chain can authorize the request without authenticating it again
+func WithAPIKey(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, apikeyctx{}, key)
+}
+
+// APIKeyFromContext returns the authenticated api key that was stored with WithAPIKey
+func APIKeyFromContext(ctx context.Context) (*APIKey, bool) {
+	key, ok := ctx.Value(apikeyctx{}).(*APIKey)
+	return key, ok && key != nil
+}
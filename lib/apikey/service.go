@@ -0,0 +1,225 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	prefixlength = 6
+	secretlength = 32
+	separator    = "."
+)
+
+//Service the api key service, handles the creation, revocation and authentication
+//of the api keys that are used by our server to server integrations
+type Service struct {
+	*lib.Env
+	repo repoi
+}
+
+//NewService returns a new api key service that satisfies the lib.APIKeyService interface
+func NewService(env *lib.Env) (lib.APIKeyService, error) {
+	return &Service{
+		env,
+		&repo{
+			env.GormDB,
+		},
+	}, nil
+}
+
+//CreateAPIKey generates a new prefix and secret for the provided key and stores the hash
+//of the secret. The full key (prefix + secret) is only ever returned here, it can't be
+//recovered afterwards.
+func (s *Service) CreateAPIKey(ctx context.Context, key *lib.APIKey) (*lib.APIKey, string, error) {
+	if key.Name == "" {
+		return nil, "", errors.ErrNoAPIKeyName
+	}
+
+	for _, permission := range key.Permissions {
+		if !permission.Valid() {
+			return nil, "", &errors.ErrUnknownPermission{
+				Permission: string(permission),
+			}
+		}
+	}
+
+	for _, allowed := range key.AllowedIPs {
+		_, _, err := net.ParseCIDR(allowed)
+		if err != nil && net.ParseIP(allowed) == nil {
+			return nil, "", &errors.ErrInvalidAllowedIP{
+				Value: allowed,
+			}
+		}
+	}
+
+	prefix, err := random(prefixlength)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := random(secretlength)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key.Prefix = hex.EncodeToString(prefix)
+	key.Hash = hash(base64.RawURLEncoding.EncodeToString(secret))
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+		if key, err = repo.CreateAPIKey(ctx, key); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityAPIKey, key.ID, nil, key)
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key, key.Prefix + separator + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+//RevokeAPIKey revokes the provided key, any request using it afterwards will be rejected
+func (s *Service) RevokeAPIKey(ctx context.Context, key *lib.APIKey) error {
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.DeleteAPIKey(ctx, key); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionDelete, lib.AuditEntityAPIKey, key.ID, key, nil)
+	})
+}
+
+//GetAPIKeys returns every api key that hasn't been revoked
+func (s *Service) GetAPIKeys(ctx context.Context) ([]*lib.APIKey, error) {
+	return s.repo.GetAPIKeys(ctx)
+}
+
+//AuthenticateAPIKey authenticates the api key provided through the `api-key` header. The key
+//must exist, match its stored hash, not be expired and be used from one of its allowed ip
+//ranges. On success the last used timestamp of the key is updated.
+func (s *Service) AuthenticateAPIKey(ctx context.Context) (*lib.APIKey, error) {
+	token, err := lib.GetAPIKeyFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, secret, ok := strings.Cut(token, separator)
+	if !ok || prefix == "" || secret == "" {
+		return nil, errors.ErrInvalidAPIKey
+	}
+
+	key, err := s.repo.GetAPIKey(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash(secret))) != 1 {
+		return nil, errors.ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+
+	if key.Expired(now) {
+		return nil, errors.ErrAPIKeyExpired
+	}
+
+	ip := lib.ClientIP(ctx, s.TrustedProxies)
+	if !key.AllowsIP(ip) {
+		return nil, &errors.ErrAPIKeyIPNotAllowed{
+			IP: ip.String(),
+		}
+	}
+
+	if err := s.repo.TouchAPIKey(ctx, key, now); err != nil {
+		s.Log.Error(err.Error())
+	}
+
+	return key, nil
+}
+
+func random(length int) ([]byte, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+//hash the secret is random and long enough that a single sha256 is sufficient, a slow
+//password hash would only add latency to every request that uses a key
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type repoi interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error
+	CreateAPIKey(ctx context.Context, key *lib.APIKey) (*lib.APIKey, error)
+	GetAPIKey(ctx context.Context, prefix string) (*lib.APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]*lib.APIKey, error)
+	TouchAPIKey(ctx context.Context, key *lib.APIKey, now time.Time) error
+	DeleteAPIKey(ctx context.Context, key *lib.APIKey) error
+}
+
+type repo struct {
+	*gorm.DB
+}
+
+//Transaction runs the provided function with a repo that is bound to a single transaction,
+//the transaction is rolled back if the function returns an error. The context the function is
+//given is bound to the transaction as well, so the mutations are audited within it.
+func (r *repo) Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(lib.WithAuditTx(ctx, tx), &repo{tx})
+	})
+}
+
+func (r *repo) CreateAPIKey(ctx context.Context, key *lib.APIKey) (*lib.APIKey, error) {
+	err := r.DB.Create(key).Error
+	return key, err
+}
+
+func (r *repo) GetAPIKey(ctx context.Context, prefix string) (*lib.APIKey, error) {
+	key := new(lib.APIKey)
+
+	err := r.DB.First(key, "prefix = ?", prefix).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrInvalidAPIKey
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (r *repo) GetAPIKeys(ctx context.Context) (keys []*lib.APIKey, err error) {
+	keys = make([]*lib.APIKey, 0)
+	err = r.DB.Order("created_at DESC").Find(&keys).Error
+	return
+}
+
+func (r *repo) TouchAPIKey(ctx context.Context, key *lib.APIKey, now time.Time) error {
+	key.LastUsedAt = &now
+	return r.DB.Model(key).UpdateColumn("last_used_at", now).Error
+}
+
+func (r *repo) DeleteAPIKey(ctx context.Context, key *lib.APIKey) error {
+	return r.DB.Delete(key).Error
+}
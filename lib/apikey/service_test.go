@@ -0,0 +1,210 @@
+package apikey_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/apikey"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/peer"
+)
+
+var (
+	env = lib.NewEnv(commons.NewLogger(commons.EnvDev))
+
+	service, err = apikey.NewService(env)
+
+	ctx = context.Background()
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tables := []struct {
+		key  *lib.APIKey
+		fail bool
+	}{
+		{
+			key:  &lib.APIKey{},
+			fail: true,
+		},
+		{
+			key: &lib.APIKey{
+				Name:       "warehouse",
+				AllowedIPs: []string{"not an ip"},
+			},
+			fail: true,
+		},
+		{
+			key: &lib.APIKey{
+				Name:        "warehouse",
+				Permissions: []lib.Permission{lib.PermissionReadOrders, "orders:delete"},
+			},
+			fail: true,
+		},
+		{
+			key: &lib.APIKey{
+				Name:        "warehouse",
+				Permissions: []lib.Permission{lib.PermissionReadOrders},
+				AllowedIPs:  []string{"10.0.0.0/8"},
+			},
+			fail: false,
+		},
+	}
+
+	for _, table := range tables {
+		key, secret, err := service.CreateAPIKey(ctx, table.key)
+
+		if table.fail && err == nil {
+			t.Error("create api key was suppose to fail but didn't")
+			return
+		}
+
+		if table.fail {
+			continue
+		}
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if secret == "" || key.Prefix == "" || key.Hash == "" {
+			t.Error("api key was created without a prefix, hash or secret")
+		}
+
+		assert.NotContains(t, key.Hash, secret)
+
+		if err := deseed([]*lib.APIKey{key}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	past := time.Now().Add(-time.Hour)
+
+	tables := []struct {
+		key     *lib.APIKey
+		ip      string
+		tamper  bool
+		revoked bool
+		fail    bool
+	}{
+		{
+			key: &lib.APIKey{
+				Name: "accounting",
+			},
+			ip:   "192.168.1.1",
+			fail: false,
+		},
+		{
+			key: &lib.APIKey{
+				Name: "accounting",
+			},
+			ip:     "192.168.1.1",
+			tamper: true,
+			fail:   true,
+		},
+		{
+			key: &lib.APIKey{
+				Name: "accounting",
+			},
+			ip:      "192.168.1.1",
+			revoked: true,
+			fail:    true,
+		},
+		{
+			key: &lib.APIKey{
+				Name:      "accounting",
+				ExpiresAt: &past,
+			},
+			ip:   "192.168.1.1",
+			fail: true,
+		},
+		{
+			key: &lib.APIKey{
+				Name:       "warehouse",
+				AllowedIPs: []string{"10.0.0.0/8"},
+			},
+			ip:   "10.1.2.3",
+			fail: false,
+		},
+		{
+			key: &lib.APIKey{
+				Name:       "warehouse",
+				AllowedIPs: []string{"10.0.0.0/8"},
+			},
+			ip:   "192.168.1.1",
+			fail: true,
+		},
+	}
+
+	for _, table := range tables {
+		key, secret, err := service.CreateAPIKey(ctx, table.key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if table.tamper {
+			secret += "x"
+		}
+
+		if table.revoked {
+			if err := service.RevokeAPIKey(ctx, key); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		ctx := peer.NewContext(lib.SetAPIKeyContext(ctx, secret), &peer.Peer{
+			Addr: &net.TCPAddr{
+				IP:   net.ParseIP(table.ip),
+				Port: 4081,
+			},
+		})
+
+		authenticated, err := service.AuthenticateAPIKey(ctx)
+
+		if table.fail && err == nil {
+			t.Error("authenticate api key was suppose to fail but didn't")
+		}
+
+		if !table.fail && err != nil {
+			t.Error(err)
+		}
+
+		if !table.fail && err == nil {
+			assert.Equal(t, key.ID, authenticated.ID)
+			assert.NotNil(t, authenticated.LastUsedAt)
+		}
+
+		if err := deseed([]*lib.APIKey{key}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+func deseed(keys []*lib.APIKey) error {
+	for _, key := range keys {
+		if err := env.GormDB.Unscoped().Delete(key).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := NewTrustedProxies("10.0.0.0/8, 192.168.1.10")
+
+	tables := []struct {
+		peer      string
+		forwarded []string
+		ip        string
+	}{
+		{peer: "203.0.113.7", ip: "203.0.113.7"},
+		//only a trusted proxy is believed about who it is forwarding for
+		{peer: "203.0.113.7", forwarded: []string{"198.51.100.1"}, ip: "203.0.113.7"},
+		{peer: "10.0.0.5", forwarded: []string{"198.51.100.1"}, ip: "198.51.100.1"},
+		{peer: "10.0.0.5", forwarded: []string{"198.51.100.1, 192.168.1.10"}, ip: "198.51.100.1"},
+		{peer: "10.0.0.5", forwarded: []string{"198.51.100.1", "192.168.1.10"}, ip: "198.51.100.1"},
+		//hops left of the first one that isn't trusted are whatever the caller sent
+		{peer: "10.0.0.5", forwarded: []string{"1.1.1.1, 198.51.100.1"}, ip: "198.51.100.1"},
+		{peer: "10.0.0.5", forwarded: []string{"10.0.0.9"}, ip: "10.0.0.9"},
+		{peer: "10.0.0.5", ip: "10.0.0.5"},
+		{peer: "10.0.0.5", forwarded: []string{"unknown"}},
+	}
+
+	for _, table := range tables {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{
+				IP:   net.ParseIP(table.peer),
+				Port: 4081,
+			},
+		})
+
+		if table.forwarded != nil {
+			ctx = metadata.NewIncomingContext(ctx, metadata.MD{forwardedheader: table.forwarded})
+		}
+
+		ip := ClientIP(ctx, trusted)
+		if table.ip == "" {
+			assert.Nil(t, ip, table.forwarded)
+			continue
+		}
+
+		assert.True(t, net.ParseIP(table.ip).Equal(ip), "expected %s got %s", table.ip, ip)
+	}
+
+	assert.Nil(t, ClientIP(context.Background(), trusted))
+}
@@ -13,5 +13,8 @@ const (
 type Attachment struct {
 	Type AttachmentType
 	URL  string
+	//ObjectKey is the key of the object in the bucket, attachments made before it was kept
+	//don't have one
+	ObjectKey string
 	commons.Model
 }
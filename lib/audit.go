@@ -0,0 +1,339 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type auditctx struct{}
+
+type audittx struct{}
+
+// AuditService represents the append-only audit log of every mutation made through pisces
+type AuditService interface {
+	Record(ctx context.Context, entry *AuditEntry) error
+	GetAuditEntries(ctx context.Context, opts ...WithAuditOptions) ([]*AuditEntry, int64, error)
+}
+
+// AuditAction the primitive type for every action that is recorded in the audit log
+type AuditAction string
+
+const (
+	//AuditActionCreate is recorded when an entity is created
+	AuditActionCreate AuditAction = "CREATE"
+	//AuditActionUpdate is recorded when an entity is updated
+	AuditActionUpdate AuditAction = "UPDATE"
+	//AuditActionStatusChange is recorded when an update changes the status of an order
+	AuditActionStatusChange AuditAction = "STATUS_CHANGE"
+	//AuditActionDelete is recorded when an entity is soft deleted
+	AuditActionDelete AuditAction = "DELETE"
+	//AuditActionHardDelete is recorded when an entity is removed for good
+	AuditActionHardDelete AuditAction = "HARD_DELETE"
+	//AuditActionRestore is recorded when a soft deleted entity is taken out of the trash
+	AuditActionRestore AuditAction = "RESTORE"
+	//AuditActionArchive is recorded when an order is archived
+	AuditActionArchive AuditAction = "ARCHIVE"
+	//AuditActionUnarchive is recorded when an order is taken out of the archive
+	AuditActionUnarchive AuditAction = "UNARCHIVE"
+)
+
+// AuditEntity the primitive type for every kind of entity that is recorded in the audit log
+type AuditEntity string
+
+const (
+	AuditEntityProduct        AuditEntity = "PRODUCT"
+	AuditEntityProductOption  AuditEntity = "PRODUCT_OPTION"
+	AuditEntityProductVariant AuditEntity = "PRODUCT_VARIANT"
+	AuditEntityProductImage   AuditEntity = "PRODUCT_IMAGE"
+	AuditEntityOrder          AuditEntity = "ORDER"
+	AuditEntityInquiry        AuditEntity = "INQUIRY"
+	AuditEntityCart           AuditEntity = "CART"
+	AuditEntityUser           AuditEntity = "USER"
+	AuditEntityAPIKey         AuditEntity = "API_KEY"
+	AuditEntityCategory       AuditEntity = "CATEGORY"
+	AuditEntityCollection     AuditEntity = "COLLECTION"
+	AuditEntityScheduledPrice AuditEntity = "SCHEDULED_PRICE"
+)
+
+// AuditActorType the primitive type for who made the mutation
+type AuditActorType string
+
+const (
+	//AuditActorAnonymous is used for mutations made on public routes without any credentials
+	AuditActorAnonymous AuditActorType = "ANONYMOUS"
+	//AuditActorUser is used for mutations made with a user JWT
+	AuditActorUser AuditActorType = "USER"
+	//AuditActorAPIKey is used for mutations made with an api key
+	AuditActorAPIKey AuditActorType = "API_KEY"
+	//AuditActorSystem is used for mutations pisces makes on its own, i.e. background jobs
+	AuditActorSystem AuditActorType = "SYSTEM"
+)
+
+// AuditEntry a single record of the audit log. Entries are never updated or deleted so they
+// don't carry the usual updated/deleted timestamps.
+type AuditEntry struct {
+	ID         uuid.UUID      `json:"id" gorm:"type:varchar(36);primaryKey"`
+	ActorID    uuid.UUID      `json:"actor_id"`
+	ActorType  AuditActorType `json:"actor_type"`
+	Actor      string         `json:"actor"`
+	Action     AuditAction    `json:"action"`
+	Method     string         `json:"method"`
+	EntityType AuditEntity    `json:"entity_type"`
+	EntityID   uuid.UUID      `json:"entity_id"`
+	//Before, After and Diff are json documents, Diff only holds the fields
+	//that changed as {"field": {"before": ..., "after": ...}}
+	Before    string    `json:"before"`
+	After     string    `json:"after"`
+	Diff      string    `json:"diff"`
+	RequestID string    `json:"request_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditContext holds who made a request and how, it is attached to the context by the
+// audit interceptor so the services can record it with every mutation
+type AuditContext struct {
+	ActorID   uuid.UUID
+	ActorType AuditActorType
+	Actor     string
+	Method    string
+	RequestID string
+}
+
+// WithAuditContext attaches the provided audit context to the context
+func WithAuditContext(ctx context.Context, audit *AuditContext) context.Context {
+	return context.WithValue(ctx, auditctx{}, audit)
+}
+
+// AuditContextFromContext returns the audit context attached to the context, if there isn't
+// one the request is considered to be made by pisces itself
+func AuditContextFromContext(ctx context.Context) *AuditContext {
+	if audit, ok := ctx.Value(auditctx{}).(*AuditContext); ok && audit != nil {
+		return audit
+	}
+
+	return &AuditContext{
+		ActorType: AuditActorSystem,
+		Actor:     pisces.Username,
+	}
+}
+
+// WithAuditTx binds the transaction of a mutation to the context, the entries recorded with the
+// context are written within it so they are committed, or rolled back, along with the mutation
+func WithAuditTx(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, audittx{}, tx)
+}
+
+// AuditTxFromContext returns the transaction bound to the context with WithAuditTx, if any
+func AuditTxFromContext(ctx context.Context) (*gorm.DB, bool) {
+	tx, ok := ctx.Value(audittx{}).(*gorm.DB)
+	return tx, ok && tx != nil
+}
+
+// NewAuditEntry creates a new audit entry for the provided entity. The actor, method and
+// request id are taken from the context while before and after (either can be nil) are
+// stored as json along with the diff between them.
+func NewAuditEntry(ctx context.Context, action AuditAction, entity AuditEntity, id uuid.UUID, before, after interface{}) (*AuditEntry, error) {
+	audit := AuditContextFromContext(ctx)
+
+	entry := &AuditEntry{
+		ID:         uuid.New(),
+		ActorID:    audit.ActorID,
+		ActorType:  audit.ActorType,
+		Actor:      audit.Actor,
+		Action:     action,
+		Method:     audit.Method,
+		EntityType: entity,
+		EntityID:   id,
+		RequestID:  audit.RequestID,
+		CreatedAt:  time.Now(),
+	}
+
+	b, err := auditdocument(before)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := auditdocument(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := make(map[string]interface{})
+
+	for key, value := range a {
+		if previous, ok := b[key]; !ok || !reflect.DeepEqual(previous, value) {
+			diff[key] = map[string]interface{}{
+				"before": b[key],
+				"after":  value,
+			}
+		}
+	}
+
+	for key, value := range b {
+		if _, ok := a[key]; !ok {
+			diff[key] = map[string]interface{}{
+				"before": value,
+				"after":  nil,
+			}
+		}
+	}
+
+	if entry.Before, err = auditjson(before); err != nil {
+		return nil, err
+	}
+
+	if entry.After, err = auditjson(after); err != nil {
+		return nil, err
+	}
+
+	if entry.Diff, err = auditjson(diff); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Audit records a mutation with the audit service of the env. It is called within the
+// transaction of the mutation, with a context bound to it (see WithAuditTx), so a mutation is
+// never committed without its entry and an entry that can't be recorded fails the mutation.
+// When no audit service is configured (i.e. in our service tests) nothing is recorded.
+func (env *Env) Audit(ctx context.Context, action AuditAction, entity AuditEntity, id uuid.UUID, before, after interface{}) error {
+	if env.AuditService == nil {
+		return nil
+	}
+
+	entry, err := NewAuditEntry(ctx, action, entity, id, before, after)
+	if err != nil {
+		return err
+	}
+
+	return env.AuditService.Record(ctx, entry)
+}
+
+func auditdocument(value interface{}) (document map[string]interface{}, err error) {
+	document = make(map[string]interface{})
+
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil()) {
+		return
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	//values that don't marshal into an object (i.e. a cart) are kept under
+	//a single key so they still show up in the diff
+	if err = json.Unmarshal(raw, &document); err != nil {
+		document = map[string]interface{}{}
+		var scalar interface{}
+		if err = json.Unmarshal(raw, &scalar); err != nil {
+			return nil, err
+		}
+		document["value"] = scalar
+	}
+
+	return document, nil
+}
+
+func auditjson(value interface{}) (string, error) {
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil()) {
+		return "", nil
+	}
+
+	raw, err := json.Marshal(value)
+	return string(raw), err
+}
+
+// AuditOption the conditions that the audit log can be filtered and paginated by
+type AuditOption struct {
+	ActorID    *uuid.UUID
+	Action     *AuditAction
+	Method     *string
+	EntityType *AuditEntity
+	EntityID   *uuid.UUID
+	RequestID  *string
+	From       *time.Time
+	To         *time.Time
+	Limit      int
+	Offset     int
+}
+
+type WithAuditOptions func(o *AuditOption) error
+
+func WithAuditActor(id uuid.UUID) WithAuditOptions {
+	return func(o *AuditOption) error {
+		if id == uuid.Nil {
+			return nil
+		}
+		o.ActorID = &id
+		return nil
+	}
+}
+
+func WithAuditAction(action AuditAction) WithAuditOptions {
+	return func(o *AuditOption) error {
+		if action == "" {
+			return nil
+		}
+		o.Action = &action
+		return nil
+	}
+}
+
+func WithAuditMethod(method string) WithAuditOptions {
+	return func(o *AuditOption) error {
+		if method == "" {
+			return nil
+		}
+		o.Method = &method
+		return nil
+	}
+}
+
+func WithAuditEntity(entity AuditEntity, id uuid.UUID) WithAuditOptions {
+	return func(o *AuditOption) error {
+		if entity != "" {
+			o.EntityType = &entity
+		}
+		if id != uuid.Nil {
+			o.EntityID = &id
+		}
+		return nil
+	}
+}
+
+func WithAuditRequestID(id string) WithAuditOptions {
+	return func(o *AuditOption) error {
+		if id == "" {
+			return nil
+		}
+		o.RequestID = &id
+		return nil
+	}
+}
+
+func WithAuditRange(from, to time.Time) WithAuditOptions {
+	return func(o *AuditOption) error {
+		if !from.IsZero() {
+			o.From = &from
+		}
+		if !to.IsZero() {
+			o.To = &to
+		}
+		return nil
+	}
+}
+
+func WithAuditPage(limit, offset int) WithAuditOptions {
+	return func(o *AuditOption) error {
+		o.Limit = limit
+		o.Offset = offset
+		return nil
+	}
+}
@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultlimit = 50
+	maxlimit     = 500
+)
+
+//Service the audit service, appends entries to the audit log and lets admins
+//query them. Entries can never be updated or deleted through it.
+type Service struct {
+	*lib.Env
+	repo repoi
+}
+
+//NewService returns a new audit service that satisfies the lib.AuditService interface
+func NewService(env *lib.Env) (lib.AuditService, error) {
+	return &Service{
+		env,
+		&repo{
+			env.GormDB,
+		},
+	}, nil
+}
+
+//Record appends the provided entry to the audit log, within the transaction bound to the
+//context when there is one
+func (s *Service) Record(ctx context.Context, entry *lib.AuditEntry) error {
+	return s.repo.CreateEntry(ctx, entry)
+}
+
+//GetAuditEntries returns the entries that match the provided options, newest first,
+//along with the total number of entries that match them regardless of the page
+func (s *Service) GetAuditEntries(ctx context.Context, opts ...lib.WithAuditOptions) ([]*lib.AuditEntry, int64, error) {
+	options := new(lib.AuditOption)
+	for _, opt := range opts {
+		if err := opt(options); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if options.Limit < 0 || options.Offset < 0 {
+		return nil, 0, &errors.ErrInvalidAuditPage{
+			Limit:  options.Limit,
+			Offset: options.Offset,
+		}
+	}
+
+	if options.Limit == 0 {
+		options.Limit = defaultlimit
+	}
+
+	if options.Limit > maxlimit {
+		options.Limit = maxlimit
+	}
+
+	return s.repo.GetEntries(ctx, options)
+}
+
+type repoi interface {
+	CreateEntry(ctx context.Context, entry *lib.AuditEntry) error
+	GetEntries(ctx context.Context, options *lib.AuditOption) ([]*lib.AuditEntry, int64, error)
+}
+
+type repo struct {
+	*gorm.DB
+}
+
+func (r *repo) CreateEntry(ctx context.Context, entry *lib.AuditEntry) error {
+	db := r.DB
+	if tx, ok := lib.AuditTxFromContext(ctx); ok {
+		db = tx
+	}
+	return db.WithContext(ctx).Create(entry).Error
+}
+
+func (r *repo) GetEntries(ctx context.Context, options *lib.AuditOption) (entries []*lib.AuditEntry, total int64, err error) {
+	tx := r.DB.WithContext(ctx).Model(new(lib.AuditEntry))
+
+	if options.ActorID != nil {
+		tx = tx.Where("actor_id = ?", options.ActorID)
+	}
+
+	if options.Action != nil {
+		tx = tx.Where("action = ?", options.Action)
+	}
+
+	if options.Method != nil {
+		tx = tx.Where("method = ?", options.Method)
+	}
+
+	if options.EntityType != nil {
+		tx = tx.Where("entity_type = ?", options.EntityType)
+	}
+
+	if options.EntityID != nil {
+		tx = tx.Where("entity_id = ?", options.EntityID)
+	}
+
+	if options.RequestID != nil {
+		tx = tx.Where("request_id = ?", options.RequestID)
+	}
+
+	if options.From != nil {
+		tx = tx.Where("created_at >= ?", options.From)
+	}
+
+	if options.To != nil {
+		tx = tx.Where("created_at < ?", options.To)
+	}
+
+	//the filters are shared by the count and the page
+	tx = tx.Session(&gorm.Session{})
+
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	entries = make([]*lib.AuditEntry, 0)
+
+	err = tx.Order("created_at DESC").
+		Order("id DESC").
+		Limit(options.Limit).
+		Offset(options.Offset).
+		Find(&entries).Error
+
+	return
+}
@@ -0,0 +1,167 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/audit"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var (
+	env = lib.NewEnv(commons.NewLogger(commons.EnvDev))
+
+	service, err = audit.NewService(env)
+
+	actor = uuid.New()
+
+	ctx = lib.WithAuditContext(context.Background(), &lib.AuditContext{
+		ActorType: lib.AuditActorUser,
+		ActorID:   actor,
+		Actor:     "testuser",
+		Method:    "/pisces.Pisces/SaveProduct",
+		RequestID: uuid.New().String(),
+	})
+)
+
+func TestGetAuditEntries(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product := uuid.New()
+
+	entries := []*lib.AuditEntry{}
+
+	for _, action := range []lib.AuditAction{lib.AuditActionCreate, lib.AuditActionUpdate, lib.AuditActionHardDelete} {
+		entry, err := lib.NewAuditEntry(ctx, action, lib.AuditEntityProduct, product, nil, &lib.Product{
+			Name: "A dozen cookies",
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := service.Record(ctx, entry); err != nil {
+			t.Error(err)
+			return
+		}
+
+		entries = append(entries, entry)
+	}
+	defer deseed(entries)
+
+	tables := []struct {
+		opts     []lib.WithAuditOptions
+		expected int
+		total    int64
+		fail     bool
+	}{
+		{
+			opts: []lib.WithAuditOptions{
+				lib.WithAuditEntity(lib.AuditEntityProduct, product),
+			},
+			expected: 3,
+			total:    3,
+		},
+		{
+			opts: []lib.WithAuditOptions{
+				lib.WithAuditEntity(lib.AuditEntityProduct, product),
+				lib.WithAuditAction(lib.AuditActionUpdate),
+			},
+			expected: 1,
+			total:    1,
+		},
+		{
+			opts: []lib.WithAuditOptions{
+				lib.WithAuditActor(actor),
+				lib.WithAuditPage(2, 0),
+			},
+			expected: 2,
+			total:    3,
+		},
+		{
+			opts: []lib.WithAuditOptions{
+				lib.WithAuditActor(actor),
+				lib.WithAuditRange(time.Now().Add(time.Hour), time.Time{}),
+			},
+			expected: 0,
+			total:    0,
+		},
+		{
+			opts: []lib.WithAuditOptions{
+				lib.WithAuditPage(-1, 0),
+			},
+			fail: true,
+		},
+	}
+
+	for _, table := range tables {
+		result, total, err := service.GetAuditEntries(ctx, table.opts...)
+
+		if table.fail {
+			if err == nil {
+				t.Error("get audit entries was suppose to fail but didn't")
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		assert.Equal(t, table.expected, len(result))
+		assert.Equal(t, table.total, total)
+
+		for _, entry := range result {
+			assert.Equal(t, actor, entry.ActorID)
+		}
+	}
+}
+
+func TestRecordTransaction(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product := uuid.New()
+	rollback := errors.New("rollback")
+
+	//an entry recorded within a transaction is rolled back along with it
+	err := env.GormDB.Transaction(func(tx *gorm.DB) error {
+		entry, err := lib.NewAuditEntry(ctx, lib.AuditActionCreate, lib.AuditEntityProduct, product, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		if err := service.Record(lib.WithAuditTx(ctx, tx), entry); err != nil {
+			return err
+		}
+
+		return rollback
+	})
+	assert.Equal(t, rollback, err)
+
+	_, total, err := service.GetAuditEntries(ctx, lib.WithAuditEntity(lib.AuditEntityProduct, product))
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(0), total)
+	}
+}
+
+func deseed(entries []*lib.AuditEntry) error {
+	for _, entry := range entries {
+		if err := env.GormDB.Delete(entry).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAuditEntry(t *testing.T) {
+	actor := uuid.New()
+
+	tables := []struct {
+		ctx      context.Context
+		action   AuditAction
+		before   *Product
+		after    *Product
+		expected map[string]interface{}
+		actor    AuditActorType
+	}{
+		{
+			ctx: WithAuditContext(context.Background(), &AuditContext{
+				ActorType: AuditActorUser,
+				ActorID:   actor,
+				Actor:     "admin",
+				Method:    "/pisces.Pisces/SaveProduct",
+				RequestID: "request",
+			}),
+			action: AuditActionUpdate,
+			before: &Product{
+				Name: "A dozen cookies",
+				Cost: 40,
+			},
+			after: &Product{
+				Name: "A dozen cookies",
+				Cost: 45,
+			},
+			expected: map[string]interface{}{
+				"Cost": map[string]interface{}{
+					"before": float64(40),
+					"after":  float64(45),
+				},
+			},
+			actor: AuditActorUser,
+		},
+		{
+			ctx:    context.Background(),
+			action: AuditActionCreate,
+			after: &Product{
+				Name: "A dozen cookies",
+			},
+			expected: map[string]interface{}{
+				"Name": map[string]interface{}{
+					"before": nil,
+					"after":  "A dozen cookies",
+				},
+			},
+			actor: AuditActorSystem,
+		},
+	}
+
+	for _, table := range tables {
+		entry, err := NewAuditEntry(table.ctx, table.action, AuditEntityProduct, uuid.Nil, table.before, table.after)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		assert.Equal(t, table.action, entry.Action)
+		assert.Equal(t, table.actor, entry.ActorType)
+		assert.NotEqual(t, uuid.Nil, entry.ID)
+
+		if table.before == nil {
+			assert.Equal(t, "", entry.Before)
+		}
+
+		diff := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(entry.Diff), &diff); err != nil {
+			t.Error(err)
+			continue
+		}
+
+		for key, expected := range table.expected {
+			assert.Equal(t, expected, diff[key])
+		}
+
+		if table.before != nil {
+			assert.NotContains(t, diff, "Name")
+		}
+	}
+}
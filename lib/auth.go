@@ -28,6 +28,7 @@ type AuthService interface {
 	GenerateJWT(ctx context.Context, user *User) (string, error)
 	AuthenticateToken(ctx context.Context) (*User, error)
 	AuthenticateAdmin(ctx context.Context) (*User, error)
+	FindUser(ctx context.Context, username, email string) (*User, error)
 	Login(context.Context, *LoginRequest) (*User, error)
 }
 
@@ -60,25 +61,31 @@ type CreateUserRequest struct {
 // GetAuthFromContext gets the authentication token can be omitted by specifing the route that
 // doesn't require authentication during gateway intialization
 func GetAuthFromContext(ctx context.Context) (string, error) {
+	return getHeaderFromContext(ctx, authheader)
+}
+
+// getHeaderFromContext returns the single value of the provided header from the incoming
+// metadata, if the header is missing or has been provided more than once an error is raised
+func getHeaderFromContext(ctx context.Context, header string) (string, error) {
 	metadata, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		return "", errors.ErrNoMetadata
 	}
 
-	auth, ok := metadata[authheader]
+	values, ok := metadata[header]
 	if !ok {
 		return "", &errors.ErrInvalidHeader{
-			Header: authheader,
+			Header: header,
 		}
 	}
 
-	if len(auth) > 1 {
+	if len(values) != 1 {
 		return "", &errors.ErrInvalidHeader{
-			Header: authheader,
+			Header: header,
 		}
 	}
 
-	return auth[0], nil
+	return values[0], nil
 }
 
 func SetAuthContext(ctx context.Context, token string) context.Context {
@@ -3,6 +3,7 @@ package auth
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/cryptnode-software/pisces/lib"
@@ -47,8 +48,25 @@ func (s *Service) Login(ctx context.Context, req *lib.LoginRequest) (*lib.User,
 }
 
 // CreateUser creates a user in the
-func (s *Service) CreateUser(ctx context.Context, user *lib.User, password string) (*lib.User, error) {
-	return s.repo.CreateUser(ctx, user, password)
+func (s *Service) CreateUser(ctx context.Context, user *lib.User, password string) (result *lib.User, err error) {
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo RepoI) (err error) {
+		if result, err = repo.CreateUser(ctx, user, password); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityUser, result.ID, nil, result)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// FindUser returns the user that matches the provided username and/or email
+func (s *Service) FindUser(ctx context.Context, username, email string) (*lib.User, error) {
+	return s.repo.FindUser(ctx, username, email)
 }
 
 // GenerateJWT creates a jwt and signs it with the secret that is collected from the JWTSecret env property
@@ -110,12 +128,21 @@ func (s *Service) DecodeJWT(ctx context.Context, token string) (*lib.User, error
 }
 
 func (s *Service) DeleteUser(ctx context.Context, user *lib.User, conditions *lib.DeleteConditions) error {
-	if conditions != nil {
-		if conditions.HardDelete {
-			return s.repo.HardDelete(ctx, user)
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo RepoI) error {
+		if conditions != nil && conditions.HardDelete {
+			if err := repo.HardDelete(ctx, user); err != nil {
+				return err
+			}
+
+			return s.Audit(ctx, lib.AuditActionHardDelete, lib.AuditEntityUser, user.ID, user, nil)
 		}
-	}
-	return s.repo.SoftDelete(ctx, user)
+
+		if err := repo.SoftDelete(ctx, user); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionDelete, lib.AuditEntityUser, user.ID, user, nil)
+	})
 }
 
 // AuthenticateToken makes sure a token is valid and isn't expired otherwise it
@@ -153,6 +180,7 @@ func (s *Service) AuthenticateAdmin(ctx context.Context) (*lib.User, error) {
 }
 
 type RepoI interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context, repo RepoI) error) error
 	CreateUser(ctx context.Context, user *lib.User, password string) (*lib.User, error)
 	FindUser(ctx context.Context, username, email string) (*lib.User, error)
 	Login(context.Context, *lib.LoginRequest) (*lib.User, error)
@@ -164,6 +192,15 @@ type repo struct {
 	*gorm.DB
 }
 
+// Transaction runs the provided function with a repo that is bound to a single transaction,
+// the transaction is rolled back if the function returns an error. The context the function is
+// given is bound to the transaction as well, so the mutations are audited within it.
+func (r *repo) Transaction(ctx context.Context, fn func(ctx context.Context, repo RepoI) error) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(lib.WithAuditTx(ctx, tx), &repo{tx})
+	})
+}
+
 func (r *repo) CreateUser(ctx context.Context, luser *lib.User, password string) (*lib.User, error) {
 	if luser.Username == "" {
 		return nil, errors.ErrNoUsernameOrEmailProvided
@@ -230,8 +267,10 @@ func (r *repo) FindUser(ctx context.Context, username, email string) (*lib.User,
 		tx = tx.Where("username = ?", username)
 	}
 
+	//emails are matched regardless of their case, the same as the email of
+	//an identity that logs in through single sign-on
 	if email != "" {
-		tx = tx.Where("email = ?", email)
+		tx = tx.Where("LOWER(email) = ?", strings.ToLower(email))
 	}
 
 	user := new(lib.User)
@@ -249,7 +288,7 @@ func (r *repo) FindUser(ctx context.Context, username, email string) (*lib.User,
 	}
 
 	if email != "" {
-		if email != user.Email {
+		if !strings.EqualFold(email, user.Email) {
 			return nil, errors.ErrNoUserFound
 		}
 	}
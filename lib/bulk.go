@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"context"
+	"io"
+
+	"github.com/google/uuid"
+)
+
+// BulkService imports and exports the whole catalog at once. Imports create or update
+// products by their id, or by the sku of one of their variants, and are applied in a single
+// transaction: either every product is saved or none of them is.
+type BulkService interface {
+	//ImportProducts validates every record of the file and saves the products when none of
+	//them is invalid, a dry run only validates them
+	ImportProducts(ctx context.Context, format BulkFormat, r io.Reader, dryrun bool) (*ImportResult, error)
+	ExportProducts(ctx context.Context, format BulkFormat, w io.Writer) error
+}
+
+// BulkFormat the primitive type for the formats products are imported and exported in
+type BulkFormat string
+
+const (
+	//BulkFormatCSV a header followed by a line for every variant, products without variants
+	//take a single line. The lines of a product follow each other.
+	BulkFormatCSV BulkFormat = "csv"
+	//BulkFormatJSON an array of products, with their variants nested in them
+	BulkFormatJSON BulkFormat = "json"
+)
+
+// ProductRecord a product as it is imported and exported, along with its variants
+type ProductRecord struct {
+	ID          *uuid.UUID       `json:"id,omitempty"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Cost        float32          `json:"cost"`
+	Inventory   int              `json:"inventory"`
+	Variants    []*VariantRecord `json:"variants,omitempty"`
+	//Row is where the product is found in the imported file, the line of a csv or the
+	//position of the product in json
+	Row int `json:"-"`
+}
+
+// VariantRecord a variant of a product as it is imported and exported, a variant without a
+// cost sells at the cost of its product
+type VariantRecord struct {
+	ID        *uuid.UUID     `json:"id,omitempty"`
+	SKU       string         `json:"sku"`
+	Options   VariantOptions `json:"options"`
+	Cost      *float32       `json:"cost,omitempty"`
+	Inventory int            `json:"inventory"`
+	Weight    float32        `json:"weight"`
+	//Row is where the variant is found in the imported file
+	Row int `json:"-"`
+}
+
+// ImportResult the outcome of an import. Created and Updated count the products that were,
+// or for a dry run would have been, created and updated.
+type ImportResult struct {
+	DryRun  bool
+	Created int
+	Updated int
+	//Errors are the problems of every invalid record, nothing is saved when there are any
+	Errors []*ImportError
+}
+
+// ImportError a problem with a single field of a record of an import
+type ImportError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ImportProducts imports the products of the file, it is only available to admins
+func (g *Gateway) ImportProducts(ctx context.Context, format BulkFormat, r io.Reader, dryrun bool) (*ImportResult, error) {
+	if _, err := g.admin(ctx); err != nil {
+		return nil, err
+	}
+
+	result, err := g.services.BulkService.ImportProducts(ctx, format, r, dryrun)
+	if err != nil || dryrun {
+		return result, err
+	}
+
+	//an import can touch any number of products, so the index is rebuilt rather than
+	//updated product by product
+	if err := g.services.SearchService.Rebuild(ctx); err != nil {
+		g.Env.Log.Error(err.Error())
+	}
+
+	return result, nil
+}
+
+// ExportProducts writes every product along with its variants to w, it is only available to
+// admins
+func (g *Gateway) ExportProducts(ctx context.Context, format BulkFormat, w io.Writer) error {
+	if _, err := g.admin(ctx); err != nil {
+		return err
+	}
+
+	return g.services.BulkService.ExportProducts(ctx, format, w)
+}
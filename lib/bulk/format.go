@@ -0,0 +1,368 @@
+package bulk
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+)
+
+//columns are the columns of a csv, in the order they are exported in. The product columns
+//are repeated on every line of a product, only the ones of its first line are imported.
+var columns = []string{
+	"product_id",
+	"name",
+	"description",
+	"cost",
+	"inventory",
+	"variant_id",
+	"sku",
+	"options",
+	"variant_cost",
+	"variant_inventory",
+	"weight",
+}
+
+//decode reads the records of the file, the values that can't be parsed are returned as the
+//errors of their rows. An error is only returned when the file can't be read at all.
+func decode(format lib.BulkFormat, r io.Reader) ([]*lib.ProductRecord, []*lib.ImportError, error) {
+	switch format {
+	case lib.BulkFormatCSV:
+		return decodecsv(r)
+	case lib.BulkFormatJSON:
+		records, err := decodejson(r)
+		return records, nil, err
+	default:
+		return nil, nil, &errors.ErrUnknownBulkFormat{
+			Format: string(format),
+		}
+	}
+}
+
+//encode writes the records in the format
+func encode(format lib.BulkFormat, w io.Writer, records []*lib.ProductRecord) error {
+	switch format {
+	case lib.BulkFormatCSV:
+		return encodecsv(w, records)
+	case lib.BulkFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	default:
+		return &errors.ErrUnknownBulkFormat{
+			Format: string(format),
+		}
+	}
+}
+
+func decodejson(r io.Reader) ([]*lib.ProductRecord, error) {
+	records := make([]*lib.ProductRecord, 0)
+
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, &errors.ErrMalformedImport{
+			Row:     1,
+			Message: err.Error(),
+		}
+	}
+
+	for i, record := range records {
+		if record == nil {
+			return nil, &errors.ErrMalformedImport{
+				Row:     i + 1,
+				Message: "a product can't be null",
+			}
+		}
+
+		record.Row = i + 1
+		for _, variant := range record.Variants {
+			if variant == nil {
+				return nil, &errors.ErrMalformedImport{
+					Row:     i + 1,
+					Message: "a variant can't be null",
+				}
+			}
+			variant.Row = record.Row
+		}
+	}
+
+	return records, nil
+}
+
+//line the values of a line of a csv by their column
+type line struct {
+	row    int
+	values map[string]string
+	errs   []*lib.ImportError
+}
+
+func (l *line) get(column string) string {
+	return strings.TrimSpace(l.values[column])
+}
+
+func (l *line) fail(column, message string) {
+	l.errs = append(l.errs, &lib.ImportError{
+		Row:     l.row,
+		Field:   column,
+		Message: message,
+	})
+}
+
+func (l *line) id(column string) *uuid.UUID {
+	value := l.get(column)
+	if value == "" {
+		return nil
+	}
+
+	id, err := uuid.Parse(value)
+	if err != nil {
+		l.fail(column, "must be a uuid")
+		return nil
+	}
+
+	return &id
+}
+
+func (l *line) float(column string) *float32 {
+	value := l.get(column)
+	if value == "" {
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		l.fail(column, "must be a number")
+		return nil
+	}
+
+	result := float32(f)
+	return &result
+}
+
+func (l *line) int(column string) int {
+	value := l.get(column)
+	if value == "" {
+		return 0
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		l.fail(column, "must be a whole number")
+	}
+
+	return i
+}
+
+//options parses the options of a variant, i.e. "Color=Red;Size=M"
+func (l *line) options(column string) lib.VariantOptions {
+	options := make(lib.VariantOptions)
+
+	for _, pair := range strings.Split(l.get(column), ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			l.fail(column, fmt.Sprintf("%q must be an option and its value, i.e. Size=M", pair))
+			continue
+		}
+
+		options[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return options
+}
+
+func decodecsv(r io.Reader) ([]*lib.ProductRecord, []*lib.ImportError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, &errors.ErrMalformedImport{
+			Row:     1,
+			Message: "the header is missing",
+		}
+	}
+
+	if err != nil {
+		return nil, nil, &errors.ErrMalformedImport{
+			Row:     1,
+			Message: err.Error(),
+		}
+	}
+
+	index := make(map[string]int, len(header))
+	for i, column := range header {
+		index[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+
+	//only the name is required, every other column may be left out
+	if _, ok := index["name"]; !ok {
+		return nil, nil, &errors.ErrMalformedImport{
+			Row:     1,
+			Message: "the header must have a name column",
+		}
+	}
+
+	records := make([]*lib.ProductRecord, 0)
+	errs := make([]*lib.ImportError, 0)
+
+	var last *lib.ProductRecord
+
+	for row := 2; ; row++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, &errors.ErrMalformedImport{
+				Row:     row,
+				Message: err.Error(),
+			}
+		}
+
+		l := &line{
+			row:    row,
+			values: make(map[string]string, len(columns)),
+		}
+
+		for _, column := range columns {
+			if i, ok := index[column]; ok && i < len(fields) {
+				l.values[column] = fields[i]
+			}
+		}
+
+		record := &lib.ProductRecord{
+			ID:          l.id("product_id"),
+			Name:        l.get("name"),
+			Description: l.get("description"),
+			Inventory:   l.int("inventory"),
+			Row:         row,
+		}
+
+		if cost := l.float("cost"); cost != nil {
+			record.Cost = *cost
+		}
+
+		//the lines of a product follow each other, they are told apart by the id of the
+		//product or by its name when it doesn't have an id yet
+		if last == nil || !same(last, record) {
+			records = append(records, record)
+			last = record
+		}
+
+		if l.get("sku") != "" || l.get("variant_id") != "" {
+			variant := &lib.VariantRecord{
+				ID:        l.id("variant_id"),
+				SKU:       l.get("sku"),
+				Options:   l.options("options"),
+				Cost:      l.float("variant_cost"),
+				Inventory: l.int("variant_inventory"),
+				Row:       row,
+			}
+
+			if weight := l.float("weight"); weight != nil {
+				variant.Weight = *weight
+			}
+
+			last.Variants = append(last.Variants, variant)
+		}
+
+		errs = append(errs, l.errs...)
+	}
+
+	return records, errs, nil
+}
+
+//same reports whether the line of the record continues the product of the last one
+func same(last, record *lib.ProductRecord) bool {
+	if last.ID != nil || record.ID != nil {
+		return last.ID != nil && record.ID != nil && *last.ID == *record.ID
+	}
+
+	return last.Name == record.Name
+}
+
+func encodecsv(w io.Writer, records []*lib.ProductRecord) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		product := []string{
+			id(record.ID),
+			record.Name,
+			record.Description,
+			number(record.Cost),
+			strconv.Itoa(record.Inventory),
+		}
+
+		if len(record.Variants) == 0 {
+			if err := writer.Write(append(product, "", "", "", "", "", "")); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, variant := range record.Variants {
+			cost := ""
+			if variant.Cost != nil {
+				cost = number(*variant.Cost)
+			}
+
+			fields := append(append([]string{}, product...),
+				id(variant.ID),
+				variant.SKU,
+				options(variant.Options),
+				cost,
+				strconv.Itoa(variant.Inventory),
+				number(variant.Weight),
+			)
+
+			if err := writer.Write(fields); err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func id(value *uuid.UUID) string {
+	if value == nil {
+		return ""
+	}
+	return value.String()
+}
+
+func number(value float32) string {
+	return strconv.FormatFloat(float64(value), 'f', -1, 32)
+}
+
+//options writes the options of a variant sorted by their name, i.e. "Color=Red;Size=M"
+func options(values lib.VariantOptions) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+values[name])
+	}
+
+	return strings.Join(pairs, ";")
+}
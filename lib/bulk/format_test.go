@@ -0,0 +1,101 @@
+package bulk
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cryptnode-software/pisces/lib"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCSV(t *testing.T) {
+	file := strings.Join([]string{
+		"name,cost,inventory,sku,options,variant_cost,variant_inventory",
+		"Oatmeal cookies,12,4,,,,",
+		"T-shirt,20,,SHIRT-S,Size=S,,5",
+		"T-shirt,20,,SHIRT-M,Size=M,22.5,five",
+		"Mug,cheap,1,,,,",
+	}, "\n")
+
+	records, errs, err := decode(lib.BulkFormatCSV, strings.NewReader(file))
+	if !assert.Nil(t, err) || !assert.Len(t, records, 3) {
+		return
+	}
+
+	assert.Equal(t, "Oatmeal cookies", records[0].Name)
+	assert.Equal(t, float32(12), records[0].Cost)
+	assert.Empty(t, records[0].Variants)
+
+	//the lines of a product follow each other
+	shirt := records[1]
+	if assert.Len(t, shirt.Variants, 2) {
+		assert.Equal(t, lib.VariantOptions{"Size": "S"}, shirt.Variants[0].Options)
+		assert.Nil(t, shirt.Variants[0].Cost)
+		assert.Equal(t, float32(22.5), *shirt.Variants[1].Cost)
+		assert.Equal(t, 4, shirt.Variants[1].Row)
+	}
+
+	assert.Equal(t, []*lib.ImportError{
+		{Row: 4, Field: "variant_inventory", Message: "must be a whole number"},
+		{Row: 5, Field: "cost", Message: "must be a number"},
+	}, errs)
+
+	_, _, err = decode(lib.BulkFormatCSV, strings.NewReader("sku,cost\nA,1"))
+	assert.IsType(t, new(perrors.ErrMalformedImport), err)
+
+	_, _, err = decode("xml", strings.NewReader(""))
+	assert.Equal(t, &perrors.ErrUnknownBulkFormat{Format: "xml"}, err)
+}
+
+func TestEncodeCSV(t *testing.T) {
+	cost := float32(22.5)
+
+	records := []*lib.ProductRecord{
+		{Name: "Mug", Cost: 8.99, Inventory: 3},
+		{
+			Name: "T-shirt",
+			Cost: 20,
+			Variants: []*lib.VariantRecord{
+				{SKU: "SHIRT-M", Options: lib.VariantOptions{"Size": "M", "Color": "Red"}, Cost: &cost, Inventory: 5},
+				{SKU: "SHIRT-L", Options: lib.VariantOptions{"Size": "L", "Color": "Red"}},
+			},
+		},
+	}
+
+	buffer := new(bytes.Buffer)
+	if !assert.Nil(t, encode(lib.BulkFormatCSV, buffer, records)) {
+		return
+	}
+
+	assert.Equal(t, strings.Join([]string{
+		"product_id,name,description,cost,inventory,variant_id,sku,options,variant_cost,variant_inventory,weight",
+		",Mug,,8.99,3,,,,,,",
+		",T-shirt,,20,0,,SHIRT-M,Color=Red;Size=M,22.5,5,0",
+		",T-shirt,,20,0,,SHIRT-L,Color=Red;Size=L,,0,0",
+		"",
+	}, "\n"), buffer.String())
+
+	//what is exported can be imported again as it is
+	decoded, errs, err := decode(lib.BulkFormatCSV, buffer)
+	if assert.Nil(t, err) && assert.Empty(t, errs) && assert.Len(t, decoded, 2) {
+		assert.Len(t, decoded[1].Variants, 2)
+		assert.Equal(t, records[1].Variants[0].Options, decoded[1].Variants[0].Options)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	records, _, err := decode(lib.BulkFormatJSON, strings.NewReader(`[
+		{"name": "Mug", "cost": 8.99},
+		{"name": "T-shirt", "variants": [{"sku": "SHIRT-M", "options": {"Size": "M"}}]}
+	]`))
+
+	if assert.Nil(t, err) && assert.Len(t, records, 2) {
+		assert.Equal(t, 2, records[1].Row)
+		assert.Equal(t, 2, records[1].Variants[0].Row)
+	}
+
+	_, _, err = decode(lib.BulkFormatJSON, strings.NewReader(`[null]`))
+	assert.Equal(t, &perrors.ErrMalformedImport{Row: 1, Message: "a product can't be null"}, err)
+}
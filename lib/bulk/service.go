@@ -0,0 +1,722 @@
+package bulk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//Service the bulk service, it saves imported products straight through its own repo so the
+//whole import is applied in a single transaction
+type Service struct {
+	*lib.Env
+	repo repoi
+}
+
+//NewService returns a new bulk service
+func NewService(env *lib.Env) (lib.BulkService, error) {
+	return &Service{
+		env,
+		&repo{
+			env.GormDB,
+		},
+	}, nil
+}
+
+//ImportProducts validates every record against the catalog as it is and saves them all when
+//none is invalid. Products are matched by their id or else by the sku of one of their
+//variants, the ones that don't match are created. Variants are matched the same way, the
+//variants that aren't in the import are left alone.
+func (s *Service) ImportProducts(ctx context.Context, format lib.BulkFormat, r io.Reader, dryrun bool) (*lib.ImportResult, error) {
+	records, invalid, err := decode(format, r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &lib.ImportResult{
+		DryRun: dryrun,
+		Errors: invalid,
+	}
+
+	var plans []*plan
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		planner := &planner{
+			repo:     repo,
+			skus:     make(map[string]int),
+			products: make(map[uuid.UUID]int),
+		}
+
+		for _, record := range records {
+			p, err := planner.plan(ctx, record)
+			if err != nil {
+				return err
+			}
+
+			if p != nil {
+				plans = append(plans, p)
+			}
+		}
+
+		result.Errors = append(result.Errors, planner.errs...)
+		if len(result.Errors) > 0 {
+			return &errors.ErrInvalidImport{
+				Errors: len(result.Errors),
+			}
+		}
+
+		for _, p := range plans {
+			if p.before == nil {
+				result.Created++
+			} else {
+				result.Updated++
+			}
+		}
+
+		if dryrun {
+			return nil
+		}
+
+		if err := apply(ctx, repo, plans); err != nil {
+			return err
+		}
+
+		return s.audit(ctx, plans)
+	})
+
+	if err != nil {
+		if _, ok := err.(*errors.ErrInvalidImport); ok {
+			return result, err
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//ExportProducts writes every product, along with its variants, in the format. The export can
+//be imported again as it is.
+func (s *Service) ExportProducts(ctx context.Context, format lib.BulkFormat, w io.Writer) error {
+	if format != lib.BulkFormatCSV && format != lib.BulkFormatJSON {
+		return &errors.ErrUnknownBulkFormat{
+			Format: string(format),
+		}
+	}
+
+	products, err := s.repo.GetProducts(ctx)
+	if err != nil {
+		return err
+	}
+
+	records := make([]*lib.ProductRecord, 0, len(products))
+
+	for _, product := range products {
+		id := product.ID
+
+		record := &lib.ProductRecord{
+			ID:          &id,
+			Name:        product.Name,
+			Description: product.Description,
+			Cost:        product.Cost,
+			Inventory:   product.Inventory,
+		}
+
+		for _, variant := range product.Variants {
+			id := variant.ID
+
+			record.Variants = append(record.Variants, &lib.VariantRecord{
+				ID:        &id,
+				SKU:       variant.SKU,
+				Options:   variant.Options,
+				Cost:      variant.Cost,
+				Inventory: variant.Inventory,
+				Weight:    variant.Weight,
+			})
+		}
+
+		records = append(records, record)
+	}
+
+	return encode(format, w, records)
+}
+
+//audit records the changes of the plans, it must be called within the transaction they were
+//applied in
+func (s *Service) audit(ctx context.Context, plans []*plan) error {
+	for _, p := range plans {
+		action := lib.AuditActionUpdate
+		if p.before == nil {
+			action = lib.AuditActionCreate
+		}
+
+		if err := s.Audit(ctx, action, lib.AuditEntityProduct, p.product.ID, p.before, p.product); err != nil {
+			return err
+		}
+
+		for _, change := range p.options {
+			action := lib.AuditActionUpdate
+			if change.before == nil {
+				action = lib.AuditActionCreate
+			}
+
+			if err := s.Audit(ctx, action, lib.AuditEntityProductOption, change.after.ID, change.before, change.after); err != nil {
+				return err
+			}
+		}
+
+		for _, change := range p.variants {
+			action := lib.AuditActionUpdate
+			if change.before == nil {
+				action = lib.AuditActionCreate
+			}
+
+			if err := s.Audit(ctx, action, lib.AuditEntityProductVariant, change.after.ID, change.before, change.after); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//plan what an import does to a single product, before is nil for a product that is created
+type plan struct {
+	before   *lib.Product
+	product  *lib.Product
+	options  []*optionchange
+	variants []*variantchange
+}
+
+type optionchange struct {
+	before *lib.ProductOption
+	after  *lib.ProductOption
+}
+
+type variantchange struct {
+	before *lib.ProductVariant
+	after  *lib.ProductVariant
+	//row is where the variant is found in the import
+	row int
+}
+
+//planner validates the records of an import and plans their changes, it keeps track of the
+//products and skus that were already imported so neither is imported twice
+type planner struct {
+	repo repoi
+	//skus and products hold the row every sku and product was first imported at
+	skus     map[string]int
+	products map[uuid.UUID]int
+	errs     []*lib.ImportError
+}
+
+func (p *planner) fail(row int, field, format string, args ...interface{}) {
+	p.errs = append(p.errs, &lib.ImportError{
+		Row:     row,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+//plan returns the changes the record makes to its product, it is nil when the record is
+//invalid. An error is only returned when the catalog can't be read.
+func (p *planner) plan(ctx context.Context, record *lib.ProductRecord) (*plan, error) {
+	failed := len(p.errs)
+
+	if record.Name = strings.TrimSpace(record.Name); record.Name == "" {
+		p.fail(record.Row, "name", "is required")
+	}
+
+	if record.Cost < 0 {
+		p.fail(record.Row, "cost", "must not be negative")
+	}
+
+	if record.Inventory < 0 {
+		p.fail(record.Row, "inventory", "must not be negative")
+	}
+
+	existing, err := p.resolve(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &plan{
+		before: existing,
+		product: &lib.Product{
+			Name:        record.Name,
+			Description: record.Description,
+			Cost:        record.Cost,
+			Inventory:   record.Inventory,
+		},
+	}
+
+	variants := make([]*lib.ProductVariant, 0)
+	options := make([]*lib.ProductOption, 0)
+
+	if existing != nil {
+		if row, ok := p.products[existing.ID]; ok {
+			p.fail(record.Row, "product_id", "the product was already imported at row %d", row)
+		}
+		p.products[existing.ID] = record.Row
+
+		result.product.Model = existing.Model
+		variants = append(variants, existing.Variants...)
+		options = append(options, existing.Options...)
+	}
+
+	for _, v := range record.Variants {
+		change, err := p.variant(ctx, existing, v)
+		if err != nil {
+			return nil, err
+		}
+
+		if change == nil {
+			continue
+		}
+
+		result.variants = append(result.variants, change)
+
+		if change.before == nil {
+			change.after.Position = len(variants)
+			variants = append(variants, change.after)
+			continue
+		}
+
+		for i := range variants {
+			if variants[i].ID == change.before.ID {
+				variants[i] = change.after
+			}
+		}
+	}
+
+	if len(p.errs) == failed {
+		result.options = p.options(record, options, variants, result.variants)
+	}
+
+	if len(p.errs) > failed {
+		return nil, nil
+	}
+
+	return result, nil
+}
+
+//resolve returns the product the record updates, nil when it creates one
+func (p *planner) resolve(ctx context.Context, record *lib.ProductRecord) (*lib.Product, error) {
+	if record.ID != nil {
+		product, err := p.repo.GetProduct(ctx, *record.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if product == nil {
+			p.fail(record.Row, "product_id", "no product was found with the id %s", record.ID)
+		}
+
+		return product, nil
+	}
+
+	var found *lib.Product
+
+	for _, v := range record.Variants {
+		if v.SKU = strings.TrimSpace(v.SKU); v.SKU == "" {
+			continue
+		}
+
+		variant, err := p.repo.GetProductVariantBySKU(ctx, v.SKU)
+		if err != nil {
+			return nil, err
+		}
+
+		if variant == nil {
+			continue
+		}
+
+		if found != nil && found.ID != variant.ProductID {
+			p.fail(v.Row, "sku", "%q belongs to another product than the other skus of the product", v.SKU)
+			continue
+		}
+
+		if found == nil {
+			if found, err = p.repo.GetProduct(ctx, variant.ProductID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return found, nil
+}
+
+//variant validates the variant record and returns the change it makes to the variants of the
+//product, it is nil when the record is invalid
+func (p *planner) variant(ctx context.Context, product *lib.Product, record *lib.VariantRecord) (*variantchange, error) {
+	failed := len(p.errs)
+
+	if record.SKU = strings.TrimSpace(record.SKU); record.SKU == "" {
+		p.fail(record.Row, "sku", "is required")
+		return nil, nil
+	}
+
+	if row, ok := p.skus[record.SKU]; ok {
+		p.fail(record.Row, "sku", "%q was already imported at row %d", record.SKU, row)
+		return nil, nil
+	}
+	p.skus[record.SKU] = record.Row
+
+	if record.Cost != nil && *record.Cost < 0 {
+		p.fail(record.Row, "variant_cost", "must not be negative")
+	}
+
+	if record.Inventory < 0 {
+		p.fail(record.Row, "variant_inventory", "must not be negative")
+	}
+
+	if record.Weight < 0 {
+		p.fail(record.Row, "weight", "must not be negative")
+	}
+
+	var before *lib.ProductVariant
+
+	if product != nil {
+		for _, variant := range product.Variants {
+			if (record.ID != nil && variant.ID == *record.ID) || (record.ID == nil && variant.SKU == record.SKU) {
+				before = variant
+			}
+		}
+	}
+
+	if record.ID != nil && before == nil {
+		p.fail(record.Row, "variant_id", "isn't a variant of the product")
+	}
+
+	taken, err := p.repo.GetProductVariantBySKU(ctx, record.SKU)
+	if err != nil {
+		return nil, err
+	}
+
+	if taken != nil && (before == nil || taken.ID != before.ID) {
+		p.fail(record.Row, "sku", "%q is taken by another variant", record.SKU)
+	}
+
+	if len(p.errs) > failed {
+		return nil, nil
+	}
+
+	options := make(lib.VariantOptions, len(record.Options))
+	for name, value := range record.Options {
+		options[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	after := &lib.ProductVariant{
+		SKU:       record.SKU,
+		Options:   options,
+		Cost:      record.Cost,
+		Inventory: record.Inventory,
+		Weight:    record.Weight,
+	}
+
+	if before != nil {
+		after.ProductID = before.ProductID
+		after.Position = before.Position
+		after.Model = before.Model
+	}
+
+	return &variantchange{before, after, record.Row}, nil
+}
+
+//options makes sure every variant of the product has a value for the same options, and that
+//no two variants have the same values, and returns the options that have to be created or
+//given new values for the imported variants
+func (p *planner) options(record *lib.ProductRecord, existing []*lib.ProductOption, variants []*lib.ProductVariant, changes []*variantchange) []*optionchange {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	//the options of the product are kept, a product without any takes the ones of its
+	//first variant
+	names := make([]string, 0)
+	for _, option := range existing {
+		names = append(names, option.Name)
+	}
+
+	if len(names) == 0 {
+		for name := range variants[0].Options {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	//the problems of the variants that were imported are reported at their own row, the
+	//ones of the variants that were already there at the row of the product
+	rows := make(map[*lib.ProductVariant]int, len(changes))
+	for _, change := range changes {
+		rows[change.after] = change.row
+	}
+
+	for i, variant := range variants {
+		row, imported := rows[variant]
+		if !imported {
+			row = record.Row
+		}
+
+		complete := len(variant.Options) == len(names)
+		for _, name := range names {
+			if variant.Options[name] == "" {
+				complete = false
+			}
+		}
+
+		if !complete {
+			p.fail(row, "options", "the variant %q must have a value for %s and nothing else", variant.SKU, strings.Join(names, ", "))
+			continue
+		}
+
+		for _, other := range variants[:i] {
+			if _, ok := rows[other]; (imported || ok) && other.Options.Equal(variant.Options) {
+				p.fail(row, "options", "the variant %q has the same options as the variant %q", variant.SKU, other.SKU)
+			}
+		}
+	}
+
+	result := make([]*optionchange, 0)
+
+	for position, name := range names {
+		var before *lib.ProductOption
+		for _, option := range existing {
+			if option.Name == name {
+				before = option
+			}
+		}
+
+		after := &lib.ProductOption{
+			Name:     name,
+			Position: position,
+		}
+
+		if before != nil {
+			*after = *before
+			after.Values = append(lib.OptionValues{}, before.Values...)
+		}
+
+		for _, variant := range variants {
+			if value := variant.Options[name]; value != "" && !after.Values.Contains(value) {
+				after.Values = append(after.Values, value)
+			}
+		}
+
+		if before == nil || len(after.Values) != len(before.Values) {
+			result = append(result, &optionchange{before, after})
+		}
+	}
+
+	return result
+}
+
+//apply saves the planned changes, the options of a product are saved before its variants
+func apply(ctx context.Context, repo repoi, plans []*plan) error {
+	for _, p := range plans {
+		if err := repo.SaveProduct(ctx, p.product); err != nil {
+			return err
+		}
+
+		var before *float32
+		if p.before != nil {
+			before = &p.before.Cost
+		}
+
+		cost := p.product.Cost
+		if err := repo.CreatePriceChange(ctx, lib.NewPriceChange(ctx, p.product.ID, nil, before, &cost)); err != nil {
+			return err
+		}
+
+		if err := restock(ctx, repo, p.product.ID, nil, p.product.Inventory); err != nil {
+			return err
+		}
+
+		for _, change := range p.options {
+			change.after.ProductID = p.product.ID
+			if err := repo.SaveProductOption(ctx, change.after); err != nil {
+				return err
+			}
+		}
+
+		for _, change := range p.variants {
+			change.after.ProductID = p.product.ID
+			if err := repo.SaveProductVariant(ctx, change.after); err != nil {
+				return err
+			}
+
+			var before *float32
+			if change.before != nil {
+				before = change.before.Cost
+			}
+
+			if err := repo.CreatePriceChange(ctx, lib.NewPriceChange(ctx, p.product.ID, &change.after.ID, before, change.after.Cost)); err != nil {
+				return err
+			}
+
+			if err := restock(ctx, repo, p.product.ID, &change.after.ID, change.after.Inventory); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//restock brings the stock of the product, or of the variant, to the imported inventory. The
+//difference is recorded in the ledger as an adjustment.
+func restock(ctx context.Context, repo repoi, product uuid.UUID, variant *uuid.UUID, inventory int) error {
+	stock, err := repo.LockInventory(ctx, product, variant)
+	if err != nil {
+		return err
+	}
+
+	return repo.MoveInventory(ctx, lib.NewInventoryMovement(ctx, lib.InventoryMovementAdjustment, product, variant, inventory-stock, "imported"))
+}
+
+type repoi interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error
+	GetProducts(ctx context.Context) ([]*lib.Product, error)
+	GetProduct(ctx context.Context, id uuid.UUID) (*lib.Product, error)
+	GetProductVariantBySKU(ctx context.Context, sku string) (*lib.ProductVariant, error)
+	SaveProduct(ctx context.Context, product *lib.Product) error
+	SaveProductOption(ctx context.Context, option *lib.ProductOption) error
+	SaveProductVariant(ctx context.Context, variant *lib.ProductVariant) error
+	CreatePriceChange(ctx context.Context, change *lib.PriceChange) error
+	LockInventory(ctx context.Context, product uuid.UUID, variant *uuid.UUID) (int, error)
+	MoveInventory(ctx context.Context, movement *lib.InventoryMovement) error
+}
+
+type repo struct {
+	*gorm.DB
+}
+
+//Transaction runs the provided function with a repo that is bound to a single transaction,
+//the transaction is rolled back if the function returns an error. The context the function is
+//given is bound to the transaction as well, so the mutations are audited within it.
+func (r *repo) Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(lib.WithAuditTx(ctx, tx), &repo{tx})
+	})
+}
+
+//GetProducts returns every product by name, along with its options and variants in order
+func (r *repo) GetProducts(ctx context.Context) (products []*lib.Product, err error) {
+	products = make([]*lib.Product, 0)
+	err = nested(r.DB.WithContext(ctx)).Order("name ASC, id ASC").Find(&products).Error
+	return
+}
+
+//GetProduct returns the product along with its options and variants, nil when there is none
+func (r *repo) GetProduct(ctx context.Context, id uuid.UUID) (*lib.Product, error) {
+	product := new(lib.Product)
+
+	err := nested(r.DB).First(product, "id = ?", id).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+//GetProductVariantBySKU returns the variant with the sku, nil when there is none
+func (r *repo) GetProductVariantBySKU(ctx context.Context, sku string) (*lib.ProductVariant, error) {
+	variant := new(lib.ProductVariant)
+
+	err := r.DB.First(variant, "sku = ?", sku).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
+
+//SaveProduct saves the product by itself, its stock is moved through the ledger instead
+func (r *repo) SaveProduct(ctx context.Context, product *lib.Product) error {
+	if product.ID == uuid.Nil {
+		return r.DB.Omit(clause.Associations, "Inventory").Create(product).Error
+	}
+
+	return r.DB.Model(product).
+		Select("Name", "Description", "Cost").
+		Updates(product).Error
+}
+
+func (r *repo) SaveProductOption(ctx context.Context, option *lib.ProductOption) error {
+	if option.ID == uuid.Nil {
+		return r.DB.Create(option).Error
+	}
+
+	return r.DB.Model(option).
+		Select("Values").
+		Updates(option).Error
+}
+
+func (r *repo) SaveProductVariant(ctx context.Context, variant *lib.ProductVariant) error {
+	if variant.ID == uuid.Nil {
+		return r.DB.Omit("Inventory").Create(variant).Error
+	}
+
+	return r.DB.Model(variant).
+		Select("SKU", "Options", "Cost", "Weight").
+		Updates(variant).Error
+}
+
+//nested preloads the options and variants of the products in the order of their position
+func nested(tx *gorm.DB) *gorm.DB {
+	return tx.
+		Preload("Options", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC, created_at ASC")
+		}).
+		Preload("Variants", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC, created_at ASC")
+		})
+}
+
+//CreatePriceChange records the change of a price, a nil change is a price that stayed the same
+func (r *repo) CreatePriceChange(ctx context.Context, change *lib.PriceChange) error {
+	if change == nil {
+		return nil
+	}
+	return r.DB.WithContext(ctx).Create(change).Error
+}
+
+//LockInventory returns the stock of the product, or of the variant when one is provided,
+//locked until the transaction ends
+func (r *repo) LockInventory(ctx context.Context, product uuid.UUID, variant *uuid.UUID) (stock int, err error) {
+	tx := r.DB.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Select("COALESCE(inventory, 0)")
+
+	if variant != nil {
+		err = tx.Model(new(lib.ProductVariant)).Where("id = ?", *variant).Scan(&stock).Error
+		return
+	}
+
+	err = tx.Model(new(lib.Product)).Where("id = ?", product).Scan(&stock).Error
+	return
+}
+
+//MoveInventory records the movement, which moves the stock of its product or variant along
+//with it. A nil movement is one where nothing moved.
+func (r *repo) MoveInventory(ctx context.Context, movement *lib.InventoryMovement) error {
+	if movement == nil {
+		return nil
+	}
+	return r.DB.WithContext(ctx).Create(movement).Error
+}
@@ -0,0 +1,110 @@
+package bulk_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/bulk"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	env = lib.NewEnv(
+		commons.NewLogger(commons.EnvDev),
+	)
+
+	service, err = bulk.NewService(env)
+
+	ctx = context.Background()
+)
+
+func TestImportProducts(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//the suffix keeps the skus apart from the ones of other runs
+	suffix := uuid.New().String()[:8]
+
+	file := fmt.Sprintf(strings.Join([]string{
+		"name,cost,sku,options,variant_inventory",
+		"Imported shirt %[1]s,20,S-%[1]s,Size=S,5",
+		"Imported shirt %[1]s,20,M-%[1]s,Size=M,0",
+	}, "\n"), suffix)
+
+	result, err := service.ImportProducts(ctx, lib.BulkFormatCSV, strings.NewReader(file), true)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, &lib.ImportResult{DryRun: true, Created: 1}, result)
+
+	//a dry run doesn't save anything
+	var count int64
+	env.GormDB.Model(new(lib.ProductVariant)).Where("sku = ?", "S-"+suffix).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	result, err = service.ImportProducts(ctx, lib.BulkFormatCSV, strings.NewReader(file), false)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, 1, result.Created)
+
+	variant := new(lib.ProductVariant)
+	if !assert.Nil(t, env.GormDB.First(variant, "sku = ?", "S-"+suffix).Error) {
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(&lib.Product{Model: commons.Model{ID: variant.ProductID}})
+
+	//the product is found again by the sku of its variants, a new size adds to the option
+	update := fmt.Sprintf(strings.Join([]string{
+		"name,cost,sku,options,variant_inventory",
+		"Imported shirt %[1]s,25,M-%[1]s,Size=M,3",
+		"Imported shirt %[1]s,25,L-%[1]s,Size=L,1",
+	}, "\n"), suffix)
+
+	result, err = service.ImportProducts(ctx, lib.BulkFormatCSV, strings.NewReader(update), false)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, &lib.ImportResult{Updated: 1}, result)
+
+	option := new(lib.ProductOption)
+	if assert.Nil(t, env.GormDB.First(option, "product_id = ?", variant.ProductID).Error) {
+		assert.Equal(t, lib.OptionValues{"S", "M", "L"}, option.Values)
+	}
+
+	//nothing is saved when any record is invalid
+	invalid := fmt.Sprintf(strings.Join([]string{
+		"name,cost,sku,options",
+		"Imported shirt %[1]s,30,M-%[1]s,Size=M",
+		",5,,",
+		"Another shirt %[1]s,-5,XL-%[1]s,Size=XL",
+	}, "\n"), suffix)
+
+	result, err = service.ImportProducts(ctx, lib.BulkFormatCSV, strings.NewReader(invalid), false)
+	assert.Equal(t, &perrors.ErrInvalidImport{Errors: 2}, err)
+	if assert.NotNil(t, result) && assert.Len(t, result.Errors, 2) {
+		assert.Equal(t, 3, result.Errors[0].Row)
+		assert.Equal(t, "name", result.Errors[0].Field)
+		assert.Equal(t, 4, result.Errors[1].Row)
+		assert.Equal(t, "cost", result.Errors[1].Field)
+	}
+
+	product := new(lib.Product)
+	if assert.Nil(t, env.GormDB.First(product, "id = ?", variant.ProductID).Error) {
+		assert.Equal(t, float32(25), product.Cost)
+	}
+
+	buffer := new(bytes.Buffer)
+	if assert.Nil(t, service.ExportProducts(ctx, lib.BulkFormatJSON, buffer)) {
+		assert.Contains(t, buffer.String(), `"sku": "L-`+suffix+`"`)
+	}
+}
@@ -2,19 +2,39 @@ package lib
 
 import (
 	"context"
+	"time"
 
 	commons "github.com/cryptnode-software/commons/pkg"
 	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
 )
 
+const carttokenheader = "cart-token"
+
 // CartService represents the structure that the cart service should be
 // when we implement it in its functional form. i.e. lib/cart/service.go
 type CartService interface {
-	SaveProduct(ctx context.Context, order *Order, product *Product, action CartAction, quantity int) error
+	SaveProduct(ctx context.Context, order *Order, product *Product, variant *ProductVariant, action CartAction, quantity int) ([]*Cart, error)
 	SaveCart(ctx context.Context, cart []*Cart) ([]*Cart, error)
 	GetCart(context.Context, *Order) ([]*Cart, error)
+
+	OpenShoppingCart(ctx context.Context, user *User) (*ShoppingCart, error)
+	GetShoppingCart(ctx context.Context, id uuid.UUID) (*ShoppingCart, error)
+	SaveShoppingCartProduct(ctx context.Context, cart *ShoppingCart, product *Product, variant *ProductVariant, action CartAction, quantity int) (*ShoppingCart, error)
+	MergeShoppingCarts(ctx context.Context, guest *ShoppingCart, user *User) (*ShoppingCart, error)
+	DeleteExpiredShoppingCarts(ctx context.Context, now time.Time) (int64, error)
+	IssueCartToken(ctx context.Context, cart *ShoppingCart) (string, error)
+	VerifyCartToken(ctx context.Context, token string) (uuid.UUID, error)
 }
 
+const (
+	// MaxCartQuantity is the most of a single product that can be in one cart
+	MaxCartQuantity = 99
+
+	// CartExpiry is how long a shopping cart is kept after it was last changed
+	CartExpiry = 30 * 24 * time.Hour
+)
+
 // CartAction represents the primitive type for all of the CartActions.
 // This is used for add or removing a product from the provided order
 type CartAction string
@@ -26,12 +46,62 @@ const (
 	//RemoveProduct dispates an action that lets our cart service know that it
 	//needs to remove a product from its collection
 	RemoveProduct CartAction = "REMOVE"
+	//SetQuantity dispatches an action that sets the quantity of a product in the
+	//cart, setting it to zero removes the product
+	SetQuantity CartAction = "SET"
+	//ClearCart dispatches an action that removes every product from the cart, no
+	//product is required for it
+	ClearCart CartAction = "CLEAR"
 )
 
+// Cart a single line of either an order or a shopping cart, lines of a product with variants
+// reference the variant that was picked. UnitPrice is what a unit sold for when the order was
+// placed, later changes of the price don't change what the order costs.
 type Cart struct {
-	ProductID uuid.UUID
-	Product   *Product `gorm:"references:ID;"`
-	OrderID   uuid.UUID
-	Quantity  int64
+	ProductID      uuid.UUID
+	Product        *Product `gorm:"references:ID;"`
+	VariantID      *uuid.UUID
+	Variant        *ProductVariant
+	OrderID        *uuid.UUID
+	ShoppingCartID *uuid.UUID
+	Quantity       int64
+	UnitPrice      *float32
 	commons.Model
 }
+
+// UnitCost returns what a single unit of the line costs, the price it was placed at or else
+// the cost of its variant when it has one of its own. Lines without a price need the product
+// and variant to be loaded.
+func (line *Cart) UnitCost() float32 {
+	if line.UnitPrice != nil {
+		return *line.UnitPrice
+	}
+	return line.Variant.Price(line.Product)
+}
+
+// ShoppingCart a cart that exists before there is an order for it. Guests are identified by a
+// signed cart token and users by their id, at checkout the lines are moved to the new order.
+type ShoppingCart struct {
+	UserID    *uuid.UUID
+	ExpiresAt time.Time
+	Lines     []*Cart
+	commons.Model
+}
+
+// Expired reports whether the cart has expired by the provided time
+func (cart *ShoppingCart) Expired(now time.Time) bool {
+	return !cart.ExpiresAt.After(now)
+}
+
+// GetCartTokenFromContext returns the guest cart token provided through the `cart-token` header
+func GetCartTokenFromContext(ctx context.Context) (string, error) {
+	return getHeaderFromContext(ctx, carttokenheader)
+}
+
+// SetCartTokenContext sets the guest cart token on the incoming metadata
+func SetCartTokenContext(ctx context.Context, token string) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	md.Set(carttokenheader, token)
+	return metadata.NewIncomingContext(ctx, md)
+}
@@ -2,12 +2,19 @@ package cart
 
 import (
 	"context"
+	"time"
 
 	"github.com/cryptnode-software/pisces/lib"
 	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+//cartaudience keeps cart tokens from being used as any other kind of token
+const cartaudience = "cart"
+
 //Service the cart service handles any interactions/validations that we
 //might need for the cart table and logic that should be handled accordingly
 type Service struct {
@@ -26,35 +33,188 @@ func NewService(env *lib.Env) (lib.CartService, error) {
 	}, nil
 }
 
-//SaveProduct validates and delegates the tasks required to add/remove a product to/from an order
-func (service *Service) SaveProduct(ctx context.Context, order *lib.Order, product *lib.Product, action lib.CartAction, quantity int) error {
+//change a cart line before and after it was changed, recorded in the audit log once the
+//transaction that made the change has been committed
+type change struct {
+	id     uuid.UUID
+	before *lib.Cart
+	after  *lib.Cart
+}
+
+//owner is what cart lines belong to, either an order or a shopping cart
+type owner struct {
+	column string
+	id     uuid.UUID
+}
+
+func orderowner(id uuid.UUID) owner {
+	return owner{"order_id", id}
+}
+
+func cartowner(id uuid.UUID) owner {
+	return owner{"shopping_cart_id", id}
+}
+
+//item is what a line is for, a product along with the variant of it (uuid.Nil for products
+//without variants), every item has a single line in a cart
+type item struct {
+	product uuid.UUID
+	variant uuid.UUID
+}
+
+func newitem(product *lib.Product, variant *lib.ProductVariant) item {
+	it := item{product: product.ID}
+	if variant != nil {
+		it.variant = variant.ID
+	}
+	return it
+}
+
+func lineitem(line *lib.Cart) item {
+	it := item{product: line.ProductID}
+	if line.VariantID != nil {
+		it.variant = *line.VariantID
+	}
+	return it
+}
+
+func (it item) variantid() *uuid.UUID {
+	if it.variant == uuid.Nil {
+		return nil
+	}
+	id := it.variant
+	return &id
+}
+
+//line returns a new line of the owner for the provided item
+func (o owner) line(it item) *lib.Cart {
+	id := o.id
+
+	line := &lib.Cart{
+		ProductID: it.product,
+		VariantID: it.variantid(),
+	}
+
+	if o.column == "order_id" {
+		line.OrderID = &id
+	} else {
+		line.ShoppingCartID = &id
+	}
+
+	return line
+}
+
+//SaveProduct applies the provided action for a product (the variant of it, for products with
+//variants) to the cart of the order and returns the resulting cart. Every action runs in a
+//single transaction, if any line ends up above the maximum quantity or above what is in
+//stock nothing is changed.
+func (service *Service) SaveProduct(ctx context.Context, order *lib.Order, product *lib.Product, variant *lib.ProductVariant, action lib.CartAction, quantity int) ([]*lib.Cart, error) {
 
 	if order == nil {
-		return errors.ErrCartOrderNotProvided
+		return nil, errors.ErrCartOrderNotProvided
 	}
 
-	if product == nil {
-		return errors.ErrProductNotProvided
+	if product == nil && action != lib.ClearCart {
+		return nil, errors.ErrProductNotProvided
 	}
 
-	var err error
-	switch action {
-	case lib.RemoveProduct:
-		err = service.repo.RemoveProduct(ctx, order, product)
-	case lib.AddProduct:
-		err = service.repo.AddProduct(ctx, order, product, quantity)
-	default:
-		return &errors.ErrCartActionNotRecognized{
-			Action: string(action),
+	err := service.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		changes, err := apply(ctx, repo, orderowner(order.ID), product, variant, action, quantity)
+		if err != nil {
+			return err
 		}
+
+		return service.audit(ctx, changes)
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
-	return err
+	return service.repo.GetCart(ctx, order)
 }
 
-//SaveCart ...
+//SaveCart sets the quantity of every product in the provided cart within a single transaction.
+//Lines for the same product (and variant) of an order are merged into one, both in the provided
+//cart and with the lines that are already stored, and a quantity of zero removes the product.
 func (service *Service) SaveCart(ctx context.Context, cart []*lib.Cart) ([]*lib.Cart, error) {
-	return service.repo.SaveCart(ctx, cart)
+
+	type key struct {
+		order uuid.UUID
+		item  item
+	}
+
+	orders := make([]uuid.UUID, 0)
+	keys := make([]key, 0)
+	quantities := make(map[key]int64)
+
+	for _, content := range cart {
+		if content == nil || content.OrderID == nil || *content.OrderID == uuid.Nil {
+			return nil, errors.ErrCartOrderNotProvided
+		}
+
+		if content.ProductID == uuid.Nil {
+			return nil, errors.ErrProductNotProvided
+		}
+
+		if content.Quantity < 0 {
+			return nil, &errors.ErrInvalidCartQuantity{
+				Quantity: content.Quantity,
+			}
+		}
+
+		k := key{*content.OrderID, lineitem(content)}
+
+		if _, ok := quantities[k]; !ok {
+			keys = append(keys, k)
+		}
+
+		quantities[k] += content.Quantity
+
+		if !contains(orders, *content.OrderID) {
+			orders = append(orders, *content.OrderID)
+		}
+	}
+
+	err := service.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		var changes []change
+
+		for _, k := range keys {
+			line, merged, err := merge(ctx, repo, orderowner(k.order), k.item)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, merged...)
+
+			changed, err := set(ctx, repo, orderowner(k.order), k.item, line, quantities[k])
+			changes = append(changes, changed...)
+			if err != nil {
+				return err
+			}
+		}
+
+		return service.audit(ctx, changes)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*lib.Cart, 0)
+
+	for _, id := range orders {
+		order := new(lib.Order)
+		order.ID = id
+
+		lines, err := service.repo.GetCart(ctx, order)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, lines...)
+	}
+
+	return result, nil
 }
 
 //GetCart simply accepts an order and returns (if any) products that are associated with it
@@ -67,42 +227,604 @@ func (service *Service) GetCart(ctx context.Context, order *lib.Order) ([]*lib.C
 	return service.repo.GetCart(ctx, order)
 }
 
+//OpenShoppingCart returns the shopping cart of the provided user, creating one if they don't
+//have one yet. Without a user a new guest cart is created, guests are given a cart token
+//(see IssueCartToken) to get back to it.
+func (service *Service) OpenShoppingCart(ctx context.Context, user *lib.User) (*lib.ShoppingCart, error) {
+	if user != nil {
+		cart, err := service.repo.GetUserShoppingCart(ctx, user.ID, time.Now())
+		if err != errors.ErrShoppingCartNotFound {
+			return cart, err
+		}
+	}
+
+	cart := &lib.ShoppingCart{
+		ExpiresAt: time.Now().Add(lib.CartExpiry),
+		Lines:     make([]*lib.Cart, 0),
+	}
+
+	if user != nil {
+		id := user.ID
+		cart.UserID = &id
+	}
+
+	if err := service.repo.CreateShoppingCart(ctx, cart); err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+//GetShoppingCart returns the shopping cart and its lines, expired carts are treated as if they
+//don't exist anymore
+func (service *Service) GetShoppingCart(ctx context.Context, id uuid.UUID) (*lib.ShoppingCart, error) {
+	return service.repo.GetShoppingCart(ctx, id, time.Now())
+}
+
+//SaveShoppingCartProduct applies the provided action for a product to the shopping cart, with
+//the same rules as SaveProduct. Every change pushes back the expiry of the cart.
+func (service *Service) SaveShoppingCartProduct(ctx context.Context, cart *lib.ShoppingCart, product *lib.Product, variant *lib.ProductVariant, action lib.CartAction, quantity int) (*lib.ShoppingCart, error) {
+	if cart == nil {
+		return nil, errors.ErrShoppingCartNotFound
+	}
+
+	if product == nil && action != lib.ClearCart {
+		return nil, errors.ErrProductNotProvided
+	}
+
+	err := service.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		now := time.Now()
+
+		if _, err := repo.GetShoppingCart(ctx, cart.ID, now); err != nil {
+			return err
+		}
+
+		changes, err := apply(ctx, repo, cartowner(cart.ID), product, variant, action, quantity)
+		if err != nil {
+			return err
+		}
+
+		if err := repo.TouchShoppingCart(ctx, cart.ID, now.Add(lib.CartExpiry)); err != nil {
+			return err
+		}
+
+		return service.audit(ctx, changes)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return service.repo.GetShoppingCart(ctx, cart.ID, time.Now())
+}
+
+//MergeShoppingCarts moves the lines of a guest cart into the cart of the user that just logged
+//in and removes the guest cart. Logging in shouldn't fail because of the cart, so quantities
+//that would go above the maximum or what is in stock are capped and products (or variants)
+//that can't be bought anymore are dropped.
+func (service *Service) MergeShoppingCarts(ctx context.Context, guest *lib.ShoppingCart, user *lib.User) (*lib.ShoppingCart, error) {
+	if guest == nil || user == nil {
+		return nil, errors.ErrShoppingCartNotFound
+	}
+
+	//a cart that already belongs to someone can't be claimed by another user
+	if guest.UserID != nil {
+		if *guest.UserID != user.ID {
+			return nil, errors.ErrShoppingCartNotFound
+		}
+		return service.GetShoppingCart(ctx, guest.ID)
+	}
+
+	target, err := service.OpenShoppingCart(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = service.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		var changes []change
+		now := time.Now()
+
+		source, err := repo.GetShoppingCart(ctx, guest.ID, now)
+		if err != nil {
+			return err
+		}
+
+		for _, incoming := range source.Lines {
+			it := lineitem(incoming)
+
+			line, merged, err := merge(ctx, repo, cartowner(target.ID), it)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, merged...)
+
+			quantity := incoming.Quantity
+			if line != nil {
+				quantity += line.Quantity
+			}
+
+			stock, err := repo.GetStock(ctx, it)
+			if unavailable(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if quantity > lib.MaxCartQuantity {
+				quantity = lib.MaxCartQuantity
+			}
+
+			if quantity > stock {
+				quantity = stock
+			}
+
+			changed, err := set(ctx, repo, cartowner(target.ID), it, line, quantity)
+			changes = append(changes, changed...)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := repo.DeleteShoppingCart(ctx, source); err != nil {
+			return err
+		}
+
+		if err := repo.TouchShoppingCart(ctx, target.ID, now.Add(lib.CartExpiry)); err != nil {
+			return err
+		}
+
+		return service.audit(ctx, changes)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return service.repo.GetShoppingCart(ctx, target.ID, time.Now())
+}
+
+//DeleteExpiredShoppingCarts removes every shopping cart (and its lines) that expired before the
+//provided time, it returns how many were removed
+func (service *Service) DeleteExpiredShoppingCarts(ctx context.Context, now time.Time) (int64, error) {
+	return service.repo.DeleteExpiredShoppingCarts(ctx, now)
+}
+
+type cartclaims struct {
+	CartID string `json:"cart_id"`
+	jwt.RegisteredClaims
+}
+
+//IssueCartToken issues the signed token a guest uses to get back to their shopping cart. The
+//token doesn't expire by itself, it stops working once the cart expires.
+func (service *Service) IssueCartToken(ctx context.Context, cart *lib.ShoppingCart) (string, error) {
+	if cart == nil || cart.ID == uuid.Nil {
+		return "", errors.ErrShoppingCartNotFound
+	}
+
+	claims := cartclaims{
+		CartID: cart.ID.String(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience: jwt.ClaimStrings{cartaudience},
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(service.JWTEnv.SigningKey(cartaudience))
+}
+
+//VerifyCartToken verifies a token issued by IssueCartToken and returns the id of its cart
+func (service *Service) VerifyCartToken(ctx context.Context, token string) (uuid.UUID, error) {
+	claims := new(cartclaims)
+
+	t, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return service.JWTEnv.SigningKey(cartaudience), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+	if err != nil || !t.Valid || !claims.VerifyAudience(cartaudience, true) {
+		return uuid.Nil, errors.ErrInvalidCartToken
+	}
+
+	id, err := uuid.Parse(claims.CartID)
+	if err != nil {
+		return uuid.Nil, errors.ErrInvalidCartToken
+	}
+
+	return id, nil
+}
+
+//audit records the changes to the lines, it must be called within the transaction they were
+//made in
+func (service *Service) audit(ctx context.Context, changes []change) error {
+	for _, c := range changes {
+		action := lib.AuditActionUpdate
+
+		switch {
+		case c.before == nil:
+			action = lib.AuditActionCreate
+		case c.after == nil:
+			action = lib.AuditActionDelete
+		}
+
+		if err := service.Audit(ctx, action, lib.AuditEntityCart, c.id, c.before, c.after); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//apply applies a cart action for a product to the lines of the owner, it must be called within
+//a transaction
+func apply(ctx context.Context, repo repoi, o owner, product *lib.Product, variant *lib.ProductVariant, action lib.CartAction, quantity int) ([]change, error) {
+	var changes []change
+
+	switch action {
+	case lib.AddProduct:
+		if quantity <= 0 {
+			return nil, &errors.ErrInvalidCartQuantity{
+				Quantity: int64(quantity),
+			}
+		}
+
+		line, merged, err := merge(ctx, repo, o, newitem(product, variant))
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, merged...)
+
+		current := int64(0)
+		if line != nil {
+			current = line.Quantity
+		}
+
+		changed, err := set(ctx, repo, o, newitem(product, variant), line, current+int64(quantity))
+		return append(changes, changed...), err
+	case lib.SetQuantity, lib.RemoveProduct:
+		if action == lib.RemoveProduct {
+			quantity = 0
+		}
+
+		if quantity < 0 {
+			return nil, &errors.ErrInvalidCartQuantity{
+				Quantity: int64(quantity),
+			}
+		}
+
+		line, merged, err := merge(ctx, repo, o, newitem(product, variant))
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, merged...)
+
+		changed, err := set(ctx, repo, o, newitem(product, variant), line, int64(quantity))
+		return append(changes, changed...), err
+	case lib.ClearCart:
+		lines, err := repo.GetLines(ctx, o, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range lines {
+			if err := repo.DeleteLine(ctx, line); err != nil {
+				return nil, err
+			}
+			changes = append(changes, change{line.ID, line, nil})
+		}
+
+		return changes, nil
+	default:
+		return nil, &errors.ErrCartActionNotRecognized{
+			Action: string(action),
+		}
+	}
+}
+
+//merge locks the lines of the item that belong to the owner and merges them into a single
+//line, which is returned (nil when the item isn't in the cart)
+func merge(ctx context.Context, repo repoi, o owner, it item) (*lib.Cart, []change, error) {
+	lines, err := repo.GetLines(ctx, o, &it)
+	if err != nil || len(lines) == 0 {
+		return nil, nil, err
+	}
+
+	line := lines[0]
+	if len(lines) == 1 {
+		return line, nil, nil
+	}
+
+	changes := make([]change, 0, len(lines))
+	before := *line
+
+	for _, duplicate := range lines[1:] {
+		line.Quantity += duplicate.Quantity
+
+		if err := repo.DeleteLine(ctx, duplicate); err != nil {
+			return nil, nil, err
+		}
+		changes = append(changes, change{duplicate.ID, duplicate, nil})
+	}
+
+	if err := repo.SaveLine(ctx, line); err != nil {
+		return nil, nil, err
+	}
+
+	after := *line
+	changes = append(changes, change{line.ID, &before, &after})
+
+	return line, changes, nil
+}
+
+//set sets the quantity of the provided line (nil when the item isn't in the cart yet),
+//making sure it is within the maximum quantity and what is in stock
+func set(ctx context.Context, repo repoi, o owner, it item, line *lib.Cart, quantity int64) ([]change, error) {
+	if quantity == 0 {
+		if line == nil {
+			return nil, nil
+		}
+
+		if err := repo.DeleteLine(ctx, line); err != nil {
+			return nil, err
+		}
+
+		return []change{{line.ID, line, nil}}, nil
+	}
+
+	if quantity > lib.MaxCartQuantity {
+		return nil, &errors.ErrCartQuantityExceeded{
+			ProductID: it.product,
+			Quantity:  quantity,
+			Max:       lib.MaxCartQuantity,
+		}
+	}
+
+	stock, err := repo.GetStock(ctx, it)
+	if err != nil {
+		return nil, err
+	}
+
+	if quantity > stock {
+		return nil, &errors.ErrInsufficientStock{
+			ProductID: it.product,
+			VariantID: it.variantid(),
+			Requested: quantity,
+			Available: stock,
+		}
+	}
+
+	var before *lib.Cart
+
+	if line == nil {
+		line = o.line(it)
+	} else {
+		previous := *line
+		before = &previous
+	}
+
+	if before != nil && before.Quantity == quantity {
+		return nil, nil
+	}
+
+	line.Quantity = quantity
+
+	if err := repo.SaveLine(ctx, line); err != nil {
+		return nil, err
+	}
+
+	after := *line
+
+	return []change{{line.ID, before, &after}}, nil
+}
+
+//unavailable reports whether the error is because the item can't be bought anymore
+func unavailable(err error) bool {
+	switch err.(type) {
+	case *errors.ErrNoProductFound, *errors.ErrVariantNotProvided:
+		return true
+	}
+	return err == errors.ErrVariantNotFound
+}
+
+func contains(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
 type repoi interface {
-	AddProduct(ctx context.Context, order *lib.Order, product *lib.Product, quantity int) error
-	SaveCart(ctx context.Context, cart []*lib.Cart) ([]*lib.Cart, error)
-	RemoveProduct(ctx context.Context, order *lib.Order, product *lib.Product) error
+	Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error
+	GetLines(ctx context.Context, o owner, it *item) ([]*lib.Cart, error)
+	GetStock(ctx context.Context, it item) (int64, error)
 	GetCart(context.Context, *lib.Order) ([]*lib.Cart, error)
+	SaveLine(ctx context.Context, line *lib.Cart) error
+	DeleteLine(ctx context.Context, line *lib.Cart) error
+	CreateShoppingCart(ctx context.Context, cart *lib.ShoppingCart) error
+	GetShoppingCart(ctx context.Context, id uuid.UUID, now time.Time) (*lib.ShoppingCart, error)
+	GetUserShoppingCart(ctx context.Context, user uuid.UUID, now time.Time) (*lib.ShoppingCart, error)
+	TouchShoppingCart(ctx context.Context, id uuid.UUID, expires time.Time) error
+	DeleteShoppingCart(ctx context.Context, cart *lib.ShoppingCart) error
+	DeleteExpiredShoppingCarts(ctx context.Context, now time.Time) (int64, error)
 }
 
 type repo struct {
 	*gorm.DB
 }
 
-//RemoveProduct gives us a simple way of directly writing to the cart table w/o any validation
-//other than the ones that are within the grom module itself. If you want any sort of validation
-//you should do it in the service itself
-func (repo *repo) RemoveProduct(ctx context.Context, order *lib.Order, product *lib.Product) error {
-	return repo.DB.Delete(new(lib.Cart), "order_id = ?", order.ID, "product_id = ?", product.ID).Error
+//Transaction runs the provided function with a repo that is bound to a single transaction,
+//the transaction is rolled back if the function returns an error. The context the function is
+//given is bound to the transaction as well, so the mutations are audited within it.
+func (r *repo) Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(lib.WithAuditTx(ctx, tx), &repo{tx})
+	})
 }
 
-//AddProduct: tldr; adds a product to the provided order directly into the cart table.
-//gives us a simple way of directly writing to the cart table w/o any validation
-//other than the ones that are within the gorm module itself. If you want any sort of validation
-//you should do it in the service itself
-func (repo *repo) AddProduct(ctx context.Context, order *lib.Order, product *lib.Product, quantity int) error {
-	return repo.DB.Save(new(lib.Cart)).Error
+//GetLines returns the lines of the owner for the provided item (every line of the owner
+//when no item is provided) and locks them until the transaction ends
+func (repo *repo) GetLines(ctx context.Context, o owner, it *item) (lines []*lib.Cart, err error) {
+	tx := repo.DB.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where(o.column+" = ?", o.id)
+
+	if it != nil {
+		tx = tx.Where("product_id = ?", it.product)
+
+		if it.variant != uuid.Nil {
+			tx = tx.Where("variant_id = ?", it.variant)
+		} else {
+			tx = tx.Where("variant_id IS NULL")
+		}
+	}
+
+	lines = make([]*lib.Cart, 0)
+	err = tx.Order("created_at ASC").Find(&lines).Error
+	return
+}
+
+//GetStock returns how many of the item that is being added to a cart are in stock. The
+//product, or the variant, is locked until the transaction ends so its stock can't change while
+//the cart is being saved. Products with variants are only stocked by variant.
+func (repo *repo) GetStock(ctx context.Context, it item) (int64, error) {
+	if it.variant != uuid.Nil {
+		variant := new(lib.ProductVariant)
+
+		err := repo.DB.Clauses(clause.Locking{Strength: "SHARE"}).
+			Joins("JOIN products ON products.id = product_variants.product_id AND products.deleted_at IS NULL").
+			First(variant, "product_variants.id = ? AND product_variants.product_id = ?", it.variant, it.product).Error
+
+		if err == gorm.ErrRecordNotFound {
+			return 0, errors.ErrVariantNotFound
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		return int64(variant.Inventory), nil
+	}
+
+	product := new(lib.Product)
+
+	err := repo.DB.Clauses(clause.Locking{Strength: "SHARE"}).
+		First(product, "id = ?", it.product).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return 0, &errors.ErrNoProductFound{
+			ID: it.product,
+		}
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	var variants int64
+	if err := repo.DB.Model(new(lib.ProductVariant)).Where("product_id = ?", it.product).Count(&variants).Error; err != nil {
+		return 0, err
+	}
+
+	if variants > 0 {
+		return 0, &errors.ErrVariantNotProvided{
+			ProductID: it.product,
+		}
+	}
+
+	return int64(product.Inventory), nil
 }
 
 //GetCart accepts an entire order and returns any products and the quantity that have been
 //added to the order.
 func (repo *repo) GetCart(ctx context.Context, order *lib.Order) (cart []*lib.Cart, err error) {
-	repo.DB.Model(new(lib.Cart)).Find(&cart, "order_id = ?", order.ID)
+	cart = make([]*lib.Cart, 0)
+	err = repo.DB.WithContext(ctx).Model(new(lib.Cart)).Order("created_at ASC").Find(&cart, "order_id = ?", order.ID).Error
 	return
 }
 
-func (repo *repo) SaveCart(ctx context.Context, cart []*lib.Cart) (result []*lib.Cart, err error) {
+func (repo *repo) SaveLine(ctx context.Context, line *lib.Cart) error {
+	return repo.DB.Save(line).Error
+}
+
+func (repo *repo) DeleteLine(ctx context.Context, line *lib.Cart) error {
+	//shopping carts are short lived, their lines aren't kept around
+	if line.ShoppingCartID != nil {
+		return repo.DB.Unscoped().Delete(line).Error
+	}
+	return repo.DB.Delete(line).Error
+}
+
+func (repo *repo) CreateShoppingCart(ctx context.Context, cart *lib.ShoppingCart) error {
+	return repo.DB.WithContext(ctx).Create(cart).Error
+}
+
+func (repo *repo) GetShoppingCart(ctx context.Context, id uuid.UUID, now time.Time) (*lib.ShoppingCart, error) {
+	cart := new(lib.ShoppingCart)
+
+	err := repo.DB.WithContext(ctx).
+		Preload("Lines", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		}).
+		First(cart, "id = ? AND expires_at > ?", id, now).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrShoppingCartNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+func (repo *repo) GetUserShoppingCart(ctx context.Context, user uuid.UUID, now time.Time) (*lib.ShoppingCart, error) {
+	cart := new(lib.ShoppingCart)
 
-	err = repo.DB.Save(cart).Error
+	err := repo.DB.WithContext(ctx).
+		Preload("Lines", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		}).
+		Order("updated_at DESC").
+		First(cart, "user_id = ? AND expires_at > ?", user, now).Error
 
-	return cart, err
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrShoppingCartNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+func (repo *repo) TouchShoppingCart(ctx context.Context, id uuid.UUID, expires time.Time) error {
+	return repo.DB.Model(new(lib.ShoppingCart)).
+		Where("id = ?", id).
+		Update("expires_at", expires).Error
+}
+
+func (repo *repo) DeleteShoppingCart(ctx context.Context, cart *lib.ShoppingCart) error {
+	if err := repo.DB.Unscoped().Where("shopping_cart_id = ?", cart.ID).Delete(new(lib.Cart)).Error; err != nil {
+		return err
+	}
+
+	return repo.DB.Unscoped().Delete(cart).Error
+}
+
+func (repo *repo) DeleteExpiredShoppingCarts(ctx context.Context, now time.Time) (deleted int64, err error) {
+	err = repo.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(new(lib.ShoppingCart)).Unscoped().Select("id").Where("expires_at <= ?", now)
+
+		if err := tx.Unscoped().Where("shopping_cart_id IN (?)", expired).Delete(new(lib.Cart)).Error; err != nil {
+			return err
+		}
+
+		result := tx.Unscoped().Where("expires_at <= ?", now).Delete(new(lib.ShoppingCart))
+		deleted = result.RowsAffected
+		return result.Error
+	})
+
+	return
 }
@@ -1,33 +1,576 @@
 package cart_test
 
 import (
+	"context"
+	"testing"
+	"time"
+
 	commons "github.com/cryptnode-software/commons/pkg"
 	"github.com/cryptnode-software/pisces/lib"
 	"github.com/cryptnode-software/pisces/lib/cart"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
 )
 
 var (
-	service, err = cart.NewService(
-		lib.NewEnv(
-			commons.NewLogger(commons.EnvDev),
-		),
+	env = lib.NewEnv(
+		commons.NewLogger(commons.EnvDev),
 	)
+
+	service, err = cart.NewService(env)
+
+	ctx = context.Background()
 )
 
-// func TestGetCart(t *testing.T) {
-// 	tables := []struct {
-// 		order *lib.Order
-// 		cart  *lib.Cart
-// 		fail  bool
-// 	}{
-// 		{
-// 			fail:  true,
-// 			order: &lib.Order{
-
-// 			},
-// 			cart: &lib.Cart{
-// 				Product: &lib.Product{},
-// 			},
-// 		},
-// 	}
-// }
+func TestSaveProduct(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product, err := seedproduct(10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	tables := []struct {
+		//existing are the quantities of the lines that are already in the cart
+		existing []int64
+		action   lib.CartAction
+		quantity int
+		expected []int64
+		err      error
+	}{
+		{
+			action:   lib.AddProduct,
+			quantity: 2,
+			expected: []int64{2},
+		},
+		{
+			existing: []int64{2},
+			action:   lib.AddProduct,
+			quantity: 3,
+			expected: []int64{5},
+		},
+		{
+			//duplicate lines are merged into one
+			existing: []int64{1, 2},
+			action:   lib.AddProduct,
+			quantity: 1,
+			expected: []int64{4},
+		},
+		{
+			existing: []int64{2},
+			action:   lib.SetQuantity,
+			quantity: 7,
+			expected: []int64{7},
+		},
+		{
+			existing: []int64{2},
+			action:   lib.SetQuantity,
+			quantity: 0,
+			expected: []int64{},
+		},
+		{
+			existing: []int64{2, 3},
+			action:   lib.RemoveProduct,
+			expected: []int64{},
+		},
+		{
+			existing: []int64{2},
+			action:   lib.ClearCart,
+			expected: []int64{},
+		},
+		{
+			existing: []int64{2},
+			action:   lib.AddProduct,
+			quantity: 0,
+			expected: []int64{2},
+			err: &perrors.ErrInvalidCartQuantity{
+				Quantity: 0,
+			},
+		},
+		{
+			existing: []int64{2},
+			action:   lib.SetQuantity,
+			quantity: -1,
+			expected: []int64{2},
+			err: &perrors.ErrInvalidCartQuantity{
+				Quantity: -1,
+			},
+		},
+		{
+			//nothing is changed when there isn't enough in stock, not
+			//even the duplicate lines are merged
+			existing: []int64{2, 2},
+			action:   lib.AddProduct,
+			quantity: 7,
+			expected: []int64{2, 2},
+			err: &perrors.ErrInsufficientStock{
+				ProductID: product.ID,
+				Requested: 11,
+				Available: 10,
+			},
+		},
+		{
+			existing: []int64{2},
+			action:   lib.SetQuantity,
+			quantity: lib.MaxCartQuantity + 1,
+			expected: []int64{2},
+			err: &perrors.ErrCartQuantityExceeded{
+				ProductID: product.ID,
+				Quantity:  lib.MaxCartQuantity + 1,
+				Max:       lib.MaxCartQuantity,
+			},
+		},
+		{
+			existing: []int64{2},
+			action:   lib.CartAction("DOUBLE"),
+			quantity: 1,
+			expected: []int64{2},
+			err: &perrors.ErrCartActionNotRecognized{
+				Action: "DOUBLE",
+			},
+		},
+	}
+
+	for _, table := range tables {
+		order, err := seedorder(product, table.existing...)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		_, err = service.SaveProduct(ctx, order, product, nil, table.action, table.quantity)
+		assert.Equal(t, table.err, err, "%s %d on %v", table.action, table.quantity, table.existing)
+
+		result, err := service.GetCart(ctx, order)
+		if err != nil {
+			t.Error(err)
+		}
+
+		assert.Equal(t, table.expected, quantities(result), "%s %d on %v", table.action, table.quantity, table.existing)
+
+		if err := deseed(order); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSaveProductVariant(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product, err := seedproduct(10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	small := &lib.ProductVariant{ProductID: product.ID, SKU: "COOKIES-S-" + uuid.New().String()[:8], Inventory: 3}
+	large := &lib.ProductVariant{ProductID: product.ID, SKU: "COOKIES-L-" + uuid.New().String()[:8], Inventory: 5}
+
+	for _, variant := range []*lib.ProductVariant{small, large} {
+		if err := env.GormDB.Create(variant).Error; err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	order, err := seedorder(product)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer deseed(order)
+
+	//a product with variants is stocked by variant, so one has to be picked
+	_, err = service.SaveProduct(ctx, order, product, nil, lib.AddProduct, 1)
+	assert.Equal(t, &perrors.ErrVariantNotProvided{ProductID: product.ID}, err)
+
+	//every variant gets a line of its own
+	_, err = service.SaveProduct(ctx, order, product, small, lib.AddProduct, 2)
+	assert.Nil(t, err)
+
+	cart, err := service.SaveProduct(ctx, order, product, large, lib.AddProduct, 4)
+	if assert.Nil(t, err) && assert.Len(t, cart, 2) {
+		assert.Equal(t, &small.ID, cart[0].VariantID)
+		assert.Equal(t, &large.ID, cart[1].VariantID)
+	}
+
+	//the stock of the variant is what counts, not the one of the product
+	_, err = service.SaveProduct(ctx, order, product, small, lib.AddProduct, 2)
+	assert.Equal(t, &perrors.ErrInsufficientStock{
+		ProductID: product.ID,
+		VariantID: &small.ID,
+		Requested: 4,
+		Available: 3,
+	}, err)
+
+	other, err := seedproduct(10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(other)
+
+	_, err = service.SaveProduct(ctx, order, other, small, lib.AddProduct, 1)
+	assert.Equal(t, perrors.ErrVariantNotFound, err)
+
+	cart, err = service.SaveProduct(ctx, order, product, small, lib.RemoveProduct, 0)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int64{4}, quantities(cart))
+	}
+}
+
+func TestSaveCart(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product, err := seedproduct(10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	tables := []struct {
+		existing []int64
+		cart     []int64
+		expected []int64
+		err      error
+	}{
+		{
+			cart:     []int64{3},
+			expected: []int64{3},
+		},
+		{
+			//lines for the same product are merged, in the cart that is
+			//provided and with the lines that are already stored
+			existing: []int64{1, 1},
+			cart:     []int64{2, 4},
+			expected: []int64{6},
+		},
+		{
+			existing: []int64{2},
+			cart:     []int64{0},
+			expected: []int64{},
+		},
+		{
+			existing: []int64{2},
+			cart:     []int64{6, 6},
+			expected: []int64{2},
+			err: &perrors.ErrInsufficientStock{
+				ProductID: product.ID,
+				Requested: 12,
+				Available: 10,
+			},
+		},
+		{
+			existing: []int64{2},
+			cart:     []int64{-1},
+			expected: []int64{2},
+			err: &perrors.ErrInvalidCartQuantity{
+				Quantity: -1,
+			},
+		},
+	}
+
+	for _, table := range tables {
+		order, err := seedorder(product, table.existing...)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		contents := make([]*lib.Cart, 0, len(table.cart))
+		for _, quantity := range table.cart {
+			contents = append(contents, &lib.Cart{
+				OrderID:   &order.ID,
+				ProductID: product.ID,
+				Quantity:  quantity,
+			})
+		}
+
+		_, err = service.SaveCart(ctx, contents)
+		assert.Equal(t, table.err, err, "%v on %v", table.cart, table.existing)
+
+		result, err := service.GetCart(ctx, order)
+		if err != nil {
+			t.Error(err)
+		}
+
+		assert.Equal(t, table.expected, quantities(result), "%v on %v", table.cart, table.existing)
+
+		if err := deseed(order); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestMergeShoppingCarts(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product, err := seedproduct(10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	tables := []struct {
+		guest    []int64
+		saved    []int64
+		expected []int64
+	}{
+		{
+			guest:    []int64{2},
+			expected: []int64{2},
+		},
+		{
+			guest:    []int64{2},
+			saved:    []int64{3},
+			expected: []int64{5},
+		},
+		{
+			//merging never fails a login, the quantity is capped by
+			//what is in stock instead
+			guest:    []int64{6},
+			saved:    []int64{7},
+			expected: []int64{10},
+		},
+		{
+			saved:    []int64{3},
+			expected: []int64{3},
+		},
+	}
+
+	for _, table := range tables {
+		user := new(lib.User)
+		user.ID = uuid.New()
+
+		guest, err := seedshoppingcart(nil, product, table.guest...)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		saved, err := seedshoppingcart(user, product, table.saved...)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		merged, err := service.MergeShoppingCarts(ctx, guest, user)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		assert.Equal(t, saved.ID, merged.ID)
+		assert.Equal(t, table.expected, quantities(merged.Lines), "%v into %v", table.guest, table.saved)
+
+		//the guest cart is gone once it has been merged
+		_, err = service.GetShoppingCart(ctx, guest.ID)
+		assert.Equal(t, perrors.ErrShoppingCartNotFound, err)
+
+		//and can't be claimed by anyone else
+		other := new(lib.User)
+		other.ID = uuid.New()
+
+		_, err = service.MergeShoppingCarts(ctx, merged, other)
+		assert.Equal(t, perrors.ErrShoppingCartNotFound, err)
+
+		if err := deseedshoppingcart(merged); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestDeleteExpiredShoppingCarts(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product, err := seedproduct(10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	cart, err := seedshoppingcart(nil, product, 2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//nothing has expired yet
+	if _, err := service.DeleteExpiredShoppingCarts(ctx, time.Now()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := service.GetShoppingCart(ctx, cart.ID); err != nil {
+		t.Error(err)
+		return
+	}
+
+	deleted, err := service.DeleteExpiredShoppingCarts(ctx, time.Now().Add(lib.CartExpiry+time.Minute))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	assert.GreaterOrEqual(t, deleted, int64(1))
+
+	var lines int64
+	env.GormDB.Unscoped().Model(new(lib.Cart)).Where("shopping_cart_id = ?", cart.ID).Count(&lines)
+	assert.Equal(t, int64(0), lines)
+}
+
+func TestCartToken(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	cart := new(lib.ShoppingCart)
+	cart.ID = uuid.New()
+
+	token, err := service.IssueCartToken(ctx, cart)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tables := []struct {
+		token    string
+		expected uuid.UUID
+		err      error
+	}{
+		{
+			token:    token,
+			expected: cart.ID,
+		},
+		{
+			token:    token + "tampered",
+			expected: uuid.Nil,
+			err:      perrors.ErrInvalidCartToken,
+		},
+		{
+			token:    "",
+			expected: uuid.Nil,
+			err:      perrors.ErrInvalidCartToken,
+		},
+	}
+
+	for _, table := range tables {
+		id, err := service.VerifyCartToken(ctx, table.token)
+		assert.Equal(t, table.err, err)
+		assert.Equal(t, table.expected, id)
+	}
+}
+
+func quantities(cart []*lib.Cart) []int64 {
+	result := make([]int64, 0, len(cart))
+	for _, line := range cart {
+		result = append(result, line.Quantity)
+	}
+	return result
+}
+
+func seedproduct(inventory int) (*lib.Product, error) {
+	product := &lib.Product{
+		Name:      "A dozen cookies",
+		Cost:      12,
+		Inventory: inventory,
+	}
+
+	return product, env.GormDB.Create(product).Error
+}
+
+//seedorder creates an order with a line for the product for every provided quantity
+func seedorder(product *lib.Product, existing ...int64) (*lib.Order, error) {
+	order := &lib.Order{
+		PaymentMethod: lib.PaymentMethodNotImplemented,
+		Status:        lib.OrderStatusUserPending,
+		Due:           time.Now().Add(24 * time.Hour),
+		Inquiry: &lib.Inquiry{
+			Description: "Magna ipsum culpa labore pariatur elit commodo consequat esse est.",
+			Email:       "test.user@test.io",
+		},
+	}
+
+	if err := env.GormDB.Create(order).Error; err != nil {
+		return nil, err
+	}
+
+	for _, quantity := range existing {
+		line := &lib.Cart{
+			OrderID:   &order.ID,
+			ProductID: product.ID,
+			Quantity:  quantity,
+		}
+
+		if err := env.GormDB.Create(line).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+func deseed(order *lib.Order) error {
+	if err := env.GormDB.Unscoped().Where("order_id = ?", order.ID).Delete(new(lib.Cart)).Error; err != nil {
+		return err
+	}
+
+	if err := env.GormDB.Unscoped().Delete(order).Error; err != nil {
+		return err
+	}
+
+	return env.GormDB.Unscoped().Delete(order.Inquiry).Error
+}
+
+//seedshoppingcart creates a shopping cart with a line for the product for every provided quantity
+func seedshoppingcart(user *lib.User, product *lib.Product, existing ...int64) (*lib.ShoppingCart, error) {
+	cart, err := service.OpenShoppingCart(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, quantity := range existing {
+		line := &lib.Cart{
+			ShoppingCartID: &cart.ID,
+			ProductID:      product.ID,
+			Quantity:       quantity,
+		}
+
+		if err := env.GormDB.Create(line).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return cart, nil
+}
+
+func deseedshoppingcart(cart *lib.ShoppingCart) error {
+	if err := env.GormDB.Unscoped().Where("shopping_cart_id = ?", cart.ID).Delete(new(lib.Cart)).Error; err != nil {
+		return err
+	}
+
+	return env.GormDB.Unscoped().Delete(cart).Error
+}
@@ -0,0 +1,250 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/google/uuid"
+)
+
+// CatalogService organizes the products of the storefront into categories, tags and
+// collections. Categories are hierarchical, tags are free-form and collections are curated
+// lists of products in the order they were put in.
+type CatalogService interface {
+	GetCategories(ctx context.Context) ([]*Category, error)
+	SaveCategory(ctx context.Context, category *Category) (*Category, error)
+	DeleteCategory(ctx context.Context, category *Category) error
+	SetProductCategories(ctx context.Context, product uuid.UUID, categories []uuid.UUID) error
+	GetTags(ctx context.Context) ([]*Tag, error)
+	SetProductTags(ctx context.Context, product uuid.UUID, tags []string) error
+	GetCollections(ctx context.Context) ([]*Collection, error)
+	GetCollection(ctx context.Context, id uuid.UUID) (*Collection, error)
+	SaveCollection(ctx context.Context, collection *Collection) (*Collection, error)
+	DeleteCollection(ctx context.Context, collection *Collection) error
+	SetCollectionProducts(ctx context.Context, collection uuid.UUID, products []uuid.UUID) error
+}
+
+// Category a node of the category tree, categories without a parent are at the top of it.
+// Position orders a category among its siblings.
+type Category struct {
+	Name     string
+	Slug     string
+	ParentID *uuid.UUID
+	Position int
+	//Children is only filled in by GetCategories
+	Children []*Category `gorm:"-"`
+	commons.Model
+}
+
+// Tag a free-form label of a product, tags are created as they are used
+type Tag struct {
+	Name      string `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+// Collection a curated list of products, i.e. "holiday specials"
+type Collection struct {
+	Name        string
+	Slug        string
+	Description string
+	//Products is only filled in by GetCollection, in the order they were curated in
+	Products []*Product `gorm:"-"`
+	commons.Model
+}
+
+// CollectionProduct places a product in a collection at the provided position
+type CollectionProduct struct {
+	CollectionID uuid.UUID `gorm:"primaryKey"`
+	ProductID    uuid.UUID `gorm:"primaryKey"`
+	Position     int
+}
+
+// NewSlug returns the url friendly version of the provided name, i.e. "Cookies & Cream"
+// becomes "cookies-cream"
+func NewSlug(name string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+
+		if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// NewTagName returns the name a tag is stored under, tags are matched regardless of case
+// and surrounding whitespace
+func NewTagName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// CategoryDescendants returns the id of the category along with the ids of every category
+// below it in the tree that is made of the provided categories
+func CategoryDescendants(categories []*Category, id uuid.UUID) []uuid.UUID {
+	children := make(map[uuid.UUID][]uuid.UUID)
+	for _, category := range categories {
+		if category.ParentID != nil {
+			children[*category.ParentID] = append(children[*category.ParentID], category.ID)
+		}
+	}
+
+	result := []uuid.UUID{id}
+	seen := map[uuid.UUID]bool{id: true}
+
+	for i := 0; i < len(result); i++ {
+		for _, child := range children[result[i]] {
+			if !seen[child] {
+				seen[child] = true
+				result = append(result, child)
+			}
+		}
+	}
+
+	return result
+}
+
+// WithProductCategory only returns the products of the category, or of any category
+// below it
+func WithProductCategory(id uuid.UUID) WithGetProductsOptions {
+	return func(o *GetProductsOption) error {
+		if id == uuid.Nil {
+			return nil
+		}
+		o.CategoryID = &id
+		return nil
+	}
+}
+
+// WithProductTag only returns the products that are tagged with the tag
+func WithProductTag(tag string) WithGetProductsOptions {
+	return func(o *GetProductsOption) error {
+		if tag = NewTagName(tag); tag == "" {
+			return nil
+		}
+		o.Tag = &tag
+		return nil
+	}
+}
+
+// WithProductCatalog loads the categories and tags of the products along with them
+func WithProductCatalog() WithGetProductsOptions {
+	return func(o *GetProductsOption) error {
+		o.Catalog = true
+		return nil
+	}
+}
+
+// GetCategories returns the category tree, it is public so the storefront can build its
+// navigation from it
+func (g *Gateway) GetCategories(ctx context.Context) ([]*Category, error) {
+	return g.services.CatalogService.GetCategories(ctx)
+}
+
+// GetCollections returns every collection without its products
+func (g *Gateway) GetCollections(ctx context.Context) ([]*Collection, error) {
+	return g.services.CatalogService.GetCollections(ctx)
+}
+
+// GetCollection returns the collection along with its products in order
+func (g *Gateway) GetCollection(ctx context.Context, id uuid.UUID) (*Collection, error) {
+	return g.services.CatalogService.GetCollection(ctx, id)
+}
+
+// BrowseProducts returns the products filtered by the provided options, i.e. the products
+// of a category or tag
+func (g *Gateway) BrowseProducts(ctx context.Context, opts ...WithGetProductsOptions) ([]*Product, error) {
+	return g.services.ProductService.GetProducts(ctx, opts...)
+}
+
+// BrowseProductsPage returns a single page of the products filtered and sorted by the
+// provided options
+func (g *Gateway) BrowseProductsPage(ctx context.Context, opts ...WithGetProductsOptions) (*ProductPage, error) {
+	return g.services.ProductService.GetProductsPage(ctx, opts...)
+}
+
+// SaveCategory creates or updates a category
+func (g *Gateway) SaveCategory(ctx context.Context, category *Category) (*Category, error) {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return nil, err
+	}
+
+	return g.services.CatalogService.SaveCategory(ctx, category)
+}
+
+// DeleteCategory deletes a category that has no categories below it
+func (g *Gateway) DeleteCategory(ctx context.Context, category *Category) error {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return err
+	}
+
+	return g.services.CatalogService.DeleteCategory(ctx, category)
+}
+
+// SetProductCategories replaces the categories of the product
+func (g *Gateway) SetProductCategories(ctx context.Context, product uuid.UUID, categories []uuid.UUID) error {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return err
+	}
+
+	if err := g.services.CatalogService.SetProductCategories(ctx, product, categories); err != nil {
+		return err
+	}
+
+	g.reindex(ctx, product)
+	return nil
+}
+
+// SetProductTags replaces the tags of the product
+func (g *Gateway) SetProductTags(ctx context.Context, product uuid.UUID, tags []string) error {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return err
+	}
+
+	if err := g.services.CatalogService.SetProductTags(ctx, product, tags); err != nil {
+		return err
+	}
+
+	g.reindex(ctx, product)
+	return nil
+}
+
+// SaveCollection creates or updates a collection, its products are set with
+// SetCollectionProducts
+func (g *Gateway) SaveCollection(ctx context.Context, collection *Collection) (*Collection, error) {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return nil, err
+	}
+
+	return g.services.CatalogService.SaveCollection(ctx, collection)
+}
+
+// DeleteCollection deletes a collection, the products in it are left alone
+func (g *Gateway) DeleteCollection(ctx context.Context, collection *Collection) error {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return err
+	}
+
+	return g.services.CatalogService.DeleteCollection(ctx, collection)
+}
+
+// SetCollectionProducts replaces the products of the collection, they are kept in the
+// order they are provided in
+func (g *Gateway) SetCollectionProducts(ctx context.Context, collection uuid.UUID, products []uuid.UUID) error {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return err
+	}
+
+	return g.services.CatalogService.SetCollectionProducts(ctx, collection, products)
+}
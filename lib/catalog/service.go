@@ -0,0 +1,676 @@
+package catalog
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//Service the catalog service, organizes the products into categories, tags and collections
+type Service struct {
+	*lib.Env
+	repo repoi
+}
+
+//NewService returns a new catalog service
+func NewService(env *lib.Env) (lib.CatalogService, error) {
+	return &Service{
+		env,
+		&repo{
+			env.GormDB,
+		},
+	}, nil
+}
+
+//GetCategories returns the top of the category tree, every category holds the categories
+//right below it in Children. Siblings are sorted by their position and then by name.
+func (s *Service) GetCategories(ctx context.Context) ([]*lib.Category, error) {
+	categories, err := s.repo.GetCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[uuid.UUID]*lib.Category, len(categories))
+	for _, category := range categories {
+		nodes[category.ID] = category
+	}
+
+	roots := make([]*lib.Category, 0)
+
+	for _, category := range categories {
+		if category.ParentID != nil {
+			if parent, ok := nodes[*category.ParentID]; ok {
+				parent.Children = append(parent.Children, category)
+				continue
+			}
+		}
+
+		roots = append(roots, category)
+	}
+
+	return roots, nil
+}
+
+//SaveCategory creates a new category when it doesn't have an id yet and updates the
+//existing one otherwise. The slug is made from the name when none is provided.
+func (s *Service) SaveCategory(ctx context.Context, category *lib.Category) (*lib.Category, error) {
+	if category.Name = strings.TrimSpace(category.Name); category.Name == "" {
+		return nil, errors.ErrNoCatalogName
+	}
+
+	category.Slug = slug(category.Slug, category.Name)
+
+	if existing, err := s.repo.GetCategoryBySlug(ctx, category.Slug); err != nil {
+		return nil, err
+	} else if existing != nil && existing.ID != category.ID {
+		return nil, &errors.ErrSlugTaken{
+			Slug: category.Slug,
+		}
+	}
+
+	if category.ParentID != nil {
+		categories, err := s.repo.GetCategories(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if !contains(categories, *category.ParentID) {
+			return nil, errors.ErrCategoryNotFound
+		}
+
+		//a category can't be moved below itself or any of its descendants
+		if category.ID != uuid.Nil {
+			for _, id := range lib.CategoryDescendants(categories, category.ID) {
+				if id == *category.ParentID {
+					return nil, &errors.ErrCategoryCycle{
+						CategoryID: category.ID,
+						ParentID:   *category.ParentID,
+					}
+				}
+			}
+		}
+	}
+
+	if category.ID == uuid.Nil {
+		err := s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+			if err := repo.CreateCategory(ctx, category); err != nil {
+				return err
+			}
+
+			return s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityCategory, category.ID, nil, category)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return category, nil
+	}
+
+	before, err := s.repo.GetCategory(ctx, category.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var after *lib.Category
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+		if err := repo.UpdateCategory(ctx, category); err != nil {
+			return err
+		}
+
+		if after, err = repo.GetCategory(ctx, category.ID); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityCategory, category.ID, before, after)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return after, nil
+}
+
+//DeleteCategory deletes the category, its products are taken out of it but are left alone
+//otherwise. The categories below it have to be moved or deleted first.
+func (s *Service) DeleteCategory(ctx context.Context, category *lib.Category) error {
+	before, err := s.repo.GetCategory(ctx, category.ID)
+	if err != nil {
+		return err
+	}
+
+	categories, err := s.repo.GetCategories(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(lib.CategoryDescendants(categories, before.ID)) > 1 {
+		return &errors.ErrCategoryHasChildren{
+			CategoryID: before.ID,
+		}
+	}
+
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.DeleteCategory(ctx, before); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionDelete, lib.AuditEntityCategory, before.ID, before, nil)
+	})
+}
+
+//SetProductCategories replaces the categories of the product with the provided ones
+func (s *Service) SetProductCategories(ctx context.Context, product uuid.UUID, ids []uuid.UUID) error {
+	if err := s.product(ctx, product); err != nil {
+		return err
+	}
+
+	ids = unique(ids)
+
+	categories, err := s.repo.GetCategories(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if !contains(categories, id) {
+			return errors.ErrCategoryNotFound
+		}
+	}
+
+	before, err := s.repo.GetProductCategories(ctx, product)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.SetProductCategories(ctx, product, ids); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityProduct, product,
+			map[string]interface{}{"categories": before},
+			map[string]interface{}{"categories": ids},
+		)
+	})
+}
+
+//GetTags returns every tag that has been used, sorted by name
+func (s *Service) GetTags(ctx context.Context) ([]*lib.Tag, error) {
+	return s.repo.GetTags(ctx)
+}
+
+//SetProductTags replaces the tags of the product with the provided ones, tags that don't
+//exist yet are created
+func (s *Service) SetProductTags(ctx context.Context, product uuid.UUID, tags []string) error {
+	if err := s.product(ctx, product); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+
+	for _, tag := range tags {
+		if name := lib.NewTagName(tag); name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	before, err := s.repo.GetProductTags(ctx, product)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.SetProductTags(ctx, product, names); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityProduct, product,
+			map[string]interface{}{"tags": before},
+			map[string]interface{}{"tags": names},
+		)
+	})
+}
+
+//GetCollections returns every collection sorted by name, without their products
+func (s *Service) GetCollections(ctx context.Context) ([]*lib.Collection, error) {
+	return s.repo.GetCollections(ctx)
+}
+
+//GetCollection returns the collection along with its products in the order they were
+//curated in
+func (s *Service) GetCollection(ctx context.Context, id uuid.UUID) (*lib.Collection, error) {
+	collection, err := s.repo.GetCollection(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if collection.Products, err = s.repo.GetCollectionProducts(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+//SaveCollection creates a new collection when it doesn't have an id yet and updates the
+//existing one otherwise. The slug is made from the name when none is provided.
+func (s *Service) SaveCollection(ctx context.Context, collection *lib.Collection) (*lib.Collection, error) {
+	if collection.Name = strings.TrimSpace(collection.Name); collection.Name == "" {
+		return nil, errors.ErrNoCatalogName
+	}
+
+	collection.Slug = slug(collection.Slug, collection.Name)
+
+	if existing, err := s.repo.GetCollectionBySlug(ctx, collection.Slug); err != nil {
+		return nil, err
+	} else if existing != nil && existing.ID != collection.ID {
+		return nil, &errors.ErrSlugTaken{
+			Slug: collection.Slug,
+		}
+	}
+
+	if collection.ID == uuid.Nil {
+		err := s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+			if err := repo.CreateCollection(ctx, collection); err != nil {
+				return err
+			}
+
+			return s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityCollection, collection.ID, nil, collection)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return collection, nil
+	}
+
+	before, err := s.repo.GetCollection(ctx, collection.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var after *lib.Collection
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+		if err := repo.UpdateCollection(ctx, collection); err != nil {
+			return err
+		}
+
+		if after, err = repo.GetCollection(ctx, collection.ID); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityCollection, collection.ID, before, after)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return after, nil
+}
+
+//DeleteCollection deletes the collection, the products that were in it are left alone
+func (s *Service) DeleteCollection(ctx context.Context, collection *lib.Collection) error {
+	before, err := s.repo.GetCollection(ctx, collection.ID)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.DeleteCollection(ctx, before); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionDelete, lib.AuditEntityCollection, before.ID, before, nil)
+	})
+}
+
+//SetCollectionProducts replaces the products of the collection, they are kept in the order
+//they are provided in
+func (s *Service) SetCollectionProducts(ctx context.Context, collection uuid.UUID, products []uuid.UUID) error {
+	if _, err := s.repo.GetCollection(ctx, collection); err != nil {
+		return err
+	}
+
+	products = unique(products)
+
+	for _, product := range products {
+		if err := s.product(ctx, product); err != nil {
+			return err
+		}
+	}
+
+	before, err := s.repo.GetCollectionProductIDs(ctx, collection)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.SetCollectionProducts(ctx, collection, products); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityCollection, collection,
+			map[string]interface{}{"products": before},
+			map[string]interface{}{"products": products},
+		)
+	})
+}
+
+//product makes sure the product exists
+func (s *Service) product(ctx context.Context, id uuid.UUID) error {
+	exists, err := s.repo.ProductExists(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return &errors.ErrNoProductFound{
+			ID: id,
+		}
+	}
+
+	return nil
+}
+
+//slug returns the provided slug cleaned up, or one made from the name when there isn't one
+func slug(value, name string) string {
+	if value = lib.NewSlug(value); value != "" {
+		return value
+	}
+	return lib.NewSlug(name)
+}
+
+func contains(categories []*lib.Category, id uuid.UUID) bool {
+	for _, category := range categories {
+		if category.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+//unique drops the nil and repeated ids, the order of the rest is kept
+func unique(ids []uuid.UUID) []uuid.UUID {
+	result := make([]uuid.UUID, 0, len(ids))
+	seen := make(map[uuid.UUID]bool)
+
+	for _, id := range ids {
+		if id != uuid.Nil && !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+
+	return result
+}
+
+type repoi interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error
+	GetCategories(ctx context.Context) ([]*lib.Category, error)
+	GetCategory(ctx context.Context, id uuid.UUID) (*lib.Category, error)
+	GetCategoryBySlug(ctx context.Context, slug string) (*lib.Category, error)
+	CreateCategory(ctx context.Context, category *lib.Category) error
+	UpdateCategory(ctx context.Context, category *lib.Category) error
+	DeleteCategory(ctx context.Context, category *lib.Category) error
+	GetProductCategories(ctx context.Context, product uuid.UUID) ([]uuid.UUID, error)
+	SetProductCategories(ctx context.Context, product uuid.UUID, categories []uuid.UUID) error
+	GetTags(ctx context.Context) ([]*lib.Tag, error)
+	GetProductTags(ctx context.Context, product uuid.UUID) ([]string, error)
+	SetProductTags(ctx context.Context, product uuid.UUID, tags []string) error
+	GetCollections(ctx context.Context) ([]*lib.Collection, error)
+	GetCollection(ctx context.Context, id uuid.UUID) (*lib.Collection, error)
+	GetCollectionBySlug(ctx context.Context, slug string) (*lib.Collection, error)
+	GetCollectionProducts(ctx context.Context, id uuid.UUID) ([]*lib.Product, error)
+	GetCollectionProductIDs(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error)
+	CreateCollection(ctx context.Context, collection *lib.Collection) error
+	UpdateCollection(ctx context.Context, collection *lib.Collection) error
+	DeleteCollection(ctx context.Context, collection *lib.Collection) error
+	SetCollectionProducts(ctx context.Context, collection uuid.UUID, products []uuid.UUID) error
+	ProductExists(ctx context.Context, id uuid.UUID) (bool, error)
+}
+
+type repo struct {
+	*gorm.DB
+}
+
+//Transaction runs the provided function with a repo that is bound to a single transaction,
+//the transaction is rolled back if the function returns an error. The context the function is
+//given is bound to the transaction as well, so the mutations are audited within it.
+func (r *repo) Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(lib.WithAuditTx(ctx, tx), &repo{tx})
+	})
+}
+
+func (r *repo) GetCategories(ctx context.Context) (categories []*lib.Category, err error) {
+	categories = make([]*lib.Category, 0)
+	err = r.DB.WithContext(ctx).Order("position, name").Find(&categories).Error
+	return
+}
+
+func (r *repo) GetCategory(ctx context.Context, id uuid.UUID) (*lib.Category, error) {
+	category := new(lib.Category)
+
+	err := r.DB.WithContext(ctx).First(category, "id = ?", id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrCategoryNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return category, nil
+}
+
+func (r *repo) GetCategoryBySlug(ctx context.Context, slug string) (*lib.Category, error) {
+	category := new(lib.Category)
+
+	err := r.DB.WithContext(ctx).First(category, "slug = ?", slug).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return category, nil
+}
+
+func (r *repo) CreateCategory(ctx context.Context, category *lib.Category) error {
+	return r.DB.WithContext(ctx).Create(category).Error
+}
+
+func (r *repo) UpdateCategory(ctx context.Context, category *lib.Category) error {
+	//the parent is selected explicitly so a category can be moved to the top of the tree
+	return r.DB.WithContext(ctx).Model(category).
+		Select("name", "slug", "parent_id", "position").
+		Updates(category).Error
+}
+
+func (r *repo) DeleteCategory(ctx context.Context, category *lib.Category) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM product_categories WHERE category_id = ?", category.ID).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(category).Error
+	})
+}
+
+func (r *repo) GetProductCategories(ctx context.Context, product uuid.UUID) (ids []uuid.UUID, err error) {
+	ids = make([]uuid.UUID, 0)
+	err = r.DB.WithContext(ctx).Table("product_categories").
+		Where("product_id = ?", product).
+		Order("category_id").
+		Pluck("category_id", &ids).Error
+	return
+}
+
+func (r *repo) SetProductCategories(ctx context.Context, product uuid.UUID, categories []uuid.UUID) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM product_categories WHERE product_id = ?", product).Error; err != nil {
+			return err
+		}
+
+		for _, category := range categories {
+			if err := tx.Exec("INSERT INTO product_categories (product_id, category_id) VALUES (?, ?)", product, category).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *repo) GetTags(ctx context.Context) (tags []*lib.Tag, err error) {
+	tags = make([]*lib.Tag, 0)
+	err = r.DB.WithContext(ctx).Order("name").Find(&tags).Error
+	return
+}
+
+func (r *repo) GetProductTags(ctx context.Context, product uuid.UUID) (tags []string, err error) {
+	tags = make([]string, 0)
+	err = r.DB.WithContext(ctx).Table("product_tags").
+		Where("product_id = ?", product).
+		Order("tag_name").
+		Pluck("tag_name", &tags).Error
+	return
+}
+
+func (r *repo) SetProductTags(ctx context.Context, product uuid.UUID, tags []string) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM product_tags WHERE product_id = ?", product).Error; err != nil {
+			return err
+		}
+
+		for _, tag := range tags {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&lib.Tag{Name: tag}).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Exec("INSERT INTO product_tags (product_id, tag_name) VALUES (?, ?)", product, tag).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *repo) GetCollections(ctx context.Context) (collections []*lib.Collection, err error) {
+	collections = make([]*lib.Collection, 0)
+	err = r.DB.WithContext(ctx).Order("name").Find(&collections).Error
+	return
+}
+
+func (r *repo) GetCollection(ctx context.Context, id uuid.UUID) (*lib.Collection, error) {
+	collection := new(lib.Collection)
+
+	err := r.DB.WithContext(ctx).First(collection, "id = ?", id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrCollectionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+func (r *repo) GetCollectionBySlug(ctx context.Context, slug string) (*lib.Collection, error) {
+	collection := new(lib.Collection)
+
+	err := r.DB.WithContext(ctx).First(collection, "slug = ?", slug).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+func (r *repo) GetCollectionProducts(ctx context.Context, id uuid.UUID) (products []*lib.Product, err error) {
+	products = make([]*lib.Product, 0)
+	err = r.DB.WithContext(ctx).
+		Joins("JOIN collection_products ON collection_products.product_id = products.id").
+		Where("collection_products.collection_id = ?", id).
+		Order("collection_products.position").
+		Find(&products).Error
+	return
+}
+
+func (r *repo) GetCollectionProductIDs(ctx context.Context, id uuid.UUID) (ids []uuid.UUID, err error) {
+	ids = make([]uuid.UUID, 0)
+	err = r.DB.WithContext(ctx).Model(new(lib.CollectionProduct)).
+		Where("collection_id = ?", id).
+		Order("position").
+		Pluck("product_id", &ids).Error
+	return
+}
+
+func (r *repo) CreateCollection(ctx context.Context, collection *lib.Collection) error {
+	return r.DB.WithContext(ctx).Create(collection).Error
+}
+
+func (r *repo) UpdateCollection(ctx context.Context, collection *lib.Collection) error {
+	return r.DB.WithContext(ctx).Model(collection).
+		Select("name", "slug", "description").
+		Updates(collection).Error
+}
+
+func (r *repo) DeleteCollection(ctx context.Context, collection *lib.Collection) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", collection.ID).Delete(new(lib.CollectionProduct)).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(collection).Error
+	})
+}
+
+func (r *repo) SetCollectionProducts(ctx context.Context, collection uuid.UUID, products []uuid.UUID) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", collection).Delete(new(lib.CollectionProduct)).Error; err != nil {
+			return err
+		}
+
+		if len(products) == 0 {
+			return nil
+		}
+
+		lines := make([]*lib.CollectionProduct, len(products))
+		for i, product := range products {
+			lines[i] = &lib.CollectionProduct{
+				CollectionID: collection,
+				ProductID:    product,
+				Position:     i,
+			}
+		}
+
+		return tx.Create(&lines).Error
+	})
+}
+
+func (r *repo) ProductExists(ctx context.Context, id uuid.UUID) (bool, error) {
+	var count int64
+	err := r.DB.WithContext(ctx).Model(new(lib.Product)).Where("id = ?", id).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,212 @@
+package catalog_test
+
+import (
+	"context"
+	"testing"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/catalog"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/cryptnode-software/pisces/lib/product"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	env = lib.NewEnv(
+		commons.NewLogger(commons.EnvDev),
+	)
+
+	service, err = catalog.NewService(env)
+
+	products, _ = product.NewService(env)
+
+	ctx = context.Background()
+)
+
+func TestCategories(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//the suffix keeps the slugs apart from the ones of other runs
+	suffix := uuid.New().String()[:8]
+
+	cookies, err := service.SaveCategory(ctx, &lib.Category{
+		Name: "Cookies " + suffix,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(cookies)
+
+	assert.Equal(t, "cookies-"+suffix, cookies.Slug)
+
+	chocolate, err := service.SaveCategory(ctx, &lib.Category{
+		Name:     "Chocolate chip " + suffix,
+		ParentID: &cookies.ID,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(chocolate)
+
+	_, err = service.SaveCategory(ctx, &lib.Category{
+		Name: "Cookies " + suffix,
+	})
+	assert.Equal(t, &perrors.ErrSlugTaken{Slug: cookies.Slug}, err)
+
+	//a category can't end up below itself
+	cookies.ParentID = &chocolate.ID
+	_, err = service.SaveCategory(ctx, cookies)
+	assert.Equal(t, &perrors.ErrCategoryCycle{
+		CategoryID: cookies.ID,
+		ParentID:   chocolate.ID,
+	}, err)
+	cookies.ParentID = nil
+
+	err = service.DeleteCategory(ctx, cookies)
+	assert.Equal(t, &perrors.ErrCategoryHasChildren{CategoryID: cookies.ID}, err)
+
+	tree, err := service.GetCategories(ctx)
+	if assert.Nil(t, err) {
+		found := false
+		for _, root := range tree {
+			if root.ID == cookies.ID {
+				found = true
+				if assert.Len(t, root.Children, 1) {
+					assert.Equal(t, chocolate.ID, root.Children[0].ID)
+				}
+			}
+		}
+		assert.True(t, found)
+	}
+
+	//the products of a category include the ones of the categories below it
+	p, err := products.SaveProduct(ctx, &lib.Product{
+		Name: "A dozen chocolate chip cookies " + suffix,
+		Cost: 12,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(p)
+
+	assert.Nil(t, service.SetProductCategories(ctx, p.ID, []uuid.UUID{chocolate.ID}))
+	assert.Equal(t, perrors.ErrCategoryNotFound, service.SetProductCategories(ctx, p.ID, []uuid.UUID{uuid.New()}))
+
+	for _, category := range []*lib.Category{cookies, chocolate} {
+		result, err := products.GetProducts(ctx, lib.WithProductCategory(category.ID))
+		if assert.Nil(t, err) && assert.Len(t, result, 1) {
+			assert.Equal(t, p.ID, result[0].ID)
+		}
+	}
+
+	result, err := products.GetProduct(ctx, lib.WithProductID(p.ID), lib.WithProductCatalog())
+	if assert.Nil(t, err) && assert.Len(t, result.Categories, 1) {
+		assert.Equal(t, chocolate.ID, result.Categories[0].ID)
+	}
+
+	//deleting a category takes its products out of it
+	assert.Nil(t, service.DeleteCategory(ctx, chocolate))
+
+	empty, err := products.GetProducts(ctx, lib.WithProductCategory(cookies.ID))
+	assert.Nil(t, err)
+	assert.Len(t, empty, 0)
+}
+
+func TestTags(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tag := "Gluten Free " + uuid.New().String()[:8]
+
+	p, err := products.SaveProduct(ctx, &lib.Product{
+		Name: "A dozen cookies",
+		Cost: 12,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(p)
+	defer env.GormDB.Delete(&lib.Tag{Name: lib.NewTagName(tag)})
+
+	assert.Nil(t, service.SetProductTags(ctx, p.ID, []string{tag, "  " + tag, ""}))
+
+	result, err := products.GetProducts(ctx, lib.WithProductTag(tag))
+	if assert.Nil(t, err) && assert.Len(t, result, 1) {
+		assert.Equal(t, p.ID, result[0].ID)
+	}
+
+	tagged, err := products.GetProduct(ctx, lib.WithProductID(p.ID), lib.WithProductCatalog())
+	if assert.Nil(t, err) && assert.Len(t, tagged.Tags, 1) {
+		assert.Equal(t, lib.NewTagName(tag), tagged.Tags[0].Name)
+	}
+
+	assert.Nil(t, service.SetProductTags(ctx, p.ID, nil))
+
+	result, err = products.GetProducts(ctx, lib.WithProductTag(tag))
+	assert.Nil(t, err)
+	assert.Len(t, result, 0)
+
+	err = service.SetProductTags(ctx, uuid.Nil, []string{tag})
+	assert.Equal(t, &perrors.ErrNoProductFound{ID: uuid.Nil}, err)
+}
+
+func TestCollections(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	collection, err := service.SaveCollection(ctx, &lib.Collection{
+		Name:        "Holiday specials " + uuid.New().String()[:8],
+		Description: "Only around for the holidays",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(collection)
+
+	ids := make([]uuid.UUID, 3)
+	for i := range ids {
+		p, err := products.SaveProduct(ctx, &lib.Product{
+			Name: "A dozen cookies",
+			Cost: 12,
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer env.GormDB.Unscoped().Delete(p)
+
+		ids[i] = p.ID
+	}
+
+	//the products are kept in the order they were curated in
+	curated := []uuid.UUID{ids[2], ids[0], ids[1], ids[0]}
+	assert.Nil(t, service.SetCollectionProducts(ctx, collection.ID, curated))
+
+	result, err := service.GetCollection(ctx, collection.ID)
+	if assert.Nil(t, err) && assert.Len(t, result.Products, 3) {
+		for i, id := range curated[:3] {
+			assert.Equal(t, id, result.Products[i].ID)
+		}
+	}
+
+	err = service.SetCollectionProducts(ctx, collection.ID, []uuid.UUID{uuid.Nil, ids[0]})
+	assert.Nil(t, err)
+
+	result, err = service.GetCollection(ctx, collection.ID)
+	if assert.Nil(t, err) && assert.Len(t, result.Products, 1) {
+		assert.Equal(t, ids[0], result.Products[0].ID)
+	}
+
+	assert.Nil(t, service.DeleteCollection(ctx, collection))
+
+	_, err = service.GetCollection(ctx, collection.ID)
+	assert.Equal(t, perrors.ErrCollectionNotFound, err)
+}
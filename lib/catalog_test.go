@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSlug(t *testing.T) {
+	tables := []struct {
+		name     string
+		expected string
+	}{
+		{name: "Cookies", expected: "cookies"},
+		{name: "  Cookies & Cream ", expected: "cookies-cream"},
+		{name: "Holiday Specials 2026!", expected: "holiday-specials-2026"},
+		{name: "Crème brûlée", expected: "crème-brûlée"},
+		{name: "--", expected: ""},
+	}
+
+	for _, table := range tables {
+		assert.Equal(t, table.expected, NewSlug(table.name))
+	}
+}
+
+func TestNewTagName(t *testing.T) {
+	assert.Equal(t, "gluten free", NewTagName("  Gluten   Free "))
+	assert.Equal(t, "", NewTagName("   "))
+}
+
+func TestCategoryDescendants(t *testing.T) {
+	root, child, grandchild, other := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	categories := []*Category{
+		{ParentID: &child},
+		{ParentID: nil},
+		{ParentID: &root},
+		{ParentID: nil},
+	}
+
+	categories[0].ID = grandchild
+	categories[1].ID = root
+	categories[2].ID = child
+	categories[3].ID = other
+
+	assert.Equal(t, []uuid.UUID{root, child, grandchild}, CategoryDescendants(categories, root))
+	assert.Equal(t, []uuid.UUID{child, grandchild}, CategoryDescendants(categories, child))
+	assert.Equal(t, []uuid.UUID{other}, CategoryDescendants(categories, other))
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CheckoutService turns a cart into an order, its inventory reservation and its external payment
+// in a single operation
+type CheckoutService interface {
+	Checkout(ctx context.Context, req *CheckoutRequest) (*Order, error)
+	ExpireOrders(ctx context.Context, before time.Time) (int64, error)
+}
+
+// CheckoutRequest everything that is needed to place an order. The lines are taken from the
+// shopping cart when one is provided, otherwise from Lines.
+type CheckoutRequest struct {
+	Inquiry        *Inquiry
+	PaymentMethod  PaymentMethod
+	Due            time.Time
+	UserID         *uuid.UUID
+	ShoppingCartID *uuid.UUID
+	Lines          []*Cart
+}
+
+// fingerprint hashes everything that makes up the request so an idempotency key can't be
+// reused for a different checkout. A shopping cart is identified by its id rather than its
+// lines since the cart is gone once the first attempt succeeded.
+func (req *CheckoutRequest) fingerprint() (string, error) {
+	type line struct {
+		ProductID uuid.UUID  `json:"product_id"`
+		VariantID *uuid.UUID `json:"variant_id,omitempty"`
+		Quantity  int64      `json:"quantity"`
+	}
+
+	var lines []line
+
+	if req.ShoppingCartID == nil {
+		for _, l := range req.Lines {
+			lines = append(lines, line{l.ProductID, l.VariantID, l.Quantity})
+		}
+	}
+
+	inquiry := req.Inquiry
+	if inquiry == nil {
+		inquiry = new(Inquiry)
+	}
+
+	b, err := json.Marshal(struct {
+		Description    string        `json:"description"`
+		FirstName      string        `json:"first_name"`
+		LastName       string        `json:"last_name"`
+		Number         string        `json:"number"`
+		Email          string        `json:"email"`
+		PaymentMethod  PaymentMethod `json:"payment_method"`
+		Due            int64         `json:"due"`
+		UserID         *uuid.UUID    `json:"user_id"`
+		ShoppingCartID *uuid.UUID    `json:"shopping_cart_id"`
+		Lines          []line        `json:"lines"`
+	}{
+		Description:    inquiry.Description,
+		FirstName:      inquiry.FirstName,
+		LastName:       inquiry.LastName,
+		Number:         inquiry.Number,
+		Email:          inquiry.Email,
+		PaymentMethod:  req.PaymentMethod,
+		Due:            req.Due.Unix(),
+		UserID:         req.UserID,
+		ShoppingCartID: req.ShoppingCartID,
+		Lines:          lines,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return hash(string(b)), nil
+}
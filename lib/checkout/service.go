@@ -0,0 +1,857 @@
+package checkout
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//batch is how many abandoned orders are expired at a time
+const batch = 100
+
+//Service places orders in a single operation, instead of having the client create the inquiry,
+//the order, the payment and the cart one after the other
+type Service struct {
+	*lib.Env
+	paypal lib.PaypalService
+	repo   repoi
+}
+
+//NewService returns a new checkout service, the paypal service is used to create the payment
+//of orders that are paid through paypal
+func NewService(env *lib.Env, paypal lib.PaypalService) (lib.CheckoutService, error) {
+	if paypal == nil {
+		return nil, errors.ErrNoPaypalService
+	}
+
+	return &Service{
+		env,
+		paypal,
+		&repo{
+			env.GormDB,
+		},
+	}, nil
+}
+
+//line a product (the variant of it, for products with variants) and the quantity of it that
+//is being checked out
+type line struct {
+	ProductID uuid.UUID
+	VariantID *uuid.UUID
+	Quantity  int64
+}
+
+//placed everything that was written while placing an order, it is what gets audited along with
+//the order and what gets undone when its payment can't be created
+type placed struct {
+	//id is the id of the order, it is picked before the order is created so the stock can be
+	//reserved for it
+	id       uuid.UUID
+	order    *lib.Order
+	products []*lib.Product
+	variants []*lib.ProductVariant
+	lines    []line
+	before   map[uuid.UUID]lib.Product
+	//stocked is the stock of the variants before it was reserved
+	stocked map[uuid.UUID]lib.ProductVariant
+	//cart is the shopping cart that was checked out, as it was before it was expired
+	cart *lib.ShoppingCart
+}
+
+//Checkout places the order of the request. The products are locked, their stock is checked and
+//reserved, and the inquiry, the order and its cart are created within a single transaction. A
+//shopping cart that is checked out is locked and expired within that transaction as well, so it
+//can't be checked out twice. The
+//payment is created once that transaction has been committed, if it can't be everything the
+//transaction wrote is undone again so nothing is left behind. Retries are made safe by the
+//gateway, with the idempotency store every idempotent request goes through.
+func (s *Service) Checkout(ctx context.Context, req *lib.CheckoutRequest) (*lib.Order, error) {
+	if req == nil {
+		return nil, errors.ErrNoCheckoutRequest
+	}
+
+	if req.Inquiry == nil {
+		return nil, &errors.ErrNoOrderInquiryProvided{
+			OrderID: uuid.Nil.String(),
+		}
+	}
+
+	switch req.PaymentMethod {
+	case lib.PaymentMethodPaypal, lib.PaymentMethodNotImplemented:
+	default:
+		return nil, errors.ErrNoPaymentMethod
+	}
+
+	var result *placed
+
+	err := s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+		if result, err = place(ctx, repo, req); err != nil {
+			return err
+		}
+
+		return s.audit(ctx, result)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	order := result.order
+
+	switch order.PaymentMethod {
+	case lib.PaymentMethodPaypal:
+		order, err = s.paypal.CreateOrder(ctx, order)
+		if err == nil {
+			err = s.repo.SaveExtID(ctx, order)
+		}
+	}
+
+	if err != nil {
+		//an order that paypal created but we couldn't save is never approved by the buyer, since
+		//they never get to see it, and is dropped by paypal on its own
+		if err := s.compensate(ctx, result); err != nil {
+			s.Log.Error(err.Error())
+		}
+		return nil, err
+	}
+
+	if req.ShoppingCartID != nil {
+		//the order is placed, a cart that can't be removed will simply expire
+		if err := s.repo.DeleteShoppingCart(ctx, *req.ShoppingCartID); err != nil {
+			s.Log.Error(err.Error())
+		}
+	}
+
+	return order, nil
+}
+
+//place validates the lines of the request, reserves their stock and creates the inquiry, the
+//order and its cart. It must be called within a transaction.
+func place(ctx context.Context, repo repoi, req *lib.CheckoutRequest) (*placed, error) {
+	requested := req.Lines
+
+	var cart *lib.ShoppingCart
+
+	if req.ShoppingCartID != nil {
+		var err error
+		if cart, err = repo.LockShoppingCart(ctx, *req.ShoppingCartID, time.Now()); err != nil {
+			return nil, err
+		}
+
+		//a concurrent checkout of the same cart waits on the lock and finds it expired
+		if err := repo.ExpireShoppingCart(ctx, cart.ID, time.Now()); err != nil {
+			return nil, err
+		}
+
+		requested = cart.Lines
+	}
+
+	lines, err := merge(requested)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(lines))
+	for _, l := range lines {
+		ids = append(ids, l.ProductID)
+	}
+
+	products, err := repo.LockProducts(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := repo.LockVariants(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	//the lines are priced at what the products sell for right now, sales included, and keep
+	//that price whatever happens to it afterwards
+	now := time.Now()
+
+	scheduled, err := repo.GetScheduledPrices(ctx, ids, now)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &placed{
+		id:      uuid.New(),
+		lines:   lines,
+		before:  make(map[uuid.UUID]lib.Product),
+		stocked: make(map[uuid.UUID]lib.ProductVariant),
+		cart:    cart,
+	}
+
+	byid := make(map[uuid.UUID]*lib.Product)
+	for _, product := range products {
+		byid[product.ID] = product
+	}
+
+	byproduct := make(map[uuid.UUID][]*lib.ProductVariant)
+	for _, variant := range variants {
+		byproduct[variant.ProductID] = append(byproduct[variant.ProductID], variant)
+	}
+
+	var total float32
+	prices := make([]float32, len(lines))
+
+	for i, l := range lines {
+		product, ok := byid[l.ProductID]
+		if !ok {
+			return nil, &errors.ErrNoProductFound{
+				ID: l.ProductID,
+			}
+		}
+
+		if l.VariantID == nil {
+			if len(byproduct[product.ID]) > 0 {
+				return nil, &errors.ErrVariantNotProvided{
+					ProductID: product.ID,
+				}
+			}
+
+			if err := reserve(ctx, repo, result, product, l); err != nil {
+				return nil, err
+			}
+
+			prices[i] = lib.EffectivePrice(product, nil, scheduled, now)
+			total += float32(l.Quantity) * prices[i]
+			continue
+		}
+
+		variant := find(byproduct[product.ID], *l.VariantID)
+		if variant == nil {
+			return nil, errors.ErrVariantNotFound
+		}
+
+		if err := reservevariant(ctx, repo, result, variant, l); err != nil {
+			return nil, err
+		}
+
+		prices[i] = lib.EffectivePrice(product, variant, scheduled, now)
+		total += float32(l.Quantity) * prices[i]
+	}
+
+	inquiry := *req.Inquiry
+	inquiry.ID = uuid.Nil
+	inquiry.UserID = req.UserID
+
+	order := &lib.Order{
+		Model:         commons.Model{ID: result.id},
+		Inquiry:       &inquiry,
+		PaymentMethod: req.PaymentMethod,
+		Status:        lib.OrderStatusUserPending,
+		Due:           req.Due,
+		UserID:        req.UserID,
+		StockReserved: true,
+	}
+
+	for i, l := range lines {
+		order.Cart = append(order.Cart, &lib.Cart{
+			ProductID: l.ProductID,
+			VariantID: l.VariantID,
+			Quantity:  l.Quantity,
+			UnitPrice: &prices[i],
+		})
+	}
+
+	if err := repo.CreateOrder(ctx, order); err != nil {
+		return nil, err
+	}
+
+	order.Total = total
+	result.order = order
+
+	return result, nil
+}
+
+//merge merges the lines of the same product (and variant) into one and makes sure every
+//quantity is within what a cart may hold, the lines are returned sorted by product so products
+//are always locked in the same order
+func merge(cart []*lib.Cart) ([]line, error) {
+	type key struct {
+		product uuid.UUID
+		variant uuid.UUID
+	}
+
+	quantities := make(map[key]int64)
+
+	for _, content := range cart {
+		if content == nil || content.ProductID == uuid.Nil {
+			return nil, errors.ErrProductNotProvided
+		}
+
+		if content.Quantity <= 0 {
+			return nil, &errors.ErrInvalidCartQuantity{
+				Quantity: content.Quantity,
+			}
+		}
+
+		k := key{product: content.ProductID}
+		if content.VariantID != nil {
+			k.variant = *content.VariantID
+		}
+
+		quantities[k] += content.Quantity
+	}
+
+	if len(quantities) == 0 {
+		return nil, errors.ErrEmptyShoppingCart
+	}
+
+	lines := make([]line, 0, len(quantities))
+
+	for k, quantity := range quantities {
+		if quantity > lib.MaxCartQuantity {
+			return nil, &errors.ErrCartQuantityExceeded{
+				ProductID: k.product,
+				Quantity:  quantity,
+				Max:       lib.MaxCartQuantity,
+			}
+		}
+
+		l := line{ProductID: k.product, Quantity: quantity}
+		if k.variant != uuid.Nil {
+			variant := k.variant
+			l.VariantID = &variant
+		}
+
+		lines = append(lines, l)
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].ProductID != lines[j].ProductID {
+			return lines[i].ProductID.String() < lines[j].ProductID.String()
+		}
+		return variantkey(lines[i]) < variantkey(lines[j])
+	})
+
+	return lines, nil
+}
+
+func variantkey(l line) string {
+	if l.VariantID == nil {
+		return ""
+	}
+	return l.VariantID.String()
+}
+
+//reserve takes the quantity of the line out of the stock of the product
+func reserve(ctx context.Context, repo repoi, result *placed, product *lib.Product, l line) error {
+	if l.Quantity > int64(product.Inventory) {
+		return &errors.ErrInsufficientStock{
+			ProductID: l.ProductID,
+			Requested: l.Quantity,
+			Available: int64(product.Inventory),
+		}
+	}
+
+	result.before[product.ID] = *product
+
+	movement := lib.NewInventoryMovement(ctx, lib.InventoryMovementReservation, product.ID, nil, int(-l.Quantity), "checkout")
+	if err := repo.MoveInventory(ctx, movement.ForOrder(result.id)); err != nil {
+		return err
+	}
+
+	product.Inventory -= int(l.Quantity)
+	result.products = append(result.products, product)
+
+	return nil
+}
+
+//reservevariant takes the quantity of the line out of the stock of the variant, products with
+//variants are only stocked by variant
+func reservevariant(ctx context.Context, repo repoi, result *placed, variant *lib.ProductVariant, l line) error {
+	if l.Quantity > int64(variant.Inventory) {
+		return &errors.ErrInsufficientStock{
+			ProductID: l.ProductID,
+			VariantID: l.VariantID,
+			Requested: l.Quantity,
+			Available: int64(variant.Inventory),
+		}
+	}
+
+	result.stocked[variant.ID] = *variant
+
+	movement := lib.NewInventoryMovement(ctx, lib.InventoryMovementReservation, l.ProductID, &variant.ID, int(-l.Quantity), "checkout")
+	if err := repo.MoveInventory(ctx, movement.ForOrder(result.id)); err != nil {
+		return err
+	}
+
+	variant.Inventory -= int(l.Quantity)
+	result.variants = append(result.variants, variant)
+
+	return nil
+}
+
+func find(variants []*lib.ProductVariant, id uuid.UUID) *lib.ProductVariant {
+	for _, variant := range variants {
+		if variant.ID == id {
+			return variant
+		}
+	}
+	return nil
+}
+
+//restock releases the stock that was reserved for a line of the order, of its variant when it
+//has one
+func restock(ctx context.Context, repo repoi, order uuid.UUID, product uuid.UUID, variant *uuid.UUID, quantity int64, reason string) error {
+	movement := lib.NewInventoryMovement(ctx, lib.InventoryMovementRelease, product, variant, int(quantity), reason)
+	return repo.MoveInventory(ctx, movement.ForOrder(order))
+}
+
+//compensate undoes everything place wrote, the reserved stock is put back and the order, its
+//cart and its inquiry are removed for good, which is audited along with their creation
+func (s *Service) compensate(ctx context.Context, result *placed) error {
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		for _, l := range result.lines {
+			if err := restock(ctx, repo, result.id, l.ProductID, l.VariantID, l.Quantity, "checkout failed"); err != nil {
+				return err
+			}
+		}
+
+		//the shopping cart can be checked out again
+		if result.cart != nil {
+			if err := repo.ExpireShoppingCart(ctx, result.cart.ID, result.cart.ExpiresAt); err != nil {
+				return err
+			}
+		}
+
+		order := result.order
+		if err := repo.DeleteOrder(ctx, order); err != nil {
+			return err
+		}
+
+		if err := s.Audit(ctx, lib.AuditActionHardDelete, lib.AuditEntityOrder, order.ID, order, nil); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionHardDelete, lib.AuditEntityInquiry, order.InquiryID, order.Inquiry, nil)
+	})
+}
+
+//audit records everything place wrote, it must be called within the same transaction
+func (s *Service) audit(ctx context.Context, result *placed) error {
+	order := result.order
+
+	if err := s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityInquiry, order.InquiryID, nil, order.Inquiry); err != nil {
+		return err
+	}
+
+	if err := s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityOrder, order.ID, nil, order); err != nil {
+		return err
+	}
+
+	for _, line := range order.Cart {
+		if err := s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityCart, line.ID, nil, line); err != nil {
+			return err
+		}
+	}
+
+	for _, product := range result.products {
+		before := result.before[product.ID]
+		if err := s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityProduct, product.ID, &before, product); err != nil {
+			return err
+		}
+	}
+
+	for _, variant := range result.variants {
+		before := result.stocked[variant.ID]
+		if err := s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityProductVariant, variant.ID, &before, variant); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//ExpireOrders expires every order that has been pending on the user since before the provided
+//time. Orders whose payment was captured in the meantime are left alone. The stock that was
+//reserved for the order is put back, its inquiry is removed and its payment is voided once it
+//has been expired, it returns how many orders were expired. Voids that failed on an earlier run
+//are retried first.
+func (s *Service) ExpireOrders(ctx context.Context, before time.Time) (int64, error) {
+	var (
+		expired int64
+		failed  []error
+		cursor  uuid.UUID
+	)
+
+	for {
+		orders, err := s.repo.GetPendingVoids(ctx, cursor, batch)
+		if err != nil {
+			return expired, err
+		}
+
+		for _, order := range orders {
+			cursor = order.ID
+
+			if err := s.void(ctx, order); err != nil {
+				s.Log.Error(err.Error())
+				failed = append(failed, err)
+			}
+		}
+
+		if len(orders) < batch {
+			break
+		}
+	}
+
+	cursor = uuid.Nil
+
+	for {
+		orders, err := s.repo.GetAbandonedOrders(ctx, before, cursor, batch)
+		if err != nil {
+			return expired, err
+		}
+
+		for _, order := range orders {
+			cursor = order.ID
+
+			ok, err := s.expire(ctx, order)
+			if ok {
+				expired++
+			}
+
+			if err != nil {
+				s.Log.Error(err.Error())
+				failed = append(failed, err)
+			}
+		}
+
+		if len(orders) < batch {
+			break
+		}
+	}
+
+	if len(failed) > 0 {
+		return expired, &errors.ErrOrdersNotExpired{
+			Failed: len(failed),
+			Err:    failed[0],
+		}
+	}
+
+	return expired, nil
+}
+
+//expire expires a single abandoned order, it reports false when the order was no longer
+//pending by the time it was locked. Paypal is never called while the order is locked, the
+//void is recorded along with the expiry and only made once it has been committed.
+func (s *Service) expire(ctx context.Context, order *lib.Order) (bool, error) {
+	if order.PaymentMethod == lib.PaymentMethodPaypal && order.ExtID != "" {
+		captured, err := s.paypal.Captured(ctx, order)
+		if err != nil {
+			return false, err
+		}
+
+		if captured {
+			return false, &errors.ErrPaymentCaptured{
+				OrderID: order.ID,
+			}
+		}
+	}
+
+	var before, after *lib.Order
+
+	err := s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+		before, err = repo.LockOrder(ctx, order.ID)
+		if err != nil || before.Status != lib.OrderStatusUserPending {
+			before = nil
+			return err
+		}
+
+		if before.StockReserved {
+			for _, line := range before.Cart {
+				if err := restock(ctx, repo, before.ID, line.ProductID, line.VariantID, line.Quantity, "order expired"); err != nil {
+					return err
+				}
+			}
+		}
+
+		result := *before
+		result.Status = lib.OrderStatusExpired
+		result.StockReserved = false
+		result.VoidPending = before.PaymentMethod == lib.PaymentMethodPaypal && before.ExtID != ""
+		after = &result
+
+		if err := repo.ExpireOrder(ctx, after); err != nil {
+			return err
+		}
+
+		if err := s.Audit(ctx, lib.AuditActionStatusChange, lib.AuditEntityOrder, order.ID, before, after); err != nil {
+			return err
+		}
+
+		inquiry, err := repo.DeleteOrphanedInquiry(ctx, before.InquiryID)
+		if err != nil || inquiry == nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionDelete, lib.AuditEntityInquiry, inquiry.ID, inquiry, nil)
+	})
+
+	if err != nil || before == nil {
+		return false, err
+	}
+
+	if after.VoidPending {
+		return true, s.void(ctx, after)
+	}
+
+	return true, nil
+}
+
+//void voids the paypal payment of an expired order. A void that fails is left pending and
+//retried on the next run, unless the payment was captured since, which no retry would change.
+func (s *Service) void(ctx context.Context, order *lib.Order) error {
+	err := s.paypal.VoidOrder(ctx, order)
+	if _, captured := err.(*errors.ErrPaymentCaptured); err != nil && !captured {
+		return err
+	}
+
+	//a captured payment is still reported, it just isn't retried
+	if cerr := s.repo.ClearVoidPending(ctx, order.ID); cerr != nil {
+		return cerr
+	}
+
+	return err
+}
+
+type repoi interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error
+	LockShoppingCart(ctx context.Context, id uuid.UUID, now time.Time) (*lib.ShoppingCart, error)
+	ExpireShoppingCart(ctx context.Context, id uuid.UUID, at time.Time) error
+	LockProducts(ctx context.Context, ids []uuid.UUID) ([]*lib.Product, error)
+	LockVariants(ctx context.Context, products []uuid.UUID) ([]*lib.ProductVariant, error)
+	GetScheduledPrices(ctx context.Context, products []uuid.UUID, at time.Time) ([]*lib.ScheduledPrice, error)
+	MoveInventory(ctx context.Context, movement *lib.InventoryMovement) error
+	CreateOrder(ctx context.Context, order *lib.Order) error
+	SaveExtID(ctx context.Context, order *lib.Order) error
+	DeleteOrder(ctx context.Context, order *lib.Order) error
+	DeleteShoppingCart(ctx context.Context, id uuid.UUID) error
+	GetAbandonedOrders(ctx context.Context, before time.Time, cursor uuid.UUID, limit int) ([]*lib.Order, error)
+	GetPendingVoids(ctx context.Context, cursor uuid.UUID, limit int) ([]*lib.Order, error)
+	ClearVoidPending(ctx context.Context, id uuid.UUID) error
+	LockOrder(ctx context.Context, id uuid.UUID) (*lib.Order, error)
+	ExpireOrder(ctx context.Context, order *lib.Order) error
+	DeleteOrphanedInquiry(ctx context.Context, id uuid.UUID) (*lib.Inquiry, error)
+}
+
+type repo struct {
+	*gorm.DB
+}
+
+//Transaction runs the provided function with a repo that is bound to a single transaction,
+//the transaction is rolled back if the function returns an error. The context the function is
+//given is bound to the transaction as well, so the mutations are audited within it.
+func (r *repo) Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(lib.WithAuditTx(ctx, tx), &repo{tx})
+	})
+}
+
+//LockShoppingCart returns the shopping cart along with its lines, locked until the transaction
+//ends. Carts that have expired by now aren't found.
+func (r *repo) LockShoppingCart(ctx context.Context, id uuid.UUID, now time.Time) (*lib.ShoppingCart, error) {
+	cart := new(lib.ShoppingCart)
+
+	err := r.DB.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(cart, "id = ? AND expires_at > ?", id, now).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrShoppingCartNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	cart.Lines = make([]*lib.Cart, 0)
+	err = r.DB.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Order("created_at ASC").
+		Find(&cart.Lines, "shopping_cart_id = ?", id).Error
+
+	return cart, err
+}
+
+//ExpireShoppingCart moves the expiry of the shopping cart to the provided time
+func (r *repo) ExpireShoppingCart(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return r.DB.WithContext(ctx).Model(new(lib.ShoppingCart)).
+		Where("id = ?", id).
+		Update("expires_at", at).Error
+}
+
+//LockProducts returns the products, locked until the transaction ends so their stock can't
+//change while it is being reserved
+func (r *repo) LockProducts(ctx context.Context, ids []uuid.UUID) (products []*lib.Product, err error) {
+	products = make([]*lib.Product, 0, len(ids))
+	err = r.DB.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Order("id ASC").
+		Find(&products, "id IN ?", ids).Error
+	return
+}
+
+//LockVariants returns every variant of the products, locked until the transaction ends so
+//their stock can't change while it is being reserved
+func (r *repo) LockVariants(ctx context.Context, products []uuid.UUID) (variants []*lib.ProductVariant, err error) {
+	variants = make([]*lib.ProductVariant, 0)
+	err = r.DB.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Order("id ASC").
+		Find(&variants, "product_id IN ?", products).Error
+	return
+}
+
+//GetScheduledPrices returns the prices scheduled for the products that are in effect at the
+//instant without being their cost yet
+func (r *repo) GetScheduledPrices(ctx context.Context, products []uuid.UUID, at time.Time) (prices []*lib.ScheduledPrice, err error) {
+	prices = make([]*lib.ScheduledPrice, 0)
+	err = r.DB.
+		Where("product_id IN ?", products).
+		Scopes(lib.ScheduledPricesAt(at)).
+		Find(&prices).Error
+	return
+}
+
+//MoveInventory records the movement, which moves the stock of its product or variant along
+//with it. A nil movement is one where nothing moved.
+func (r *repo) MoveInventory(ctx context.Context, movement *lib.InventoryMovement) error {
+	if movement == nil {
+		return nil
+	}
+	return r.DB.Create(movement).Error
+}
+
+//CreateOrder creates the order along with its inquiry and cart
+func (r *repo) CreateOrder(ctx context.Context, order *lib.Order) error {
+	return r.DB.Create(order).Error
+}
+
+func (r *repo) SaveExtID(ctx context.Context, order *lib.Order) error {
+	return r.DB.WithContext(ctx).Model(new(lib.Order)).
+		Where("id = ?", order.ID).
+		Update("ext_id", order.ExtID).Error
+}
+
+//DeleteOrder removes the order, its cart and its inquiry for good
+func (r *repo) DeleteOrder(ctx context.Context, order *lib.Order) error {
+	if err := r.DB.Unscoped().Where("order_id = ?", order.ID).Delete(new(lib.Cart)).Error; err != nil {
+		return err
+	}
+
+	if err := r.DB.Unscoped().Where("id = ?", order.ID).Delete(new(lib.Order)).Error; err != nil {
+		return err
+	}
+
+	return r.DB.Unscoped().Where("id = ?", order.InquiryID).Delete(new(lib.Inquiry)).Error
+}
+
+func (r *repo) DeleteShoppingCart(ctx context.Context, id uuid.UUID) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("shopping_cart_id = ?", id).Delete(new(lib.Cart)).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Where("id = ?", id).Delete(new(lib.ShoppingCart)).Error
+	})
+}
+
+//GetAbandonedOrders returns the orders that have been pending on the user since before the
+//provided time, ordered by id and starting after the cursor
+func (r *repo) GetAbandonedOrders(ctx context.Context, before time.Time, cursor uuid.UUID, limit int) (orders []*lib.Order, err error) {
+	orders = make([]*lib.Order, 0, limit)
+
+	tx := r.DB.WithContext(ctx).
+		Where("status = ? AND created_at < ?", lib.OrderStatusUserPending, before)
+
+	if cursor != uuid.Nil {
+		tx = tx.Where("id > ?", cursor)
+	}
+
+	err = tx.Order("id ASC").Limit(limit).Find(&orders).Error
+	return
+}
+
+//GetPendingVoids returns the expired orders whose payment still has to be voided
+func (r *repo) GetPendingVoids(ctx context.Context, cursor uuid.UUID, limit int) (orders []*lib.Order, err error) {
+	orders = make([]*lib.Order, 0, limit)
+
+	tx := r.DB.WithContext(ctx).
+		Where("status = ? AND void_pending = ?", lib.OrderStatusExpired, true)
+
+	if cursor != uuid.Nil {
+		tx = tx.Where("id > ?", cursor)
+	}
+
+	err = tx.Order("id ASC").Limit(limit).Find(&orders).Error
+	return
+}
+
+func (r *repo) ClearVoidPending(ctx context.Context, id uuid.UUID) error {
+	return r.DB.WithContext(ctx).Model(new(lib.Order)).
+		Where("id = ?", id).
+		Update("void_pending", false).Error
+}
+
+//LockOrder returns the order along with its cart, locked until the transaction ends
+func (r *repo) LockOrder(ctx context.Context, id uuid.UUID) (*lib.Order, error) {
+	order := new(lib.Order)
+
+	err := r.DB.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Cart").
+		First(order, "id = ?", id).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrOrderNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (r *repo) ExpireOrder(ctx context.Context, order *lib.Order) error {
+	return r.DB.Model(new(lib.Order)).
+		Where("id = ?", order.ID).
+		Updates(map[string]interface{}{
+			"status":         order.Status,
+			"stock_reserved": order.StockReserved,
+			"void_pending":   order.VoidPending,
+		}).Error
+}
+
+//DeleteOrphanedInquiry soft deletes the inquiry unless an order that hasn't expired still uses
+//it, the deleted inquiry is returned (nil when it wasn't deleted)
+func (r *repo) DeleteOrphanedInquiry(ctx context.Context, id uuid.UUID) (*lib.Inquiry, error) {
+	var live int64
+
+	err := r.DB.Model(new(lib.Order)).
+		Where("inquiry_id = ? AND status <> ?", id, lib.OrderStatusExpired).
+		Count(&live).Error
+
+	if err != nil || live > 0 {
+		return nil, err
+	}
+
+	inquiry := new(lib.Inquiry)
+
+	err = r.DB.First(inquiry, "id = ?", id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return inquiry, r.DB.Delete(inquiry).Error
+}
@@ -0,0 +1,571 @@
+package checkout_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/checkout"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/cryptnode-software/pisces/lib/orders"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	env = lib.NewEnv(
+		commons.NewLogger(commons.EnvDev),
+	)
+
+	paypal = new(paypalservice)
+
+	service, err = checkout.NewService(env, paypal)
+
+	ctx = context.Background()
+)
+
+//paypalservice hands out external ids unless it is told to fail, the payments of the orders
+//in captured can't be voided and voids fail with voiderr when it is set
+type paypalservice struct {
+	lib.PaypalService
+	err      error
+	voiderr  error
+	orders   []*lib.Order
+	voided   []uuid.UUID
+	captured map[uuid.UUID]bool
+}
+
+func (s *paypalservice) CreateOrder(ctx context.Context, order *lib.Order) (*lib.Order, error) {
+	s.orders = append(s.orders, order)
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	order.ExtID = "PAYPAL-" + order.ID.String()
+	return order, nil
+}
+
+func (s *paypalservice) Captured(ctx context.Context, order *lib.Order) (bool, error) {
+	return s.captured[order.ID], nil
+}
+
+func (s *paypalservice) VoidOrder(ctx context.Context, order *lib.Order) error {
+	if s.voiderr != nil {
+		return s.voiderr
+	}
+
+	if s.captured[order.ID] {
+		return &perrors.ErrPaymentCaptured{
+			OrderID: order.ID,
+		}
+	}
+
+	s.voided = append(s.voided, order.ID)
+	return nil
+}
+
+func TestCheckout(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product, err := seedproduct(10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	order, err := service.Checkout(ctx, request(lib.PaymentMethodPaypal, product, 2, 1))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer deseed(order)
+
+	assert.Equal(t, lib.OrderStatusUserPending, order.Status)
+	assert.Equal(t, "PAYPAL-"+order.ID.String(), order.ExtID)
+	assert.Equal(t, float32(36), order.Total)
+
+	if assert.Len(t, order.Cart, 1) {
+		assert.Equal(t, int64(3), order.Cart[0].Quantity)
+	}
+
+	stored := new(lib.Order)
+	if assert.Nil(t, env.GormDB.First(stored, "id = ?", order.ID).Error) {
+		assert.Equal(t, order.ExtID, stored.ExtID)
+	}
+
+	assert.Equal(t, 7, inventory(t, product))
+}
+
+func TestCheckoutCompensation(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product, err := seedproduct(2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	//nothing is written when there isn't enough in stock
+	_, err = service.Checkout(ctx, request(lib.PaymentMethodPaypal, product, 3))
+	assert.Equal(t, &perrors.ErrInsufficientStock{
+		ProductID: product.ID,
+		Requested: 3,
+		Available: 2,
+	}, err)
+	assert.Equal(t, 2, inventory(t, product))
+
+	//everything is undone when the payment can't be created
+	paypal.err = errors.New("paypal is down")
+	paypal.orders = nil
+	defer func() { paypal.err = nil }()
+
+	_, err = service.Checkout(ctx, request(lib.PaymentMethodPaypal, product, 2))
+	assert.Equal(t, paypal.err, err)
+	assert.Equal(t, 2, inventory(t, product))
+
+	if assert.Len(t, paypal.orders, 1) {
+		var count int64
+		env.GormDB.Unscoped().Model(new(lib.Order)).Where("id = ?", paypal.orders[0].ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+
+		env.GormDB.Unscoped().Model(new(lib.Cart)).Where("order_id = ?", paypal.orders[0].ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+
+		env.GormDB.Unscoped().Model(new(lib.Inquiry)).Where("id = ?", paypal.orders[0].InquiryID).Count(&count)
+		assert.Equal(t, int64(0), count)
+	}
+
+	//the stock is there for the next attempt once paypal is back
+	paypal.err = nil
+
+	order, err := service.Checkout(ctx, request(lib.PaymentMethodPaypal, product, 2))
+	if assert.Nil(t, err) {
+		deseed(order)
+	}
+}
+
+func TestCheckoutShoppingCart(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product, err := seedproduct(10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	cart := &lib.ShoppingCart{
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	if err := env.GormDB.Create(cart).Error; err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, quantity := range []int64{1, 2} {
+		line := &lib.Cart{
+			ShoppingCartID: &cart.ID,
+			ProductID:      product.ID,
+			Quantity:       quantity,
+		}
+
+		if err := env.GormDB.Create(line).Error; err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	req := request(lib.PaymentMethodNotImplemented, nil)
+	req.ShoppingCartID = &cart.ID
+
+	order, err := service.Checkout(ctx, req)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer deseed(order)
+
+	assert.Equal(t, "", order.ExtID)
+
+	if assert.Len(t, order.Cart, 1) {
+		assert.Equal(t, int64(3), order.Cart[0].Quantity)
+	}
+
+	//the shopping cart is gone once it has been checked out
+	var count int64
+	env.GormDB.Unscoped().Model(new(lib.ShoppingCart)).Where("id = ?", cart.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	_, err = service.Checkout(ctx, req)
+	assert.Equal(t, perrors.ErrShoppingCartNotFound, err)
+
+	//a cart that is checked out twice at once is only placed once
+	cart = &lib.ShoppingCart{
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	if err := env.GormDB.Create(cart).Error; err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := env.GormDB.Create(&lib.Cart{ShoppingCartID: &cart.ID, ProductID: product.ID, Quantity: 1}).Error; err != nil {
+		t.Error(err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+
+	for i := range errs {
+		req := request(lib.PaymentMethodNotImplemented, nil)
+		req.ShoppingCartID = &cart.ID
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			order, err := service.Checkout(ctx, req)
+			if err == nil {
+				deseed(order)
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+
+	assert.ElementsMatch(t, []error{nil, perrors.ErrShoppingCartNotFound}, errs)
+	assert.Equal(t, 6, inventory(t, product))
+}
+
+func TestExpireOrders(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product, err := seedproduct(10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	abandoned, err := service.Checkout(ctx, request(lib.PaymentMethodPaypal, product, 2))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer deseed(abandoned)
+
+	captured, err := service.Checkout(ctx, request(lib.PaymentMethodPaypal, product, 3))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer deseed(captured)
+
+	recent, err := service.Checkout(ctx, request(lib.PaymentMethodPaypal, product, 1))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer deseed(recent)
+
+	assert.Equal(t, 4, inventory(t, product))
+
+	past := time.Now().Add(-96 * time.Hour)
+	env.GormDB.Model(new(lib.Order)).Where("id IN ?", []uuid.UUID{abandoned.ID, captured.ID}).Update("created_at", past)
+
+	paypal.captured = map[uuid.UUID]bool{captured.ID: true}
+	paypal.voided = nil
+	defer func() { paypal.captured = nil }()
+
+	expired, err := service.ExpireOrders(ctx, time.Now().Add(-72*time.Hour))
+	assert.Equal(t, int64(1), expired)
+
+	failed, ok := err.(*perrors.ErrOrdersNotExpired)
+	if assert.True(t, ok) {
+		assert.Equal(t, 1, failed.Failed)
+	}
+
+	assert.Equal(t, []uuid.UUID{abandoned.ID}, paypal.voided)
+
+	//only the stock of the abandoned order is back in the inventory
+	assert.Equal(t, 6, inventory(t, product))
+
+	status := func(order *lib.Order) lib.OrderStatus {
+		result := new(lib.Order)
+		env.GormDB.First(result, "id = ?", order.ID)
+		return result.Status
+	}
+
+	assert.Equal(t, lib.OrderStatusExpired, status(abandoned))
+	assert.Equal(t, lib.OrderStatusUserPending, status(captured))
+	assert.Equal(t, lib.OrderStatusUserPending, status(recent))
+
+	//the inquiry of the expired order is soft deleted
+	inquiry := new(lib.Inquiry)
+	assert.NotNil(t, env.GormDB.First(inquiry, "id = ?", abandoned.InquiryID).Error)
+	assert.Nil(t, env.GormDB.Unscoped().First(inquiry, "id = ?", abandoned.InquiryID).Error)
+
+	//a void that fails doesn't keep the order from expiring, it is retried on the next run
+	paypal.captured = nil
+	paypal.voiderr = errors.New("paypal is unavailable")
+	expired, err = service.ExpireOrders(ctx, time.Now().Add(-72*time.Hour))
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(1), expired)
+	assert.Equal(t, 9, inventory(t, product))
+	assert.Equal(t, lib.OrderStatusExpired, status(captured))
+
+	paypal.voiderr = nil
+	paypal.voided = nil
+	expired, err = service.ExpireOrders(ctx, time.Now().Add(-72*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), expired)
+	assert.Equal(t, []uuid.UUID{captured.ID}, paypal.voided)
+
+	//expiring again doesn't put the stock back twice nor void the payment again
+	paypal.voided = nil
+	expired, err = service.ExpireOrders(ctx, time.Now().Add(-72*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), expired)
+	assert.Empty(t, paypal.voided)
+	assert.Equal(t, 9, inventory(t, product))
+}
+
+func TestSettleOrder(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	orderservice, err := orders.NewService(env)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product, err := seedproduct(10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	order, err := service.Checkout(ctx, request(lib.PaymentMethodPaypal, product, 2))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer deseed(order)
+	defer env.GormDB.Where("order_id = ?", order.ID).Delete(new(lib.InventoryMovement))
+
+	movements := func() (count int64) {
+		env.GormDB.Model(new(lib.InventoryMovement)).Where("order_id = ?", order.ID).Count(&count)
+		return
+	}
+
+	reserved := func() *lib.Order {
+		result := new(lib.Order)
+		env.GormDB.First(result, "id = ?", order.ID)
+		return result
+	}
+
+	before := movements()
+
+	//anyone who isn't root can't complete the order, its stock stays reserved
+	order.Status = lib.OrderStatusCompleted
+
+	_, err = orderservice.SaveOrder(ctx, order, &lib.SaveConditions{})
+	assert.IsType(t, &perrors.ErrOrderStatusNotAllowed{}, err)
+
+	stored := reserved()
+	assert.True(t, stored.StockReserved)
+	assert.Equal(t, lib.OrderStatusUserPending, stored.Status)
+	assert.Equal(t, before, movements())
+	assert.Equal(t, 8, inventory(t, product))
+
+	//root completes it, the reserved stock is released and sold
+	_, err = orderservice.SaveOrder(ctx, order, &lib.SaveConditions{Root: true})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	stored = reserved()
+	assert.False(t, stored.StockReserved)
+	assert.Equal(t, lib.OrderStatusCompleted, stored.Status)
+	assert.Equal(t, before+2, movements())
+	assert.Equal(t, 8, inventory(t, product))
+
+	//a completed order can't be reopened by anyone but root
+	order.Status = lib.OrderStatusUserPending
+
+	_, err = orderservice.SaveOrder(ctx, order, &lib.SaveConditions{})
+	assert.IsType(t, &perrors.ErrOrderStatusTransition{}, err)
+	assert.Equal(t, lib.OrderStatusCompleted, reserved().Status)
+
+	//the customer can call off an order that is still pending, its stock is put back
+	cancelled, err := service.Checkout(ctx, request(lib.PaymentMethodPaypal, product, 3))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer deseed(cancelled)
+	defer env.GormDB.Where("order_id = ?", cancelled.ID).Delete(new(lib.InventoryMovement))
+
+	assert.Equal(t, 5, inventory(t, product))
+
+	cancelled.Status = lib.OrderStatusCancelled
+
+	_, err = orderservice.SaveOrder(ctx, cancelled, &lib.SaveConditions{})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, 8, inventory(t, product))
+}
+
+func TestCheckoutVariants(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product, err := seedproduct(10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	cost := float32(15)
+
+	large := &lib.ProductVariant{ProductID: product.ID, SKU: "COOKIES-L-" + uuid.New().String()[:8], Cost: &cost, Inventory: 5}
+	small := &lib.ProductVariant{ProductID: product.ID, SKU: "COOKIES-S-" + uuid.New().String()[:8], Inventory: 3}
+
+	for _, variant := range []*lib.ProductVariant{large, small} {
+		if err := env.GormDB.Create(variant).Error; err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	//a product with variants can only be checked out by variant
+	_, err = service.Checkout(ctx, request(lib.PaymentMethodNotImplemented, product, 1))
+	assert.Equal(t, &perrors.ErrVariantNotProvided{ProductID: product.ID}, err)
+
+	req := request(lib.PaymentMethodPaypal, product)
+	req.Lines = []*lib.Cart{
+		{ProductID: product.ID, VariantID: &large.ID, Quantity: 2},
+		{ProductID: product.ID, VariantID: &small.ID, Quantity: 1},
+	}
+
+	order, err := service.Checkout(ctx, req)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer deseed(order)
+
+	//the large variant has a cost of its own, the small one costs what the product does
+	assert.Equal(t, float32(42), order.Total)
+	assert.Len(t, order.Cart, 2)
+
+	assert.Equal(t, 3, stock(t, large))
+	assert.Equal(t, 2, stock(t, small))
+	assert.Equal(t, 10, inventory(t, product))
+
+	req.Lines = []*lib.Cart{
+		{ProductID: product.ID, VariantID: &small.ID, Quantity: 3},
+	}
+
+	_, err = service.Checkout(ctx, req)
+	assert.Equal(t, &perrors.ErrInsufficientStock{
+		ProductID: product.ID,
+		VariantID: &small.ID,
+		Requested: 3,
+		Available: 2,
+	}, err)
+
+	other := uuid.New()
+	req.Lines = []*lib.Cart{
+		{ProductID: product.ID, VariantID: &other, Quantity: 1},
+	}
+
+	_, err = service.Checkout(ctx, req)
+	assert.Equal(t, perrors.ErrVariantNotFound, err)
+
+	//the stock of the variants is put back when the payment can't be created
+	paypal.err = errors.New("paypal is down")
+	defer func() { paypal.err = nil }()
+
+	req.Lines = []*lib.Cart{
+		{ProductID: product.ID, VariantID: &large.ID, Quantity: 1},
+	}
+
+	_, err = service.Checkout(ctx, req)
+	assert.Equal(t, paypal.err, err)
+	assert.Equal(t, 3, stock(t, large))
+}
+
+func request(method lib.PaymentMethod, product *lib.Product, quantities ...int64) *lib.CheckoutRequest {
+	req := &lib.CheckoutRequest{
+		PaymentMethod: method,
+		Due:           time.Now().Add(24 * time.Hour).Truncate(time.Second),
+		Inquiry: &lib.Inquiry{
+			Description: "Magna ipsum culpa labore pariatur elit commodo consequat esse est.",
+			Email:       "test.user@test.io",
+		},
+	}
+
+	for _, quantity := range quantities {
+		req.Lines = append(req.Lines, &lib.Cart{
+			ProductID: product.ID,
+			Quantity:  quantity,
+		})
+	}
+
+	return req
+}
+
+func seedproduct(inventory int) (*lib.Product, error) {
+	product := &lib.Product{
+		Name:      "A dozen cookies",
+		Cost:      12,
+		Inventory: inventory,
+	}
+
+	return product, env.GormDB.Create(product).Error
+}
+
+func inventory(t *testing.T, product *lib.Product) int {
+	result := new(lib.Product)
+	if err := env.GormDB.First(result, "id = ?", product.ID).Error; err != nil {
+		t.Error(err)
+	}
+	return result.Inventory
+}
+
+func stock(t *testing.T, variant *lib.ProductVariant) int {
+	result := new(lib.ProductVariant)
+	if err := env.GormDB.First(result, "id = ?", variant.ID).Error; err != nil {
+		t.Error(err)
+	}
+	return result.Inventory
+}
+
+func deseed(order *lib.Order) {
+	env.GormDB.Unscoped().Where("order_id = ?", order.ID).Delete(new(lib.Cart))
+	env.GormDB.Unscoped().Where("id = ?", order.ID).Delete(new(lib.Order))
+	env.GormDB.Unscoped().Where("id = ?", order.InquiryID).Delete(new(lib.Inquiry))
+}
@@ -199,7 +199,7 @@ func convertCart(cart []*proto.CartContents) (result []*Cart) {
 		}
 
 		if order, err := uuid.Parse(pcontent.OrderId); err == nil {
-			content.OrderID = order
+			content.OrderID = &order
 		}
 
 		if id, err := uuid.Parse(pcontent.Id); err == nil {
@@ -226,7 +226,9 @@ func convertCartToProto(cart []*Cart) (result []*proto.CartContents) {
 		pcontent := new(proto.CartContents)
 
 		pcontent.ProductId = content.ProductID.String()
-		pcontent.OrderId = content.OrderID.String()
+		if content.OrderID != nil {
+			pcontent.OrderId = content.OrderID.String()
+		}
 		pcontent.Quantity = content.Quantity
 		pcontent.Id = content.ID.String()
 
@@ -1,8 +1,16 @@
 package lib
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	commons "github.com/cryptnode-software/commons/pkg"
 	pgorm "github.com/cryptnode-software/pisces/lib/gorm"
@@ -24,8 +32,43 @@ const (
 	envS3Endpoint  string = "AWS_ENDPOINT"
 	envS3Region    string = "AWS_REGION"
 	envS3Bucket    string = "S3_BUCKET"
+
+	envOIDCIssuer         string = "OIDC_ISSUER"
+	envOIDCClientID       string = "OIDC_CLIENT_ID"
+	envOIDCClientSecret   string = "OIDC_CLIENT_SECRET"
+	envOIDCRedirectURL    string = "OIDC_REDIRECT_URL"
+	envOIDCAppURL         string = "OIDC_APP_URL"
+	envOIDCAllowedDomains string = "OIDC_ALLOWED_DOMAINS"
+
+	envIdempotencyStore string = "IDEMPOTENCY_STORE"
+
+	envOrderExpiry string = "ORDER_EXPIRY"
+
+	envProductRetention string = "PRODUCT_RETENTION"
+
+	envLowStockThreshold string = "LOW_STOCK_THRESHOLD"
+	envLowStockWebhook   string = "LOW_STOCK_WEBHOOK"
+
+	envAccessWebhook string = "ACCESS_WEBHOOK"
+
+	envShutdownTimeout string = "SHUTDOWN_TIMEOUT"
+
+	envTrustedProxies string = "TRUSTED_PROXIES"
 )
 
+// DefaultOrderExpiry is how long an order may be pending on the user before it is expired,
+// when ORDER_EXPIRY isn't set
+const DefaultOrderExpiry = 72 * time.Hour
+
+// DefaultProductRetention is how long a deleted product is kept in the trash before it is
+// purged, when PRODUCT_RETENTION isn't set
+const DefaultProductRetention = 30 * 24 * time.Hour
+
+// DefaultShutdownTimeout is how long the requests in flight are given to finish once the
+// replica is told to shut down, when SHUTDOWN_TIMEOUT isn't set. It leaves room within the
+// 30s grace period of kubernetes to close the database.
+const DefaultShutdownTimeout = 20 * time.Second
+
 // Env ...
 type Env struct {
 	GormDB      *gorm.DB
@@ -34,6 +77,34 @@ type Env struct {
 	PaypalEnv   *PaypalEnv
 	JWTEnv      *JWTEnv
 	AWSEnv      *AWSEnv
+	OIDCEnv     *OIDCEnv
+	//IdempotencyStore is where the results of requests made with an idempotency
+	//key are kept, it defaults to the database
+	IdempotencyStore IdempotencyStoreType
+	//OrderExpiry is how long an order may be pending on the user before the
+	//scheduler expires it
+	OrderExpiry time.Duration
+	//ProductRetention is how long a deleted product is kept in the trash before the
+	//scheduler purges it
+	ProductRetention time.Duration
+	//LowStockThreshold is the stock below which products and variants without a
+	//threshold of their own are alerted about, no alerts are sent for them when it is 0
+	LowStockThreshold int
+	//LowStockWebhook is where low stock alerts are posted to as json, they are
+	//only logged when it isn't set
+	LowStockWebhook string
+	//AccessWebhook is where the access tokens of guest orders and inquiries are posted
+	//to as json so they are emailed to the guest, i.e. by a mail relay
+	AccessWebhook string
+	//ShutdownTimeout is how long the requests in flight are given to finish once the
+	//replica is told to shut down, the ones that are left are cancelled
+	ShutdownTimeout time.Duration
+	//TrustedProxies are the proxies in front of the replica, i.e. the ingress, that
+	//X-Forwarded-For is read from to find the ip of a caller
+	TrustedProxies []*net.IPNet
+	//AuditService is set once the services are initialized, every service
+	//records its mutations through it with Audit
+	AuditService AuditService
 }
 
 // PaypalEnv the structure for the paypal environment
@@ -48,6 +119,22 @@ type JWTEnv struct {
 	Secret string
 }
 
+// OIDCEnv the structure that is required for staff single sign-on, it is optional
+// and left nil when no issuer has been configured
+type OIDCEnv struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	//AppURL is where staff are sent with a one-time code once they have logged
+	//in, the app redeems it at /oidc/token for their pisces JWT. If it isn't set
+	//the JWT is written back as json instead.
+	AppURL string
+	//AllowedDomains are the email domains that will have a staff account
+	//provisioned automatically on their first login.
+	AllowedDomains []string
+}
+
 // UploadType the primitive type that all of upload configurations support
 type UploadType string
 
@@ -97,6 +184,24 @@ func NewEnv(logger commons.Logger) (result *Env) {
 
 	result.AWSEnv = NewAWSEnv()
 
+	result.OIDCEnv = NewOIDCEnv()
+
+	result.IdempotencyStore = NewIdempotencyStoreType(os.Getenv(envIdempotencyStore))
+
+	result.OrderExpiry = NewOrderExpiry(os.Getenv(envOrderExpiry))
+
+	result.ProductRetention = NewProductRetention(os.Getenv(envProductRetention))
+
+	result.LowStockThreshold = NewLowStockThreshold(os.Getenv(envLowStockThreshold))
+
+	result.LowStockWebhook = os.Getenv(envLowStockWebhook)
+
+	result.AccessWebhook = os.Getenv(envAccessWebhook)
+
+	result.ShutdownTimeout = NewShutdownTimeout(os.Getenv(envShutdownTimeout))
+
+	result.TrustedProxies = NewTrustedProxies(os.Getenv(envTrustedProxies))
+
 	return
 }
 
@@ -127,6 +232,14 @@ func NewPaypalEnv(env commons.Environment, client, secret string) (result *Paypa
 	return
 }
 
+// SigningKey derives a key from the jwt secret for the provided purpose, tokens that are signed
+// for one purpose can then never pass as tokens of another (or as login tokens)
+func (env *JWTEnv) SigningKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(env.Secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
 func NewJWTEnv(secret string) (env *JWTEnv) {
 	env = new(JWTEnv)
 
@@ -134,9 +247,42 @@ func NewJWTEnv(secret string) (env *JWTEnv) {
 		log.Fatalf("%s not set, if not properly set jwt tokens will be unsafe to use", envJWTSecret)
 	}
 
+	env.Secret = secret
+
 	return
 }
 
+// BucketURL returns the public url of the bucket, objects are found right below it
+func (env *AWSEnv) BucketURL() string {
+	return fmt.Sprintf("https://%s.%s.linodeobjects.com", env.Bucket, env.Region)
+}
+
+// ObjectURL returns the public url of the object with the provided key
+func (env *AWSEnv) ObjectURL(key string) string {
+	return env.BucketURL() + (&url.URL{Path: "/" + key}).EscapedPath()
+}
+
+// ObjectKey returns the key of the object at the provided url, it reports false when the url
+// isn't the url of an object in the bucket
+func (env *AWSEnv) ObjectKey(raw string) (string, bool) {
+	bucket, err := url.Parse(env.BucketURL())
+	if err != nil {
+		return "", false
+	}
+
+	object, err := url.Parse(raw)
+	if err != nil || object.Scheme != bucket.Scheme || object.Host != bucket.Host {
+		return "", false
+	}
+
+	key := strings.TrimPrefix(object.Path, "/")
+	if key == "" {
+		return "", false
+	}
+
+	return key, true
+}
+
 func NewAWSEnv() (env *AWSEnv) {
 	env = new(AWSEnv)
 	if env.Region = os.Getenv(envS3Region); env.Region == "" {
@@ -160,3 +306,147 @@ func NewAWSEnv() (env *AWSEnv) {
 
 	return
 }
+
+func NewOIDCEnv() (env *OIDCEnv) {
+	issuer := os.Getenv(envOIDCIssuer)
+	if issuer == "" {
+		log.Printf("%s is not set, staff single sign-on is disabled", envOIDCIssuer)
+		return nil
+	}
+
+	env = &OIDCEnv{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientSecret: os.Getenv(envOIDCClientSecret),
+		AppURL:       os.Getenv(envOIDCAppURL),
+	}
+
+	if env.ClientID = os.Getenv(envOIDCClientID); env.ClientID == "" {
+		log.Fatalf("%s not set and required for single sign-on", envOIDCClientID)
+	}
+	if env.RedirectURL = os.Getenv(envOIDCRedirectURL); env.RedirectURL == "" {
+		log.Fatalf("%s not set and required for single sign-on", envOIDCRedirectURL)
+	}
+
+	for _, domain := range strings.Split(os.Getenv(envOIDCAllowedDomains), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			env.AllowedDomains = append(env.AllowedDomains, domain)
+		}
+	}
+
+	return
+}
+
+// IdempotencyStoreType the primitive type for the stores idempotency records can be kept in
+type IdempotencyStoreType string
+
+const (
+	//IdempotencyStoreDatabase keeps idempotency records in the database, shared by every replica
+	IdempotencyStoreDatabase IdempotencyStoreType = "DATABASE"
+	//IdempotencyStoreMemory keeps idempotency records in memory, only suitable for a single
+	//replica and our tests
+	IdempotencyStoreMemory IdempotencyStoreType = "MEMORY"
+)
+
+func NewIdempotencyStoreType(store string) IdempotencyStoreType {
+	switch IdempotencyStoreType(strings.ToUpper(store)) {
+	case IdempotencyStoreMemory:
+		return IdempotencyStoreMemory
+	case IdempotencyStoreDatabase, "":
+		return IdempotencyStoreDatabase
+	default:
+		log.Fatalf("%s must be either %s or %s", envIdempotencyStore, IdempotencyStoreDatabase, IdempotencyStoreMemory)
+		return ""
+	}
+}
+
+// NewOrderExpiry parses the order expiry, i.e. "72h", falling back to DefaultOrderExpiry
+func NewOrderExpiry(expiry string) time.Duration {
+	if expiry == "" {
+		return DefaultOrderExpiry
+	}
+
+	duration, err := time.ParseDuration(expiry)
+	if err != nil || duration <= 0 {
+		log.Fatalf("%s must be a positive duration i.e. 72h, %q was provided", envOrderExpiry, expiry)
+	}
+
+	return duration
+}
+
+// NewProductRetention parses the product retention, i.e. "720h", falling back to
+// DefaultProductRetention
+func NewProductRetention(retention string) time.Duration {
+	if retention == "" {
+		return DefaultProductRetention
+	}
+
+	duration, err := time.ParseDuration(retention)
+	if err != nil || duration <= 0 {
+		log.Fatalf("%s must be a positive duration i.e. 720h, %q was provided", envProductRetention, retention)
+	}
+
+	return duration
+}
+
+// NewShutdownTimeout parses the shutdown timeout, i.e. "20s", falling back to
+// DefaultShutdownTimeout
+func NewShutdownTimeout(timeout string) time.Duration {
+	if timeout == "" {
+		return DefaultShutdownTimeout
+	}
+
+	duration, err := time.ParseDuration(timeout)
+	if err != nil || duration <= 0 {
+		log.Fatalf("%s must be a positive duration i.e. 20s, %q was provided", envShutdownTimeout, timeout)
+	}
+
+	return duration
+}
+
+// NewLowStockThreshold parses the low stock threshold, no threshold is 0
+func NewLowStockThreshold(threshold string) int {
+	if threshold == "" {
+		return 0
+	}
+
+	result, err := strconv.Atoi(threshold)
+	if err != nil || result < 0 {
+		log.Fatalf("%s must be a whole number that isn't negative i.e. 5, %q was provided", envLowStockThreshold, threshold)
+	}
+
+	return result
+}
+
+// NewTrustedProxies parses the comma separated CIDR ranges or ips of the trusted proxies, i.e.
+// "10.0.0.0/8,192.168.1.10". No proxy is trusted when it is empty.
+func NewTrustedProxies(proxies string) (result []*net.IPNet) {
+	for _, proxy := range strings.Split(proxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				log.Fatalf("%s must be a list of CIDR ranges or ips i.e. 10.0.0.0/8, %q was provided", envTrustedProxies, proxy)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Fatalf("%s must be a list of CIDR ranges or ips i.e. 10.0.0.0/8, %q was provided", envTrustedProxies, proxy)
+		}
+
+		result = append(result, network)
+	}
+
+	return
+}
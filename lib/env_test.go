@@ -0,0 +1,31 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectKey(t *testing.T) {
+	env := &AWSEnv{
+		Bucket: "pisces",
+		Region: "us-east-1",
+	}
+
+	url := env.ObjectURL("images/a cookie.png")
+	assert.Equal(t, "https://pisces.us-east-1.linodeobjects.com/images/a%20cookie.png", url)
+
+	key, ok := env.ObjectKey(url)
+	assert.True(t, ok)
+	assert.Equal(t, "images/a cookie.png", key)
+
+	for _, url := range []string{
+		"https://elsewhere.us-east-1.linodeobjects.com/images/a.png",
+		"http://pisces.us-east-1.linodeobjects.com/images/a.png",
+		"https://pisces.us-east-1.linodeobjects.com/",
+		"://",
+	} {
+		_, ok := env.ObjectKey(url)
+		assert.False(t, ok, url)
+	}
+}
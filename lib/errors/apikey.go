@@ -0,0 +1,57 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	//ErrInvalidAPIKey is returned when the provided api key is malformed, unknown, revoked
+	//or doesn't match the stored hash. We purposely don't tell the caller which one.
+	ErrInvalidAPIKey = errors.New("the api key provided is invalid, please provide a different one")
+
+	//ErrAPIKeyExpired is returned when the provided api key has expired
+	ErrAPIKeyExpired = errors.New("the api key provided has expired, please request a new one")
+
+	//ErrNoAPIKeyName is returned when an admin tries to create an api key without a name
+	ErrNoAPIKeyName = errors.New("no name was provided for the api key, please provide one")
+)
+
+//ErrAPIKeyIPNotAllowed is returned when the api key is used from an ip that is outside
+//of the ranges it was scoped to
+type ErrAPIKeyIPNotAllowed struct {
+	IP string
+}
+
+func (err *ErrAPIKeyIPNotAllowed) Error() string {
+	return fmt.Sprintf("the api key provided can't be used from %s", err.IP)
+}
+
+//ErrAPIKeyPermissionDenied is returned when the api key doesn't hold the permission
+//required for the requested route
+type ErrAPIKeyPermissionDenied struct {
+	Method string
+}
+
+func (err *ErrAPIKeyPermissionDenied) Error() string {
+	return fmt.Sprintf("the api key provided doesn't have access to %s", err.Method)
+}
+
+//ErrUnknownPermission is returned when an api key is created with a permission that doesn't
+//exist
+type ErrUnknownPermission struct {
+	Permission string
+}
+
+func (err *ErrUnknownPermission) Error() string {
+	return fmt.Sprintf("%s is not a permission that can be granted to an api key", err.Permission)
+}
+
+//ErrInvalidAllowedIP is returned when an allowed ip range on an api key can't be parsed
+type ErrInvalidAllowedIP struct {
+	Value string
+}
+
+func (err *ErrInvalidAllowedIP) Error() string {
+	return fmt.Sprintf("%s is not a valid ip or cidr range", err.Value)
+}
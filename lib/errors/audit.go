@@ -0,0 +1,14 @@
+package errors
+
+import "fmt"
+
+//ErrInvalidAuditPage is returned when the audit log is paginated with a negative
+//limit or offset
+type ErrInvalidAuditPage struct {
+	Limit  int
+	Offset int
+}
+
+func (err *ErrInvalidAuditPage) Error() string {
+	return fmt.Sprintf("invalid audit log page, limit %d and offset %d must not be negative", err.Limit, err.Offset)
+}
@@ -0,0 +1,36 @@
+package errors
+
+import (
+	"fmt"
+)
+
+//ErrUnknownBulkFormat is returned when products are imported or exported in a format that
+//isn't csv or json
+type ErrUnknownBulkFormat struct {
+	Format string
+}
+
+func (err *ErrUnknownBulkFormat) Error() string {
+	return fmt.Sprintf("unknown format %q, products are imported and exported as csv or json", err.Format)
+}
+
+//ErrMalformedImport is returned when the file of an import can't be read at all, i.e. json
+//that doesn't parse or a csv without the columns of a product
+type ErrMalformedImport struct {
+	Row     int
+	Message string
+}
+
+func (err *ErrMalformedImport) Error() string {
+	return fmt.Sprintf("the import can't be read at row %d: %s", err.Row, err.Message)
+}
+
+//ErrInvalidImport is returned when records of an import are invalid, the errors of every
+//record are found in the result of the import and nothing was saved
+type ErrInvalidImport struct {
+	Errors int
+}
+
+func (err *ErrInvalidImport) Error() string {
+	return fmt.Sprintf("the import has %d invalid fields, nothing was saved", err.Errors)
+}
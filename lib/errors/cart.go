@@ -3,6 +3,8 @@ package errors
 import (
 	"errors"
 	"fmt"
+
+	"github.com/google/uuid"
 )
 
 //ErrCartActionNotRecognized the structure and the information that it needs to produce a valid err
@@ -29,4 +31,52 @@ func (err *ErrNoCart) Error() string {
 var (
 	//ErrCartOrderNotProvided is returned when there is no order provided during the any process that my need it
 	ErrCartOrderNotProvided = errors.New("no order was provided while trying to query one that requires it, please provide a valid order")
+
+	//ErrShoppingCartNotFound is returned when the shopping cart doesn't exist, has expired or
+	//doesn't belong to the caller
+	ErrShoppingCartNotFound = errors.New("no shopping cart was found, please start a new one")
+
+	//ErrInvalidCartToken is returned when a guest cart token is malformed or wasn't signed by us
+	ErrInvalidCartToken = errors.New("the cart token provided is invalid, please provide a different one")
+
+	//ErrEmptyShoppingCart is returned when checking out a shopping cart without any products in it
+	ErrEmptyShoppingCart = errors.New("the shopping cart is empty, please add a product before checking out")
 )
+
+//ErrInvalidCartQuantity is returned when a product is added to the cart with a
+//quantity that isn't positive, or set to a negative quantity
+type ErrInvalidCartQuantity struct {
+	Quantity int64
+}
+
+func (err *ErrInvalidCartQuantity) Error() string {
+	return fmt.Sprintf("%d is not a valid quantity for a product in the cart", err.Quantity)
+}
+
+//ErrCartQuantityExceeded is returned when a product would end up in the cart more
+//times than a single cart allows
+type ErrCartQuantityExceeded struct {
+	ProductID uuid.UUID
+	Quantity  int64
+	Max       int64
+}
+
+func (err *ErrCartQuantityExceeded) Error() string {
+	return fmt.Sprintf("the cart can't hold %d of product %s, at most %d are allowed", err.Quantity, err.ProductID, err.Max)
+}
+
+//ErrInsufficientStock is returned when there isn't enough of a product (or of the variant
+//of it, when one was picked) in stock for the quantity that was requested
+type ErrInsufficientStock struct {
+	ProductID uuid.UUID
+	VariantID *uuid.UUID
+	Requested int64
+	Available int64
+}
+
+func (err *ErrInsufficientStock) Error() string {
+	if err.VariantID != nil {
+		return fmt.Sprintf("only %d of variant %s of product %s are in stock, %d were requested", err.Available, *err.VariantID, err.ProductID, err.Requested)
+	}
+	return fmt.Sprintf("only %d of product %s are in stock, %d were requested", err.Available, err.ProductID, err.Requested)
+}
@@ -0,0 +1,50 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+var (
+	//ErrCategoryNotFound is returned when a category that doesn't exist is referenced
+	ErrCategoryNotFound = errors.New("no category was found with the provided id")
+
+	//ErrCollectionNotFound is returned when a collection that doesn't exist is referenced
+	ErrCollectionNotFound = errors.New("no collection was found with the provided id")
+
+	//ErrNoCatalogName is returned when a category or collection is saved without a name
+	ErrNoCatalogName = errors.New("a name is required for categories and collections, please provide one")
+)
+
+//ErrCategoryCycle is returned when a category would end up below itself in the category
+//tree, either directly or through one of its descendants
+type ErrCategoryCycle struct {
+	CategoryID uuid.UUID
+	ParentID   uuid.UUID
+}
+
+func (err *ErrCategoryCycle) Error() string {
+	return fmt.Sprintf("category %s can't be moved below %s since it would end up below itself", err.CategoryID, err.ParentID)
+}
+
+//ErrCategoryHasChildren is returned when a category that other categories are below is
+//deleted, they have to be moved or deleted first
+type ErrCategoryHasChildren struct {
+	CategoryID uuid.UUID
+}
+
+func (err *ErrCategoryHasChildren) Error() string {
+	return fmt.Sprintf("category %s still has categories below it, please move or delete them first", err.CategoryID)
+}
+
+//ErrSlugTaken is returned when a category or collection is saved with the slug of
+//another one
+type ErrSlugTaken struct {
+	Slug string
+}
+
+func (err *ErrSlugTaken) Error() string {
+	return fmt.Sprintf("the slug %q is already taken, please provide a different one", err.Slug)
+}
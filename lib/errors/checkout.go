@@ -0,0 +1,10 @@
+package errors
+
+import "errors"
+
+var (
+	//ErrNoCheckoutRequest is returned when a checkout is made without a request
+	ErrNoCheckoutRequest = errors.New("no checkout request was provided, please provide one")
+	//ErrNoPaymentMethod is returned when a checkout doesn't provide a supported payment method
+	ErrNoPaymentMethod = errors.New("no supported payment method was provided, please provide one")
+)
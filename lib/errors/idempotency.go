@@ -0,0 +1,16 @@
+package errors
+
+import "errors"
+
+var (
+	//ErrIdempotencyKeyReused is returned when an idempotency key is used again for a request
+	//that is different from the one it was first used for
+	ErrIdempotencyKeyReused = errors.New("the idempotency key provided was already used for a different request, please provide a new one")
+
+	//ErrRequestInProgress is returned when a request is retried with the idempotency key of
+	//an attempt that hasn't finished yet
+	ErrRequestInProgress = errors.New("a request with the provided idempotency key is still in progress, please try again shortly")
+
+	//ErrInvalidIdempotencyKey is returned when the idempotency key is longer than we allow
+	ErrInvalidIdempotencyKey = errors.New("the idempotency key provided is invalid, please provide one of at most 255 characters")
+)
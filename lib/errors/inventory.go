@@ -0,0 +1,41 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	//ErrNoInventoryReason is returned when stock is adjusted without saying why
+	ErrNoInventoryReason = errors.New("a reason is required to adjust the inventory, please provide one")
+)
+
+//ErrInvalidInventoryMovement is returned when stock is adjusted with a movement that can't be
+//made by hand, or with a quantity that doesn't fit it. Receipts and returns bring stock in,
+//adjustments move it either way.
+type ErrInvalidInventoryMovement struct {
+	Kind     string
+	Quantity int
+}
+
+func (err *ErrInvalidInventoryMovement) Error() string {
+	return fmt.Sprintf("invalid inventory movement %q of %d, the movement must be a RECEIPT or RETURN of a positive quantity or an ADJUSTMENT of any quantity but zero", err.Kind, err.Quantity)
+}
+
+//ErrInvalidLowStockThreshold is returned when a low stock threshold is negative
+type ErrInvalidLowStockThreshold struct {
+	Threshold int
+}
+
+func (err *ErrInvalidLowStockThreshold) Error() string {
+	return fmt.Sprintf("invalid low stock threshold %d, it must not be negative", err.Threshold)
+}
+
+//ErrLowStockWebhook is returned when the low stock webhook doesn't accept the alerts
+type ErrLowStockWebhook struct {
+	Status int
+}
+
+func (err *ErrLowStockWebhook) Error() string {
+	return fmt.Sprintf("the low stock webhook responded with status %d", err.Status)
+}
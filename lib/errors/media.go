@@ -0,0 +1,40 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	//ErrProductImageNotFound is returned when an image that isn't in the gallery of a product
+	//is referenced
+	ErrProductImageNotFound = errors.New("no image of the product was found with the provided id")
+)
+
+//ErrInvalidUpload is returned when an upload is confirmed with a url that isn't one of
+//the bucket, uploads have to be made through StartUpload
+type ErrInvalidUpload struct {
+	URL string
+}
+
+func (err *ErrInvalidUpload) Error() string {
+	return fmt.Sprintf("%q isn't the url of an upload, please upload the file through StartUpload", err.URL)
+}
+
+//ErrUploadNotFound is returned when an upload is confirmed before the file was uploaded
+type ErrUploadNotFound struct {
+	Key string
+}
+
+func (err *ErrUploadNotFound) Error() string {
+	return fmt.Sprintf("nothing was uploaded as %q, please upload the file before confirming it", err.Key)
+}
+
+//ErrUploadConfirmed is returned when an upload that was already confirmed is confirmed again
+type ErrUploadConfirmed struct {
+	Key string
+}
+
+func (err *ErrUploadConfirmed) Error() string {
+	return fmt.Sprintf("the upload %q was already confirmed", err.Key)
+}
@@ -0,0 +1,48 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	//ErrOIDCNotConfigured is returned when single sign-on is used without an issuer
+	//being configured
+	ErrOIDCNotConfigured = errors.New("single sign-on is not configured, please provide an issuer")
+
+	//ErrOIDCInvalidState is returned when the state returned by the issuer is unknown,
+	//has already been used or has expired
+	ErrOIDCInvalidState = errors.New("the single sign-on state is invalid or has expired, please login again")
+
+	//ErrOIDCInvalidCode is returned when the one-time code of a completed login is
+	//unknown, has already been redeemed or has expired
+	ErrOIDCInvalidCode = errors.New("the single sign-on code is invalid or has expired, please login again")
+
+	//ErrOIDCInvalidToken is returned when the id token returned by the issuer fails
+	//verification
+	ErrOIDCInvalidToken = errors.New("the id token returned by the issuer is invalid")
+
+	//ErrOIDCEmailNotVerified is returned when the issuer hasn't verified the email of
+	//the identity, we can't map an unverified email to a user
+	ErrOIDCEmailNotVerified = errors.New("the email of the identity has not been verified by the issuer")
+)
+
+//ErrOIDCDomainNotAllowed is returned when the email domain of the identity isn't allowed
+//to sign in through single sign-on
+type ErrOIDCDomainNotAllowed struct {
+	Email string
+}
+
+func (err *ErrOIDCDomainNotAllowed) Error() string {
+	return fmt.Sprintf("the domain of %s isn't allowed to sign in", err.Email)
+}
+
+//ErrOIDCIssuer is returned when the issuer responds with an unexpected status
+type ErrOIDCIssuer struct {
+	Endpoint string
+	Status   int
+}
+
+func (err *ErrOIDCIssuer) Error() string {
+	return fmt.Sprintf("issuer responded to %s with status %d", err.Endpoint, err.Status)
+}
@@ -1,6 +1,29 @@
 package errors
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+var (
+	//ErrOrderNotFound is returned when the order doesn't exist or the caller isn't allowed
+	//to read it, we purposely don't tell the caller which one.
+	ErrOrderNotFound = errors.New("no order was found with the provided id")
+
+	//ErrInquiryNotFound is returned when the inquiry doesn't exist or the caller isn't allowed
+	//to read it, we purposely don't tell the caller which one.
+	ErrInquiryNotFound = errors.New("no inquiry was found with the provided id")
+
+	//ErrInvalidAccessToken is returned when a guest access token is malformed, expired or
+	//wasn't signed by us
+	ErrInvalidAccessToken = errors.New("the access token provided is invalid, please provide a different one")
+
+	//ErrInvalidOrderCursor is returned when the cursor of an order listing is malformed or
+	//was returned for a different sort
+	ErrInvalidOrderCursor = errors.New("the order cursor provided is invalid, please start the listing over")
+)
 
 //ErrNoOrderInquiryProvided is returned when there wasn't an inquiry for an
 //order even though it is required. Inquiry is required because it gives the
@@ -12,3 +35,71 @@ type ErrNoOrderInquiryProvided struct {
 func (err *ErrNoOrderInquiryProvided) Error() string {
 	return fmt.Sprintf("inquiry is required on an order for order %s and there wasn't one provided", err.OrderID)
 }
+
+//ErrOrdersNotExpired is returned when some of the abandoned orders couldn't be expired, Err is
+//the reason the first of them couldn't be
+type ErrOrdersNotExpired struct {
+	Failed int
+	Err    error
+}
+
+func (err *ErrOrdersNotExpired) Error() string {
+	return fmt.Sprintf("%d abandoned orders couldn't be expired, the first because: %s", err.Failed, err.Err)
+}
+
+func (err *ErrOrdersNotExpired) Unwrap() error {
+	return err.Err
+}
+
+//ErrOrderNotArchivable is returned when an order that isn't completed or cancelled is
+//archived
+type ErrOrderNotArchivable struct {
+	OrderID uuid.UUID
+	Status  string
+}
+
+func (err *ErrOrderNotArchivable) Error() string {
+	return fmt.Sprintf("order %s is %s, only completed or cancelled orders can be archived", err.OrderID, err.Status)
+}
+
+//ErrOrderStatusNotAllowed is returned when someone other than staff moves an order to a
+//status that only staff may, i.e. accepting it
+type ErrOrderStatusNotAllowed struct {
+	Status string
+}
+
+func (err *ErrOrderStatusNotAllowed) Error() string {
+	return fmt.Sprintf("only staff can move an order to %s", err.Status)
+}
+
+//ErrOrderStatusTransition is returned when someone other than staff moves an order that is
+//done with, i.e. completed or expired, to a different status
+type ErrOrderStatusTransition struct {
+	OrderID uuid.UUID
+	From    string
+	To      string
+}
+
+func (err *ErrOrderStatusTransition) Error() string {
+	return fmt.Sprintf("order %s is %s and can't be moved to %s", err.OrderID, err.From, err.To)
+}
+
+//ErrAccessWebhook is returned when the access webhook doesn't accept an access token
+type ErrAccessWebhook struct {
+	Status int
+}
+
+func (err *ErrAccessWebhook) Error() string {
+	return fmt.Sprintf("the access webhook responded with status %d", err.Status)
+}
+
+//ErrInvalidOrderPage is returned when orders are listed with a negative limit or a sort
+//that doesn't exist
+type ErrInvalidOrderPage struct {
+	Limit  int
+	SortBy string
+}
+
+func (err *ErrInvalidOrderPage) Error() string {
+	return fmt.Sprintf("invalid order page, limit %d must not be negative and sort %q must be one of the order sorts", err.Limit, err.SortBy)
+}
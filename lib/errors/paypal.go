@@ -0,0 +1,17 @@
+package errors
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+//ErrPaymentCaptured is returned when the payment of an order can't be voided anymore since
+//the money has already been captured
+type ErrPaymentCaptured struct {
+	OrderID uuid.UUID
+}
+
+func (err *ErrPaymentCaptured) Error() string {
+	return fmt.Sprintf("the payment of order %s has already been captured and can't be voided", err.OrderID)
+}
@@ -0,0 +1,37 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+)
+
+//ErrNoPolicy is returned for any rpc that doesn't have an authorization policy
+//registered, those are denied by default
+type ErrNoPolicy struct {
+	Method string
+}
+
+func (err *ErrNoPolicy) Error() string {
+	return fmt.Sprintf("%s doesn't have an authorization policy, access is denied", err.Method)
+}
+
+//ErrInvalidPolicies is returned on startup when the authorization policies are out
+//of sync with the rpcs that are actually served
+type ErrInvalidPolicies struct {
+	Missing []string
+	Stale   []string
+}
+
+func (err *ErrInvalidPolicies) Error() string {
+	reasons := []string{}
+
+	if len(err.Missing) > 0 {
+		reasons = append(reasons, fmt.Sprintf("no policy registered for %s", strings.Join(err.Missing, ", ")))
+	}
+
+	if len(err.Stale) > 0 {
+		reasons = append(reasons, fmt.Sprintf("policy registered for unknown %s", strings.Join(err.Stale, ", ")))
+	}
+
+	return "invalid authorization policies: " + strings.Join(reasons, "; ")
+}
@@ -0,0 +1,32 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	//ErrScheduledPriceNotFound is returned when a scheduled price that doesn't exist (or was
+	//cancelled) is referenced
+	ErrScheduledPriceNotFound = errors.New("no scheduled price was found with the provided id")
+
+	//ErrScheduledPriceOver is returned when a scheduled price is cancelled after it was
+	//applied, or after the sale it is for has ended
+	ErrScheduledPriceOver = errors.New("the scheduled price was already applied or has ended and can no longer be cancelled")
+)
+
+//ErrInvalidScheduledPrice is returned when a price is scheduled with a negative price or
+//with a sale that doesn't end after it starts
+type ErrInvalidScheduledPrice struct {
+	Price    float32
+	StartsAt time.Time
+	EndsAt   *time.Time
+}
+
+func (err *ErrInvalidScheduledPrice) Error() string {
+	if err.EndsAt != nil {
+		return fmt.Sprintf("invalid sale at %.2f from %s until %s, the price must not be negative and the sale must end after it starts", err.Price, err.StartsAt.Format(time.RFC3339), err.EndsAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("invalid scheduled price %.2f, the price must not be negative", err.Price)
+}
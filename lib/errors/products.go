@@ -7,12 +7,17 @@ import (
 	"github.com/google/uuid"
 )
 
-//ErrNoProductFound ...
+//ErrNoProductFound is returned when no product was found with the id, or with the name when
+//it was looked up by name
 type ErrNoProductFound struct {
-	ID uuid.UUID
+	ID   uuid.UUID
+	Name string
 }
 
 func (err *ErrNoProductFound) Error() string {
+	if err.Name != "" {
+		return fmt.Sprintf("no product return with the name %q", err.Name)
+	}
 	return fmt.Sprintf("no product return with the id %s", err.ID)
 }
 
@@ -20,3 +25,40 @@ var (
 	//ErrProductNotProvide is a generic error for one
 	ErrProductNotProvided = errors.New("there was no product provided when one was required, please provide a proper product")
 )
+
+var (
+	//ErrInvalidProductCursor is returned when the cursor of a product listing is malformed
+	//or was returned by a listing with another sort
+	ErrInvalidProductCursor = errors.New("the product cursor provided is invalid, please start the listing over")
+)
+
+//ErrInvalidProductSort is returned when products are sorted by a field that isn't one of the
+//sortable fields, by the same field twice or in a direction that doesn't exist
+type ErrInvalidProductSort struct {
+	Field     string
+	Direction string
+}
+
+func (err *ErrInvalidProductSort) Error() string {
+	return fmt.Sprintf("invalid product sort %q %q, the field must be one of name, cost, inventory, created_at or popularity and the direction ASC or DESC", err.Field, err.Direction)
+}
+
+//ErrInvalidProductPage is returned when products are listed with a negative limit
+type ErrInvalidProductPage struct {
+	Limit int
+}
+
+func (err *ErrInvalidProductPage) Error() string {
+	return fmt.Sprintf("invalid product page, limit %d must not be negative", err.Limit)
+}
+
+//ErrProductInUse is returned when a product is deleted for good while cart lines, and so the
+//orders they belong to, still reference it
+type ErrProductInUse struct {
+	ID    uuid.UUID
+	Lines int64
+}
+
+func (err *ErrProductInUse) Error() string {
+	return fmt.Sprintf("product %s is referenced by %d cart lines and can't be deleted for good, it can only be moved to the trash", err.ID, err.Lines)
+}
@@ -0,0 +1,23 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	//ErrInvalidSearchCursor is returned when the cursor of a search is malformed or was
+	//returned by a search with another query
+	ErrInvalidSearchCursor = errors.New("the search cursor provided is invalid, please start the search over")
+)
+
+//ErrInvalidSearchPage is returned when products are searched with a negative limit or a
+//price bucket that doesn't exist
+type ErrInvalidSearchPage struct {
+	Limit       int
+	PriceBucket string
+}
+
+func (err *ErrInvalidSearchPage) Error() string {
+	return fmt.Sprintf("invalid search page, limit %d must not be negative and price bucket %q must be one of the price buckets", err.Limit, err.PriceBucket)
+}
@@ -21,6 +21,43 @@ var (
 	//ErrNoCartService provides a clean way to prevent cart service for throwing
 	//exceptions during any initialization that might require it
 	ErrNoCartService = errors.New("no cart service was provided during service initialization, please provide one")
+	//ErrNoCheckoutService provides a clean way to prevent checkout service for throwing
+	//exceptions during any initialization that might require it
+	ErrNoCheckoutService = errors.New("no checkout service was provided during service initialization, please provide one")
+	//ErrNoAPIKeyService provides a clean way to prevent api key service for throwing
+	//exceptions during any initialization that might require it
+	ErrNoAPIKeyService = errors.New("no api key service was provided during service initialization, please provide one")
+	//ErrNoAuditService provides a clean way to prevent audit service for throwing
+	//exceptions during any initialization that might require it
+	ErrNoAuditService = errors.New("no audit service was provided during service initialization, please provide one")
+	//ErrNoIdempotencyStore provides a clean way to prevent idempotency store for throwing
+	//exceptions during any initialization that might require it
+	ErrNoIdempotencyStore = errors.New("no idempotency store was provided during service initialization, please provide one")
+	//ErrNoSchedulerService provides a clean way to prevent scheduler service for throwing
+	//exceptions during any initialization that might require it
+	ErrNoSchedulerService = errors.New("no scheduler service was provided during service initialization, please provide one")
+	//ErrNoCatalogService provides a clean way to prevent catalog service for throwing
+	//exceptions during any initialization that might require it
+	ErrNoCatalogService = errors.New("no catalog service was provided during service initialization, please provide one")
+	//ErrNoMediaService provides a clean way to prevent media service for throwing
+	//exceptions during any initialization that might require it
+	ErrNoMediaService = errors.New("no media service was provided during service initialization, please provide one")
+	//ErrNoSearchService provides a clean way to prevent search service for throwing
+	//exceptions during any initialization that might require it
+	ErrNoSearchService = errors.New("no search service was provided during service initialization, please provide one")
+	//ErrNoBulkService provides a clean way to prevent bulk service for throwing
+	//exceptions during any initialization that might require it
+	ErrNoBulkService = errors.New("no bulk service was provided during service initialization, please provide one")
+	//ErrNoPricingService provides a clean way to prevent pricing service for throwing
+	//exceptions during any initialization that might require it
+	ErrNoPricingService = errors.New("no pricing service was provided during service initialization, please provide one")
+	//ErrNoInventoryService provides a clean way to prevent inventory service for throwing
+	//exceptions during any initialization that might require it
+	ErrNoInventoryService = errors.New("no inventory service was provided during service initialization, please provide one")
+	//ErrNoLowStockNotifier is returned when the inventory service is created without a notifier
+	ErrNoLowStockNotifier = errors.New("no low stock notifier was provided during service initialization, please provide one")
+	//ErrNoBucket is returned when a service that stores uploads is created without a bucket
+	ErrNoBucket = errors.New("no bucket was provided during service initialization, please provide one")
 )
 
 type ErrInvalidRequest struct {
@@ -0,0 +1,160 @@
+package errors
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+//sentinels the grpc status codes of the errors that are returned as they are, errors with
+//fields of their own are mapped in Code instead
+var sentinels = map[error]codes.Code{
+	gorm.ErrRecordNotFound:   codes.NotFound,
+	context.Canceled:         codes.Canceled,
+	context.DeadlineExceeded: codes.DeadlineExceeded,
+
+	ErrNoUserFound:            codes.NotFound,
+	ErrShoppingCartNotFound:   codes.NotFound,
+	ErrCategoryNotFound:       codes.NotFound,
+	ErrCollectionNotFound:     codes.NotFound,
+	ErrProductImageNotFound:   codes.NotFound,
+	ErrOrderNotFound:          codes.NotFound,
+	ErrInquiryNotFound:        codes.NotFound,
+	ErrScheduledPriceNotFound: codes.NotFound,
+	ErrVariantNotFound:        codes.NotFound,
+	ErrOptionNotFound:         codes.NotFound,
+
+	ErrNoAPIKeyName:              codes.InvalidArgument,
+	ErrNoUsernameOrEmailProvided: codes.InvalidArgument,
+	ErrCartOrderNotProvided:      codes.InvalidArgument,
+	ErrNoCatalogName:             codes.InvalidArgument,
+	ErrNoPaymentMethod:           codes.InvalidArgument,
+	ErrNoCheckoutRequest:         codes.InvalidArgument,
+	ErrInvalidIdempotencyKey:     codes.InvalidArgument,
+	ErrNoInventoryReason:         codes.InvalidArgument,
+	ErrOIDCInvalidState:          codes.InvalidArgument,
+	ErrOIDCInvalidCode:           codes.InvalidArgument,
+	ErrInvalidOrderCursor:        codes.InvalidArgument,
+	ErrProductNotProvided:        codes.InvalidArgument,
+	ErrInvalidProductCursor:      codes.InvalidArgument,
+	ErrInvalidSearchCursor:       codes.InvalidArgument,
+	ErrNoVariantSKU:              codes.InvalidArgument,
+	ErrNoOptionName:              codes.InvalidArgument,
+
+	ErrInvalidAPIKey:      codes.Unauthenticated,
+	ErrAPIKeyExpired:      codes.Unauthenticated,
+	ErrInvalidPassword:    codes.Unauthenticated,
+	ErrNoMetadata:         codes.Unauthenticated,
+	ErrInvalidCartToken:   codes.Unauthenticated,
+	ErrInvalidAccessToken: codes.Unauthenticated,
+	ErrOIDCInvalidToken:   codes.Unauthenticated,
+
+	ErrOIDCEmailNotVerified: codes.PermissionDenied,
+
+	ErrEmptyShoppingCart:    codes.FailedPrecondition,
+	ErrScheduledPriceOver:   codes.FailedPrecondition,
+	ErrOIDCNotConfigured:    codes.FailedPrecondition,
+	ErrIdempotencyKeyReused: codes.FailedPrecondition,
+
+	//the request that is in progress can be retried once it is done
+	ErrRequestInProgress: codes.Aborted,
+}
+
+//Code returns the grpc status code of the error, or of the first error it wraps that has one.
+//Errors that aren't ours, i.e. those of the database, are Internal.
+func Code(err error) codes.Code {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if code, ok := sentinel(err); ok {
+			return code
+		}
+
+		switch err.(type) {
+		case *ErrNoCart, *ErrUploadNotFound, *ErrNoProductFound:
+			return codes.NotFound
+
+		case *ErrInvalidAllowedIP, *ErrUnknownPermission, *ErrInvalidAuditPage, *ErrUnknownBulkFormat, *ErrMalformedImport,
+			*ErrInvalidImport, *ErrCartActionNotRecognized, *ErrInvalidCartQuantity,
+			*ErrInvalidInventoryMovement, *ErrInvalidLowStockThreshold, *ErrInvalidUpload,
+			*ErrNoOrderInquiryProvided, *ErrInvalidOrderPage, *ErrInvalidScheduledPrice,
+			*ErrInvalidProductSort, *ErrInvalidProductPage, *ErrInvalidSearchPage, *ErrInvalidRequest,
+			*ErrInvalidVariantOptions, *ErrVariantNotProvided:
+			return codes.InvalidArgument
+
+		case *ErrInvalidHeader:
+			return codes.Unauthenticated
+
+		case ErrNoAdminAccess, *ErrNoAdminAccess, *ErrAPIKeyIPNotAllowed, *ErrAPIKeyPermissionDenied,
+			*ErrNoPolicy, *ErrOIDCDomainNotAllowed, *ErrOrderStatusNotAllowed:
+			return codes.PermissionDenied
+
+		case *ErrSlugTaken, *ErrSKUTaken, *ErrOptionTaken, *ErrVariantExists:
+			return codes.AlreadyExists
+
+		case *ErrCartQuantityExceeded, *ErrInsufficientStock, *ErrCategoryCycle, *ErrCategoryHasChildren,
+			*ErrUploadConfirmed, *ErrOrderNotArchivable, *ErrPaymentCaptured, *ErrProductInUse,
+			*ErrOptionInUse, *ErrOrderStatusTransition:
+			return codes.FailedPrecondition
+
+		//the issuer or webhook we depend on didn't respond the way it should
+		case *ErrOIDCIssuer, *ErrLowStockWebhook, *ErrAccessWebhook:
+			return codes.Unavailable
+		}
+	}
+
+	return codes.Internal
+}
+
+//sentinel returns the code of the error when it is one of the sentinels. The map isn't indexed
+//by the error since that panics for errors that aren't comparable, comparing them doesn't.
+func sentinel(err error) (codes.Code, bool) {
+	for e, code := range sentinels {
+		if err == e {
+			return code, true
+		}
+	}
+	return codes.Unknown, false
+}
+
+//Status returns the grpc status of the error, errors that already are a grpc status are
+//returned as they are. Invalid requests come with a field violation for every field that
+//was invalid.
+func Status(err error) *status.Status {
+	if err == nil {
+		return nil
+	}
+
+	if s, ok := status.FromError(err); ok {
+		return s
+	}
+
+	s := status.New(Code(err), err.Error())
+
+	var invalid *ErrInvalidRequest
+	if !errors.As(err, &invalid) || len(invalid.Fields) == 0 {
+		return s
+	}
+
+	request := new(errdetails.BadRequest)
+	for field, description := range invalid.Fields {
+		request.FieldViolations = append(request.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: description,
+		})
+	}
+
+	//the fields are a map, they are sorted so the details are the same on every response
+	sort.Slice(request.FieldViolations, func(i, j int) bool {
+		return request.FieldViolations[i].Field < request.FieldViolations[j].Field
+	})
+
+	if detailed, err := s.WithDetails(request); err == nil {
+		return detailed
+	}
+
+	return s
+}
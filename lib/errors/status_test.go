@@ -0,0 +1,66 @@
+package errors_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+func TestCode(t *testing.T) {
+	for err, code := range map[error]codes.Code{
+		errors.ErrOrderNotFound:                           codes.NotFound,
+		&errors.ErrNoProductFound{ID: uuid.New()}:         codes.NotFound,
+		gorm.ErrRecordNotFound:                            codes.NotFound,
+		errors.ErrProductNotProvided:                      codes.InvalidArgument,
+		errors.ErrNoCheckoutRequest:                       codes.InvalidArgument,
+		&errors.ErrInvalidRequest{}:                       codes.InvalidArgument,
+		&errors.ErrUnknownPermission{}:                    codes.InvalidArgument,
+		errors.ErrInvalidAPIKey:                           codes.Unauthenticated,
+		errors.ErrNoAdminAccess{Username: "user"}:         codes.PermissionDenied,
+		&errors.ErrAPIKeyPermissionDenied{}:               codes.PermissionDenied,
+		&errors.ErrSKUTaken{SKU: "TEE-S"}:                 codes.AlreadyExists,
+		&errors.ErrInsufficientStock{}:                    codes.FailedPrecondition,
+		&errors.ErrOrderStatusNotAllowed{}:                codes.PermissionDenied,
+		&errors.ErrOrderStatusTransition{}:                codes.FailedPrecondition,
+		errors.ErrRequestInProgress:                       codes.Aborted,
+		context.DeadlineExceeded:                          codes.DeadlineExceeded,
+		errors.ErrNoProductService:                        codes.Internal,
+		fmt.Errorf("saving: %w", errors.ErrOrderNotFound): codes.NotFound,
+	} {
+		assert.Equal(t, code, errors.Code(err), err.Error())
+	}
+}
+
+func TestStatus(t *testing.T) {
+	assert.Nil(t, errors.Status(nil))
+
+	//a status is returned as it is
+	denied := status.Error(codes.PermissionDenied, "denied")
+	assert.Equal(t, codes.PermissionDenied, errors.Status(denied).Code())
+
+	s := errors.Status(&errors.ErrInvalidRequest{
+		Fields: map[string]string{
+			"name": "is required",
+			"cost": "must not be negative",
+		},
+	})
+
+	assert.Equal(t, codes.InvalidArgument, s.Code())
+
+	if assert.Len(t, s.Details(), 1) {
+		request, ok := s.Details()[0].(*errdetails.BadRequest)
+		if assert.True(t, ok) && assert.Len(t, request.FieldViolations, 2) {
+			assert.Equal(t, "cost", request.FieldViolations[0].Field)
+			assert.Equal(t, "name", request.FieldViolations[1].Field)
+			assert.Equal(t, "is required", request.FieldViolations[1].Description)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+var (
+	//ErrVariantNotFound is returned when a variant that doesn't exist, or that belongs to a
+	//different product, is referenced
+	ErrVariantNotFound = errors.New("no variant of the product was found with the provided id")
+
+	//ErrOptionNotFound is returned when an option that doesn't exist is referenced
+	ErrOptionNotFound = errors.New("no option of the product was found with the provided id")
+
+	//ErrNoVariantSKU is returned when a variant is saved without a sku
+	ErrNoVariantSKU = errors.New("a sku is required for every variant, please provide one")
+
+	//ErrNoOptionName is returned when an option is saved without a name or without any values
+	ErrNoOptionName = errors.New("a name and at least one value are required for every option, please provide them")
+)
+
+//ErrSKUTaken is returned when a variant is saved with the sku of another variant
+type ErrSKUTaken struct {
+	SKU string
+}
+
+func (err *ErrSKUTaken) Error() string {
+	return fmt.Sprintf("the sku %q is already taken, please provide a different one", err.SKU)
+}
+
+//ErrOptionTaken is returned when a product would end up with two options of the same name
+type ErrOptionTaken struct {
+	ProductID uuid.UUID
+	Name      string
+}
+
+func (err *ErrOptionTaken) Error() string {
+	return fmt.Sprintf("product %s already has an option named %q", err.ProductID, err.Name)
+}
+
+//ErrOptionInUse is returned when an option, or one of its values, is removed while variants
+//of the product still use it
+type ErrOptionInUse struct {
+	OptionID uuid.UUID
+	Value    string
+}
+
+func (err *ErrOptionInUse) Error() string {
+	if err.Value == "" {
+		return fmt.Sprintf("option %s is still used by variants of its product", err.OptionID)
+	}
+	return fmt.Sprintf("the value %q of option %s is still used by variants of its product", err.Value, err.OptionID)
+}
+
+//ErrInvalidVariantOptions is returned when the option values of a variant don't match the
+//options of its product, every option has to be given one of its values
+type ErrInvalidVariantOptions struct {
+	Option string
+	Value  string
+}
+
+func (err *ErrInvalidVariantOptions) Error() string {
+	if err.Value == "" {
+		return fmt.Sprintf("no value was provided for the option %q", err.Option)
+	}
+	return fmt.Sprintf("%q isn't a value of the option %q", err.Value, err.Option)
+}
+
+//ErrVariantExists is returned when a variant is saved with the same option values as
+//another variant of the product
+type ErrVariantExists struct {
+	VariantID uuid.UUID
+}
+
+func (err *ErrVariantExists) Error() string {
+	return fmt.Sprintf("variant %s already has the same option values", err.VariantID)
+}
+
+//ErrVariantNotProvided is returned when a product with variants is added to a cart without
+//picking one of them
+type ErrVariantNotProvided struct {
+	ProductID uuid.UUID
+}
+
+func (err *ErrVariantNotProvided) Error() string {
+	return fmt.Sprintf("product %s is sold by variant, please pick one of its variants", err.ProductID)
+}
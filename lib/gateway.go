@@ -2,14 +2,15 @@ package lib
 
 import (
 	"context"
-	"fmt"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/cryptnode-software/pisces/lib/errors"
 	"github.com/google/uuid"
 	proto "go.buf.build/grpc/go/thenewlebowski/pisces/general/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 // NewGateway is the going to return a gateway i.e. "controller"
@@ -35,17 +36,68 @@ func NewGateway(env *Env, services *Services) (*Gateway, error) {
 		return nil, errors.ErrNoCartService
 	}
 
+	if services.CheckoutService == nil {
+		return nil, errors.ErrNoCheckoutService
+	}
+
+	if services.APIKeyService == nil {
+		return nil, errors.ErrNoAPIKeyService
+	}
+
+	if services.AuditService == nil {
+		return nil, errors.ErrNoAuditService
+	}
+
+	if services.IdempotencyStore == nil {
+		return nil, errors.ErrNoIdempotencyStore
+	}
+
+	if services.SchedulerService == nil {
+		return nil, errors.ErrNoSchedulerService
+	}
+
+	if services.CatalogService == nil {
+		return nil, errors.ErrNoCatalogService
+	}
+
+	if services.MediaService == nil {
+		return nil, errors.ErrNoMediaService
+	}
+
+	if services.SearchService == nil {
+		return nil, errors.ErrNoSearchService
+	}
+
+	if services.BulkService == nil {
+		return nil, errors.ErrNoBulkService
+	}
+
+	if services.PricingService == nil {
+		return nil, errors.ErrNoPricingService
+	}
+
+	if services.InventoryService == nil {
+		return nil, errors.ErrNoInventoryService
+	}
+
 	return &Gateway{
 		services: services,
 		Env:      env,
 	}, nil
 }
 
-//Gateway represents the gateway structure that accepts requests
+//Gateway represents the gateway structure that accepts requests. Only the methods of
+//proto.PiscesServer are served, over grpc and grpc-web. Its other exported methods (api keys,
+//catalog, cart products, media, pricing, inventory, trash, ...) aren't reachable by any client
+//yet since the pisces proto module has no rpcs for them. They are wired up as rpcs once it
+//does. Until then they authorize the caller themselves, since neither the policies nor the
+//validations apply to them.
 type Gateway struct {
 	proto.UnimplementedPiscesServer
 	services *Services
 	Env      *Env
+	//draining is set once the replica starts shutting down, see Drain
+	draining int32
 }
 
 //SaveOrder creates an order that
@@ -59,16 +111,41 @@ func (g *Gateway) SaveOrder(ctx context.Context, req *proto.SaveOrderRequest) (r
 
 	conditions := &SaveConditions{}
 
-	if _, err := g.services.AuthService.AuthenticateAdmin(ctx); err == nil {
+	if err := g.authorize(ctx, PermissionWriteOrders); err == nil {
 		conditions.Root = true
 	}
 
+	created := order.ID == uuid.Nil
+
+	//only those who may access the order can update it, to anyone else it doesn't exist
+	if !created {
+		if _, err := g.writableorder(ctx, order.ID, PermissionWriteOrders); err != nil {
+			return nil, err
+		}
+	}
+
+	if created {
+		if user, ok := g.user(ctx); ok {
+			order.UserID = &user.ID
+			if order.Inquiry != nil {
+				order.Inquiry.UserID = &user.ID
+			}
+		}
+	}
+
 	order, err = g.services.OrderService.SaveOrder(ctx, order, conditions)
 	if err != nil {
 		g.Env.Log.Error(err.Error())
 		return nil, err
 	}
 
+	if created {
+		g.grant(ctx, &AccessGrant{
+			OrderID:   order.ID,
+			InquiryID: order.InquiryID,
+		}, order.Inquiry)
+	}
+
 	if order.ExtID == "" {
 		switch order.PaymentMethod {
 		case PaymentMethodPaypal:
@@ -102,23 +179,75 @@ func (g *Gateway) SaveOrder(ctx context.Context, req *proto.SaveOrderRequest) (r
 //SaveCart saves the provided cart and
 func (g *Gateway) SaveCart(ctx context.Context, req *proto.SaveCartRequest) (res *proto.SaveCartResponse, err error) {
 
-	if req.Cart == nil || len(req.Cart) <= 0 {
-		return nil, &errors.ErrInvalidRequest{
-			Fields: map[string]string{
-				"reason": "no cart was provided, you can't save an empty or nil cart",
-			},
+	//an empty cart is rejected by ValidateInterceptor
+	cart := convertCart(req.Cart)
+
+	checked := make(map[uuid.UUID]bool)
+
+	for _, content := range cart {
+		//lines without an order are rejected by the cart service
+		if content.OrderID == nil || checked[*content.OrderID] {
+			continue
 		}
-	}
 
-	cart := convertCart(req.Cart)
+		if _, err := g.cartorder(ctx, *content.OrderID); err != nil {
+			return nil, err
+		}
+
+		checked[*content.OrderID] = true
+	}
 
 	cart, err = g.services.CartService.SaveCart(ctx, cart)
+	if err != nil {
+		g.Env.Log.Error(err.Error())
+		return nil, err
+	}
 
 	return &proto.SaveCartResponse{
 		Cart: convertCartToProto(cart),
 	}, nil
 }
 
+//SaveCartProduct applies the provided cart action for a product to the cart of an order
+//and returns the resulting cart. The order must be accessible to the caller, `ClearCart`
+//doesn't require a product. The variant is required for products with variants and must
+//be uuid.Nil for any other product.
+func (g *Gateway) SaveCartProduct(ctx context.Context, orderID, productID, variantID uuid.UUID, action CartAction, quantity int) ([]*Cart, error) {
+	order, err := g.cartorder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	product, variant := cartproduct(productID, variantID, action)
+
+	cart, err := g.services.CartService.SaveProduct(ctx, order, product, variant, action, quantity)
+	if err != nil {
+		g.Env.Log.Error(err.Error())
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+//cartproduct returns the product and variant a cart action is for, `ClearCart` isn't for
+//any product
+func cartproduct(productID, variantID uuid.UUID, action CartAction) (product *Product, variant *ProductVariant) {
+	if action == ClearCart {
+		return nil, nil
+	}
+
+	product = new(Product)
+	product.ID = productID
+
+	if variantID != uuid.Nil {
+		variant = new(ProductVariant)
+		variant.ID = variantID
+		variant.ProductID = productID
+	}
+
+	return
+}
+
 //Login route...
 func (g *Gateway) Login(ctx context.Context, req *proto.LoginRequest) (*proto.JWT, error) {
 	request := &LoginRequest{
@@ -132,6 +261,14 @@ func (g *Gateway) Login(ctx context.Context, req *proto.LoginRequest) (*proto.JW
 		return nil, err
 	}
 
+	//whatever the user put in their cart as a guest is added to their saved
+	//cart, failing to do so shouldn't keep them from logging in
+	if token, err := GetCartTokenFromContext(ctx); err == nil {
+		if err := g.mergecart(ctx, token, user); err != nil {
+			g.Env.Log.Error(err.Error())
+		}
+	}
+
 	token, err := g.services.AuthService.GenerateJWT(ctx, user)
 
 	return &proto.JWT{
@@ -144,6 +281,26 @@ func (g *Gateway) SaveInquiry(ctx context.Context, req *proto.Inquiry) (*proto.I
 
 	inquiry := convertInquiry(req)
 
+	created := inquiry.ID == uuid.Nil
+
+	if user, ok := g.user(ctx); ok && created {
+		inquiry.UserID = &user.ID
+	}
+
+	//only those who may access the inquiry can update it, to anyone else it doesn't exist
+	if !created {
+		existing, err := g.services.OrderService.GetInquiry(ctx, inquiry.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !g.accessible(ctx, PermissionWriteInquiries, existing.OwnedBy, func(grant *AccessGrant) bool {
+			return grant.InquiryID == existing.ID
+		}) {
+			return nil, errors.ErrInquiryNotFound
+		}
+	}
+
 	inquiry, err := g.services.OrderService.SaveInquiry(ctx, inquiry)
 
 	if err != nil {
@@ -151,6 +308,12 @@ func (g *Gateway) SaveInquiry(ctx context.Context, req *proto.Inquiry) (*proto.I
 		return nil, err
 	}
 
+	if created {
+		g.grant(ctx, &AccessGrant{
+			InquiryID: inquiry.ID,
+		}, inquiry)
+	}
+
 	return convertInquiryToProto(inquiry), nil
 }
 
@@ -163,6 +326,12 @@ func (g *Gateway) GetOrders(ctx context.Context, req *proto.GetOrdersRequest) (r
 			return nil, err
 		}
 
+		if !g.accessible(ctx, PermissionReadOrders, order.OwnedBy, func(grant *AccessGrant) bool {
+			return grant.OrderID == order.ID
+		}) {
+			return nil, errors.ErrOrderNotFound
+		}
+
 		o, err := convertOrderToProto(order)
 		if err != nil {
 			return nil, err
@@ -176,7 +345,7 @@ func (g *Gateway) GetOrders(ctx context.Context, req *proto.GetOrdersRequest) (r
 	}
 
 	//everything beyond this is admin only
-	_, err = g.AuthenticateAdmin(ctx)
+	err = g.authorize(ctx, PermissionReadOrders)
 	if err != nil {
 		g.Env.Log.Error(err.Error())
 		return
@@ -187,7 +356,17 @@ func (g *Gateway) GetOrders(ctx context.Context, req *proto.GetOrdersRequest) (r
 		SortBy: OrdersSortByDueDescending,
 	}
 
-	orders, err := g.services.OrderService.GetOrders(ctx, conditions)
+	//a request without a status lists the orders of every status
+	if conditions.Status == OrderStatusNotImplemented {
+		conditions.Status = ""
+	}
+
+	//the rpc isn't paginated, the orders are still read a page at a time
+	orders := make([]*Order, 0)
+	err = g.services.OrderService.StreamOrders(ctx, conditions, func(order *Order) error {
+		orders = append(orders, order)
+		return nil
+	})
 	if err != nil {
 		g.Env.Log.Error(err.Error())
 		return nil, err
@@ -205,6 +384,74 @@ func (g *Gateway) GetOrders(ctx context.Context, req *proto.GetOrdersRequest) (r
 	return
 }
 
+//GetArchivedOrders returns the archived orders with the provided status (of every status
+//when none is provided), they are left out of GetOrders
+func (g *Gateway) GetArchivedOrders(ctx context.Context, status OrderStatus) ([]*Order, error) {
+	if err := g.authorize(ctx, PermissionReadOrders); err != nil {
+		return nil, err
+	}
+
+	orders := make([]*Order, 0)
+	err := g.services.OrderService.StreamOrders(ctx, &OrderConditions{
+		Status:   status,
+		SortBy:   OrdersSortByDueDescending,
+		Archived: true,
+	}, func(order *Order) error {
+		orders = append(orders, order)
+		return nil
+	})
+
+	return orders, err
+}
+
+//GetOrdersPage returns a page of the orders that match the conditions along with the
+//total number of them, the next page is read with the cursor of the page
+func (g *Gateway) GetOrdersPage(ctx context.Context, conditions *OrderConditions) (*OrderPage, error) {
+	if err := g.authorize(ctx, PermissionReadOrders); err != nil {
+		return nil, err
+	}
+
+	return g.services.OrderService.GetOrders(ctx, conditions)
+}
+
+//StreamOrders calls fn with every order that matches the conditions without loading them
+//all at once, i.e. to export them
+func (g *Gateway) StreamOrders(ctx context.Context, conditions *OrderConditions, fn func(*Order) error) error {
+	if err := g.authorize(ctx, PermissionReadOrders); err != nil {
+		return err
+	}
+
+	return g.services.OrderService.StreamOrders(ctx, conditions, fn)
+}
+
+//ArchiveOrder archives a completed or cancelled order
+func (g *Gateway) ArchiveOrder(ctx context.Context, id uuid.UUID) (*Order, error) {
+	if err := g.authorize(ctx, PermissionWriteOrders); err != nil {
+		return nil, err
+	}
+
+	order, err := g.services.OrderService.GetOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.services.OrderService.ArchiveOrder(ctx, order)
+}
+
+//UnarchiveOrder takes an order out of the archive
+func (g *Gateway) UnarchiveOrder(ctx context.Context, id uuid.UUID) (*Order, error) {
+	if err := g.authorize(ctx, PermissionWriteOrders); err != nil {
+		return nil, err
+	}
+
+	order, err := g.services.OrderService.GetOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.services.OrderService.UnarchiveOrder(ctx, order)
+}
+
 //GetInquires gathers all of the inquires based off the conditions that are provided through
 //the original rpc call
 func (g *Gateway) GetInquires(ctx context.Context, req *proto.GetInquiresRequest) (res *proto.GetInquiresResponse, err error) {
@@ -216,6 +463,12 @@ func (g *Gateway) GetInquires(ctx context.Context, req *proto.GetInquiresRequest
 			return nil, err
 		}
 
+		if !g.accessible(ctx, PermissionReadInquiries, inqury.OwnedBy, func(grant *AccessGrant) bool {
+			return grant.InquiryID == inqury.ID
+		}) {
+			return nil, errors.ErrInquiryNotFound
+		}
+
 		return &proto.GetInquiresResponse{
 			Inquiry: convertInquiryToProto(inqury),
 		}, nil
@@ -223,7 +476,7 @@ func (g *Gateway) GetInquires(ctx context.Context, req *proto.GetInquiresRequest
 	}
 
 	//everything beyond this is admin only
-	_, err = g.AuthenticateAdmin(ctx)
+	err = g.authorize(ctx, PermissionReadInquiries)
 	if err != nil {
 		g.Env.Log.Error(err.Error())
 		return
@@ -311,6 +564,10 @@ func (g *Gateway) SaveProduct(ctx context.Context, req *proto.SaveProductRequest
 		convertProductFromProto(req.Product),
 	)
 
+	if err == nil {
+		g.reindex(ctx, product.ID)
+	}
+
 	res = new(proto.SaveProductResponse)
 
 	res.Product = convertProductToProto(product)
@@ -318,32 +575,20 @@ func (g *Gateway) SaveProduct(ctx context.Context, req *proto.SaveProductRequest
 	return
 }
 
+//StartUpload returns the presigned url the file can be uploaded to and the url it can be found
+//at afterwards, the url is what gets confirmed i.e. by ConfirmProductImage
 func (g *Gateway) StartUpload(ctx context.Context, req *proto.StartUploadRequest) (res *proto.StartUploadResponse, err error) {
 
 	res = new(proto.StartUploadResponse)
 
-	{
-
-		uuid := uuid.New().String() + req.Key
-
-		client := s3.NewPresignClient(g.services.S3Client)
-
-		req, err := client.PresignPutObject(ctx, &s3.PutObjectInput{
-			ACL:    types.ObjectCannedACLPublicRead,
-			Bucket: &g.Env.AWSEnv.Bucket,
-			Key:    &uuid,
-		},
-		)
-
-		if err != nil {
-			return nil, err
-		}
-
-		res.PresignedUrl = req.URL
-		res.Url = fmt.Sprintf("https://%s.%s.linodeobjects.com", g.Env.AWSEnv.Bucket, g.Env.AWSEnv.Region)
+	key := uuid.New().String() + req.Key
 
+	if res.PresignedUrl, err = g.services.Bucket.PresignPut(ctx, key); err != nil {
+		return nil, err
 	}
 
+	res.Url = g.services.Bucket.URL(key)
+
 	return
 }
 
@@ -360,6 +605,54 @@ func (g *Gateway) GeneratePaypalClientToken(ctx context.Context, req *emptypb.Em
 	}, nil
 }
 
+//StartOIDCLogin returns the url of the issuer that staff have to be sent to in
+//order to log in through single sign-on, and the state the browser is bound to
+func (g *Gateway) StartOIDCLogin(ctx context.Context) (string, string, error) {
+	if g.services.OIDCService == nil {
+		return "", "", errors.ErrOIDCNotConfigured
+	}
+
+	return g.services.OIDCService.StartLogin(ctx)
+}
+
+//CompleteOIDCLogin completes a single sign-on login with the state and code the
+//issuer redirected back with, and returns the one-time code the app redeems with
+//RedeemOIDCCode
+func (g *Gateway) CompleteOIDCLogin(ctx context.Context, state, code string) (string, error) {
+	if g.services.OIDCService == nil {
+		return "", errors.ErrOIDCNotConfigured
+	}
+
+	user, err := g.services.OIDCService.CompleteLogin(ctx, state, code)
+	if err != nil {
+		return "", err
+	}
+
+	return g.services.OIDCService.IssueCode(ctx, user)
+}
+
+//RedeemOIDCCode redeems the one-time code of a completed single sign-on login for
+//the same JWT that `Login` issues
+func (g *Gateway) RedeemOIDCCode(ctx context.Context, code string) (*proto.JWT, error) {
+	if g.services.OIDCService == nil {
+		return nil, errors.ErrOIDCNotConfigured
+	}
+
+	user, err := g.services.OIDCService.RedeemCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := g.services.AuthService.GenerateJWT(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.JWT{
+		Jwt: token,
+	}, nil
+}
+
 //CheckJWT checks to see if a jwt token is valid and whether or not it has been tampered
 //with the method that this uses `ValidateJWT` within the auth  service is one that will
 //be used to
@@ -399,3 +692,384 @@ func (g *Gateway) AuthenticateAdmin(ctx context.Context) (*User, error) {
 func (g *Gateway) AuthenticateToken(ctx context.Context) (*User, error) {
 	return g.services.AuthService.AuthenticateToken(ctx)
 }
+
+//AuthenticateAPIKey is a export by pass to allow us to directly communicate
+//with the api key service from out of the base Pisces library. The `api-key`
+//header must be set with a valid key in order to be approved.
+func (g *Gateway) AuthenticateAPIKey(ctx context.Context) (*APIKey, error) {
+	return g.services.APIKeyService.AuthenticateAPIKey(ctx)
+}
+
+//CreateAPIKey creates a new api key on behalf of the authenticated admin. The
+//returned string is the full key and is the only time it can be read.
+func (g *Gateway) CreateAPIKey(ctx context.Context, key *APIKey) (*APIKey, string, error) {
+	user, err := g.admin(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key.CreatedBy = user.ID
+
+	return g.services.APIKeyService.CreateAPIKey(ctx, key)
+}
+
+//GetAPIKeys returns every active api key, admin only
+func (g *Gateway) GetAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	if _, err := g.admin(ctx); err != nil {
+		return nil, err
+	}
+
+	return g.services.APIKeyService.GetAPIKeys(ctx)
+}
+
+//RevokeAPIKey revokes the provided api key, admin only
+func (g *Gateway) RevokeAPIKey(ctx context.Context, key *APIKey) error {
+	if _, err := g.admin(ctx); err != nil {
+		return err
+	}
+
+	return g.services.APIKeyService.RevokeAPIKey(ctx, key)
+}
+
+//GetAuditEntries returns the audit log filtered and paginated by the provided
+//options along with the total number of matching entries, admin only
+func (g *Gateway) GetAuditEntries(ctx context.Context, opts ...WithAuditOptions) ([]*AuditEntry, int64, error) {
+	if _, err := g.admin(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	return g.services.AuditService.GetAuditEntries(ctx, opts...)
+}
+
+//authorize approves a request that was either made with an api key holding the
+//provided permission or by an admin
+func (g *Gateway) authorize(ctx context.Context, permission Permission) error {
+	if key, ok := APIKeyFromContext(ctx); ok {
+		if key.HasPermission(permission) {
+			return nil
+		}
+
+		return &errors.ErrAPIKeyPermissionDenied{
+			Method: string(permission),
+		}
+	}
+
+	_, err := g.admin(ctx)
+	return err
+}
+
+//user returns the user the request was made by, if any. The user authenticated by
+//the AuthorizeInterceptor is used when there is one.
+func (g *Gateway) user(ctx context.Context) (*User, bool) {
+	if user, ok := UserFromContext(ctx); ok {
+		return user, true
+	}
+
+	if _, err := GetAuthFromContext(ctx); err != nil {
+		return nil, false
+	}
+
+	user, err := g.AuthenticateToken(ctx)
+	return user, err == nil && user != nil
+}
+
+//accessible reports whether the request may access an order or inquiry. Staff (and
+//api keys) holding the provided permission can access anything, users what they own
+//and guests what their access token was granted for.
+func (g *Gateway) accessible(ctx context.Context, permission Permission, owned func(*User) bool, granted func(*AccessGrant) bool) bool {
+	if user, ok := g.user(ctx); ok && owned(user) {
+		return true
+	}
+
+	if token, err := GetAccessTokenFromContext(ctx); err == nil {
+		grant, err := g.services.OrderService.VerifyAccessToken(ctx, token)
+		if err == nil && granted(grant) {
+			return true
+		}
+	}
+
+	return g.authorize(ctx, permission) == nil
+}
+
+//OpenCart returns the shopping cart of the caller. Users get their saved cart, guests the
+//cart of the `cart-token` header they provided. Guests without a (valid) token get a new
+//cart, its token is sent back through the `cart-token` response header.
+func (g *Gateway) OpenCart(ctx context.Context) (*ShoppingCart, error) {
+	if cart, err := g.shoppingcart(ctx); err == nil {
+		return cart, nil
+	} else if err != errors.ErrShoppingCartNotFound {
+		return nil, err
+	}
+
+	user, _ := g.user(ctx)
+
+	cart, err := g.services.CartService.OpenShoppingCart(ctx, user)
+	if err != nil {
+		g.Env.Log.Error(err.Error())
+		return nil, err
+	}
+
+	if user == nil {
+		token, err := g.services.CartService.IssueCartToken(ctx, cart)
+		if err != nil {
+			return nil, err
+		}
+
+		//there is no transport stream when the gateway is called directly
+		//i.e. in our tests, so a failure to set the header is ignored
+		_ = grpc.SetHeader(ctx, metadata.Pairs(carttokenheader, token))
+	}
+
+	return cart, nil
+}
+
+//SaveShoppingCartProduct applies the provided cart action for a product (and the variant of
+//it, see SaveCartProduct) to the shopping cart of the caller (see OpenCart), `ClearCart`
+//doesn't require a product
+func (g *Gateway) SaveShoppingCartProduct(ctx context.Context, productID, variantID uuid.UUID, action CartAction, quantity int) (*ShoppingCart, error) {
+	cart, err := g.OpenCart(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	product, variant := cartproduct(productID, variantID, action)
+
+	cart, err = g.services.CartService.SaveShoppingCartProduct(ctx, cart, product, variant, action, quantity)
+	if err != nil {
+		g.Env.Log.Error(err.Error())
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+//Checkout places the order of the request in a single operation. Without any lines the
+//shopping cart of the caller is checked out. Retries are made safe with the
+//`idempotency-key` header, just like the idempotent rpcs (see IdempotencyInterceptor).
+func (g *Gateway) Checkout(ctx context.Context, req *CheckoutRequest) (*Order, error) {
+	if req == nil {
+		return nil, errors.ErrNoCheckoutRequest
+	}
+
+	checkout := *req
+	checkout.UserID = nil
+	checkout.ShoppingCartID = nil
+
+	if user, ok := g.user(ctx); ok {
+		checkout.UserID = &user.ID
+	}
+
+	if len(checkout.Lines) == 0 {
+		cart, err := g.shoppingcart(ctx)
+		if err != nil {
+			return nil, err
+		}
+		checkout.ShoppingCartID = &cart.ID
+	}
+
+	key, err := GetIdempotencyKeyFromContext(ctx)
+	if err != nil || key == "" {
+		return g.checkout(ctx, &checkout)
+	}
+
+	if len(key) > maxidempotencykeylength {
+		return nil, errors.ErrInvalidIdempotencyKey
+	}
+
+	fingerprint, err := checkout.fingerprint()
+	if err != nil {
+		return nil, err
+	}
+
+	//the id of the order is what is stored, retries read the order again
+	result, err := g.idempotent(ctx, key, method("Checkout"), fingerprint, func(ctx context.Context) (interface{}, error) {
+		order, err := g.checkout(ctx, &checkout)
+		if err != nil {
+			return nil, err
+		}
+		return wrapperspb.String(order.ID.String()), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	placed, ok := result.(*wrapperspb.StringValue)
+	if !ok {
+		return nil, errors.ErrOrderNotFound
+	}
+
+	id, err := uuid.Parse(placed.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.services.OrderService.GetOrder(ctx, id)
+}
+
+//checkout places the order and grants the caller access to it
+func (g *Gateway) checkout(ctx context.Context, req *CheckoutRequest) (*Order, error) {
+	order, err := g.services.CheckoutService.Checkout(ctx, req)
+	if err != nil {
+		g.Env.Log.Error(err.Error())
+		return nil, err
+	}
+
+	g.grant(ctx, &AccessGrant{
+		OrderID:   order.ID,
+		InquiryID: order.InquiryID,
+	}, req.Inquiry)
+
+	return order, nil
+}
+
+//DeleteExpiredCarts removes every shopping cart that has expired, it isn't reachable
+//through any rpc and is meant to be run periodically by pisces itself
+func (g *Gateway) DeleteExpiredCarts(ctx context.Context) (int64, error) {
+	return g.services.CartService.DeleteExpiredShoppingCarts(ctx, time.Now())
+}
+
+//shoppingcart returns the existing shopping cart of the caller, users always use their own
+//cart while guests use the one of their cart token
+func (g *Gateway) shoppingcart(ctx context.Context) (*ShoppingCart, error) {
+	if user, ok := g.user(ctx); ok {
+		cart, err := g.services.CartService.OpenShoppingCart(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+
+		//a guest cart that is still around is picked up by the user, i.e. when
+		//they were already logged in before they started shopping as a guest
+		if token, err := GetCartTokenFromContext(ctx); err == nil {
+			if err := g.mergecart(ctx, token, user); err == nil {
+				return g.services.CartService.GetShoppingCart(ctx, cart.ID)
+			}
+		}
+
+		return cart, nil
+	}
+
+	token, err := GetCartTokenFromContext(ctx)
+	if err != nil {
+		return nil, errors.ErrShoppingCartNotFound
+	}
+
+	id, err := g.services.CartService.VerifyCartToken(ctx, token)
+	if err != nil {
+		return nil, errors.ErrShoppingCartNotFound
+	}
+
+	cart, err := g.services.CartService.GetShoppingCart(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	//a cart that was claimed by a user can't be reached with its guest token
+	if cart.UserID != nil {
+		return nil, errors.ErrShoppingCartNotFound
+	}
+
+	return cart, nil
+}
+
+//mergecart merges the guest cart of the provided cart token into the cart of the user
+func (g *Gateway) mergecart(ctx context.Context, token string, user *User) error {
+	id, err := g.services.CartService.VerifyCartToken(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	guest, err := g.services.CartService.GetShoppingCart(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if guest.UserID != nil {
+		return nil
+	}
+
+	_, err = g.services.CartService.MergeShoppingCarts(ctx, guest, user)
+	return err
+}
+
+//cartorder returns the order whose cart is about to be changed, as long as the request
+//may change it
+func (g *Gateway) cartorder(ctx context.Context, id uuid.UUID) (*Order, error) {
+	return g.writableorder(ctx, id, PermissionWriteCarts)
+}
+
+//writableorder returns the order that is about to be changed, as long as the request may
+//change it. Orders it may not change are reported as not found, just like the ones it may
+//not read.
+func (g *Gateway) writableorder(ctx context.Context, id uuid.UUID, permission Permission) (*Order, error) {
+	order, err := g.services.OrderService.GetOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !g.accessible(ctx, permission, order.OwnedBy, func(grant *AccessGrant) bool {
+		return grant.OrderID == order.ID
+	}) {
+		return nil, errors.ErrOrderNotFound
+	}
+
+	return order, nil
+}
+
+//grant issues a guest access token for a newly placed order or inquiry and sends it
+//back through the `access-token` response header, guests are sent it by email as well
+//through the access notifier. Failing to issue or send one doesn't fail the request,
+//the order or inquiry has already been saved.
+func (g *Gateway) grant(ctx context.Context, grant *AccessGrant, inquiry *Inquiry) {
+	token, err := g.services.OrderService.IssueAccessToken(ctx, grant)
+	if err != nil {
+		g.Env.Log.Error(err.Error())
+		return
+	}
+
+	//there is no transport stream when the gateway is called directly
+	//i.e. in our tests, so a failure to set the header is ignored
+	_ = grpc.SetHeader(ctx, metadata.Pairs(accesstokenheader, token))
+
+	//users reach what they placed through their account instead
+	if _, ok := g.user(ctx); ok || g.services.AccessNotifier == nil {
+		return
+	}
+
+	//an order can be placed for an inquiry that was saved on its own
+	if inquiry == nil {
+		if inquiry, err = g.services.OrderService.GetInquiry(ctx, grant.InquiryID); err != nil {
+			g.Env.Log.Error(err.Error())
+			return
+		}
+	}
+
+	if inquiry.Email == "" {
+		return
+	}
+
+	if err := g.services.AccessNotifier.NotifyAccess(ctx, &AccessNotice{
+		Email:       inquiry.Email,
+		OrderID:     grant.OrderID,
+		InquiryID:   grant.InquiryID,
+		AccessToken: token,
+	}); err != nil {
+		g.Env.Log.Error(err.Error())
+	}
+}
+
+//admin returns the admin the request was made by. The user authenticated by the
+//AuthorizeInterceptor is used when there is one, requests that didn't go through
+//it (i.e. the gateway is called directly) are authenticated here.
+func (g *Gateway) admin(ctx context.Context) (*User, error) {
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		return g.AuthenticateAdmin(ctx)
+	}
+
+	if !user.Admin {
+		return nil, errors.ErrNoAdminAccess{Username: user.Username}
+	}
+
+	return user, nil
+}
@@ -0,0 +1,228 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	proto "go.buf.build/grpc/go/thenewlebowski/pisces/general/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+//orderservice keeps orders in memory, its access tokens are simply "grant:" followed
+//by the id of the order they grant access to
+type orderservice struct {
+	OrderService
+	orders map[uuid.UUID]*Order
+	saved  []uuid.UUID
+}
+
+func (s *orderservice) SaveOrder(ctx context.Context, order *Order, conditions *SaveConditions) (*Order, error) {
+	s.saved = append(s.saved, order.ID)
+	if order.ID == uuid.Nil {
+		order.ID = uuid.New()
+	}
+	return order, nil
+}
+
+func (s *orderservice) GetOrder(ctx context.Context, id uuid.UUID) (*Order, error) {
+	order, ok := s.orders[id]
+	if !ok {
+		return nil, errors.ErrOrderNotFound
+	}
+	return order, nil
+}
+
+func (s *orderservice) IssueAccessToken(ctx context.Context, grant *AccessGrant) (string, error) {
+	return "grant:" + grant.OrderID.String(), nil
+}
+
+func (s *orderservice) VerifyAccessToken(ctx context.Context, token string) (*AccessGrant, error) {
+	id, err := uuid.Parse(strings.TrimPrefix(token, "grant:"))
+	if err != nil {
+		return nil, errors.ErrInvalidAccessToken
+	}
+	return &AccessGrant{OrderID: id}, nil
+}
+
+//accessnotifier keeps the notices it was asked to send
+type accessnotifier struct {
+	notices []*AccessNotice
+}
+
+func (n *accessnotifier) NotifyAccess(ctx context.Context, notice *AccessNotice) error {
+	n.notices = append(n.notices, notice)
+	return nil
+}
+
+func TestGetOrdersOwnership(t *testing.T) {
+	owned := &Order{UserID: &users["user"].ID}
+	owned.ID = uuid.New()
+
+	guest := &Order{}
+	guest.ID = uuid.New()
+
+	gateway := &Gateway{
+		Env: &Env{},
+		services: &Services{
+			AuthService: new(authservice),
+			OrderService: &orderservice{
+				orders: map[uuid.UUID]*Order{
+					owned.ID: owned,
+					guest.ID: guest,
+				},
+			},
+		},
+	}
+
+	tables := []struct {
+		order  uuid.UUID
+		auth   string
+		access string
+		err    error
+	}{
+		{order: owned.ID, auth: "user"},
+		{order: owned.ID, auth: "admin"},
+		{order: owned.ID, auth: "other", err: errors.ErrOrderNotFound},
+		{order: owned.ID, auth: "forged", err: errors.ErrOrderNotFound},
+		{order: owned.ID, err: errors.ErrOrderNotFound},
+		{order: guest.ID, access: "grant:" + guest.ID.String()},
+		{order: guest.ID, access: "grant:" + owned.ID.String(), err: errors.ErrOrderNotFound},
+		{order: guest.ID, auth: "user", err: errors.ErrOrderNotFound},
+		{order: uuid.New(), auth: "admin", err: errors.ErrOrderNotFound},
+	}
+
+	for _, table := range tables {
+		ctx := context.Background()
+		if table.auth != "" {
+			ctx = SetAuthContext(ctx, table.auth)
+		}
+		if table.access != "" {
+			ctx = SetAccessTokenContext(ctx, table.access)
+		}
+
+		res, err := gateway.GetOrders(ctx, &proto.GetOrdersRequest{
+			OrderId: table.order.String(),
+		})
+
+		//an order that can't be read must look exactly like one that doesn't exist
+		assert.Equal(t, table.err, err, "order %s with %q/%q", table.order, table.auth, table.access)
+
+		if table.err == nil && assert.NotNil(t, res) && assert.NotNil(t, res.Order) {
+			assert.Equal(t, table.order.String(), res.Order.Id)
+		}
+	}
+}
+
+func TestSaveOrderOwnership(t *testing.T) {
+	owned := &Order{UserID: &users["user"].ID}
+	owned.ID = uuid.New()
+
+	guest := &Order{}
+	guest.ID = uuid.New()
+
+	orders := &orderservice{
+		orders: map[uuid.UUID]*Order{
+			owned.ID: owned,
+			guest.ID: guest,
+		},
+	}
+
+	gateway := &Gateway{
+		Env: &Env{},
+		services: &Services{
+			AuthService:  new(authservice),
+			OrderService: orders,
+		},
+	}
+
+	tables := []struct {
+		order  uuid.UUID
+		auth   string
+		access string
+		err    error
+	}{
+		{order: owned.ID, auth: "user"},
+		{order: owned.ID, auth: "admin"},
+		{order: owned.ID, auth: "other", err: errors.ErrOrderNotFound},
+		{order: owned.ID, err: errors.ErrOrderNotFound},
+		{order: guest.ID, access: "grant:" + guest.ID.String()},
+		{order: guest.ID, access: "grant:" + owned.ID.String(), err: errors.ErrOrderNotFound},
+		{order: uuid.New(), auth: "admin", err: errors.ErrOrderNotFound},
+	}
+
+	for _, table := range tables {
+		ctx := context.Background()
+		if table.auth != "" {
+			ctx = SetAuthContext(ctx, table.auth)
+		}
+		if table.access != "" {
+			ctx = SetAccessTokenContext(ctx, table.access)
+		}
+
+		orders.saved = nil
+
+		_, err := gateway.SaveOrder(ctx, &proto.SaveOrderRequest{
+			Order: &proto.Order{
+				Id:  table.order.String(),
+				Due: timestamppb.Now(),
+			},
+		})
+
+		//an order that can't be updated must look exactly like one that doesn't exist
+		assert.Equal(t, table.err, err, "order %s with %q/%q", table.order, table.auth, table.access)
+
+		if table.err == nil {
+			assert.Equal(t, []uuid.UUID{table.order}, orders.saved)
+		} else {
+			assert.Empty(t, orders.saved)
+		}
+	}
+}
+
+func TestSaveOrderNotifyAccess(t *testing.T) {
+	notifier := new(accessnotifier)
+
+	gateway := &Gateway{
+		Env: &Env{},
+		services: &Services{
+			AuthService:    new(authservice),
+			OrderService:   new(orderservice),
+			AccessNotifier: notifier,
+		},
+	}
+
+	save := func(ctx context.Context) *proto.Order {
+		res, err := gateway.SaveOrder(ctx, &proto.SaveOrderRequest{
+			Order: &proto.Order{
+				Due: timestamppb.Now(),
+				Inquiry: &proto.Inquiry{
+					Email: "guest@test.io",
+				},
+			},
+		})
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		return res.Order
+	}
+
+	//the guest is sent the token they can look the order up with
+	order := save(context.Background())
+
+	if assert.Len(t, notifier.notices, 1) {
+		notice := notifier.notices[0]
+		assert.Equal(t, "guest@test.io", notice.Email)
+		assert.NotEqual(t, uuid.Nil, notice.OrderID)
+		assert.Equal(t, order.Id, notice.OrderID.String())
+		assert.Equal(t, "grant:"+notice.OrderID.String(), notice.AccessToken)
+	}
+
+	//a user reaches the order through their account
+	notifier.notices = nil
+	save(SetAuthContext(context.Background(), "user"))
+	assert.Empty(t, notifier.notices)
+}
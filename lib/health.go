@@ -0,0 +1,179 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib/errors"
+)
+
+// healthtimeout is how long a dependency has to respond before it is considered down
+const healthtimeout = 2 * time.Second
+
+// HealthCheck a dependency that is checked before the replica reports itself ready. A failing
+// optional dependency is reported but doesn't keep the replica from being ready, i.e. only
+// uploads need the bucket.
+type HealthCheck struct {
+	Name     string
+	Optional bool
+	Check    func(ctx context.Context) error
+}
+
+// HealthStatus the primitive type for the outcome of a health check
+type HealthStatus string
+
+const (
+	//HealthStatusUp is the status of a dependency that responded
+	HealthStatusUp HealthStatus = "UP"
+	//HealthStatusDown is the status of a dependency that failed or didn't respond in time
+	HealthStatusDown HealthStatus = "DOWN"
+)
+
+// HealthResult the outcome of a single health check
+type HealthResult struct {
+	Status   HealthStatus `json:"status"`
+	Optional bool         `json:"optional"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// HealthReport the outcome of the health checks of the replica. It isn't ready while it is
+// draining or when a dependency that isn't optional is down.
+type HealthReport struct {
+	Ready    bool                     `json:"ready"`
+	Draining bool                     `json:"draining"`
+	Checks   map[string]*HealthResult `json:"checks"`
+}
+
+// Drain marks the replica as shutting down, it reports itself as not ready from then on so no
+// new requests are routed to it while the ones in flight are finished
+func (g *Gateway) Drain() {
+	atomic.StoreInt32(&g.draining, 1)
+}
+
+// Draining reports whether the replica is shutting down
+func (g *Gateway) Draining() bool {
+	return atomic.LoadInt32(&g.draining) == 1
+}
+
+// Ready checks the dependencies of the replica, it is what the readiness probe is answered with
+func (g *Gateway) Ready(ctx context.Context) *HealthReport {
+	report := Check(ctx, g.healthchecks()...)
+
+	if g.Draining() {
+		report.Ready = false
+		report.Draining = true
+	}
+
+	return report
+}
+
+// healthchecks are the dependencies of pisces, the database is the only one that is required
+func (g *Gateway) healthchecks() []*HealthCheck {
+	checks := []*HealthCheck{
+		{
+			Name: "database",
+			Check: func(ctx context.Context) error {
+				db, err := g.Env.GormDB.DB()
+				if err != nil {
+					return err
+				}
+
+				return db.PingContext(ctx)
+			},
+		},
+	}
+
+	if g.services.Bucket != nil {
+		checks = append(checks, &HealthCheck{
+			Name:     "bucket",
+			Optional: true,
+			Check:    g.services.Bucket.Ping,
+		})
+	}
+
+	if g.Env.OIDCEnv != nil {
+		checks = append(checks, &HealthCheck{
+			Name:     "oidc",
+			Optional: true,
+			Check: func(ctx context.Context) error {
+				return pingissuer(ctx, g.Env.OIDCEnv.Issuer)
+			},
+		})
+	}
+
+	return checks
+}
+
+// Check runs the health checks at once, every one of them within the health timeout
+func Check(ctx context.Context, checks ...*HealthCheck) *HealthReport {
+	report := &HealthReport{
+		Ready:  true,
+		Checks: make(map[string]*HealthResult, len(checks)),
+	}
+
+	var (
+		mutex sync.Mutex
+		group sync.WaitGroup
+	)
+
+	for _, check := range checks {
+		group.Add(1)
+
+		go func(check *HealthCheck) {
+			defer group.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, healthtimeout)
+			defer cancel()
+
+			result := &HealthResult{
+				Status:   HealthStatusUp,
+				Optional: check.Optional,
+			}
+
+			if err := check.Check(ctx); err != nil {
+				result.Status = HealthStatusDown
+				result.Error = err.Error()
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			report.Checks[check.Name] = result
+
+			if result.Status == HealthStatusDown && !check.Optional {
+				report.Ready = false
+			}
+		}(check)
+	}
+
+	group.Wait()
+
+	return report
+}
+
+// pingissuer makes sure the issuer serves its discovery document, the oidc service only
+// fetches it once so it can't tell whether the issuer is still up
+func pingissuer(ctx context.Context, issuer string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return &errors.ErrOIDCIssuer{
+			Endpoint: req.URL.Path,
+			Status:   res.StatusCode,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	up := func(ctx context.Context) error {
+		return nil
+	}
+
+	down := func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}
+
+	//a dependency that doesn't respond is cut off by the health timeout
+	hanging := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	tables := []struct {
+		checks []*HealthCheck
+		ready  bool
+	}{
+		{ready: true},
+		{checks: []*HealthCheck{{Name: "database", Check: up}}, ready: true},
+		{checks: []*HealthCheck{{Name: "database", Check: down}}},
+		{checks: []*HealthCheck{{Name: "database", Check: up}, {Name: "bucket", Optional: true, Check: down}}, ready: true},
+		{checks: []*HealthCheck{{Name: "database", Check: hanging}, {Name: "bucket", Optional: true, Check: up}}},
+	}
+
+	for _, table := range tables {
+		report := Check(context.Background(), table.checks...)
+
+		assert.Equal(t, table.ready, report.Ready)
+		assert.Len(t, report.Checks, len(table.checks))
+
+		for _, check := range table.checks {
+			result := report.Checks[check.Name]
+			if !assert.NotNil(t, result, check.Name) {
+				continue
+			}
+
+			assert.Equal(t, check.Optional, result.Optional)
+			assert.Equal(t, result.Status == HealthStatusDown, result.Error != "")
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	gateway := new(Gateway)
+	assert.False(t, gateway.Draining())
+
+	gateway.Drain()
+	assert.True(t, gateway.Draining())
+}
+
+func TestPingIssuer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/.well-known/openid-configuration" {
+			http.NotFound(resp, req)
+		}
+	}))
+	defer server.Close()
+
+	assert.Nil(t, pingissuer(context.Background(), server.URL))
+	assert.NotNil(t, pingissuer(context.Background(), server.URL+"/missing"))
+}
@@ -0,0 +1,373 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib/errors"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	idempotencyheader = "idempotency-key"
+
+	//maxidempotencykeylength is the longest idempotency key we accept
+	maxidempotencykeylength = 255
+
+	// IdempotencyExpiry is how long the result of a request is kept around for retries
+	IdempotencyExpiry = 24 * time.Hour
+
+	// IdempotencyLease is how long a request holds on to its idempotency key, it is renewed
+	// while the request is being handled so a request that is still pending after that is
+	// assumed to have died and the key can be used again
+	IdempotencyLease = time.Minute
+)
+
+// IdempotencyStore stores the results of the requests that were made with an idempotency key.
+// There is a database and an in memory implementation, see lib/idempotency.
+type IdempotencyStore interface {
+	//Claim claims the key of the record. It reports false along with the existing record when
+	//the key was already claimed, unless that claim expired or is still pending and was last
+	//updated before stale, in which case it is taken over.
+	Claim(ctx context.Context, record *IdempotencyRecord, stale time.Time) (*IdempotencyRecord, bool, error)
+	//Complete stores the result of the request the record was claimed for
+	Complete(ctx context.Context, record *IdempotencyRecord) error
+	//Renew extends the lease on a key whose request is still pending
+	Renew(ctx context.Context, key string) error
+	//Release gives up the claim on a key whose request didn't finish
+	Release(ctx context.Context, key string) error
+	//DeleteExpired removes every record that expired before the provided time
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// IdempotencyStatus the primitive type for the state of a request made with an idempotency key
+type IdempotencyStatus string
+
+const (
+	//IdempotencyStatusPending is the state of a request that is still being handled
+	IdempotencyStatusPending IdempotencyStatus = "PENDING"
+	//IdempotencyStatusCompleted is the state of a request whose result has been stored
+	IdempotencyStatusCompleted IdempotencyStatus = "COMPLETED"
+)
+
+// IdempotencyRecord the stored result of a request made with an idempotency key. Key is a hash of
+// the idempotency key, who made the request and the rpc, Fingerprint is a hash of the request.
+type IdempotencyRecord struct {
+	Key         string `gorm:"primaryKey"`
+	Method      string
+	Fingerprint string
+	Status      IdempotencyStatus
+	//Response is the response as a marshalled anypb.Any. When the request failed Code and
+	//Message are the status of the error instead, and Response is the status in full along
+	//with its details.
+	Response []byte
+	Code     uint32
+	Message  string
+	//Headers are the response headers as a json document
+	Headers   string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Expired reports whether the record has expired by the provided time
+func (record *IdempotencyRecord) Expired(now time.Time) bool {
+	return !record.ExpiresAt.After(now)
+}
+
+// replay returns the stored result of the record, as it was returned the first time
+func (record *IdempotencyRecord) replay(ctx context.Context) (interface{}, error) {
+	if record.Headers != "" {
+		md := metadata.MD{}
+		if err := json.Unmarshal([]byte(record.Headers), &md); err != nil {
+			return nil, err
+		}
+
+		_ = grpc.SetHeader(ctx, md)
+	}
+
+	if codes.Code(record.Code) != codes.OK {
+		//records that were stored before the status was kept in full only have its code
+		//and message
+		if len(record.Response) == 0 {
+			return nil, status.Error(codes.Code(record.Code), record.Message)
+		}
+
+		stored := new(spb.Status)
+		if err := proto.Unmarshal(record.Response, stored); err != nil {
+			return nil, err
+		}
+
+		return nil, status.ErrorProto(stored)
+	}
+
+	packed := new(anypb.Any)
+	if err := proto.Unmarshal(record.Response, packed); err != nil {
+		return nil, err
+	}
+
+	return packed.UnmarshalNew()
+}
+
+// GetIdempotencyKeyFromContext returns the idempotency key provided through the
+// `idempotency-key` header
+func GetIdempotencyKeyFromContext(ctx context.Context) (string, error) {
+	return getHeaderFromContext(ctx, idempotencyheader)
+}
+
+// IdempotencyInterceptor makes retries of the idempotent rpcs (see Policy) safe. The result of
+// the first request made with an `idempotency-key` header is stored, including its response
+// headers, and returned again for every retry with the same key by the same caller. A retry
+// with a different request is rejected, as is one that comes in while the first request is
+// still being handled. Requests without the header are handled as usual, as are those of the
+// guests that hold neither a cart nor an access token since nothing tells them apart.
+func (g *Gateway) IdempotencyInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	policy, ok := GetPolicy(info.FullMethod)
+	if !ok || !policy.Idempotent {
+		return handler(ctx, req)
+	}
+
+	key, err := GetIdempotencyKeyFromContext(ctx)
+	if err != nil || key == "" {
+		return handler(ctx, req)
+	}
+
+	if len(key) > maxidempotencykeylength {
+		return nil, errors.ErrInvalidIdempotencyKey
+	}
+
+	message, ok := req.(proto.Message)
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.idempotent(ctx, key, info.FullMethod, hash(string(b)), func(ctx context.Context) (interface{}, error) {
+		return handler(ctx, req)
+	})
+}
+
+// idempotent runs fn once for the idempotency key of the caller and the method, the result is
+// stored along with the headers fn sets and returned again for every retry. The fingerprint
+// identifies the request, a retry with a different one is rejected.
+func (g *Gateway) idempotent(ctx context.Context, key, method, fingerprint string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	now := time.Now()
+	store := g.services.IdempotencyStore
+
+	//guests without a token of their own share a scope, the response to one of them must
+	//never be replayed to another
+	scope := principal(ctx)
+	if scope == anonymous {
+		return fn(ctx)
+	}
+
+	record, claimed, err := store.Claim(ctx, &IdempotencyRecord{
+		Key:         hash(key, scope, method),
+		Method:      method,
+		Fingerprint: fingerprint,
+		Status:      IdempotencyStatusPending,
+		ExpiresAt:   now.Add(IdempotencyExpiry),
+	}, now.Add(-IdempotencyLease))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !claimed {
+		if record.Fingerprint != fingerprint {
+			return nil, errors.ErrIdempotencyKeyReused
+		}
+
+		if record.Status != IdempotencyStatusCompleted {
+			return nil, errors.ErrRequestInProgress
+		}
+
+		return record.replay(ctx)
+	}
+
+	stream := &headerstream{
+		ServerTransportStream: grpc.ServerTransportStreamFromContext(ctx),
+		header:                metadata.MD{},
+	}
+
+	//a request that panics never stores its result, so give up the key right away
+	//instead of having retries wait for the lease to run out
+	defer func() {
+		if p := recover(); p != nil {
+			if err := store.Release(ctx, record.Key); err != nil {
+				g.Env.Log.Error(err.Error())
+			}
+			panic(p)
+		}
+	}()
+
+	//the lease is renewed for as long as the request is being handled
+	done := make(chan struct{})
+	defer close(done)
+	go g.renew(ctx, record.Key, done)
+
+	resp, err := fn(grpc.NewContextWithServerTransportStream(ctx, stream))
+
+	if cerr := complete(record, resp, err, stream.header); cerr != nil {
+		g.Env.Log.Error(cerr.Error())
+		if rerr := store.Release(ctx, record.Key); rerr != nil {
+			g.Env.Log.Error(rerr.Error())
+		}
+		return resp, err
+	}
+
+	if cerr := store.Complete(ctx, record); cerr != nil {
+		//the request itself went through, retries are told it is in progress
+		//until the lease runs out and are handled once more after that
+		g.Env.Log.Error(cerr.Error())
+	}
+
+	return resp, err
+}
+
+// renew keeps renewing the lease on the key until done is closed, so a request that takes
+// longer than the lease isn't taken over by a retry and handled twice
+func (g *Gateway) renew(ctx context.Context, key string, done <-chan struct{}) {
+	ticker := time.NewTicker(IdempotencyLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := g.services.IdempotencyStore.Renew(ctx, key); err != nil {
+				g.Env.Log.Error(err.Error())
+			}
+		}
+	}
+}
+
+// DeleteExpiredIdempotencyRecords removes the stored results that have expired, it isn't
+// reachable through any rpc and is meant to be run periodically by pisces itself
+func (g *Gateway) DeleteExpiredIdempotencyRecords(ctx context.Context) (int64, error) {
+	return g.services.IdempotencyStore.DeleteExpired(ctx, time.Now())
+}
+
+// complete stores the result of the request on the record
+func complete(record *IdempotencyRecord, resp interface{}, err error, header metadata.MD) error {
+	record.Status = IdempotencyStatusCompleted
+
+	if len(header) > 0 {
+		b, err := json.Marshal(header)
+		if err != nil {
+			return err
+		}
+		record.Headers = string(b)
+	}
+
+	//the interceptor runs within StatusInterceptor, so the error is turned into its status
+	//here as well or a replay would lose the code and details of the first response
+	if err != nil {
+		s := errors.Status(err)
+		record.Code = uint32(s.Code())
+		record.Message = s.Message()
+
+		record.Response, err = proto.Marshal(s.Proto())
+		return err
+	}
+
+	message, ok := resp.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "the response of %s can't be stored", record.Method)
+	}
+
+	packed, err := anypb.New(message)
+	if err != nil {
+		return err
+	}
+
+	record.Response, err = proto.Marshal(packed)
+	return err
+}
+
+// anonymous is the scope of the guests that hold neither a cart nor an access token
+const anonymous = "guest"
+
+// principal identifies who made the request, idempotency keys are scoped to it. Guests are
+// identified by the cart or access token they hold, those without either share a scope and
+// their requests aren't stored.
+func principal(ctx context.Context) string {
+	if key, ok := APIKeyFromContext(ctx); ok {
+		return "api-key:" + key.ID.String()
+	}
+
+	if user, ok := UserFromContext(ctx); ok {
+		return "user:" + user.ID.String()
+	}
+
+	if token, err := GetCartTokenFromContext(ctx); err == nil && token != "" {
+		return "guest:cart:" + hash(token)
+	}
+
+	if token, err := GetAccessTokenFromContext(ctx); err == nil && token != "" {
+		return "guest:access:" + hash(token)
+	}
+
+	return anonymous
+}
+
+func hash(values ...string) string {
+	h := sha256.New()
+	for _, value := range values {
+		//quoting every value keeps ("ab", "c") and ("a", "bc") apart
+		b, _ := json.Marshal(value)
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// headerstream records the headers the handler sets so they can be replayed along with the
+// response, they are still passed on to the actual stream
+type headerstream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *headerstream) Method() string {
+	if s.ServerTransportStream == nil {
+		return ""
+	}
+	return s.ServerTransportStream.Method()
+}
+
+func (s *headerstream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	if s.ServerTransportStream == nil {
+		return nil
+	}
+	return s.ServerTransportStream.SetHeader(md)
+}
+
+func (s *headerstream) SendHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	if s.ServerTransportStream == nil {
+		return nil
+	}
+	return s.ServerTransportStream.SendHeader(md)
+}
+
+func (s *headerstream) SetTrailer(md metadata.MD) error {
+	if s.ServerTransportStream == nil {
+		return nil
+	}
+	return s.ServerTransportStream.SetTrailer(md)
+}
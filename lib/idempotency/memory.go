@@ -0,0 +1,101 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib"
+)
+
+//MemoryStore keeps idempotency records in memory. Records are lost on restart and aren't shared
+//between replicas, so it is only suitable for a single replica and our tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*lib.IdempotencyRecord
+}
+
+//NewMemoryStore returns an empty store that satisfies the lib.IdempotencyStore interface
+func NewMemoryStore() lib.IdempotencyStore {
+	return &MemoryStore{
+		records: make(map[string]*lib.IdempotencyRecord),
+	}
+}
+
+//Claim stores the record, when its key is already taken the existing record is taken over if
+//it expired or if it is still pending and was last updated before stale
+func (s *MemoryStore) Claim(ctx context.Context, record *lib.IdempotencyRecord, stale time.Time) (*lib.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	if existing, ok := s.records[record.Key]; ok {
+		pending := existing.Status == lib.IdempotencyStatusPending && existing.UpdatedAt.Before(stale)
+
+		if !existing.Expired(now) && !pending {
+			result := *existing
+			return &result, false, nil
+		}
+	}
+
+	stored := *record
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	s.records[record.Key] = &stored
+
+	return record, true, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, record *lib.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.records[record.Key]
+	if !ok {
+		return nil
+	}
+
+	stored := *record
+	stored.CreatedAt = existing.CreatedAt
+	stored.UpdatedAt = time.Now()
+	s.records[record.Key] = &stored
+
+	return nil
+}
+
+func (s *MemoryStore) Renew(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[key]; ok && existing.Status == lib.IdempotencyStatusPending {
+		existing.UpdatedAt = time.Now()
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[key]; ok && existing.Status == lib.IdempotencyStatusPending {
+		delete(s.records, key)
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpired(ctx context.Context, now time.Time) (deleted int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, record := range s.records {
+		if record.Expired(now) {
+			delete(s.records, key)
+			deleted++
+		}
+	}
+
+	return
+}
@@ -0,0 +1,79 @@
+package idempotency_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/idempotency"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := idempotency.NewMemoryStore()
+	now := time.Now()
+
+	record := func(key string, expires time.Duration) *lib.IdempotencyRecord {
+		return &lib.IdempotencyRecord{
+			Key:         key,
+			Fingerprint: "fingerprint",
+			Status:      lib.IdempotencyStatusPending,
+			ExpiresAt:   now.Add(expires),
+		}
+	}
+
+	_, claimed, err := store.Claim(ctx, record("a", time.Hour), now.Add(-time.Minute))
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+
+	//a pending claim can't be claimed again until it is stale
+	existing, claimed, err := store.Claim(ctx, record("a", time.Hour), now.Add(-time.Minute))
+	assert.Nil(t, err)
+	assert.False(t, claimed)
+	assert.Equal(t, lib.IdempotencyStatusPending, existing.Status)
+
+	_, claimed, _ = store.Claim(ctx, record("a", time.Hour), now.Add(time.Minute))
+	assert.True(t, claimed)
+
+	//completed records are returned as they were stored
+	completed := record("a", time.Hour)
+	completed.Status = lib.IdempotencyStatusCompleted
+	completed.Response = []byte("response")
+	assert.Nil(t, store.Complete(ctx, completed))
+
+	existing, claimed, _ = store.Claim(ctx, record("a", time.Hour), now.Add(time.Minute))
+	assert.False(t, claimed)
+	assert.Equal(t, lib.IdempotencyStatusCompleted, existing.Status)
+	assert.Equal(t, []byte("response"), existing.Response)
+
+	//releasing only gives up pending claims
+	assert.Nil(t, store.Release(ctx, "a"))
+	_, claimed, _ = store.Claim(ctx, record("a", time.Hour), now.Add(-time.Minute))
+	assert.False(t, claimed)
+
+	_, claimed, _ = store.Claim(ctx, record("b", time.Hour), now.Add(-time.Minute))
+	assert.True(t, claimed)
+	assert.Nil(t, store.Release(ctx, "b"))
+	_, claimed, _ = store.Claim(ctx, record("b", time.Hour), now.Add(-time.Minute))
+	assert.True(t, claimed)
+
+	//a renewed claim isn't stale yet
+	_, claimed, _ = store.Claim(ctx, record("d", time.Hour), now.Add(-time.Minute))
+	assert.True(t, claimed)
+	stale := time.Now()
+	assert.Nil(t, store.Renew(ctx, "d"))
+	_, claimed, _ = store.Claim(ctx, record("d", time.Hour), stale)
+	assert.False(t, claimed)
+
+	//expired records are taken over and removed
+	_, claimed, _ = store.Claim(ctx, record("c", -time.Second), now.Add(-time.Minute))
+	assert.True(t, claimed)
+	_, claimed, _ = store.Claim(ctx, record("c", -time.Second), now.Add(-time.Minute))
+	assert.True(t, claimed)
+
+	deleted, err := store.DeleteExpired(ctx, now)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
@@ -0,0 +1,102 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//Store keeps idempotency records in the database, so every replica of pisces shares them
+type Store struct {
+	*gorm.DB
+}
+
+//NewStore returns a store that satisfies the lib.IdempotencyStore interface, backed by the
+//database of the env
+func NewStore(env *lib.Env) (lib.IdempotencyStore, error) {
+	return &Store{
+		env.GormDB,
+	}, nil
+}
+
+//Claim inserts the record, when its key is already taken the existing record is taken over if
+//it expired or if it is still pending and was last updated before stale
+func (s *Store) Claim(ctx context.Context, record *lib.IdempotencyRecord, stale time.Time) (*lib.IdempotencyRecord, bool, error) {
+	result := s.DB.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(record)
+
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+
+	if result.RowsAffected == 1 {
+		return record, true, nil
+	}
+
+	now := time.Now()
+
+	result = s.DB.WithContext(ctx).Model(new(lib.IdempotencyRecord)).
+		Where("`key` = ?", record.Key).
+		Where(s.DB.Where("expires_at <= ?", now).Or("status = ? AND updated_at < ?", lib.IdempotencyStatusPending, stale)).
+		Updates(map[string]interface{}{
+			"method":      record.Method,
+			"fingerprint": record.Fingerprint,
+			"status":      record.Status,
+			"response":    nil,
+			"code":        0,
+			"message":     "",
+			"headers":     "",
+			"expires_at":  record.ExpiresAt,
+		})
+
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+
+	if result.RowsAffected == 1 {
+		return record, true, nil
+	}
+
+	existing := new(lib.IdempotencyRecord)
+	if err := s.DB.WithContext(ctx).First(existing, "`key` = ?", record.Key).Error; err != nil {
+		return nil, false, err
+	}
+
+	return existing, false, nil
+}
+
+func (s *Store) Complete(ctx context.Context, record *lib.IdempotencyRecord) error {
+	return s.DB.WithContext(ctx).Model(new(lib.IdempotencyRecord)).
+		Where("`key` = ?", record.Key).
+		Updates(map[string]interface{}{
+			"status":   record.Status,
+			"response": record.Response,
+			"code":     record.Code,
+			"message":  record.Message,
+			"headers":  record.Headers,
+		}).Error
+}
+
+func (s *Store) Renew(ctx context.Context, key string) error {
+	return s.DB.WithContext(ctx).Model(new(lib.IdempotencyRecord)).
+		Where("`key` = ? AND status = ?", key, lib.IdempotencyStatusPending).
+		Update("updated_at", time.Now()).Error
+}
+
+func (s *Store) Release(ctx context.Context, key string) error {
+	return s.DB.WithContext(ctx).
+		Where("`key` = ? AND status = ?", key, lib.IdempotencyStatusPending).
+		Delete(new(lib.IdempotencyRecord)).Error
+}
+
+func (s *Store) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result := s.DB.WithContext(ctx).
+		Where("expires_at <= ?", now).
+		Delete(new(lib.IdempotencyRecord))
+
+	return result.RowsAffected, result.Error
+}
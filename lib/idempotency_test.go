@@ -0,0 +1,332 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+//idempotencystore keeps records in a map, the stores themselves are tested in lib/idempotency
+type idempotencystore struct {
+	records map[string]IdempotencyRecord
+}
+
+func (s *idempotencystore) Claim(ctx context.Context, record *IdempotencyRecord, stale time.Time) (*IdempotencyRecord, bool, error) {
+	if existing, ok := s.records[record.Key]; ok {
+		return &existing, false, nil
+	}
+	s.records[record.Key] = *record
+	return record, true, nil
+}
+
+func (s *idempotencystore) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	s.records[record.Key] = *record
+	return nil
+}
+
+func (s *idempotencystore) Renew(ctx context.Context, key string) error {
+	return nil
+}
+
+func (s *idempotencystore) Release(ctx context.Context, key string) error {
+	delete(s.records, key)
+	return nil
+}
+
+func (s *idempotencystore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+//headerrecorder is the transport stream of a request, it records the headers sent back
+type headerrecorder struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *headerrecorder) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestIdempotencyInterceptor(t *testing.T) {
+	gateway := &Gateway{
+		services: &Services{
+			IdempotencyStore: &idempotencystore{
+				records: make(map[string]IdempotencyRecord),
+			},
+		},
+	}
+
+	calls := 0
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+
+		value := req.(*wrapperspb.StringValue).Value
+		switch value {
+		case "fail":
+			return nil, status.Error(codes.InvalidArgument, "failed")
+		case "missing":
+			return nil, errors.ErrOrderNotFound
+		case "invalid":
+			return nil, &errors.ErrInvalidRequest{Fields: map[string]string{"email": "must be an email"}}
+		}
+
+		_ = grpc.SetHeader(ctx, metadata.Pairs(accesstokenheader, "token"))
+
+		return wrapperspb.String(fmt.Sprintf("%s %d", value, calls)), nil
+	}
+
+	call := func(m, key string, user *User, value string) (*headerrecorder, interface{}, error) {
+		stream := &headerrecorder{}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+
+		if key != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(idempotencyheader, key))
+		}
+
+		if user != nil {
+			ctx = WithUser(ctx, user)
+		}
+
+		resp, err := gateway.IdempotencyInterceptor(ctx, wrapperspb.String(value), &grpc.UnaryServerInfo{FullMethod: m}, handler)
+		return stream, resp, err
+	}
+
+	//without a key every request is handled
+	_, first, _ := call(method("SaveInquiry"), "", nil, "a")
+	_, second, _ := call(method("SaveInquiry"), "", nil, "a")
+	assert.NotEqual(t, first.(*wrapperspb.StringValue).Value, second.(*wrapperspb.StringValue).Value)
+
+	//retries with a key get the result of the first request, headers included
+	_, first, err := call(method("SaveInquiry"), "key", users["other"], "a")
+	assert.Nil(t, err)
+
+	before := calls
+	stream, retry, err := call(method("SaveInquiry"), "key", users["other"], "a")
+	if assert.Nil(t, err) {
+		assert.Equal(t, before, calls)
+		assert.Equal(t, first.(*wrapperspb.StringValue).Value, retry.(*wrapperspb.StringValue).Value)
+		assert.Equal(t, []string{"token"}, stream.header.Get(accesstokenheader))
+	}
+
+	//a different request can't reuse the key
+	_, _, err = call(method("SaveInquiry"), "key", users["other"], "b")
+	assert.Equal(t, errors.ErrIdempotencyKeyReused, err)
+
+	//keys are scoped to the caller and the rpc
+	before = calls
+	_, _, err = call(method("SaveInquiry"), "key", users["user"], "a")
+	assert.Nil(t, err)
+	_, _, err = call(method("SaveOrder"), "key", users["other"], "a")
+	assert.Nil(t, err)
+	assert.Equal(t, before+2, calls)
+
+	//errors are replayed as well
+	_, _, err = call(method("SaveInquiry"), "fail", users["other"], "fail")
+	before = calls
+	_, _, retried := call(method("SaveInquiry"), "fail", users["other"], "fail")
+	assert.Equal(t, before, calls)
+	assert.Equal(t, status.Code(err), status.Code(retried))
+	assert.Equal(t, status.Convert(err).Message(), status.Convert(retried).Message())
+
+	//the errors of our own are replayed with their status, not as unknown errors
+	_, _, err = call(method("SaveInquiry"), "missing", users["other"], "missing")
+	assert.Equal(t, codes.NotFound, errors.Code(err))
+	_, _, retried = call(method("SaveInquiry"), "missing", users["other"], "missing")
+	assert.Equal(t, codes.NotFound, status.Code(retried))
+	assert.Equal(t, errors.ErrOrderNotFound.Error(), status.Convert(retried).Message())
+
+	//invalid requests keep their field violations
+	_, _, err = call(method("SaveInquiry"), "invalid", users["other"], "invalid")
+	_, _, retried = call(method("SaveInquiry"), "invalid", users["other"], "invalid")
+	assert.Equal(t, codes.InvalidArgument, status.Code(retried))
+	assert.Len(t, status.Convert(retried).Details(), 1)
+	assert.Equal(t, errors.Status(err).Details(), status.Convert(retried).Details())
+
+	//rpcs that aren't idempotent ignore the key
+	before = calls
+	call(method("GetProducts"), "key", users["other"], "a")
+	call(method("GetProducts"), "key", users["other"], "a")
+	assert.Equal(t, before+2, calls)
+
+	//keys are limited in length
+	_, _, err = call(method("SaveInquiry"), string(make([]byte, 256)), users["other"], "a")
+	assert.Equal(t, errors.ErrInvalidIdempotencyKey, err)
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	gateway := &Gateway{
+		services: &Services{
+			IdempotencyStore: &idempotencystore{
+				records: make(map[string]IdempotencyRecord),
+			},
+		},
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyheader, "key"))
+	ctx = WithUser(ctx, users["other"])
+	info := &grpc.UnaryServerInfo{FullMethod: method("SaveOrder")}
+
+	var nested error
+
+	_, err := gateway.IdempotencyInterceptor(ctx, wrapperspb.String("a"), info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		//a retry that comes in while the first request is still being handled
+		_, nested = gateway.IdempotencyInterceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return req, nil
+		})
+		return req, nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, errors.ErrRequestInProgress, nested)
+}
+
+func TestIdempotencyGuests(t *testing.T) {
+	gateway := &Gateway{
+		services: &Services{
+			IdempotencyStore: &idempotencystore{
+				records: make(map[string]IdempotencyRecord),
+			},
+		},
+	}
+
+	calls := 0
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		_ = grpc.SetHeader(ctx, metadata.Pairs(accesstokenheader, fmt.Sprintf("token %d", calls)))
+		return req, nil
+	}
+
+	call := func(cart string) (*headerrecorder, error) {
+		stream := &headerrecorder{}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(idempotencyheader, "key"))
+
+		if cart != "" {
+			ctx = SetCartTokenContext(ctx, cart)
+		}
+
+		_, err := gateway.IdempotencyInterceptor(ctx, wrapperspb.String("a"), &grpc.UnaryServerInfo{FullMethod: method("SaveOrder")}, handler)
+		return stream, err
+	}
+
+	//guests are told apart by their cart token, their retries get their own access token back
+	_, err := call("cart-a")
+	assert.Nil(t, err)
+
+	stream, err := call("cart-a")
+	if assert.Nil(t, err) {
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, []string{"token 1"}, stream.header.Get(accesstokenheader))
+	}
+
+	//the same key doesn't replay the response of another guest
+	stream, err = call("cart-b")
+	if assert.Nil(t, err) {
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, []string{"token 2"}, stream.header.Get(accesstokenheader))
+	}
+
+	//guests without a token share a scope, so nothing is ever replayed to them
+	_, err = call("")
+	assert.Nil(t, err)
+
+	stream, err = call("")
+	if assert.Nil(t, err) {
+		assert.Equal(t, 4, calls)
+		assert.Equal(t, []string{"token 4"}, stream.header.Get(accesstokenheader))
+	}
+}
+
+//checkoutservice places its orders in the order service it is given
+type checkoutservice struct {
+	CheckoutService
+	orders *orderservice
+	calls  int
+}
+
+func (s *checkoutservice) Checkout(ctx context.Context, req *CheckoutRequest) (*Order, error) {
+	s.calls++
+
+	order := &Order{PaymentMethod: req.PaymentMethod}
+	order.ID = uuid.New()
+	s.orders.orders[order.ID] = order
+
+	return order, nil
+}
+
+func TestCheckoutIdempotency(t *testing.T) {
+	orders := &orderservice{
+		orders: make(map[uuid.UUID]*Order),
+	}
+
+	checkout := &checkoutservice{
+		orders: orders,
+	}
+
+	gateway := &Gateway{
+		Env: &Env{},
+		services: &Services{
+			OrderService:    orders,
+			CheckoutService: checkout,
+			IdempotencyStore: &idempotencystore{
+				records: make(map[string]IdempotencyRecord),
+			},
+		},
+	}
+
+	req := func(quantity int64) *CheckoutRequest {
+		return &CheckoutRequest{
+			Inquiry:       &Inquiry{Email: "test.user@test.io"},
+			PaymentMethod: PaymentMethodPaypal,
+			Lines:         []*Cart{{ProductID: uuid.Nil, Quantity: quantity}},
+		}
+	}
+
+	call := func(key string, cart string, quantity int64) (*headerrecorder, *Order, error) {
+		stream := &headerrecorder{}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(idempotencyheader, key))
+		ctx = SetCartTokenContext(ctx, cart)
+
+		order, err := gateway.Checkout(ctx, req(quantity))
+		return stream, order, err
+	}
+
+	//a retry returns the order of the first attempt, along with its access token
+	_, first, err := call("key", "cart", 2)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	stream, retry, err := call("key", "cart", 2)
+	if assert.Nil(t, err) {
+		assert.Equal(t, first.ID, retry.ID)
+		assert.Equal(t, 1, checkout.calls)
+		assert.Equal(t, []string{"grant:" + first.ID.String()}, stream.header.Get(accesstokenheader))
+	}
+
+	//the key can't be used for a different checkout
+	_, _, err = call("key", "cart", 5)
+	assert.Equal(t, errors.ErrIdempotencyKeyReused, err)
+
+	//without a key every checkout places an order
+	_, _, err = call("", "cart", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, checkout.calls)
+
+	_, err = gateway.Checkout(context.Background(), nil)
+	assert.Equal(t, errors.ErrNoCheckoutRequest, err)
+}
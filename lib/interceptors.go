@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"context"
+
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	requestidheader = "x-request-id"
+
+	//maxrequestidlength keeps clients from storing arbitrary payloads in the
+	//audit log through the request id
+	maxrequestidlength = 64
+)
+
+// StatusInterceptor turns the errors of every request into a grpc status with the code that
+// fits them, i.e. NotFound for a product that doesn't exist, instead of the Unknown every
+// plain error reaches clients as. Invalid requests come with the fields that were invalid.
+// Errors that aren't ours are logged since they are most likely a bug or an outage.
+func (g *Gateway) StatusInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	res, err := handler(ctx, req)
+	if err == nil {
+		return res, nil
+	}
+
+	status := errors.Status(err)
+	if status.Code() == codes.Internal {
+		g.Env.Log.Error(info.FullMethod + ": " + err.Error())
+	}
+
+	return res, status.Err()
+}
+
+// AuditInterceptor attaches who made the request, the rpc method and a request id to the
+// context of every request, the services record them with every mutation they make. The
+// request id is taken from the `x-request-id` header when provided and is always echoed
+// back in the response headers.
+func (g *Gateway) AuditInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	audit := &AuditContext{
+		ActorType: AuditActorAnonymous,
+		Method:    info.FullMethod,
+	}
+
+	if id, err := getHeaderFromContext(ctx, requestidheader); err == nil && id != "" && len(id) <= maxrequestidlength {
+		audit.RequestID = id
+	} else {
+		audit.RequestID = uuid.New().String()
+	}
+
+	//there is no transport stream when the gateway is called directly
+	//i.e. in our tests, so a failure to set the header is ignored
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestidheader, audit.RequestID))
+
+	if key, ok := APIKeyFromContext(ctx); ok {
+		audit.ActorType = AuditActorAPIKey
+		audit.ActorID = key.ID
+		audit.Actor = key.Name
+	} else if user, ok := UserFromContext(ctx); ok {
+		audit.ActorType = AuditActorUser
+		audit.ActorID = user.ID
+		audit.Actor = user.Username
+	}
+
+	return handler(WithAuditContext(ctx, audit), req)
+}
+
+// AuthorizeInterceptor enforces the policy of every rpc, anything without a policy is denied.
+// Requests made with an api key are authorized by its permissions, every other request by
+// the access of the route. The authenticated user is made available to the handlers through
+// UserFromContext so they don't need to authenticate the request again.
+func (g *Gateway) AuthorizeInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	policy, ok := GetPolicy(info.FullMethod)
+	if !ok {
+		return nil, &errors.ErrNoPolicy{
+			Method: info.FullMethod,
+		}
+	}
+
+	//server to server integrations authenticate with the `api-key` header
+	//instead of the `auth` header
+	if _, err := GetAPIKeyFromContext(ctx); err == nil {
+		key, err := g.AuthenticateAPIKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if policy.Permission == "" || !key.HasPermission(policy.Permission) {
+			return nil, &errors.ErrAPIKeyPermissionDenied{
+				Method: info.FullMethod,
+			}
+		}
+
+		return handler(WithAPIKey(ctx, key), req)
+	}
+
+	switch policy.Access {
+	case AccessPublic:
+		if user, err := g.authenticate(ctx); err == nil {
+			ctx = WithUser(ctx, user)
+		}
+	case AccessUser:
+		user, err := g.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ctx = WithUser(ctx, user)
+	case AccessAdmin:
+		user, err := g.AuthenticateAdmin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ctx = WithUser(ctx, user)
+	default:
+		return nil, &errors.ErrNoPolicy{
+			Method: info.FullMethod,
+		}
+	}
+
+	return handler(ctx, req)
+}
+
+// authenticate authenticates the JWT of the request, the admin flag of the token is only
+// trusted once it has been checked against the database
+func (g *Gateway) authenticate(ctx context.Context) (*User, error) {
+	user, err := g.AuthenticateToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, errors.ErrNoUserFound
+	}
+
+	if user.Admin {
+		admin, err := g.AuthenticateAdmin(ctx)
+		if err != nil {
+			user.Admin = false
+			return user, nil
+		}
+		return admin, nil
+	}
+
+	return user, nil
+}
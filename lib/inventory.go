@@ -0,0 +1,200 @@
+package lib
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// InventoryService keeps the ledger of every movement of stock. The inventory of a product or
+// variant is what its movements add up to, it is kept on the product or variant as well so it
+// can be read without the ledger and is updated along with every movement that is recorded.
+type InventoryService interface {
+	//AdjustInventory records a receipt, return or manual adjustment of the stock of a product,
+	//or of one of its variants, along with the reason for it
+	AdjustInventory(ctx context.Context, movement *InventoryMovement) (*InventoryMovement, error)
+	//GetInventoryMovements returns the most recent movements of the stock of the product and
+	//its variants
+	GetInventoryMovements(ctx context.Context, product uuid.UUID, limit int) ([]*InventoryMovement, error)
+	//ReconcileInventory sets the inventory of every product and variant to what its ledger adds
+	//up to, it returns the ones that were off
+	ReconcileInventory(ctx context.Context) ([]*InventoryDrift, error)
+	//SetLowStockThreshold sets the threshold of the product, or of one of its variants, a nil
+	//threshold falls back to the one of the product and then to the one of the env
+	SetLowStockThreshold(ctx context.Context, product uuid.UUID, variant *uuid.UUID, threshold *int) error
+	//AlertLowStock notifies about the products and variants whose stock fell below their
+	//threshold since they were last alerted about, it returns how many there were
+	AlertLowStock(ctx context.Context, now time.Time) (int64, error)
+}
+
+// LowStockNotifier is told about the products and variants whose stock ran low
+type LowStockNotifier interface {
+	NotifyLowStock(ctx context.Context, alerts []*LowStockAlert) error
+}
+
+// InventoryMovementKind the primitive type for the reasons stock moves
+type InventoryMovementKind string
+
+const (
+	//InventoryMovementReceipt is stock that was received, i.e. from a supplier
+	InventoryMovementReceipt InventoryMovementKind = "RECEIPT"
+	//InventoryMovementSale is stock that left with a completed order
+	InventoryMovementSale InventoryMovementKind = "SALE"
+	//InventoryMovementReturn is stock that was sent back by a customer
+	InventoryMovementReturn InventoryMovementKind = "RETURN"
+	//InventoryMovementAdjustment is a manual correction of the stock, i.e. after a count
+	InventoryMovementAdjustment InventoryMovementKind = "ADJUSTMENT"
+	//InventoryMovementReservation is stock that was taken aside for an order at checkout
+	InventoryMovementReservation InventoryMovementKind = "RESERVATION"
+	//InventoryMovementRelease is reserved stock that was put back, either because the order
+	//didn't go through or because it was sold
+	InventoryMovementRelease InventoryMovementKind = "RELEASE"
+)
+
+// InventoryMovement a single movement of the stock of a product, or of one of its variants
+// when it has a variant id. Quantity is positive for stock that comes in and negative for
+// stock that goes out, OnHand is the stock that was left after it.
+type InventoryMovement struct {
+	ID        uuid.UUID             `json:"id" gorm:"type:varchar(36);primaryKey"`
+	ProductID uuid.UUID             `json:"product_id"`
+	VariantID *uuid.UUID            `json:"variant_id"`
+	Kind      InventoryMovementKind `json:"kind"`
+	Quantity  int                   `json:"quantity"`
+	OnHand    int                   `json:"on_hand"`
+	Reason    string                `json:"reason"`
+	OrderID   *uuid.UUID            `json:"order_id"`
+	ActorID   uuid.UUID             `json:"actor_id"`
+	ActorType AuditActorType        `json:"actor_type"`
+	Actor     string                `json:"actor"`
+	CreatedAt time.Time             `json:"created_at"`
+}
+
+// NewInventoryMovement returns a movement of the quantity made by the actor of the context,
+// nil when the quantity is zero since nothing moved
+func NewInventoryMovement(ctx context.Context, kind InventoryMovementKind, product uuid.UUID, variant *uuid.UUID, quantity int, reason string) *InventoryMovement {
+	if quantity == 0 {
+		return nil
+	}
+
+	audit := AuditContextFromContext(ctx)
+
+	return &InventoryMovement{
+		ID:        uuid.New(),
+		ProductID: product,
+		VariantID: variant,
+		Kind:      kind,
+		Quantity:  quantity,
+		Reason:    reason,
+		ActorID:   audit.ActorID,
+		ActorType: audit.ActorType,
+		Actor:     audit.Actor,
+	}
+}
+
+// ForOrder sets the order the movement was made for and returns the movement
+func (movement *InventoryMovement) ForOrder(order uuid.UUID) *InventoryMovement {
+	if movement != nil {
+		movement.OrderID = &order
+	}
+	return movement
+}
+
+// BeforeCreate applies the quantity of the movement to the inventory of its product or
+// variant within the transaction that records it, so the two can't drift apart. Products and
+// variants that were deleted still have their stock moved, i.e. when the order of a deleted
+// product expires.
+func (movement *InventoryMovement) BeforeCreate(tx *gorm.DB) error {
+	if movement.ID == uuid.Nil {
+		movement.ID = uuid.New()
+	}
+
+	var model interface{} = new(Product)
+	id := movement.ProductID
+
+	if movement.VariantID != nil {
+		model = new(ProductVariant)
+		id = *movement.VariantID
+	}
+
+	db := tx.Session(&gorm.Session{NewDB: true}).Unscoped()
+
+	if err := db.Model(model).
+		Where("id = ?", id).
+		Update("inventory", gorm.Expr("COALESCE(inventory, 0) + ?", movement.Quantity)).Error; err != nil {
+		return err
+	}
+
+	return db.Model(model).
+		Select("COALESCE(inventory, 0)").
+		Where("id = ?", id).
+		Scan(&movement.OnHand).Error
+}
+
+// InventoryDrift a product or variant whose inventory didn't match its ledger
+type InventoryDrift struct {
+	ProductID uuid.UUID  `json:"product_id"`
+	VariantID *uuid.UUID `json:"variant_id"`
+	Inventory int        `json:"inventory"`
+	Ledger    int        `json:"ledger"`
+}
+
+// LowStockAlert a product or variant whose stock fell below its threshold
+type LowStockAlert struct {
+	ProductID uuid.UUID  `json:"product_id"`
+	VariantID *uuid.UUID `json:"variant_id"`
+	Name      string     `json:"name"`
+	SKU       string     `json:"sku"`
+	Inventory int        `json:"inventory"`
+	Threshold int        `json:"threshold"`
+}
+
+// AdjustInventory records a movement of the stock of a product
+func (g *Gateway) AdjustInventory(ctx context.Context, movement *InventoryMovement) (*InventoryMovement, error) {
+	if err := g.authorize(ctx, PermissionWriteInventory); err != nil {
+		return nil, err
+	}
+
+	result, err := g.services.InventoryService.AdjustInventory(ctx, movement)
+	if err != nil {
+		return nil, err
+	}
+
+	g.reindex(ctx, result.ProductID)
+	return result, nil
+}
+
+// GetInventoryMovements returns the ledger of the stock of the product
+func (g *Gateway) GetInventoryMovements(ctx context.Context, product uuid.UUID, limit int) ([]*InventoryMovement, error) {
+	if err := g.authorize(ctx, PermissionReadInventory); err != nil {
+		return nil, err
+	}
+
+	return g.services.InventoryService.GetInventoryMovements(ctx, product, limit)
+}
+
+// ReconcileInventory sets the inventory of every product and variant to what its ledger adds up
+// to
+func (g *Gateway) ReconcileInventory(ctx context.Context) ([]*InventoryDrift, error) {
+	if err := g.authorize(ctx, PermissionWriteInventory); err != nil {
+		return nil, err
+	}
+
+	return g.services.InventoryService.ReconcileInventory(ctx)
+}
+
+// SetLowStockThreshold sets the low stock threshold of a product or variant
+func (g *Gateway) SetLowStockThreshold(ctx context.Context, product uuid.UUID, variant *uuid.UUID, threshold *int) error {
+	if err := g.authorize(ctx, PermissionWriteInventory); err != nil {
+		return err
+	}
+
+	return g.services.InventoryService.SetLowStockThreshold(ctx, product, variant, threshold)
+}
+
+// AlertLowStock notifies about the stock that ran low since the last run, it is run by the
+// scheduler
+func (g *Gateway) AlertLowStock(ctx context.Context) (int64, error) {
+	return g.services.InventoryService.AlertLowStock(ctx, time.Now())
+}
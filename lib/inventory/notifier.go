@@ -0,0 +1,82 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+)
+
+//NewNotifier returns the low stock notifier of the env, alerts are posted to the webhook of
+//the env when it has one and logged otherwise
+func NewNotifier(env *lib.Env) lib.LowStockNotifier {
+	if env.LowStockWebhook == "" {
+		return &lognotifier{env.Log}
+	}
+
+	return &webhook{
+		url: env.LowStockWebhook,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+//webhook posts the alerts as json, i.e. {"alerts": [{"product_id": ..., "inventory": 2}]}
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+func (w *webhook) NotifyLowStock(ctx context.Context, alerts []*lib.LowStockAlert) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"alerts": alerts,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return &errors.ErrLowStockWebhook{
+			Status: res.StatusCode,
+		}
+	}
+
+	return nil
+}
+
+//lognotifier logs the alerts, it is used when no webhook is configured
+type lognotifier struct {
+	log commons.Logger
+}
+
+func (l *lognotifier) NotifyLowStock(ctx context.Context, alerts []*lib.LowStockAlert) error {
+	for _, alert := range alerts {
+		name := alert.Name
+		if alert.SKU != "" {
+			name += " (" + alert.SKU + ")"
+		}
+
+		l.log.Info(fmt.Sprintf("low stock: %s has %d in stock, below its threshold of %d", name, alert.Inventory, alert.Threshold))
+	}
+	return nil
+}
@@ -0,0 +1,403 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	//defaultlimit is how many movements are returned when no limit is provided
+	defaultlimit = 50
+
+	//maxlimit is the most movements that are returned at once
+	maxlimit = 500
+)
+
+//Service the inventory service, keeps the ledger of the stock of products and alerts about the
+//stock that runs low
+type Service struct {
+	*lib.Env
+	notifier lib.LowStockNotifier
+	repo     repoi
+}
+
+//NewService returns a new inventory service, low stock is alerted about through the provided
+//notifier
+func NewService(env *lib.Env, notifier lib.LowStockNotifier) (lib.InventoryService, error) {
+	if notifier == nil {
+		return nil, errors.ErrNoLowStockNotifier
+	}
+
+	return &Service{
+		env,
+		notifier,
+		&repo{
+			env.GormDB,
+		},
+	}, nil
+}
+
+//AdjustInventory records a receipt, return or adjustment of the stock. Products with variants
+//are stocked by variant, so one of their variants has to be provided, and stock can't be
+//taken out that isn't there.
+func (s *Service) AdjustInventory(ctx context.Context, movement *lib.InventoryMovement) (*lib.InventoryMovement, error) {
+	if movement == nil {
+		return nil, errors.ErrProductNotProvided
+	}
+
+	switch {
+	case (movement.Kind == lib.InventoryMovementReceipt || movement.Kind == lib.InventoryMovementReturn) && movement.Quantity > 0:
+	case movement.Kind == lib.InventoryMovementAdjustment && movement.Quantity != 0:
+	default:
+		return nil, &errors.ErrInvalidInventoryMovement{
+			Kind:     string(movement.Kind),
+			Quantity: movement.Quantity,
+		}
+	}
+
+	reason := strings.TrimSpace(movement.Reason)
+	if reason == "" {
+		return nil, errors.ErrNoInventoryReason
+	}
+
+	variants, err := s.repo.GetProductVariants(ctx, movement.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	if movement.VariantID == nil && len(variants) > 0 {
+		return nil, &errors.ErrVariantNotProvided{
+			ProductID: movement.ProductID,
+		}
+	}
+
+	if movement.VariantID != nil && !contains(variants, *movement.VariantID) {
+		return nil, errors.ErrVariantNotFound
+	}
+
+	//the movement is made anew so only what can be adjusted is taken from the request
+	result := lib.NewInventoryMovement(ctx, movement.Kind, movement.ProductID, movement.VariantID, movement.Quantity, reason)
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		stock, err := repo.LockInventory(ctx, result.ProductID, result.VariantID)
+		if err != nil {
+			return err
+		}
+
+		if stock+result.Quantity < 0 {
+			return &errors.ErrInsufficientStock{
+				ProductID: result.ProductID,
+				VariantID: result.VariantID,
+				Requested: int64(-result.Quantity),
+				Available: int64(stock),
+			}
+		}
+
+		return repo.MoveInventory(ctx, result)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func contains(variants []*lib.ProductVariant, id uuid.UUID) bool {
+	for _, variant := range variants {
+		if variant.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+//GetInventoryMovements returns the most recent movements of the product and its variants
+func (s *Service) GetInventoryMovements(ctx context.Context, product uuid.UUID, limit int) ([]*lib.InventoryMovement, error) {
+	if limit <= 0 {
+		limit = defaultlimit
+	}
+
+	if limit > maxlimit {
+		limit = maxlimit
+	}
+
+	return s.repo.GetInventoryMovements(ctx, product, limit)
+}
+
+//ReconcileInventory sets the inventory of the products and variants that drifted from their
+//ledger back to it, i.e. after their stock was changed in the database by hand
+func (s *Service) ReconcileInventory(ctx context.Context) ([]*lib.InventoryDrift, error) {
+	drifts, err := s.repo.GetInventoryDrifts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, drift := range drifts {
+		if err := s.repo.Reconcile(ctx, drift); err != nil {
+			return nil, err
+		}
+
+		s.Log.Info(fmt.Sprintf("reconciled the inventory of product %s (variant %v) from %d to %d", drift.ProductID, drift.VariantID, drift.Inventory, drift.Ledger))
+	}
+
+	return drifts, nil
+}
+
+//SetLowStockThreshold sets the threshold of the product or variant, the stock is alerted about
+//again whenever it is below the new threshold
+func (s *Service) SetLowStockThreshold(ctx context.Context, product uuid.UUID, variant *uuid.UUID, threshold *int) error {
+	if threshold != nil && *threshold < 0 {
+		return &errors.ErrInvalidLowStockThreshold{
+			Threshold: *threshold,
+		}
+	}
+
+	variants, err := s.repo.GetProductVariants(ctx, product)
+	if err != nil {
+		return err
+	}
+
+	if variant != nil && !contains(variants, *variant) {
+		return errors.ErrVariantNotFound
+	}
+
+	return s.repo.SetLowStockThreshold(ctx, product, variant, threshold)
+}
+
+//AlertLowStock notifies about the products and variants that are below their threshold and
+//weren't alerted about yet. The ones that were restocked since they were alerted about are
+//alerted about again the next time they run low.
+func (s *Service) AlertLowStock(ctx context.Context, now time.Time) (int64, error) {
+	if err := s.repo.ClearLowStockAlerts(ctx, s.LowStockThreshold); err != nil {
+		return 0, err
+	}
+
+	alerts, err := s.repo.GetLowStock(ctx, s.LowStockThreshold)
+	if err != nil || len(alerts) == 0 {
+		return 0, err
+	}
+
+	if err := s.notifier.NotifyLowStock(ctx, alerts); err != nil {
+		return 0, err
+	}
+
+	if err := s.repo.MarkLowStockAlerted(ctx, alerts, now); err != nil {
+		return 0, err
+	}
+
+	return int64(len(alerts)), nil
+}
+
+type repoi interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error
+	GetProductVariants(ctx context.Context, product uuid.UUID) ([]*lib.ProductVariant, error)
+	LockInventory(ctx context.Context, product uuid.UUID, variant *uuid.UUID) (int, error)
+	MoveInventory(ctx context.Context, movement *lib.InventoryMovement) error
+	GetInventoryMovements(ctx context.Context, product uuid.UUID, limit int) ([]*lib.InventoryMovement, error)
+	GetInventoryDrifts(ctx context.Context) ([]*lib.InventoryDrift, error)
+	Reconcile(ctx context.Context, drift *lib.InventoryDrift) error
+	SetLowStockThreshold(ctx context.Context, product uuid.UUID, variant *uuid.UUID, threshold *int) error
+	ClearLowStockAlerts(ctx context.Context, threshold int) error
+	GetLowStock(ctx context.Context, threshold int) ([]*lib.LowStockAlert, error)
+	MarkLowStockAlerted(ctx context.Context, alerts []*lib.LowStockAlert, now time.Time) error
+}
+
+type repo struct {
+	*gorm.DB
+}
+
+//Transaction runs the provided function with a repo that is bound to a single transaction,
+//the transaction is rolled back if the function returns an error. The context the function is
+//given is bound to the transaction as well, so the mutations are audited within it.
+func (r *repo) Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(lib.WithAuditTx(ctx, tx), &repo{tx})
+	})
+}
+
+//GetProductVariants returns the variants of the product, the product has to exist
+func (r *repo) GetProductVariants(ctx context.Context, id uuid.UUID) ([]*lib.ProductVariant, error) {
+	product := new(lib.Product)
+
+	err := r.DB.WithContext(ctx).Preload("Variants").First(product, "id = ?", id).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, &errors.ErrNoProductFound{
+			ID: id,
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return product.Variants, nil
+}
+
+//LockInventory returns the stock of the product, or of the variant when one is provided,
+//locked until the transaction ends
+func (r *repo) LockInventory(ctx context.Context, product uuid.UUID, variant *uuid.UUID) (stock int, err error) {
+	tx := r.DB.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Select("COALESCE(inventory, 0)")
+
+	if variant != nil {
+		err = tx.Model(new(lib.ProductVariant)).Where("id = ?", *variant).Scan(&stock).Error
+		return
+	}
+
+	err = tx.Model(new(lib.Product)).Where("id = ?", product).Scan(&stock).Error
+	return
+}
+
+//MoveInventory records the movement, which moves the stock of its product or variant along
+//with it. A nil movement is one where nothing moved.
+func (r *repo) MoveInventory(ctx context.Context, movement *lib.InventoryMovement) error {
+	if movement == nil {
+		return nil
+	}
+	return r.DB.WithContext(ctx).Create(movement).Error
+}
+
+func (r *repo) GetInventoryMovements(ctx context.Context, product uuid.UUID, limit int) (movements []*lib.InventoryMovement, err error) {
+	movements = make([]*lib.InventoryMovement, 0)
+	err = r.DB.WithContext(ctx).
+		Order("created_at DESC, id ASC").
+		Limit(limit).
+		Find(&movements, "product_id = ?", product).Error
+	return
+}
+
+//GetInventoryDrifts returns the products and variants whose inventory isn't what their ledger
+//adds up to, the stock of a product with variants is kept by its variants
+func (r *repo) GetInventoryDrifts(ctx context.Context) ([]*lib.InventoryDrift, error) {
+	drifts := make([]*lib.InventoryDrift, 0)
+
+	err := r.DB.WithContext(ctx).Raw(
+		"SELECT products.id AS product_id, NULL AS variant_id, COALESCE(products.inventory, 0) AS inventory, COALESCE(SUM(inventory_movements.quantity), 0) AS ledger " +
+			"FROM products LEFT JOIN inventory_movements ON inventory_movements.product_id = products.id AND inventory_movements.variant_id IS NULL " +
+			"GROUP BY products.id, products.inventory " +
+			"HAVING COALESCE(products.inventory, 0) <> COALESCE(SUM(inventory_movements.quantity), 0) " +
+			"UNION ALL " +
+			"SELECT product_variants.product_id, product_variants.id, product_variants.inventory, COALESCE(SUM(inventory_movements.quantity), 0) " +
+			"FROM product_variants LEFT JOIN inventory_movements ON inventory_movements.variant_id = product_variants.id " +
+			"GROUP BY product_variants.id, product_variants.product_id, product_variants.inventory " +
+			"HAVING product_variants.inventory <> COALESCE(SUM(inventory_movements.quantity), 0)",
+	).Scan(&drifts).Error
+
+	return drifts, err
+}
+
+//Reconcile sets the inventory of the product or variant of the drift to its ledger, the ledger
+//is added up again so a movement made since the drift was found isn't lost
+func (r *repo) Reconcile(ctx context.Context, drift *lib.InventoryDrift) error {
+	if drift.VariantID != nil {
+		return r.DB.WithContext(ctx).Exec(
+			"UPDATE product_variants SET inventory = (SELECT COALESCE(SUM(quantity), 0) FROM inventory_movements WHERE variant_id = ?) WHERE id = ?",
+			*drift.VariantID, *drift.VariantID,
+		).Error
+	}
+
+	return r.DB.WithContext(ctx).Exec(
+		"UPDATE products SET inventory = (SELECT COALESCE(SUM(quantity), 0) FROM inventory_movements WHERE product_id = ? AND variant_id IS NULL) WHERE id = ?",
+		drift.ProductID, drift.ProductID,
+	).Error
+}
+
+//SetLowStockThreshold sets the threshold and forgets the last alert, so the stock is alerted
+//about again when it is below the new threshold
+func (r *repo) SetLowStockThreshold(ctx context.Context, product uuid.UUID, variant *uuid.UUID, threshold *int) error {
+	updates := map[string]interface{}{
+		"low_stock_threshold":  threshold,
+		"low_stock_alerted_at": nil,
+	}
+
+	if variant != nil {
+		return r.DB.WithContext(ctx).Model(new(lib.ProductVariant)).
+			Where("id = ? AND product_id = ?", *variant, product).
+			Updates(updates).Error
+	}
+
+	return r.DB.WithContext(ctx).Model(new(lib.Product)).
+		Where("id = ?", product).
+		Updates(updates).Error
+}
+
+//ClearLowStockAlerts forgets the alerts of the stock that is no longer below its threshold
+func (r *repo) ClearLowStockAlerts(ctx context.Context, threshold int) error {
+	err := r.DB.WithContext(ctx).Exec(
+		"UPDATE products SET low_stock_alerted_at = NULL "+
+			"WHERE low_stock_alerted_at IS NOT NULL AND COALESCE(inventory, 0) >= COALESCE(low_stock_threshold, ?)",
+		threshold,
+	).Error
+
+	if err != nil {
+		return err
+	}
+
+	return r.DB.WithContext(ctx).Exec(
+		"UPDATE product_variants JOIN products ON products.id = product_variants.product_id "+
+			"SET product_variants.low_stock_alerted_at = NULL "+
+			"WHERE product_variants.low_stock_alerted_at IS NOT NULL "+
+			"AND product_variants.inventory >= COALESCE(product_variants.low_stock_threshold, products.low_stock_threshold, ?)",
+		threshold,
+	).Error
+}
+
+//GetLowStock returns the products without variants and the variants that are below their
+//threshold and weren't alerted about yet
+func (r *repo) GetLowStock(ctx context.Context, threshold int) ([]*lib.LowStockAlert, error) {
+	alerts := make([]*lib.LowStockAlert, 0)
+
+	err := r.DB.WithContext(ctx).Raw(
+		"SELECT products.id AS product_id, NULL AS variant_id, products.name, '' AS sku, COALESCE(products.inventory, 0) AS inventory, COALESCE(products.low_stock_threshold, ?) AS threshold "+
+			"FROM products "+
+			"WHERE products.deleted_at IS NULL AND products.low_stock_alerted_at IS NULL "+
+			"AND COALESCE(products.inventory, 0) < COALESCE(products.low_stock_threshold, ?) "+
+			"AND NOT EXISTS (SELECT 1 FROM product_variants WHERE product_variants.product_id = products.id AND product_variants.deleted_at IS NULL) "+
+			"UNION ALL "+
+			"SELECT product_variants.product_id, product_variants.id, products.name, product_variants.sku, product_variants.inventory, COALESCE(product_variants.low_stock_threshold, products.low_stock_threshold, ?) "+
+			"FROM product_variants JOIN products ON products.id = product_variants.product_id AND products.deleted_at IS NULL "+
+			"WHERE product_variants.deleted_at IS NULL AND product_variants.low_stock_alerted_at IS NULL "+
+			"AND product_variants.inventory < COALESCE(product_variants.low_stock_threshold, products.low_stock_threshold, ?)",
+		threshold, threshold, threshold, threshold,
+	).Scan(&alerts).Error
+
+	return alerts, err
+}
+
+func (r *repo) MarkLowStockAlerted(ctx context.Context, alerts []*lib.LowStockAlert, now time.Time) error {
+	products := make([]uuid.UUID, 0)
+	variants := make([]uuid.UUID, 0)
+
+	for _, alert := range alerts {
+		if alert.VariantID != nil {
+			variants = append(variants, *alert.VariantID)
+		} else {
+			products = append(products, alert.ProductID)
+		}
+	}
+
+	if len(products) > 0 {
+		if err := r.DB.WithContext(ctx).Model(new(lib.Product)).
+			Where("id IN ?", products).
+			Update("low_stock_alerted_at", now).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(variants) > 0 {
+		return r.DB.WithContext(ctx).Model(new(lib.ProductVariant)).
+			Where("id IN ?", variants).
+			Update("low_stock_alerted_at", now).Error
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package inventory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/cryptnode-software/pisces/lib/inventory"
+	"github.com/stretchr/testify/assert"
+)
+
+//notifier remembers the alerts it was told about
+type notifier struct {
+	alerts []*lib.LowStockAlert
+}
+
+func (n *notifier) NotifyLowStock(ctx context.Context, alerts []*lib.LowStockAlert) error {
+	n.alerts = append(n.alerts, alerts...)
+	return nil
+}
+
+var (
+	env = lib.NewEnv(
+		commons.NewLogger(commons.EnvDev),
+	)
+
+	alerts = new(notifier)
+
+	service, err = inventory.NewService(env, alerts)
+
+	ctx = context.Background()
+)
+
+func TestAdjustInventory(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product := &lib.Product{Name: "Ledger product"}
+	if !assert.Nil(t, env.GormDB.Create(product).Error) {
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	_, err := service.AdjustInventory(ctx, lib.NewInventoryMovement(ctx, lib.InventoryMovementSale, product.ID, nil, -1, "sold"))
+	assert.IsType(t, new(perrors.ErrInvalidInventoryMovement), err)
+
+	_, err = service.AdjustInventory(ctx, lib.NewInventoryMovement(ctx, lib.InventoryMovementReceipt, product.ID, nil, 10, " "))
+	assert.Equal(t, perrors.ErrNoInventoryReason, err)
+
+	received, err := service.AdjustInventory(ctx, lib.NewInventoryMovement(ctx, lib.InventoryMovementReceipt, product.ID, nil, 10, "delivery"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, 10, received.OnHand)
+
+	//stock can't be taken out that isn't there
+	_, err = service.AdjustInventory(ctx, lib.NewInventoryMovement(ctx, lib.InventoryMovementAdjustment, product.ID, nil, -11, "count"))
+	assert.IsType(t, new(perrors.ErrInsufficientStock), err)
+
+	counted, err := service.AdjustInventory(ctx, lib.NewInventoryMovement(ctx, lib.InventoryMovementAdjustment, product.ID, nil, -3, "count"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, 7, counted.OnHand)
+
+	movements, err := service.GetInventoryMovements(ctx, product.ID, 0)
+	if assert.Nil(t, err) && assert.Len(t, movements, 2) {
+		assert.Equal(t, lib.InventoryMovementAdjustment, movements[0].Kind)
+		assert.Equal(t, lib.AuditActorSystem, movements[0].ActorType)
+	}
+
+	//the inventory is set back to the ledger when it is changed by hand
+	if !assert.Nil(t, env.GormDB.Model(product).Update("inventory", 2).Error) {
+		return
+	}
+
+	drifts, err := service.ReconcileInventory(ctx)
+	if assert.Nil(t, err) {
+		found := false
+		for _, drift := range drifts {
+			if drift.ProductID == product.ID && drift.VariantID == nil {
+				found = true
+				assert.Equal(t, 2, drift.Inventory)
+				assert.Equal(t, 7, drift.Ledger)
+			}
+		}
+		assert.True(t, found)
+	}
+
+	saved := new(lib.Product)
+	if assert.Nil(t, env.GormDB.First(saved, "id = ?", product.ID).Error) {
+		assert.Equal(t, 7, saved.Inventory)
+	}
+}
+
+func TestAlertLowStock(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product := &lib.Product{Name: "Low stock product"}
+	if !assert.Nil(t, env.GormDB.Create(product).Error) {
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	threshold := -1
+	assert.IsType(t, new(perrors.ErrInvalidLowStockThreshold), service.SetLowStockThreshold(ctx, product.ID, nil, &threshold))
+
+	threshold = 5
+	if !assert.Nil(t, service.SetLowStockThreshold(ctx, product.ID, nil, &threshold)) {
+		return
+	}
+
+	alerted := func() (count int) {
+		for _, alert := range alerts.alerts {
+			if alert.ProductID == product.ID {
+				count++
+			}
+		}
+		return
+	}
+
+	_, err := service.AlertLowStock(ctx, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, alerted())
+
+	//the stock is only alerted about once while it stays low
+	_, err = service.AlertLowStock(ctx, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, alerted())
+
+	//and again once it runs low after it was restocked
+	_, err = service.AdjustInventory(ctx, lib.NewInventoryMovement(ctx, lib.InventoryMovementReceipt, product.ID, nil, 5, "delivery"))
+	assert.Nil(t, err)
+
+	_, err = service.AlertLowStock(ctx, time.Now())
+	assert.Nil(t, err)
+
+	_, err = service.AdjustInventory(ctx, lib.NewInventoryMovement(ctx, lib.InventoryMovementAdjustment, product.ID, nil, -1, "damaged"))
+	assert.Nil(t, err)
+
+	_, err = service.AlertLowStock(ctx, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, alerted())
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewInventoryMovement(t *testing.T) {
+	ctx := context.Background()
+	product := uuid.New()
+	order := uuid.New()
+
+	//nothing moved, so there is nothing to record
+	movement := NewInventoryMovement(ctx, InventoryMovementAdjustment, product, nil, 0, "count")
+	assert.Nil(t, movement)
+	assert.Nil(t, movement.ForOrder(order))
+
+	movement = NewInventoryMovement(ctx, InventoryMovementReservation, product, nil, -2, "checkout").ForOrder(order)
+	if assert.NotNil(t, movement) {
+		assert.NotEqual(t, uuid.Nil, movement.ID)
+		assert.Equal(t, -2, movement.Quantity)
+		assert.Equal(t, &order, movement.OrderID)
+		assert.Equal(t, AuditActorSystem, movement.ActorType)
+	}
+
+	user := uuid.New()
+	ctx = WithAuditContext(ctx, &AuditContext{ActorID: user, ActorType: AuditActorUser, Actor: "admin"})
+
+	movement = NewInventoryMovement(ctx, InventoryMovementReceipt, product, nil, 5, "delivery")
+	if assert.NotNil(t, movement) {
+		assert.Equal(t, user, movement.ActorID)
+		assert.Equal(t, "admin", movement.Actor)
+		assert.Nil(t, movement.OrderID)
+	}
+}
+
+//inventoryservice records the thresholds it was asked to set
+type inventoryservice struct {
+	InventoryService
+	thresholds map[uuid.UUID]*int
+}
+
+func (s *inventoryservice) SetLowStockThreshold(ctx context.Context, product uuid.UUID, variant *uuid.UUID, threshold *int) error {
+	s.thresholds[product] = threshold
+	return nil
+}
+
+func TestSetLowStockThresholdAuthorization(t *testing.T) {
+	inventory := &inventoryservice{
+		thresholds: make(map[uuid.UUID]*int),
+	}
+
+	gateway := &Gateway{
+		services: &Services{
+			AuthService:      new(authservice),
+			InventoryService: inventory,
+		},
+	}
+
+	threshold := 5
+
+	tables := []struct {
+		ctx context.Context
+		err bool
+	}{
+		{ctx: SetAuthContext(context.Background(), "admin")},
+		{ctx: SetAuthContext(context.Background(), "user"), err: true},
+		{ctx: WithAPIKey(context.Background(), &APIKey{Permissions: []Permission{PermissionWriteInventory}})},
+		{ctx: WithAPIKey(context.Background(), &APIKey{Permissions: []Permission{PermissionReadInventory}}), err: true},
+		{ctx: WithAPIKey(context.Background(), &APIKey{Permissions: []Permission{PermissionWriteProducts}}), err: true},
+	}
+
+	for _, table := range tables {
+		product := uuid.New()
+
+		err := gateway.SetLowStockThreshold(table.ctx, product, nil, &threshold)
+
+		_, set := inventory.thresholds[product]
+		assert.Equal(t, table.err, err != nil, err)
+		assert.Equal(t, !table.err, set)
+	}
+}
@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"context"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/google/uuid"
+)
+
+// MediaService manages the image galleries of products. Images are uploaded straight to the
+// bucket through the presigned url of StartUpload and confirmed afterwards, which is when they
+// are added to the gallery.
+type MediaService interface {
+	GetProductImages(ctx context.Context, product uuid.UUID) ([]*ProductImage, error)
+	ConfirmProductImage(ctx context.Context, image *ProductImage, url string) (*ProductImage, error)
+	SaveProductImage(ctx context.Context, image *ProductImage) (*ProductImage, error)
+	SetProductImageOrder(ctx context.Context, product uuid.UUID, images []uuid.UUID) error
+	DeleteProductImage(ctx context.Context, image *ProductImage) error
+	PurgeProductImages(ctx context.Context, images []*ProductImage) error
+}
+
+// Bucket the object storage uploads are made to
+type Bucket interface {
+	//PresignPut returns the url the object can be uploaded to directly
+	PresignPut(ctx context.Context, key string) (string, error)
+	//URL returns the public url of the object
+	URL(key string) string
+	//Key returns the key of the object at the url, it reports false for urls that aren't
+	//in the bucket
+	Key(url string) (string, bool)
+	Exists(ctx context.Context, key string) (bool, error)
+	Delete(ctx context.Context, keys ...string) error
+	//Ping makes sure the bucket can be reached, it is one of the health checks
+	Ping(ctx context.Context) error
+}
+
+// ProductImage places an image in the gallery of a product. Position orders the gallery and
+// a single image of it is the primary one, i.e. the one shown in listings.
+type ProductImage struct {
+	ProductID    uuid.UUID
+	AttachmentID uuid.UUID
+	Attachment   *Attachment
+	Alt          string
+	Position     int
+	Primary      bool `gorm:"column:is_primary"`
+	commons.Model
+}
+
+// ConfirmProductImage adds an image that was uploaded through StartUpload to the gallery of
+// the product, url is the url StartUpload returned
+func (g *Gateway) ConfirmProductImage(ctx context.Context, image *ProductImage, url string) (*ProductImage, error) {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return nil, err
+	}
+
+	return g.services.MediaService.ConfirmProductImage(ctx, image, url)
+}
+
+// SaveProductImage updates the alt text of an image and whether it is the primary one
+func (g *Gateway) SaveProductImage(ctx context.Context, image *ProductImage) (*ProductImage, error) {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return nil, err
+	}
+
+	return g.services.MediaService.SaveProductImage(ctx, image)
+}
+
+// SetProductImageOrder orders the gallery of the product by the provided images
+func (g *Gateway) SetProductImageOrder(ctx context.Context, product uuid.UUID, images []uuid.UUID) error {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return err
+	}
+
+	return g.services.MediaService.SetProductImageOrder(ctx, product, images)
+}
+
+// DeleteProductImage takes the image out of the gallery and removes it from the bucket
+func (g *Gateway) DeleteProductImage(ctx context.Context, image *ProductImage) error {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return err
+	}
+
+	return g.services.MediaService.DeleteProductImage(ctx, image)
+}
+
+// DeleteProduct deletes the product and takes it out of search, a hard delete removes its
+// images from the bucket too
+func (g *Gateway) DeleteProduct(ctx context.Context, product *Product, conditions *DeleteConditions) error {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return err
+	}
+
+	if conditions == nil || !conditions.HardDelete {
+		if err := g.services.ProductService.DeleteProduct(ctx, product, conditions); err != nil {
+			return err
+		}
+
+		g.reindex(ctx, product.ID)
+		return nil
+	}
+
+	return g.purge(ctx, product)
+}
+
+// purge deletes the product for good along with its images, it is used by hard deletes and
+// when the trash is emptied
+func (g *Gateway) purge(ctx context.Context, product *Product) error {
+	//the gallery goes along with the product, so it has to be read before the product is gone
+	images, err := g.services.MediaService.GetProductImages(ctx, product.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := g.services.ProductService.DeleteProduct(ctx, product, &DeleteConditions{HardDelete: true}); err != nil {
+		return err
+	}
+
+	g.reindex(ctx, product.ID)
+
+	if err := g.services.MediaService.PurgeProductImages(ctx, images); err != nil {
+		//the product is gone at this point, the images are only left behind in the bucket
+		g.Env.Log.Error(err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package media
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/cryptnode-software/pisces/lib"
+)
+
+//bucket the s3 compatible bucket of the env, uploads are public so they can be shown in the
+//storefront as they are
+type bucket struct {
+	env     *lib.AWSEnv
+	client  *s3.Client
+	presign *s3.PresignClient
+}
+
+//NewBucket returns the bucket of the env that is reached through the provided client
+func NewBucket(env *lib.Env, client *s3.Client) lib.Bucket {
+	return &bucket{
+		env.AWSEnv,
+		client,
+		s3.NewPresignClient(client),
+	}
+}
+
+func (b *bucket) PresignPut(ctx context.Context, key string) (string, error) {
+	req, err := b.presign.PresignPutObject(ctx, &s3.PutObjectInput{
+		ACL:    types.ObjectCannedACLPublicRead,
+		Bucket: &b.env.Bucket,
+		Key:    &key,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return req.URL, nil
+}
+
+func (b *bucket) URL(key string) string {
+	return b.env.ObjectURL(key)
+}
+
+func (b *bucket) Key(url string) (string, bool) {
+	return b.env.ObjectKey(url)
+}
+
+//Exists reports whether the object has been uploaded
+func (b *bucket) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &b.env.Bucket,
+		Key:    &key,
+	})
+
+	var missing *types.NotFound
+	if errors.As(err, &missing) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+//Delete removes the objects, objects that don't exist are ignored
+func (b *bucket) Delete(ctx context.Context, keys ...string) error {
+	for i := range keys {
+		_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: &b.env.Bucket,
+			Key:    &keys[i],
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Ping makes sure the bucket exists and the credentials of the env can reach it
+func (b *bucket) Ping(ctx context.Context) error {
+	_, err := b.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: &b.env.Bucket,
+	})
+
+	return err
+}
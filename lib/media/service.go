@@ -0,0 +1,445 @@
+package media
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//Service the media service, keeps the image galleries of products. The images themselves are
+//kept in the bucket, the gallery only references them through their attachments.
+type Service struct {
+	*lib.Env
+	bucket lib.Bucket
+	repo   repoi
+}
+
+//NewService returns a new media service, uploads are confirmed against and removed from the
+//provided bucket
+func NewService(env *lib.Env, bucket lib.Bucket) (lib.MediaService, error) {
+	if bucket == nil {
+		return nil, errors.ErrNoBucket
+	}
+
+	return &Service{
+		env,
+		bucket,
+		&repo{
+			env.GormDB,
+		},
+	}, nil
+}
+
+//GetProductImages returns the gallery of the product in order
+func (s *Service) GetProductImages(ctx context.Context, product uuid.UUID) ([]*lib.ProductImage, error) {
+	return s.repo.GetProductImages(ctx, product)
+}
+
+//ConfirmProductImage adds the image that was uploaded to the url to the end of the gallery of
+//the product. The first image of a gallery is made the primary one, later images only when
+//they are asked to be.
+func (s *Service) ConfirmProductImage(ctx context.Context, image *lib.ProductImage, url string) (*lib.ProductImage, error) {
+	if image == nil {
+		return nil, errors.ErrProductNotProvided
+	}
+
+	key, ok := s.bucket.Key(url)
+	if !ok {
+		return nil, &errors.ErrInvalidUpload{
+			URL: url,
+		}
+	}
+
+	if err := s.repo.GetProduct(ctx, image.ProductID); err != nil {
+		return nil, err
+	}
+
+	exists, err := s.bucket.Exists(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, &errors.ErrUploadNotFound{
+			Key: key,
+		}
+	}
+
+	var result *lib.ProductImage
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if confirmed, err := repo.GetAttachmentByKey(ctx, key); err != nil {
+			return err
+		} else if confirmed != nil {
+			return &errors.ErrUploadConfirmed{
+				Key: key,
+			}
+		}
+
+		images, err := repo.LockProductImages(ctx, image.ProductID)
+		if err != nil {
+			return err
+		}
+
+		attachment := &lib.Attachment{
+			Type:      lib.AttachmentTypeImage,
+			URL:       s.bucket.URL(key),
+			ObjectKey: key,
+		}
+
+		if err := repo.CreateAttachment(ctx, attachment); err != nil {
+			return err
+		}
+
+		result = &lib.ProductImage{
+			ProductID:    image.ProductID,
+			AttachmentID: attachment.ID,
+			Attachment:   attachment,
+			Alt:          strings.TrimSpace(image.Alt),
+			Primary:      image.Primary || len(images) == 0,
+		}
+
+		if len(images) > 0 {
+			result.Position = images[len(images)-1].Position + 1
+		}
+
+		if result.Primary {
+			if err := repo.ClearPrimary(ctx, image.ProductID); err != nil {
+				return err
+			}
+		}
+
+		if err := repo.CreateProductImage(ctx, result); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityProductImage, result.ID, nil, result)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//SaveProductImage updates the alt text of the image and makes it the primary image of its
+//gallery when asked to. A gallery always has a primary image, so the primary image stays
+//primary until another one is made primary instead.
+func (s *Service) SaveProductImage(ctx context.Context, image *lib.ProductImage) (*lib.ProductImage, error) {
+	before, err := s.repo.GetProductImage(ctx, image.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	after := *before
+	after.Alt = strings.TrimSpace(image.Alt)
+	after.Primary = before.Primary || image.Primary
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if after.Primary && !before.Primary {
+			if err := repo.ClearPrimary(ctx, before.ProductID); err != nil {
+				return err
+			}
+		}
+
+		if err := repo.UpdateProductImage(ctx, &after); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityProductImage, after.ID, before, &after)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &after, nil
+}
+
+//SetProductImageOrder orders the gallery of the product by the provided images, the images
+//that aren't provided are put after them in the order they were in
+func (s *Service) SetProductImageOrder(ctx context.Context, product uuid.UUID, ids []uuid.UUID) error {
+	images, err := s.repo.GetProductImages(ctx, product)
+	if err != nil {
+		return err
+	}
+
+	byid := make(map[uuid.UUID]*lib.ProductImage, len(images))
+	for _, image := range images {
+		byid[image.ID] = image
+	}
+
+	ordered := make([]*lib.ProductImage, 0, len(images))
+	seen := make(map[uuid.UUID]bool, len(images))
+
+	for _, id := range ids {
+		image, ok := byid[id]
+		if !ok {
+			return errors.ErrProductImageNotFound
+		}
+
+		if !seen[id] {
+			seen[id] = true
+			ordered = append(ordered, image)
+		}
+	}
+
+	for _, image := range images {
+		if !seen[image.ID] {
+			ordered = append(ordered, image)
+		}
+	}
+
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		for position, image := range ordered {
+			if err := repo.SetPosition(ctx, image.ID, position); err != nil {
+				return err
+			}
+		}
+
+		return s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityProduct, product,
+			map[string]interface{}{"images": identify(images)},
+			map[string]interface{}{"images": identify(ordered)},
+		)
+	})
+}
+
+//DeleteProductImage takes the image out of its gallery and removes it from the bucket, the
+//next image of the gallery is made primary when it was the primary one
+func (s *Service) DeleteProductImage(ctx context.Context, image *lib.ProductImage) error {
+	before, err := s.repo.GetProductImage(ctx, image.ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.DeleteProductImages(ctx, []*lib.ProductImage{before}); err != nil {
+			return err
+		}
+
+		if before.Primary {
+			images, err := repo.LockProductImages(ctx, before.ProductID)
+			if err != nil {
+				return err
+			}
+
+			if len(images) > 0 {
+				images[0].Primary = true
+				if err := repo.UpdateProductImage(ctx, images[0]); err != nil {
+					return err
+				}
+			}
+		}
+
+		return s.Audit(ctx, lib.AuditActionDelete, lib.AuditEntityProductImage, before.ID, before, nil)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	//the image is out of the gallery at this point, an object that can't be removed is only
+	//left behind in the bucket
+	if err := s.bucket.Delete(ctx, keys([]*lib.ProductImage{before})...); err != nil {
+		s.Log.Error(err.Error())
+	}
+
+	return nil
+}
+
+//PurgeProductImages removes the images of a product that was deleted for good, along with
+//their attachments and their objects in the bucket
+func (s *Service) PurgeProductImages(ctx context.Context, images []*lib.ProductImage) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	err := s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.DeleteProductImages(ctx, images); err != nil {
+			return err
+		}
+
+		for _, image := range images {
+			if err := s.Audit(ctx, lib.AuditActionHardDelete, lib.AuditEntityProductImage, image.ID, image, nil); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.bucket.Delete(ctx, keys(images)...)
+}
+
+//keys returns the keys of the objects of the images, attachments made before the keys were
+//kept are left alone
+func keys(images []*lib.ProductImage) []string {
+	result := make([]string, 0, len(images))
+	for _, image := range images {
+		if image.Attachment != nil && image.Attachment.ObjectKey != "" {
+			result = append(result, image.Attachment.ObjectKey)
+		}
+	}
+	return result
+}
+
+func identify(images []*lib.ProductImage) []uuid.UUID {
+	result := make([]uuid.UUID, 0, len(images))
+	for _, image := range images {
+		result = append(result, image.ID)
+	}
+	return result
+}
+
+type repoi interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error
+	GetProduct(ctx context.Context, id uuid.UUID) error
+	GetProductImages(ctx context.Context, product uuid.UUID) ([]*lib.ProductImage, error)
+	LockProductImages(ctx context.Context, product uuid.UUID) ([]*lib.ProductImage, error)
+	GetProductImage(ctx context.Context, id uuid.UUID) (*lib.ProductImage, error)
+	GetAttachmentByKey(ctx context.Context, key string) (*lib.Attachment, error)
+	CreateAttachment(ctx context.Context, attachment *lib.Attachment) error
+	CreateProductImage(ctx context.Context, image *lib.ProductImage) error
+	UpdateProductImage(ctx context.Context, image *lib.ProductImage) error
+	ClearPrimary(ctx context.Context, product uuid.UUID) error
+	SetPosition(ctx context.Context, id uuid.UUID, position int) error
+	DeleteProductImages(ctx context.Context, images []*lib.ProductImage) error
+}
+
+type repo struct {
+	*gorm.DB
+}
+
+//Transaction runs the provided function with a repo that is bound to a single transaction,
+//the transaction is rolled back if the function returns an error. The context the function is
+//given is bound to the transaction as well, so the mutations are audited within it.
+func (r *repo) Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(lib.WithAuditTx(ctx, tx), &repo{tx})
+	})
+}
+
+//GetProduct makes sure the product exists, deleted products don't get new images
+func (r *repo) GetProduct(ctx context.Context, id uuid.UUID) error {
+	var count int64
+
+	if err := r.DB.WithContext(ctx).Model(new(lib.Product)).Where("id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return &errors.ErrNoProductFound{
+			ID: id,
+		}
+	}
+
+	return nil
+}
+
+func (r *repo) GetProductImages(ctx context.Context, product uuid.UUID) (images []*lib.ProductImage, err error) {
+	images = make([]*lib.ProductImage, 0)
+	err = r.DB.WithContext(ctx).
+		Preload("Attachment").
+		Order("position ASC, created_at ASC").
+		Find(&images, "product_id = ?", product).Error
+	return
+}
+
+//LockProductImages returns the gallery of the product in order, locked until the transaction
+//ends so images that are confirmed at the same time don't end up at the same position
+func (r *repo) LockProductImages(ctx context.Context, product uuid.UUID) (images []*lib.ProductImage, err error) {
+	images = make([]*lib.ProductImage, 0)
+	err = r.DB.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Order("position ASC, created_at ASC").
+		Find(&images, "product_id = ?", product).Error
+	return
+}
+
+func (r *repo) GetProductImage(ctx context.Context, id uuid.UUID) (*lib.ProductImage, error) {
+	image := new(lib.ProductImage)
+
+	err := r.DB.WithContext(ctx).Preload("Attachment").First(image, "id = ?", id).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrProductImageNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+//GetAttachmentByKey returns the attachment of the object, nil when there is none
+func (r *repo) GetAttachmentByKey(ctx context.Context, key string) (*lib.Attachment, error) {
+	attachment := new(lib.Attachment)
+
+	err := r.DB.Unscoped().First(attachment, "object_key = ?", key).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+func (r *repo) CreateAttachment(ctx context.Context, attachment *lib.Attachment) error {
+	return r.DB.Create(attachment).Error
+}
+
+func (r *repo) CreateProductImage(ctx context.Context, image *lib.ProductImage) error {
+	return r.DB.Omit(clause.Associations).Create(image).Error
+}
+
+func (r *repo) UpdateProductImage(ctx context.Context, image *lib.ProductImage) error {
+	return r.DB.Model(image).
+		Select("Alt", "Primary").
+		Updates(image).Error
+}
+
+//ClearPrimary makes none of the images of the product the primary one
+func (r *repo) ClearPrimary(ctx context.Context, product uuid.UUID) error {
+	return r.DB.Model(new(lib.ProductImage)).
+		Where("product_id = ? AND is_primary = ?", product, true).
+		Update("is_primary", false).Error
+}
+
+func (r *repo) SetPosition(ctx context.Context, id uuid.UUID, position int) error {
+	return r.DB.Model(new(lib.ProductImage)).
+		Where("id = ?", id).
+		Update("position", position).Error
+}
+
+//DeleteProductImages removes the images and their attachments for good
+func (r *repo) DeleteProductImages(ctx context.Context, images []*lib.ProductImage) error {
+	ids := make([]uuid.UUID, 0, len(images))
+	attachments := make([]uuid.UUID, 0, len(images))
+
+	for _, image := range images {
+		ids = append(ids, image.ID)
+		attachments = append(attachments, image.AttachmentID)
+	}
+
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("id IN ?", ids).Delete(new(lib.ProductImage)).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Where("id IN ?", attachments).Delete(new(lib.Attachment)).Error
+	})
+}
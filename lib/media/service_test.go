@@ -0,0 +1,154 @@
+package media_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/cryptnode-software/pisces/lib/media"
+	"github.com/cryptnode-software/pisces/lib/product"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	env = lib.NewEnv(
+		commons.NewLogger(commons.EnvDev),
+	)
+
+	objects = &bucket{
+		objects: make(map[string]bool),
+	}
+
+	service, err = media.NewService(env, objects)
+
+	products, _ = product.NewService(env)
+
+	ctx = context.Background()
+)
+
+//bucket keeps the objects in memory so uploads can be confirmed without a real bucket
+type bucket struct {
+	objects map[string]bool
+}
+
+func (b *bucket) PresignPut(ctx context.Context, key string) (string, error) {
+	return b.URL(key) + "?signed", nil
+}
+
+func (b *bucket) URL(key string) string {
+	return "https://bucket.test/" + key
+}
+
+func (b *bucket) Key(url string) (string, bool) {
+	key := strings.TrimPrefix(url, "https://bucket.test/")
+	return key, key != url && key != ""
+}
+
+func (b *bucket) Exists(ctx context.Context, key string) (bool, error) {
+	return b.objects[key], nil
+}
+
+func (b *bucket) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		delete(b.objects, key)
+	}
+	return nil
+}
+
+func (b *bucket) Ping(ctx context.Context) error {
+	return nil
+}
+
+//upload puts an object in the bucket and returns its url
+func upload() string {
+	key := "images/" + uuid.New().String() + ".png"
+	objects.objects[key] = true
+	return objects.URL(key)
+}
+
+func TestProductImages(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	p, err := products.SaveProduct(ctx, &lib.Product{
+		Name: "A dozen oatmeal cookies",
+		Cost: 12,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(p)
+
+	_, err = service.ConfirmProductImage(ctx, &lib.ProductImage{ProductID: p.ID}, "https://elsewhere.test/image.png")
+	assert.Equal(t, &perrors.ErrInvalidUpload{URL: "https://elsewhere.test/image.png"}, err)
+
+	_, err = service.ConfirmProductImage(ctx, &lib.ProductImage{ProductID: p.ID}, objects.URL("images/missing.png"))
+	assert.Equal(t, &perrors.ErrUploadNotFound{Key: "images/missing.png"}, err)
+
+	url := upload()
+
+	first, err := service.ConfirmProductImage(ctx, &lib.ProductImage{ProductID: p.ID, Alt: " Front "}, url)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	//the first image of a gallery is the primary one
+	assert.True(t, first.Primary)
+	assert.Equal(t, "Front", first.Alt)
+	assert.Equal(t, url, first.Attachment.URL)
+
+	_, err = service.ConfirmProductImage(ctx, &lib.ProductImage{ProductID: p.ID}, url)
+	assert.Equal(t, &perrors.ErrUploadConfirmed{Key: first.Attachment.ObjectKey}, err)
+
+	second, err := service.ConfirmProductImage(ctx, &lib.ProductImage{ProductID: p.ID}, upload())
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.False(t, second.Primary)
+	assert.Equal(t, first.Position+1, second.Position)
+
+	second.Primary = true
+	second, err = service.SaveProductImage(ctx, second)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, second.Primary)
+
+	assert.Nil(t, service.SetProductImageOrder(ctx, p.ID, []uuid.UUID{second.ID}))
+	assert.Equal(t, perrors.ErrProductImageNotFound, service.SetProductImageOrder(ctx, p.ID, []uuid.UUID{uuid.New()}))
+
+	images, err := service.GetProductImages(ctx, p.ID)
+	if assert.Nil(t, err) && assert.Len(t, images, 2) {
+		assert.Equal(t, second.ID, images[0].ID)
+		assert.False(t, images[1].Primary)
+	}
+
+	//the images come along with the product
+	found, err := products.GetProduct(ctx, lib.WithProductID(p.ID))
+	if assert.Nil(t, err) && assert.Len(t, found.Images, 2) {
+		assert.Equal(t, second.ID, found.Images[0].ID)
+		assert.NotNil(t, found.Images[0].Attachment)
+	}
+
+	//the next image is made primary when the primary one is deleted
+	assert.Nil(t, service.DeleteProductImage(ctx, second))
+	assert.False(t, objects.objects[second.Attachment.ObjectKey])
+
+	images, err = service.GetProductImages(ctx, p.ID)
+	if assert.Nil(t, err) && assert.Len(t, images, 1) {
+		assert.True(t, images[0].Primary)
+	}
+
+	assert.Nil(t, service.PurgeProductImages(ctx, images))
+	assert.False(t, objects.objects[first.Attachment.ObjectKey])
+
+	images, err = service.GetProductImages(ctx, p.ID)
+	assert.Nil(t, err)
+	assert.Empty(t, images)
+}
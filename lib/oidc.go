@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"context"
+	"time"
+)
+
+// OIDCService represents the single sign-on service our staff use to log in with their
+// external identity instead of a separate pisces password
+type OIDCService interface {
+	//StartLogin returns the url of the issuer that the staff member has to be sent to
+	//in order to authenticate, and the state of the login.
+	StartLogin(ctx context.Context) (string, string, error)
+	//CompleteLogin exchanges the code returned by the issuer and maps the identity to
+	//a pisces user, provisioning one if the email domain is allowed.
+	CompleteLogin(ctx context.Context, state, code string) (*User, error)
+	//IssueCode returns a one-time code the app redeems for the JWT of the user.
+	IssueCode(ctx context.Context, user *User) (string, error)
+	//RedeemCode returns the user the one-time code was issued for.
+	RedeemCode(ctx context.Context, code string) (*User, error)
+}
+
+// OIDCLogin holds the state of a login that has been started but not completed yet. The
+// verifier is the PKCE code verifier and is never sent to the browser.
+type OIDCLogin struct {
+	State     string `gorm:"primaryKey"`
+	Nonce     string `gorm:"not null"`
+	Verifier  string `gorm:"not null"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// OIDCCode is a one-time code issued once a login completes, the app redeems it for the
+// JWT of the user. Only the hash of the code is stored.
+type OIDCCode struct {
+	Hash      string `gorm:"primaryKey"`
+	Email     string `gorm:"not null"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,537 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	goerrors "errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	//loginttl how long a staff member has to complete a login once it has started
+	loginttl = 10 * time.Minute
+	//codettl how long the app has to redeem the code of a completed login
+	codettl = time.Minute
+)
+
+//Service the oidc service, handles the authorization code + PKCE flow against the
+//configured issuer and maps the identity it returns to a pisces user
+type Service struct {
+	*lib.Env
+	auth   lib.AuthService
+	client *http.Client
+	repo   repoi
+
+	mutex    sync.Mutex
+	provider *provider
+}
+
+//NewService returns a new oidc service that satisfies the lib.OIDCService interface,
+//users are looked up and provisioned through the provided auth service
+func NewService(env *lib.Env, auth lib.AuthService) (lib.OIDCService, error) {
+	if env.OIDCEnv == nil {
+		return nil, errors.ErrOIDCNotConfigured
+	}
+
+	if auth == nil {
+		return nil, errors.ErrNoAuthService
+	}
+
+	return &Service{
+		Env:  env,
+		auth: auth,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		repo: &repo{
+			env.GormDB,
+		},
+	}, nil
+}
+
+//StartLogin creates a new login with its own state, nonce and PKCE verifier and returns the
+//authorization url of the issuer along with the state, which the browser has to be bound to
+func (s *Service) StartLogin(ctx context.Context) (string, string, error) {
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	login := &lib.OIDCLogin{
+		ExpiresAt: time.Now().Add(loginttl),
+	}
+
+	if login.State, err = random(); err != nil {
+		return "", "", err
+	}
+
+	if login.Nonce, err = random(); err != nil {
+		return "", "", err
+	}
+
+	if login.Verifier, err = random(); err != nil {
+		return "", "", err
+	}
+
+	if err = s.repo.CreateLogin(ctx, login); err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.OIDCEnv.ClientID},
+		"redirect_uri":          {s.OIDCEnv.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return provider.AuthorizationEndpoint + separator + query.Encode(), login.State, nil
+}
+
+//CompleteLogin exchanges the code for an id token, verifies it and returns the user with the
+//same verified email. Only emails of the allowed domains can log in, if there isn't a user
+//for one yet a staff account is provisioned for it.
+func (s *Service) CompleteLogin(ctx context.Context, state, code string) (*lib.User, error) {
+	if state == "" || code == "" {
+		return nil, errors.ErrOIDCInvalidState
+	}
+
+	login, err := s.repo.ConsumeLogin(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(login.ExpiresAt) {
+		return nil, errors.ErrOIDCInvalidState
+	}
+
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.exchange(ctx, provider, login, code)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := s.verify(ctx, provider, login, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !identity.EmailVerified {
+		return nil, errors.ErrOIDCEmailNotVerified
+	}
+
+	email := strings.ToLower(identity.Email)
+
+	//the issuer vouches for the emails of its own domains only, so an account outside of
+	//them can't be logged into even when it already exists
+	if !s.allowed(email) {
+		return nil, &errors.ErrOIDCDomainNotAllowed{
+			Email: email,
+		}
+	}
+
+	user, err := s.auth.FindUser(ctx, "", email)
+	if err == nil {
+		return user, nil
+	}
+
+	if !goerrors.Is(err, gorm.ErrRecordNotFound) && err != errors.ErrNoUserFound {
+		return nil, err
+	}
+
+	//staff provisioned through single sign-on never log in with a password, so
+	//they are given one that nobody knows
+	password, err := random()
+	if err != nil {
+		return nil, err
+	}
+
+	//an allowed domain only gets staff an account, admin has to be granted to
+	//them explicitly
+	return s.auth.CreateUser(ctx, &lib.User{
+		Username: email,
+		Email:    email,
+	}, password)
+}
+
+//IssueCode returns a one-time code that the app redeems for the JWT of the user, so
+//the JWT itself is never put in a url
+func (s *Service) IssueCode(ctx context.Context, user *lib.User) (string, error) {
+	code, err := random()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.repo.CreateCode(ctx, &lib.OIDCCode{
+		Hash:      hash(code),
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(codettl),
+	}); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+//RedeemCode returns the user that the code was issued for, a code can only be
+//redeemed once
+func (s *Service) RedeemCode(ctx context.Context, code string) (*lib.User, error) {
+	if code == "" {
+		return nil, errors.ErrOIDCInvalidCode
+	}
+
+	result, err := s.repo.ConsumeCode(ctx, hash(code))
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(result.ExpiresAt) {
+		return nil, errors.ErrOIDCInvalidCode
+	}
+
+	return s.auth.FindUser(ctx, "", result.Email)
+}
+
+func (s *Service) allowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	domain := email[at+1:]
+
+	for _, allowed := range s.OIDCEnv.AllowedDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+type provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+//discover fetches (once) the discovery document of the configured issuer
+func (s *Service) discover(ctx context.Context) (*provider, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.provider != nil {
+		return s.provider, nil
+	}
+
+	result := new(provider)
+	if err := s.get(ctx, s.OIDCEnv.Issuer+"/.well-known/openid-configuration", result); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(result.Issuer, "/") != s.OIDCEnv.Issuer {
+		return nil, errors.ErrOIDCInvalidToken
+	}
+
+	s.provider = result
+
+	return result, nil
+}
+
+func (s *Service) exchange(ctx context.Context, provider *provider, login *lib.OIDCLogin, code string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.OIDCEnv.RedirectURL},
+		"client_id":     {s.OIDCEnv.ClientID},
+		"code_verifier": {login.Verifier},
+	}
+
+	if s.OIDCEnv.ClientSecret != "" {
+		form.Set("client_secret", s.OIDCEnv.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	result := struct {
+		IDToken string `json:"id_token"`
+	}{}
+
+	if err := s.do(req, &result); err != nil {
+		return "", err
+	}
+
+	if result.IDToken == "" {
+		return "", errors.ErrOIDCInvalidToken
+	}
+
+	return result.IDToken, nil
+}
+
+type claims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Nonce         string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+//verify checks the signature of the id token against the keys of the issuer along with
+//its issuer, audience, expiry and nonce
+func (s *Service) verify(ctx context.Context, provider *provider, login *lib.OIDCLogin, token string) (*claims, error) {
+	keys, err := s.keys(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(claims)
+
+	t, err := jwt.ParseWithClaims(token, result, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+
+		//issuers with a single key are allowed to omit the kid
+		if kid == "" && len(keys) == 1 {
+			for _, key := range keys {
+				return key, nil
+			}
+		}
+
+		return nil, errors.ErrOIDCInvalidToken
+	}, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}))
+
+	if err != nil || !t.Valid {
+		return nil, errors.ErrOIDCInvalidToken
+	}
+
+	if strings.TrimSuffix(result.Issuer, "/") != s.OIDCEnv.Issuer {
+		return nil, errors.ErrOIDCInvalidToken
+	}
+
+	if !result.VerifyAudience(s.OIDCEnv.ClientID, true) {
+		return nil, errors.ErrOIDCInvalidToken
+	}
+
+	if result.ExpiresAt == nil || result.Nonce != login.Nonce {
+		return nil, errors.ErrOIDCInvalidToken
+	}
+
+	return result, nil
+}
+
+func (s *Service) keys(ctx context.Context, provider *provider) (map[string]*rsa.PublicKey, error) {
+	set := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+
+	if err := s.get(ctx, provider.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			continue
+		}
+
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *Service) get(ctx context.Context, endpoint string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	return s.do(req, result)
+}
+
+func (s *Service) do(req *http.Request, result interface{}) error {
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return &errors.ErrOIDCIssuer{
+			Endpoint: req.URL.Path,
+			Status:   res.StatusCode,
+		}
+	}
+
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+//hash codes are only stored hashed so the table can't be used to log in, they are
+//random and short lived enough that a single sha256 is sufficient
+func hash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func random() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+type repoi interface {
+	CreateLogin(ctx context.Context, login *lib.OIDCLogin) error
+	ConsumeLogin(ctx context.Context, state string) (*lib.OIDCLogin, error)
+	CreateCode(ctx context.Context, code *lib.OIDCCode) error
+	ConsumeCode(ctx context.Context, hash string) (*lib.OIDCCode, error)
+}
+
+type repo struct {
+	*gorm.DB
+}
+
+func (r *repo) CreateLogin(ctx context.Context, login *lib.OIDCLogin) error {
+	//expired logins are cleaned up as new ones are created
+	if err := r.DB.Where("expires_at < ?", time.Now()).Delete(new(lib.OIDCLogin)).Error; err != nil {
+		return err
+	}
+
+	return r.DB.Create(login).Error
+}
+
+//ConsumeLogin returns the login for the provided state and deletes it so that the
+//same state can never be completed twice
+func (r *repo) ConsumeLogin(ctx context.Context, state string) (*lib.OIDCLogin, error) {
+	login := new(lib.OIDCLogin)
+
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(login, "state = ?", state).Error; err != nil {
+			return err
+		}
+
+		result := tx.Delete(new(lib.OIDCLogin), "state = ?", state)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected != 1 {
+			return errors.ErrOIDCInvalidState
+		}
+
+		return nil
+	})
+
+	if goerrors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.ErrOIDCInvalidState
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return login, nil
+}
+
+func (r *repo) CreateCode(ctx context.Context, code *lib.OIDCCode) error {
+	//expired codes are cleaned up as new ones are created
+	if err := r.DB.Where("expires_at < ?", time.Now()).Delete(new(lib.OIDCCode)).Error; err != nil {
+		return err
+	}
+
+	return r.DB.Create(code).Error
+}
+
+//ConsumeCode returns the code with the provided hash and deletes it so that the same
+//code can never be redeemed twice
+func (r *repo) ConsumeCode(ctx context.Context, hash string) (*lib.OIDCCode, error) {
+	code := new(lib.OIDCCode)
+
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(code, "hash = ?", hash).Error; err != nil {
+			return err
+		}
+
+		result := tx.Delete(new(lib.OIDCCode), "hash = ?", hash)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected != 1 {
+			return errors.ErrOIDCInvalidCode
+		}
+
+		return nil
+	})
+
+	if goerrors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.ErrOIDCInvalidCode
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return code, nil
+}
@@ -0,0 +1,220 @@
+package oidc_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/auth"
+	"github.com/cryptnode-software/pisces/lib/oidc"
+	"github.com/cryptnode-software/pisces/lib/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	env = lib.NewEnv(commons.NewLogger(commons.EnvDev))
+
+	issuer, err = oidctest.NewIssuer("pisces")
+
+	authservice, _ = auth.NewService(env)
+
+	ctx = context.Background()
+
+	//client doesn't follow the redirect back to pisces so we can read
+	//the code and state that the issuer returned
+	client = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	existing = &lib.User{
+		Username: "existing.staff",
+		Email:    "Existing.Staff@cryptnode.tech",
+	}
+
+	//accounts outside of the allowed domains can't be logged into through the
+	//issuer, even though they already exist
+	outsider = &lib.User{
+		Username: "outsider",
+		Email:    "outsider@elsewhere.io",
+	}
+)
+
+func TestCompleteLogin(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer issuer.Close()
+
+	env.OIDCEnv = &lib.OIDCEnv{
+		Issuer:         issuer.URL,
+		ClientID:       issuer.ClientID,
+		RedirectURL:    "http://localhost:4081/oidc/callback",
+		AllowedDomains: []string{"cryptnode.tech"},
+	}
+
+	service, err := oidc.NewService(env, authservice)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	user, err := authservice.CreateUser(ctx, existing, "testpassword")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer deseed([]*lib.User{user})
+
+	other, err := authservice.CreateUser(ctx, outsider, "testpassword")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer deseed([]*lib.User{other})
+
+	tables := []struct {
+		identity oidctest.Identity
+		expected *lib.User
+		fail     bool
+	}{
+		{
+			identity: oidctest.Identity{
+				Subject:       "1",
+				Email:         "new.staff@cryptnode.tech",
+				EmailVerified: true,
+			},
+			expected: &lib.User{
+				Username: "new.staff@cryptnode.tech",
+				Email:    "new.staff@cryptnode.tech",
+				Admin:    false,
+			},
+		},
+		{
+			identity: oidctest.Identity{
+				Subject:       "2",
+				Email:         "existing.staff@cryptnode.tech",
+				EmailVerified: true,
+			},
+			expected: user,
+		},
+		{
+			identity: oidctest.Identity{
+				Subject:       "3",
+				Email:         "new.staff@cryptnode.tech",
+				EmailVerified: false,
+			},
+			fail: true,
+		},
+		{
+			identity: oidctest.Identity{
+				Subject:       "4",
+				Email:         "stranger@elsewhere.io",
+				EmailVerified: true,
+			},
+			fail: true,
+		},
+		{
+			identity: oidctest.Identity{
+				Subject:       "5",
+				Email:         outsider.Email,
+				EmailVerified: true,
+			},
+			fail: true,
+		},
+	}
+
+	for _, table := range tables {
+		issuer.SetIdentity(table.identity)
+
+		state, code, err := authorize(service)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		user, err := service.CompleteLogin(ctx, state, code)
+
+		if table.fail {
+			if err == nil {
+				t.Error("complete login was suppose to fail but didn't")
+				deseed([]*lib.User{user})
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		assert.Equal(t, table.expected.Email, user.Email)
+		assert.Equal(t, table.expected.Username, user.Username)
+		assert.Equal(t, table.expected.Admin, user.Admin)
+
+		//the same state can't be used twice
+		if _, err := service.CompleteLogin(ctx, state, code); err == nil {
+			t.Error("complete login succeeded when the state was reused")
+		}
+
+		//the app is handed a one-time code for the user rather than the JWT
+		issued, err := service.IssueCode(ctx, user)
+		if err != nil {
+			t.Error(err)
+		} else {
+			redeemed, err := service.RedeemCode(ctx, issued)
+			if assert.Nil(t, err) {
+				assert.Equal(t, user.ID, redeemed.ID)
+			}
+
+			if _, err := service.RedeemCode(ctx, issued); err == nil {
+				t.Error("redeem code succeeded when the code was reused")
+			}
+		}
+
+		if user.ID != existing.ID {
+			deseed([]*lib.User{user})
+		}
+	}
+}
+
+func authorize(service lib.OIDCService) (state, code string, err error) {
+	location, started, err := service.StartLogin(ctx)
+	if err != nil {
+		return
+	}
+
+	res, err := client.Get(location)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+
+	redirect, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return
+	}
+
+	if state = redirect.Query().Get("state"); state != started {
+		return "", "", fmt.Errorf("issuer returned state %q rather than %q", state, started)
+	}
+
+	return state, redirect.Query().Get("code"), nil
+}
+
+func deseed(users []*lib.User) error {
+	for _, user := range users {
+		if err := authservice.DeleteUser(ctx, user, &lib.DeleteConditions{
+			HardDelete: true,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
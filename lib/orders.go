@@ -6,43 +6,118 @@ import (
 
 	commons "github.com/cryptnode-software/commons/pkg"
 	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
 	"gorm.io/gorm"
 )
 
+const accesstokenheader = "access-token"
+
 // OrderService represents the OrderService interface
 type OrderService interface {
 	GetInquires(ctx context.Context, conditions *GetInquiryConditions) ([]*Inquiry, error)
 	SaveOrder(context.Context, *Order, *SaveConditions) (*Order, error)
 	DeleteOrder(context.Context, *Order, *DeleteConditions) error
 	DeleteInquiry(context.Context, *Inquiry, *DeleteConditions) error
-	GetOrders(context.Context, *OrderConditions) ([]*Order, error)
+	GetOrders(context.Context, *OrderConditions) (*OrderPage, error)
+	StreamOrders(ctx context.Context, conditions *OrderConditions, fn func(*Order) error) error
 	GetInquiry(ctx context.Context, id uuid.UUID) (*Inquiry, error)
 	SaveInquiry(context.Context, *Inquiry) (*Inquiry, error)
 	GetOrder(ctx context.Context, id uuid.UUID) (*Order, error)
 	ArchiveOrder(context.Context, *Order) (*Order, error)
+	UnarchiveOrder(context.Context, *Order) (*Order, error)
+	IssueAccessToken(ctx context.Context, grant *AccessGrant) (string, error)
+	VerifyAccessToken(ctx context.Context, token string) (*AccessGrant, error)
+}
+
+// AccessGrant is what a guest access token grants read access to. The token is issued when
+// a guest places an order (or an inquiry) and is sent to them so they can look it up again
+// without an account. An order grant also covers the inquiry of the order.
+type AccessGrant struct {
+	OrderID   uuid.UUID
+	InquiryID uuid.UUID
+}
+
+// AccessNotifier sends guests the access token of the order or inquiry they placed, so they
+// can still look it up when they lose the response it was returned in
+type AccessNotifier interface {
+	NotifyAccess(ctx context.Context, notice *AccessNotice) error
+}
+
+// AccessNotice is the access token of an order or inquiry that is sent to the guest that
+// placed it
+type AccessNotice struct {
+	Email       string    `json:"email"`
+	OrderID     uuid.UUID `json:"order_id"`
+	InquiryID   uuid.UUID `json:"inquiry_id"`
+	AccessToken string    `json:"access_token"`
+}
+
+// GetAccessTokenFromContext returns the guest access token provided through the
+// `access-token` header
+func GetAccessTokenFromContext(ctx context.Context) (string, error) {
+	return getHeaderFromContext(ctx, accesstokenheader)
+}
+
+// SetAccessTokenContext sets the guest access token on the incoming metadata
+func SetAccessTokenContext(ctx context.Context, token string) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	md.Set(accesstokenheader, token)
+	return metadata.NewIncomingContext(ctx, md)
 }
 
 // OrderConditions defines the different conditions that
-// we can filter and sort orders by.
+// we can filter and sort orders by. Every filter that is left
+// empty matches any order.
 type OrderConditions struct {
 	Status OrderStatus
 	SortBy OrdersSortBy
+	//Archived lists the archived orders instead, they are left out otherwise
+	Archived      bool
+	PaymentMethod PaymentMethod
+	//CreatedFrom and CreatedTo bound when the order was placed, DueFrom and DueTo
+	//when it is due. The lower bounds are inclusive, the upper ones are not.
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	DueFrom     *time.Time
+	DueTo       *time.Time
+	//Customer matches the email, first and/or last name of the inquiry of the order
+	Customer string
+	//MinTotal and MaxTotal bound the total of the order, both are inclusive
+	MinTotal *float32
+	MaxTotal *float32
+	//ProductID only matches orders that have the product in their cart
+	ProductID *uuid.UUID
+	//Cursor continues the listing from the page it was returned with, it has to be
+	//used with the same sort. Limit is the size of the page.
+	Cursor string
+	Limit  int
+}
+
+// OrderPage a single page of orders, Total is the number of orders that match the
+// conditions across every page
+type OrderPage struct {
+	Orders []*Order
+	Total  int64
+	//NextCursor continues with the next page, it is empty on the last one
+	NextCursor string
 }
 
 // OrdersSortBy represents the primitive type for all the sorting capabilities
 type OrdersSortBy string
 
 const (
-	//OrdersSortByDateAscending ...
+	//OrdersSortByDateAscending sorts the orders by when they were placed, oldest first
 	OrdersSortByDateAscending OrdersSortBy = "DATE_ASCENDING"
 
-	//OrdersSortByDateDescending ...
+	//OrdersSortByDateDescending sorts the orders by when they were placed, newest
+	//first. It is the default when no sort is provided.
 	OrdersSortByDateDescending OrdersSortBy = "DATE_DESCENDING"
 
-	//OrdersSortByDueDescending ...
+	//OrdersSortByDueDescending sorts the orders by when they are due, latest first
 	OrdersSortByDueDescending OrdersSortBy = "DUE_DESCENDING"
 
-	//OrderSortByDueAscending ...
+	//OrderSortByDueAscending sorts the orders by when they are due, soonest first
 	OrderSortByDueAscending OrdersSortBy = "DUE_ASCENDING"
 )
 
@@ -58,9 +133,31 @@ type Order struct {
 	Due           time.Time
 	Cart          []*Cart
 	ExtID         string
+	//UserID is the user that placed the order, guests don't have one
+	UserID *uuid.UUID
+	//StockReserved is set when the stock of the cart was taken out of the inventory when
+	//the order was placed (see CheckoutService), it is put back if the order expires
+	StockReserved bool
+	//VoidPending is set when the order expired before its paypal payment was voided, the
+	//void is retried until it goes through
+	VoidPending bool
+	//ArchivedAt is set once the order has been archived, archived orders are kept
+	//out of GetOrders but are still around unlike deleted ones
+	ArchivedAt *time.Time
 	commons.Model
 }
 
+// OwnedBy reports whether the order was placed by the provided user
+func (order *Order) OwnedBy(user *User) bool {
+	return user != nil && order.UserID != nil && *order.UserID == user.ID
+}
+
+// Archivable reports whether the order is done with and can be archived, only completed
+// and cancelled orders can be
+func (order *Order) Archivable() bool {
+	return order.Status == OrderStatusCompleted || order.Status == OrderStatusCancelled
+}
+
 func (order *Order) AfterDelete(tx *gorm.DB) (err error) {
 	tx.Delete(new(Inquiry), "id = ?", order.InquiryID)
 	return
@@ -87,9 +184,16 @@ type Inquiry struct {
 	LastName    string
 	Number      string
 	Email       string
+	//UserID is the user that made the inquiry, guests don't have one
+	UserID *uuid.UUID
 	commons.Model
 }
 
+// OwnedBy reports whether the inquiry was made by the provided user
+func (inquiry *Inquiry) OwnedBy(user *User) bool {
+	return user != nil && inquiry.UserID != nil && *inquiry.UserID == user.ID
+}
+
 // OrderStatus this is the primitive datatype for OrderStatus' for
 // how we handle it through the rest of the application
 type OrderStatus string
@@ -112,6 +216,16 @@ const (
 	//selling. This is typically the final step in the ordering
 	//process
 	OrderStatusAccepted OrderStatus = "ACCEPTED"
+	//OrderStatusExpired represents an order the user never finalized, it
+	//is expired once it has been pending on them for too long (see
+	//CheckoutService.ExpireOrders)
+	OrderStatusExpired OrderStatus = "EXPIRED"
+	//OrderStatusCompleted represents an accepted order that has been
+	//fulfilled on both ends, there is nothing left to do with it
+	OrderStatusCompleted OrderStatus = "COMPLETED"
+	//OrderStatusCancelled represents an order that was called off by
+	//either party before it was fulfilled
+	OrderStatusCancelled OrderStatus = "CANCELLED"
 )
 
 // GetInquiryConditions represents the different conditions that we
@@ -0,0 +1,185 @@
+package orders
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	//defaultlimit is the size of a page of orders when no limit is provided
+	defaultlimit = 50
+
+	//maxlimit is the largest page of orders that is read at once
+	maxlimit = 500
+
+	//ordertotal is the total of an order computed the same way LoadOrderTotal does it
+	ordertotal = "(SELECT COALESCE(SUM(carts.quantity * COALESCE(carts.unit_price, product_variants.cost, products.cost)), 0) FROM carts " +
+		"JOIN products ON products.id = carts.product_id " +
+		"LEFT JOIN product_variants ON product_variants.id = carts.variant_id " +
+		"WHERE carts.order_id = orders.id AND carts.deleted_at IS NULL)"
+)
+
+//ordersort is the column an order sort is made on, the id of the order breaks ties so
+//the order is the same every time
+type ordersort struct {
+	column     string
+	descending bool
+}
+
+//sorts are the only sorts orders can be listed by, the column is never taken from the
+//request itself
+var sorts = map[lib.OrdersSortBy]*ordersort{
+	lib.OrdersSortByDateAscending:  {"created_at", false},
+	lib.OrdersSortByDateDescending: {"created_at", true},
+	lib.OrderSortByDueAscending:    {"due", false},
+	lib.OrdersSortByDueDescending:  {"due", true},
+}
+
+//cursor is the position of the last order of a page, the next page starts right after it
+type cursor struct {
+	SortBy lib.OrdersSortBy `json:"s"`
+	At     time.Time        `json:"t"`
+	ID     uuid.UUID        `json:"id"`
+}
+
+func newcursor(sortby lib.OrdersSortBy, order *lib.Order) *cursor {
+	at := order.CreatedAt
+	if sorts[sortby].column == "due" {
+		at = order.Due
+	}
+
+	return &cursor{
+		SortBy: sortby,
+		At:     at,
+		ID:     order.ID,
+	}
+}
+
+func encodecursor(sortby lib.OrdersSortBy, order *lib.Order) string {
+	raw, _ := json.Marshal(newcursor(sortby, order))
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodecursor(sortby lib.OrdersSortBy, value string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.ErrInvalidOrderCursor
+	}
+
+	c := new(cursor)
+	if err := json.Unmarshal(raw, c); err != nil || c.SortBy != sortby || c.ID == uuid.Nil {
+		return nil, errors.ErrInvalidOrderCursor
+	}
+
+	return c, nil
+}
+
+//page validates the sort, cursor and limit of the conditions, the defaults are used for
+//the ones that weren't provided
+func page(conditions *lib.OrderConditions) (sortby lib.OrdersSortBy, after *cursor, limit int, err error) {
+	if conditions == nil {
+		conditions = new(lib.OrderConditions)
+	}
+
+	sortby, limit = conditions.SortBy, conditions.Limit
+
+	if sortby == "" {
+		sortby = lib.OrdersSortByDateDescending
+	}
+
+	if _, ok := sorts[sortby]; !ok || limit < 0 {
+		return "", nil, 0, &errors.ErrInvalidOrderPage{
+			Limit:  limit,
+			SortBy: string(sortby),
+		}
+	}
+
+	if limit == 0 {
+		limit = defaultlimit
+	}
+
+	if limit > maxlimit {
+		limit = maxlimit
+	}
+
+	if conditions.Cursor != "" {
+		if after, err = decodecursor(sortby, conditions.Cursor); err != nil {
+			return "", nil, 0, err
+		}
+	}
+
+	return
+}
+
+//filter applies every filter of the conditions to the query, it leaves the sort and the
+//page to the caller
+func filter(tx *gorm.DB, conditions *lib.OrderConditions) *gorm.DB {
+	if conditions == nil {
+		conditions = new(lib.OrderConditions)
+	}
+
+	if conditions.Archived {
+		tx = tx.Where("orders.archived_at IS NOT NULL")
+	} else {
+		tx = tx.Where("orders.archived_at IS NULL")
+	}
+
+	if conditions.Status != "" {
+		tx = tx.Where("orders.status = ?", conditions.Status)
+	}
+
+	if conditions.PaymentMethod != "" {
+		tx = tx.Where("orders.payment_method = ?", conditions.PaymentMethod)
+	}
+
+	if conditions.CreatedFrom != nil {
+		tx = tx.Where("orders.created_at >= ?", conditions.CreatedFrom)
+	}
+
+	if conditions.CreatedTo != nil {
+		tx = tx.Where("orders.created_at < ?", conditions.CreatedTo)
+	}
+
+	if conditions.DueFrom != nil {
+		tx = tx.Where("orders.due >= ?", conditions.DueFrom)
+	}
+
+	if conditions.DueTo != nil {
+		tx = tx.Where("orders.due < ?", conditions.DueTo)
+	}
+
+	if customer := strings.TrimSpace(conditions.Customer); customer != "" {
+		like := "%" + escapelike(customer) + "%"
+
+		tx = tx.Where("orders.inquiry_id IN (?)", tx.Session(&gorm.Session{NewDB: true}).
+			Model(new(lib.Inquiry)).
+			Select("id").
+			Where("email LIKE ? OR first_name LIKE ? OR last_name LIKE ? OR CONCAT_WS(' ', first_name, last_name) LIKE ?", like, like, like, like))
+	}
+
+	if conditions.MinTotal != nil {
+		tx = tx.Where(ordertotal+" >= ?", conditions.MinTotal)
+	}
+
+	if conditions.MaxTotal != nil {
+		tx = tx.Where(ordertotal+" <= ?", conditions.MaxTotal)
+	}
+
+	if conditions.ProductID != nil {
+		tx = tx.Where("EXISTS (SELECT 1 FROM carts WHERE carts.order_id = orders.id AND carts.product_id = ? AND carts.deleted_at IS NULL)", conditions.ProductID)
+	}
+
+	return tx
+}
+
+//escapelike escapes the wildcards of a LIKE pattern so the search is taken literally
+func escapelike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
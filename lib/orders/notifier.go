@@ -0,0 +1,74 @@
+package orders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+)
+
+//NewNotifier returns the access notifier of the env, access tokens are posted to the webhook
+//of the env when it has one. Otherwise it is only logged that they couldn't be sent, the
+//tokens themselves are never logged.
+func NewNotifier(env *lib.Env) lib.AccessNotifier {
+	if env.AccessWebhook == "" {
+		return &lognotifier{env.Log}
+	}
+
+	return &webhook{
+		url: env.AccessWebhook,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+//webhook posts the notice as json, i.e. {"email": ..., "order_id": ..., "access_token": ...}
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+func (w *webhook) NotifyAccess(ctx context.Context, notice *lib.AccessNotice) error {
+	body, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return &errors.ErrAccessWebhook{
+			Status: res.StatusCode,
+		}
+	}
+
+	return nil
+}
+
+//lognotifier is used when no webhook is configured
+type lognotifier struct {
+	log commons.Logger
+}
+
+func (l *lognotifier) NotifyAccess(ctx context.Context, notice *lib.AccessNotice) error {
+	l.log.Info(fmt.Sprintf("no access webhook is configured, the access token of order %s and inquiry %s wasn't sent", notice.OrderID, notice.InquiryID))
+	return nil
+}
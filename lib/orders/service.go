@@ -2,11 +2,26 @@ package orders
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/cryptnode-software/pisces/lib"
 	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	//accessaudience keeps access tokens and login tokens from being used in place
+	//of one another, on top of them being signed with different keys
+	accessaudience = "order-access"
+
+	//accessexpiry is how long a guest can look up their order with the token that
+	//was sent to them
+	accessexpiry = 90 * 24 * time.Hour
 )
 
 //Service the order service, handles everything related to an order
@@ -26,9 +41,65 @@ func NewService(env *lib.Env) (lib.OrderService, error) {
 	}, nil
 }
 
-//GetOrders returns orders sorted and filtered by the conditions provided
-func (s *Service) GetOrders(ctx context.Context, conditions *lib.OrderConditions) ([]*lib.Order, error) {
-	return s.repo.GetOrders(ctx, conditions)
+//GetOrders returns a page of orders sorted and filtered by the conditions provided, along
+//with the total number of orders that match them
+func (s *Service) GetOrders(ctx context.Context, conditions *lib.OrderConditions) (*lib.OrderPage, error) {
+	sortby, after, limit, err := page(conditions)
+	if err != nil {
+		return nil, err
+	}
+
+	//one more than the limit is read to know whether there is another page
+	orders, err := s.repo.GetOrders(ctx, conditions, sortby, after, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := s.repo.CountOrders(ctx, conditions)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &lib.OrderPage{
+		Orders: orders,
+		Total:  total,
+	}
+
+	if len(orders) > limit {
+		result.Orders = orders[:limit]
+		result.NextCursor = encodecursor(sortby, result.Orders[limit-1])
+	}
+
+	return result, nil
+}
+
+//StreamOrders calls fn with every order that matches the conditions, in order. The orders
+//are read a page at a time so large listings are never loaded whole, the limit of the
+//conditions is the size of those pages. It stops at the first error fn returns.
+func (s *Service) StreamOrders(ctx context.Context, conditions *lib.OrderConditions, fn func(*lib.Order) error) error {
+	sortby, after, limit, err := page(conditions)
+	if err != nil {
+		return err
+	}
+
+	for {
+		orders, err := s.repo.GetOrders(ctx, conditions, sortby, after, limit)
+		if err != nil {
+			return err
+		}
+
+		for _, order := range orders {
+			if err := fn(order); err != nil {
+				return err
+			}
+		}
+
+		if len(orders) < limit {
+			return nil
+		}
+
+		after = newcursor(sortby, orders[len(orders)-1])
+	}
 }
 
 //GetOrder returns a specific order and any information that is associated with
@@ -54,13 +125,58 @@ func (s *Service) SaveOrder(ctx context.Context, order *lib.Order, conditions *l
 		}
 	}
 
+	var result *lib.Order
+
 	//create new order
 	if order.ID == uuid.Nil {
-		return s.repo.CreateOrder(ctx, order)
+		err := s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+			if result, err = repo.CreateOrder(ctx, order); err != nil {
+				return err
+			}
+
+			return s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityOrder, result.ID, nil, result)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return result, nil
+	}
+
+	//otherwise update a preexisting order, without conditions it isn't updated as root
+	if conditions == nil {
+		conditions = new(lib.SaveConditions)
+	}
+
+	before, err := s.repo.GetOrder(ctx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+		if result, err = repo.UpdateOrder(ctx, order, conditions); err != nil {
+			return err
+		}
+
+		after, err := repo.GetOrder(ctx, order.ID)
+		if err != nil {
+			return err
+		}
+
+		action := lib.AuditActionUpdate
+		if before.Status != after.Status {
+			action = lib.AuditActionStatusChange
+		}
+
+		return s.Audit(ctx, action, lib.AuditEntityOrder, order.ID, before, after)
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
-	//otherwise update a preexisting order
-	return s.repo.UpdateOrder(ctx, order, conditions)
+	return result, nil
 }
 
 //SaveInquiry will either create a new inquiry or update a pre-existing one. The optional
@@ -68,12 +184,41 @@ func (s *Service) SaveOrder(ctx context.Context, order *lib.Order, conditions *l
 //ID <= zero (basically zero but in the off chance someone tries to update an id that is
 //less then 0, we can catch it) create a new inquiry, otherwise update with the id that
 //is provided.
-func (s *Service) SaveInquiry(ctx context.Context, inquiry *lib.Inquiry) (*lib.Inquiry, error) {
+func (s *Service) SaveInquiry(ctx context.Context, inquiry *lib.Inquiry) (result *lib.Inquiry, err error) {
 	if inquiry.ID == uuid.Nil {
-		return s.repo.CreateInquiry(ctx, inquiry)
+		err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+			if result, err = repo.CreateInquiry(ctx, inquiry); err != nil {
+				return err
+			}
+
+			return s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityInquiry, result.ID, nil, result)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return result, nil
 	}
 
-	return s.repo.UpdateInquiry(ctx, inquiry)
+	before, err := s.repo.GetInquiry(ctx, inquiry.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+		if result, err = repo.UpdateInquiry(ctx, inquiry); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityInquiry, result.ID, before, result)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
 //GetInquires returns all of the inquires that match the *optional conditions from
@@ -83,33 +228,203 @@ func (s *Service) GetInquires(ctx context.Context, conditions *lib.GetInquiryCon
 	return s.repo.GetInquires(ctx, conditions)
 }
 
-//ArchiveOrder archives a provided order
+//ArchiveOrder archives a completed or cancelled order, archived orders are left out of
+//GetOrders unless they are asked for. Archiving an archived order is a no-op.
 func (s *Service) ArchiveOrder(ctx context.Context, order *lib.Order) (*lib.Order, error) {
-	return order, nil
+	before, err := s.repo.GetOrder(ctx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if before.ArchivedAt != nil {
+		return before, nil
+	}
+
+	if !before.Archivable() {
+		return nil, &errors.ErrOrderNotArchivable{
+			OrderID: before.ID,
+			Status:  string(before.Status),
+		}
+	}
+
+	var (
+		after    *lib.Order
+		archived bool
+	)
+
+	//the status is checked again when archiving in case it changed in the meantime
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+		if archived, err = repo.ArchiveOrder(ctx, before, time.Now()); err != nil {
+			return err
+		}
+
+		if after, err = repo.GetOrder(ctx, order.ID); err != nil || !archived {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionArchive, lib.AuditEntityOrder, after.ID, before, after)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !archived && after.ArchivedAt == nil {
+		return nil, &errors.ErrOrderNotArchivable{
+			OrderID: after.ID,
+			Status:  string(after.Status),
+		}
+	}
+
+	return after, nil
 }
 
-func (s *Service) DeleteOrder(ctx context.Context, order *lib.Order, conditions *lib.DeleteConditions) error {
-	if conditions != nil && conditions.HardDelete {
-		return s.repo.HardDeleteOrder(ctx, order)
+//UnarchiveOrder takes an order out of the archive so it is listed by GetOrders again,
+//unarchiving an order that isn't archived is a no-op
+func (s *Service) UnarchiveOrder(ctx context.Context, order *lib.Order) (*lib.Order, error) {
+	before, err := s.repo.GetOrder(ctx, order.ID)
+	if err != nil {
+		return nil, err
 	}
 
-	return s.repo.SoftDeleteOrder(ctx, order)
+	if before.ArchivedAt == nil {
+		return before, nil
+	}
+
+	var after *lib.Order
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		unarchived, err := repo.UnarchiveOrder(ctx, before)
+		if err != nil {
+			return err
+		}
+
+		if after, err = repo.GetOrder(ctx, order.ID); err != nil || !unarchived {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionUnarchive, lib.AuditEntityOrder, after.ID, before, after)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return after, nil
+}
+
+func (s *Service) DeleteOrder(ctx context.Context, order *lib.Order, conditions *lib.DeleteConditions) error {
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if conditions != nil && conditions.HardDelete {
+			if err := repo.HardDeleteOrder(ctx, order); err != nil {
+				return err
+			}
+
+			return s.Audit(ctx, lib.AuditActionHardDelete, lib.AuditEntityOrder, order.ID, order, nil)
+		}
+
+		if err := repo.SoftDeleteOrder(ctx, order); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionDelete, lib.AuditEntityOrder, order.ID, order, nil)
+	})
 }
 
 func (s *Service) DeleteInquiry(ctx context.Context, inquiry *lib.Inquiry, conditions *lib.DeleteConditions) error {
-	if conditions != nil && conditions.HardDelete {
-		return s.repo.HardDeleteInquiry(ctx, inquiry)
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if conditions != nil && conditions.HardDelete {
+			if err := repo.HardDeleteInquiry(ctx, inquiry); err != nil {
+				return err
+			}
+
+			return s.Audit(ctx, lib.AuditActionHardDelete, lib.AuditEntityInquiry, inquiry.ID, inquiry, nil)
+		}
+
+		if err := repo.SoftDeleteInquiry(ctx, inquiry); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionDelete, lib.AuditEntityInquiry, inquiry.ID, inquiry, nil)
+	})
+}
+
+type accessclaims struct {
+	OrderID   string `json:"order_id,omitempty"`
+	InquiryID string `json:"inquiry_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+//IssueAccessToken issues a signed token that grants a guest read access to the order
+//and/or inquiry of the provided grant
+func (s *Service) IssueAccessToken(ctx context.Context, grant *lib.AccessGrant) (string, error) {
+	if grant == nil || (grant.OrderID == uuid.Nil && grant.InquiryID == uuid.Nil) {
+		return "", errors.ErrInvalidAccessToken
+	}
+
+	now := time.Now()
+
+	claims := accessclaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{accessaudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessexpiry)),
+		},
+	}
+
+	if grant.OrderID != uuid.Nil {
+		claims.OrderID = grant.OrderID.String()
+	}
+
+	if grant.InquiryID != uuid.Nil {
+		claims.InquiryID = grant.InquiryID.String()
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.accesskey())
+}
+
+//VerifyAccessToken verifies a token issued by IssueAccessToken and returns what it grants
+//access to
+func (s *Service) VerifyAccessToken(ctx context.Context, token string) (*lib.AccessGrant, error) {
+	claims := new(accessclaims)
+
+	t, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.accesskey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+	if err != nil || !t.Valid || !claims.VerifyAudience(accessaudience, true) {
+		return nil, errors.ErrInvalidAccessToken
+	}
+
+	grant := new(lib.AccessGrant)
+
+	if claims.OrderID != "" {
+		if grant.OrderID, err = uuid.Parse(claims.OrderID); err != nil {
+			return nil, errors.ErrInvalidAccessToken
+		}
 	}
 
-	return s.repo.SoftDeleteInquiry(ctx, inquiry)
+	if claims.InquiryID != "" {
+		if grant.InquiryID, err = uuid.Parse(claims.InquiryID); err != nil {
+			return nil, errors.ErrInvalidAccessToken
+		}
+	}
+
+	return grant, nil
+}
+
+func (s *Service) accesskey() []byte {
+	return s.JWTEnv.SigningKey(accessaudience)
 }
 
 type repoi interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error
 	UpdateOrder(ctx context.Context, order *lib.Order, conditions *lib.SaveConditions) (*lib.Order, error)
 	GetInquires(ctx context.Context, conditions *lib.GetInquiryConditions) ([]*lib.Inquiry, error)
 	UpdateInquiry(ctx context.Context, inquiry *lib.Inquiry) (*lib.Inquiry, error)
 	CreateInquiry(ctx context.Context, inquiry *lib.Inquiry) (*lib.Inquiry, error)
-	GetOrders(context.Context, *lib.OrderConditions) ([]*lib.Order, error)
+	GetOrders(ctx context.Context, conditions *lib.OrderConditions, sortby lib.OrdersSortBy, after *cursor, limit int) ([]*lib.Order, error)
+	CountOrders(ctx context.Context, conditions *lib.OrderConditions) (int64, error)
 	CreateOrder(ctx context.Context, order *lib.Order) (*lib.Order, error)
 	GetInquiry(ctx context.Context, id uuid.UUID) (*lib.Inquiry, error)
 	GetOrder(ctx context.Context, id uuid.UUID) (*lib.Order, error)
@@ -117,55 +432,93 @@ type repoi interface {
 	SoftDeleteOrder(ctx context.Context, order *lib.Order) error
 	HardDeleteInquiry(ctx context.Context, inquiry *lib.Inquiry) error
 	SoftDeleteInquiry(ctx context.Context, inquiry *lib.Inquiry) error
+	ArchiveOrder(ctx context.Context, order *lib.Order, at time.Time) (bool, error)
+	UnarchiveOrder(ctx context.Context, order *lib.Order) (bool, error)
 }
 
 type repo struct {
 	*gorm.DB
 }
 
+//Transaction runs the provided function with a repo that is bound to a single transaction,
+//the transaction is rolled back if the function returns an error. The context the function is
+//given is bound to the transaction as well, so the mutations are audited within it.
+func (r *repo) Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(lib.WithAuditTx(ctx, tx), &repo{tx})
+	})
+}
+
 func (r *repo) GetInquiry(ctx context.Context, id uuid.UUID) (inquiry *lib.Inquiry, err error) {
 	inquiry = new(lib.Inquiry)
-	r.DB.Model(new(lib.Inquiry)).First(inquiry, "id = ?", id)
+
+	err = r.DB.Model(new(lib.Inquiry)).First(inquiry, "id = ?", id).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrInquiryNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
 	return
 }
 
-func (r *repo) CreateOrder(ctx context.Context, order *lib.Order) (result *lib.Order, err error) {
-
-	result = new(lib.Order)
+func (r *repo) CreateOrder(ctx context.Context, order *lib.Order) (*lib.Order, error) {
 
-	if err = r.DB.Save(order).Error; err != nil {
+	if err := r.DB.Save(order).Error; err != nil {
 		return nil, err
 	}
 
-	if result, err = r.LoadOrderTotal(ctx, order); err != nil {
+	if err := r.LoadOrderTotal(ctx, order); err != nil {
 		return nil, err
 	}
 
-	return
+	return order, nil
 }
 
 func (r *repo) UpdateOrder(ctx context.Context, order *lib.Order, conditions *lib.SaveConditions) (*lib.Order, error) {
 
 	err := r.DB.Transaction(func(db *gorm.DB) error {
+		current := new(lib.Order)
+
+		if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "status").
+			First(current, "id = ?", order.ID).Error; err != nil {
+			return err
+		}
+
+		//a status the api can't represent (see convertOrderStatus) leaves the status
+		//of the order as it is, rather than overwriting it with NOT_IMPLEMENTED
+		if order.Status == lib.OrderStatusNotImplemented {
+			order.Status = current.Status
+		}
+
+		if !conditions.Root {
+			if err := transition(current, order.Status); err != nil {
+				return err
+			}
+		}
+
 		if err := db.Model(new(lib.Order)).
 			Where("id = ?", order.ID).
 			Update("payment_method", order.PaymentMethod).
 			Update("due", order.Due).Error; err != nil {
-			db.Rollback()
 			return err
 		}
 
-		if order.Status != lib.OrderStatusAccepted || conditions.Root {
+		if order.Status != current.Status {
+			if err := settle(ctx, db, order.ID, order.Status); err != nil {
+				return err
+			}
+
 			if err := db.Model(new(lib.Order)).
 				Where("id = ?", order.ID).
 				Update("status", order.Status).
 				Error; err != nil {
-
-				db.Rollback()
 				return err
-
 			}
-
 		}
 
 		if conditions.Root && order.ExtID != "" {
@@ -187,48 +540,144 @@ func (r *repo) UpdateOrder(ctx context.Context, order *lib.Order, conditions *li
 	return order, err
 }
 
-func (r *repo) GetOrders(ctx context.Context, conditions *lib.OrderConditions) ([]*lib.Order, error) {
-
-	var result []*lib.Order
+//transitions are the statuses anyone but root may move an open order between, customers
+//can call an order off but accepting and completing it is up to staff. An order that is
+//done with can't be reopened by them.
+var transitions = map[lib.OrderStatus]map[lib.OrderStatus]bool{
+	lib.OrderStatusNotImplemented: {
+		lib.OrderStatusUserPending:  true,
+		lib.OrderStatusAdminPending: true,
+		lib.OrderStatusCancelled:    true,
+	},
+	lib.OrderStatusUserPending: {
+		lib.OrderStatusAdminPending: true,
+		lib.OrderStatusCancelled:    true,
+	},
+	lib.OrderStatusAdminPending: {
+		lib.OrderStatusUserPending: true,
+		lib.OrderStatusCancelled:   true,
+	},
+}
 
-	tx := r.DB
+//transition returns an error when anyone but root moves the order to the provided status
+func transition(order *lib.Order, status lib.OrderStatus) error {
+	if order.Status == status {
+		return nil
+	}
 
-	if conditions != nil {
-		if conditions.Status != lib.OrderStatusNotImplemented {
-			if err := tx.Model(new(lib.Order)).
-				Preload("Inquiry").
-				Preload("Cart").
-				Where("status = ?", conditions.Status).
-				Find(&result).Error; err != nil {
-				return nil, err
-			}
+	allowed, open := transitions[order.Status]
+	if !open {
+		return &errors.ErrOrderStatusTransition{
+			OrderID: order.ID,
+			From:    string(order.Status),
+			To:      string(status),
 		}
 	}
 
-	if conditions == nil {
-		if err := r.DB.Preload("Inquiry").Preload("Cart").Find(&result).Error; err != nil {
-			return nil, err
+	if !allowed[status] {
+		return &errors.ErrOrderStatusNotAllowed{
+			Status: string(status),
 		}
 	}
 
-	for i, order := range result {
-		order, err := r.LoadOrderTotal(ctx, order)
-		if err != nil {
-			return nil, err
+	return nil
+}
+
+//settle settles the stock that was reserved for the order once it is completed or cancelled,
+//a completed order sells it while a cancelled one puts it back in stock. It must be called
+//within a transaction.
+func settle(ctx context.Context, db *gorm.DB, id uuid.UUID, status lib.OrderStatus) error {
+	if status != lib.OrderStatusCompleted && status != lib.OrderStatusCancelled {
+		return nil
+	}
+
+	order := new(lib.Order)
+
+	err := db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Cart").
+		First(order, "id = ? AND stock_reserved = ?", id, true).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, line := range order.Cart {
+		quantity := int(line.Quantity)
+
+		release := lib.NewInventoryMovement(ctx, lib.InventoryMovementRelease, line.ProductID, line.VariantID, quantity, "order "+strings.ToLower(string(status)))
+		if err := db.Create(release.ForOrder(id)).Error; err != nil {
+			return err
+		}
+
+		if status == lib.OrderStatusCompleted {
+			sale := lib.NewInventoryMovement(ctx, lib.InventoryMovementSale, line.ProductID, line.VariantID, -quantity, "order completed")
+			if err := db.Create(sale.ForOrder(id)).Error; err != nil {
+				return err
+			}
 		}
-		result[i] = order
 	}
 
-	if tx.Error != nil {
-		return nil, tx.Error
+	return db.Model(new(lib.Order)).
+		Where("id = ?", id).
+		Update("stock_reserved", false).Error
+}
+
+func (r *repo) GetOrders(ctx context.Context, conditions *lib.OrderConditions, sortby lib.OrdersSortBy, after *cursor, limit int) ([]*lib.Order, error) {
+	result := make([]*lib.Order, 0)
+
+	tx := filter(r.DB.WithContext(ctx).Model(new(lib.Order)), conditions)
+
+	sort := sorts[sortby]
+	direction, comparison := "ASC", ">"
+	if sort.descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if after != nil {
+		tx = tx.Where(fmt.Sprintf("(orders.%[1]s %[2]s ? OR (orders.%[1]s = ? AND orders.id %[2]s ?))", sort.column, comparison), after.At, after.At, after.ID)
+	}
+
+	err := tx.Preload("Inquiry").
+		Preload("Cart").
+		Preload("Cart.Product", unscoped).
+		Preload("Cart.Variant", unscoped).
+		Order(fmt.Sprintf("orders.%s %s, orders.id %s", sort.column, direction, direction)).
+		Limit(limit).
+		Find(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.LoadOrderTotal(ctx, result...); err != nil {
+		return nil, err
 	}
 
 	return result, nil
 }
 
+func (r *repo) CountOrders(ctx context.Context, conditions *lib.OrderConditions) (total int64, err error) {
+	err = filter(r.DB.WithContext(ctx).Model(new(lib.Order)), conditions).Count(&total).Error
+	return
+}
+
 func (r *repo) GetOrder(ctx context.Context, id uuid.UUID) (order *lib.Order, err error) {
-	r.DB.Preload("Inquiry").Preload("Cart").Model(new(lib.Order)).First(&order, "id = ?", id)
-	order, err = r.LoadOrderTotal(ctx, order)
+	order = new(lib.Order)
+
+	err = r.DB.Preload("Inquiry").Preload("Cart").Preload("Cart.Product", unscoped).Preload("Cart.Variant", unscoped).Model(new(lib.Order)).First(order, "id = ?", id).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrOrderNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.LoadOrderTotal(ctx, order)
 	return
 }
 
@@ -254,14 +703,16 @@ func (r *repo) GetInquires(ctx context.Context, conditions *lib.GetInquiryCondit
 
 func (r *repo) CreateInquiry(ctx context.Context, inquiry *lib.Inquiry) (*lib.Inquiry, error) {
 
-	err := r.DB.Save(inquiry).Error
+	//the owner of an inquiry is only ever set when it is created
+	err := r.DB.Omit("user_id").Save(inquiry).Error
 
 	return inquiry, err
 }
 
 func (r *repo) UpdateInquiry(ctx context.Context, inquiry *lib.Inquiry) (*lib.Inquiry, error) {
 
-	err := r.DB.Save(inquiry).Error
+	//the owner of an inquiry is only ever set when it is created
+	err := r.DB.Omit("user_id").Save(inquiry).Error
 
 	return inquiry, err
 }
@@ -282,12 +733,100 @@ func (r *repo) SoftDeleteInquiry(ctx context.Context, inquiry *lib.Inquiry) erro
 	return r.DB.Delete(inquiry).Error
 }
 
-func (r *repo) LoadOrderTotal(ctx context.Context, order *lib.Order) (*lib.Order, error) {
+//ArchiveOrder archives the order as long as it still has the status it had when it was
+//checked, it reports whether it did
+func (r *repo) ArchiveOrder(ctx context.Context, order *lib.Order, at time.Time) (bool, error) {
+	result := r.DB.WithContext(ctx).Model(new(lib.Order)).
+		Where("id = ? AND status = ? AND archived_at IS NULL", order.ID, order.Status).
+		Update("archived_at", at)
 
-	for _, cart := range order.Cart {
-		r.DB.Model(new(lib.Product)).First(&cart.Product, "id = ?", cart.ProductID)
-		order.Total += float32(cart.Quantity) * cart.Product.Cost
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *repo) UnarchiveOrder(ctx context.Context, order *lib.Order) (bool, error) {
+	result := r.DB.WithContext(ctx).Model(new(lib.Order)).
+		Where("id = ? AND archived_at IS NOT NULL", order.ID).
+		Update("archived_at", nil)
+
+	return result.RowsAffected == 1, result.Error
+}
+
+//unscoped preloads the products and variants of an order even once they have been moved to
+//the trash, the order was placed for them regardless
+func unscoped(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
+
+//LoadOrderTotal computes the total of the orders from the products (and variants) in their
+//carts. The products and variants that weren't preloaded are read in a single query each
+//however many orders there are, products and variants in the trash still count towards the
+//total, lines of products that have been purged don't and lines of variants that have been
+//purged cost what their product does.
+func (r *repo) LoadOrderTotal(ctx context.Context, orders ...*lib.Order) error {
+	missing := make([]uuid.UUID, 0)
+	variants := make([]uuid.UUID, 0)
+
+	for _, order := range orders {
+		for _, cart := range order.Cart {
+			if cart.Product == nil {
+				missing = append(missing, cart.ProductID)
+			}
+
+			if cart.VariantID != nil && cart.Variant == nil {
+				variants = append(variants, *cart.VariantID)
+			}
+		}
 	}
 
-	return order, nil
+	if len(variants) > 0 {
+		found := make([]*lib.ProductVariant, 0)
+		if err := r.DB.WithContext(ctx).Unscoped().Find(&found, "id IN ?", variants).Error; err != nil {
+			return err
+		}
+
+		loaded := make(map[uuid.UUID]*lib.ProductVariant, len(found))
+		for _, variant := range found {
+			loaded[variant.ID] = variant
+		}
+
+		for _, order := range orders {
+			for _, cart := range order.Cart {
+				if cart.VariantID != nil && cart.Variant == nil {
+					cart.Variant = loaded[*cart.VariantID]
+				}
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		products := make([]*lib.Product, 0)
+		if err := r.DB.WithContext(ctx).Unscoped().Find(&products, "id IN ?", missing).Error; err != nil {
+			return err
+		}
+
+		loaded := make(map[uuid.UUID]*lib.Product, len(products))
+		for _, product := range products {
+			loaded[product.ID] = product
+		}
+
+		for _, order := range orders {
+			for _, cart := range order.Cart {
+				if cart.Product == nil {
+					cart.Product = loaded[cart.ProductID]
+				}
+			}
+		}
+	}
+
+	for _, order := range orders {
+		order.Total = 0
+
+		for _, cart := range order.Cart {
+			if cart.Product != nil {
+				order.Total += float32(cart.Quantity) * cart.UnitCost()
+			}
+		}
+	}
+
+	return nil
 }
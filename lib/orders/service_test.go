@@ -3,17 +3,26 @@ package orders_test
 import (
 	"context"
 	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	commons "github.com/cryptnode-software/commons/pkg"
 	"github.com/cryptnode-software/pisces/lib"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
 	"github.com/cryptnode-software/pisces/lib/orders"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
 )
 
 var (
-	service, err = orders.NewService(lib.NewEnv(commons.NewLogger(commons.EnvDev)))
+	env = lib.NewEnv(commons.NewLogger(commons.EnvDev))
+
+	service, err = orders.NewService(env)
 
 	inquiry = &lib.Inquiry{
 		Description: "some test description",
@@ -276,6 +285,81 @@ func TestGetOrder(t *testing.T) {
 	}
 }
 
+func TestGetMissingOrder(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err := service.GetOrder(ctx, uuid.New())
+	assert.Equal(t, perrors.ErrOrderNotFound, err)
+
+	_, err = service.GetInquiry(ctx, uuid.New())
+	assert.Equal(t, perrors.ErrInquiryNotFound, err)
+}
+
+func TestAccessToken(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	grant := &lib.AccessGrant{
+		OrderID:   uuid.New(),
+		InquiryID: uuid.New(),
+	}
+
+	token, err := service.IssueAccessToken(ctx, grant)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//a login token is signed with the jwt secret itself, it must not pass
+	//as an access token
+	login, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Audience:  jwt.ClaimStrings{"order-access"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tables := []struct {
+		token    string
+		expected *lib.AccessGrant
+		err      error
+	}{
+		{
+			token:    token,
+			expected: grant,
+		},
+		{
+			token: token + "tampered",
+			err:   perrors.ErrInvalidAccessToken,
+		},
+		{
+			token: login,
+			err:   perrors.ErrInvalidAccessToken,
+		},
+		{
+			token: "",
+			err:   perrors.ErrInvalidAccessToken,
+		},
+	}
+
+	for _, table := range tables {
+		grant, err := service.VerifyAccessToken(ctx, table.token)
+		assert.Equal(t, table.err, err)
+		assert.Equal(t, table.expected, grant)
+	}
+
+	if _, err := service.IssueAccessToken(ctx, new(lib.AccessGrant)); err != perrors.ErrInvalidAccessToken {
+		t.Errorf("expected %v for an empty grant, got %v", perrors.ErrInvalidAccessToken, err)
+	}
+}
+
 func TestGetOrders(t *testing.T) {
 	if err != nil {
 		t.Error(err)
@@ -336,9 +420,9 @@ func TestGetOrders(t *testing.T) {
 			return
 		}
 
-		for i, o := range orders {
-			orders[i].Due = o.Due
-			assert.Equal(t, orders[i], o)
+		for i, o := range orders.Orders {
+			orders.Orders[i].Due = o.Due
+			assert.Equal(t, orders.Orders[i], o)
 		}
 
 		if err := deseed(table.expected); err != nil {
@@ -359,6 +443,443 @@ func TestGetOrders(t *testing.T) {
 
 }
 
+func TestGetOrdersConditions(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product := &lib.Product{
+		Name: "A dozen cookies",
+		Cost: 12,
+	}
+
+	if err := env.GormDB.Create(product).Error; err != nil {
+		t.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	//the customer is unique to this test so other orders don't get in the way
+	customer := uuid.New().String()
+	now := time.Now().Truncate(time.Second)
+
+	neworder := func(method lib.PaymentMethod, due time.Duration, quantity int64) *lib.Order {
+		order := &lib.Order{
+			PaymentMethod: method,
+			Status:        lib.OrderStatusAdminPending,
+			Due:           now.Add(due),
+			Inquiry: &lib.Inquiry{
+				Description: "Magna ipsum culpa labore pariatur elit commodo consequat esse est.",
+				Email:       "test.user@test.io",
+				FirstName:   "test",
+				LastName:    customer,
+			},
+		}
+
+		if quantity > 0 {
+			order.Cart = []*lib.Cart{
+				{
+					ProductID: product.ID,
+					Quantity:  quantity,
+				},
+			}
+		}
+
+		return order
+	}
+
+	models := []*lib.Order{
+		neworder(lib.PaymentMethodPaypal, 72*time.Hour, 1),
+		neworder(lib.PaymentMethodNotImplemented, 24*time.Hour, 0),
+		neworder(lib.PaymentMethodPaypal, 48*time.Hour, 3),
+	}
+
+	if err := seed(models); err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		for _, order := range models {
+			env.GormDB.Unscoped().Where("order_id = ?", order.ID).Delete(new(lib.Cart))
+		}
+		deseed(models)
+	}()
+
+	ids := func(orders []*lib.Order) (result []uuid.UUID) {
+		for _, order := range orders {
+			result = append(result, order.ID)
+		}
+		return
+	}
+
+	low, high := float32(30), float32(36)
+	from, to := now.Add(36*time.Hour), now.Add(60*time.Hour)
+
+	tables := []struct {
+		conditions *lib.OrderConditions
+		expected   []uuid.UUID
+	}{
+		{
+			conditions: &lib.OrderConditions{
+				SortBy: lib.OrderSortByDueAscending,
+			},
+			expected: []uuid.UUID{models[1].ID, models[2].ID, models[0].ID},
+		},
+		{
+			conditions: &lib.OrderConditions{
+				SortBy: lib.OrdersSortByDueDescending,
+			},
+			expected: []uuid.UUID{models[0].ID, models[2].ID, models[1].ID},
+		},
+		{
+			conditions: &lib.OrderConditions{
+				SortBy:        lib.OrderSortByDueAscending,
+				PaymentMethod: lib.PaymentMethodPaypal,
+			},
+			expected: []uuid.UUID{models[2].ID, models[0].ID},
+		},
+		{
+			conditions: &lib.OrderConditions{
+				ProductID: &product.ID,
+				MinTotal:  &low,
+				MaxTotal:  &high,
+			},
+			expected: []uuid.UUID{models[2].ID},
+		},
+		{
+			conditions: &lib.OrderConditions{
+				DueFrom: &from,
+				DueTo:   &to,
+			},
+			expected: []uuid.UUID{models[2].ID},
+		},
+		{
+			conditions: &lib.OrderConditions{
+				Status: lib.OrderStatusAccepted,
+			},
+		},
+	}
+
+	for _, table := range tables {
+		table.conditions.Customer = "test " + customer
+
+		page, err := service.GetOrders(ctx, table.conditions)
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		assert.Equal(t, table.expected, ids(page.Orders))
+		assert.Equal(t, int64(len(table.expected)), page.Total)
+		assert.Equal(t, "", page.NextCursor)
+	}
+
+	//the pages pick up where the previous one left off
+	conditions := &lib.OrderConditions{
+		Customer: customer,
+		SortBy:   lib.OrderSortByDueAscending,
+		Limit:    2,
+	}
+
+	first, err := service.GetOrders(ctx, conditions)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []uuid.UUID{models[1].ID, models[2].ID}, ids(first.Orders))
+		assert.Equal(t, int64(3), first.Total)
+		assert.NotEqual(t, "", first.NextCursor)
+
+		conditions.Cursor = first.NextCursor
+
+		second, err := service.GetOrders(ctx, conditions)
+		if assert.Nil(t, err) {
+			assert.Equal(t, []uuid.UUID{models[0].ID}, ids(second.Orders))
+			assert.Equal(t, int64(3), second.Total)
+			assert.Equal(t, "", second.NextCursor)
+		}
+
+		//a cursor only works with the sort it was returned for
+		conditions.SortBy = lib.OrdersSortByDateAscending
+		_, err = service.GetOrders(ctx, conditions)
+		assert.Equal(t, perrors.ErrInvalidOrderCursor, err)
+	}
+
+	_, err = service.GetOrders(ctx, &lib.OrderConditions{
+		Cursor: "not a cursor",
+	})
+	assert.Equal(t, perrors.ErrInvalidOrderCursor, err)
+
+	_, err = service.GetOrders(ctx, &lib.OrderConditions{
+		SortBy: "name; DROP TABLE orders",
+	})
+	assert.Equal(t, &perrors.ErrInvalidOrderPage{
+		SortBy: "name; DROP TABLE orders",
+	}, err)
+
+	//streaming reads every order, however small the pages are
+	var streamed []uuid.UUID
+	err = service.StreamOrders(ctx, &lib.OrderConditions{
+		Customer: customer,
+		SortBy:   lib.OrdersSortByDueDescending,
+		Limit:    1,
+	}, func(order *lib.Order) error {
+		streamed = append(streamed, order.ID)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []uuid.UUID{models[0].ID, models[2].ID, models[1].ID}, streamed)
+
+	//moving the product to the trash doesn't change what was ordered
+	if err := env.GormDB.Delete(product).Error; !assert.Nil(t, err) {
+		return
+	}
+
+	page, err := service.GetOrders(ctx, &lib.OrderConditions{
+		Customer: customer,
+		MinTotal: &low,
+		MaxTotal: &high,
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []uuid.UUID{models[2].ID}, ids(page.Orders))
+	}
+
+	order, err := service.GetOrder(ctx, models[2].ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, float32(36), order.Total)
+	}
+}
+
+func TestArchiveOrder(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	newinquiry := func() *lib.Inquiry {
+		return &lib.Inquiry{
+			Description: "Magna ipsum culpa labore pariatur elit commodo consequat esse est.",
+			Email:       "test.user@test.io",
+		}
+	}
+
+	completed := &lib.Order{
+		PaymentMethod: lib.PaymentMethodNotImplemented,
+		Status:        lib.OrderStatusCompleted,
+		Due:           time.Now().Add(24 * time.Hour),
+		Inquiry:       newinquiry(),
+	}
+
+	pending := &lib.Order{
+		PaymentMethod: lib.PaymentMethodNotImplemented,
+		Status:        lib.OrderStatusUserPending,
+		Due:           time.Now().Add(24 * time.Hour),
+		Inquiry:       newinquiry(),
+	}
+
+	models := []*lib.Order{completed, pending}
+	if err := seed(models); err != nil {
+		t.Error(err)
+		return
+	}
+	defer deseed(models)
+
+	//only completed or cancelled orders can be archived
+	_, err := service.ArchiveOrder(ctx, pending)
+	assert.Equal(t, &perrors.ErrOrderNotArchivable{
+		OrderID: pending.ID,
+		Status:  string(lib.OrderStatusUserPending),
+	}, err)
+
+	archived, err := service.ArchiveOrder(ctx, completed)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.NotNil(t, archived.ArchivedAt)
+
+	contains := func(conditions *lib.OrderConditions, order *lib.Order) (found bool) {
+		err := service.StreamOrders(ctx, conditions, func(o *lib.Order) error {
+			found = found || o.ID == order.ID
+			return nil
+		})
+		assert.Nil(t, err)
+		return
+	}
+
+	//archived orders are only listed when they are asked for
+	assert.False(t, contains(&lib.OrderConditions{
+		Status: lib.OrderStatusCompleted,
+	}, completed))
+
+	assert.True(t, contains(&lib.OrderConditions{
+		Status:   lib.OrderStatusCompleted,
+		Archived: true,
+	}, completed))
+
+	//but they can still be looked up on their own
+	order, err := service.GetOrder(ctx, completed.ID)
+	if assert.Nil(t, err) {
+		assert.NotNil(t, order.ArchivedAt)
+	}
+
+	unarchived, err := service.UnarchiveOrder(ctx, completed)
+	if assert.Nil(t, err) {
+		assert.Nil(t, unarchived.ArchivedAt)
+	}
+
+	assert.True(t, contains(&lib.OrderConditions{
+		Status: lib.OrderStatusCompleted,
+	}, completed))
+}
+
+func TestSaveOrderStatus(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	completed := &lib.Order{
+		PaymentMethod: lib.PaymentMethodNotImplemented,
+		Status:        lib.OrderStatusCompleted,
+		Due:           time.Now().Add(24 * time.Hour),
+		Inquiry: &lib.Inquiry{
+			Description: "Magna ipsum culpa labore pariatur elit commodo consequat esse est.",
+			Email:       "test.user@test.io",
+		},
+	}
+
+	if err := seed([]*lib.Order{completed}); err != nil {
+		t.Error(err)
+		return
+	}
+	defer deseed([]*lib.Order{completed})
+
+	//an order loaded through the api and saved back without a status it can
+	//represent keeps the status it had
+	saved := *completed
+	saved.Status = lib.OrderStatusNotImplemented
+
+	result, err := service.SaveOrder(ctx, &saved, &lib.SaveConditions{Root: true})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, lib.OrderStatusCompleted, result.Status)
+
+	order, err := service.GetOrder(ctx, completed.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, lib.OrderStatusCompleted, order.Status)
+	}
+
+	//an order that is done with can't be reopened by anyone but root
+	for _, status := range []lib.OrderStatus{lib.OrderStatusUserPending, lib.OrderStatusAdminPending} {
+		saved.Status = status
+
+		_, err := service.SaveOrder(ctx, &saved, nil)
+		assert.IsType(t, &perrors.ErrOrderStatusTransition{}, err)
+	}
+
+	order, err = service.GetOrder(ctx, completed.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, lib.OrderStatusCompleted, order.Status)
+	}
+}
+
+//BenchmarkGetOrders reads pages of a growing number of orders, the number of queries it
+//takes to read a page mustn't grow along with them
+func BenchmarkGetOrders(b *testing.B) {
+	if err != nil {
+		b.Error(err)
+		return
+	}
+
+	product := &lib.Product{
+		Name: "A dozen cookies",
+		Cost: 12,
+	}
+
+	if err := env.GormDB.Create(product).Error; err != nil {
+		b.Error(err)
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	customer := uuid.New().String()
+	sizes := []int{1, 10, 100}
+
+	models := make([]*lib.Order, sizes[len(sizes)-1])
+	for i := range models {
+		models[i] = &lib.Order{
+			PaymentMethod: lib.PaymentMethodNotImplemented,
+			Status:        lib.OrderStatusAdminPending,
+			Due:           time.Now().Add(24 * time.Hour),
+			Inquiry: &lib.Inquiry{
+				Description: "Magna ipsum culpa labore pariatur elit commodo consequat esse est.",
+				Email:       "test.user@test.io",
+				LastName:    customer,
+			},
+			Cart: []*lib.Cart{
+				{ProductID: product.ID, Quantity: 1},
+				{ProductID: product.ID, Quantity: 2},
+			},
+		}
+	}
+
+	if err := seed(models); err != nil {
+		b.Error(err)
+		return
+	}
+	defer func() {
+		for _, order := range models {
+			env.GormDB.Unscoped().Where("order_id = ?", order.ID).Delete(new(lib.Cart))
+		}
+		deseed(models)
+	}()
+
+	var queries, counting int64
+
+	err := env.GormDB.Callback().Query().After("gorm:query").Register("orders:count_queries", func(db *gorm.DB) {
+		if atomic.LoadInt64(&counting) == 1 {
+			atomic.AddInt64(&queries, 1)
+		}
+	})
+	if err != nil {
+		b.Error(err)
+		return
+	}
+	defer env.GormDB.Callback().Query().Remove("orders:count_queries")
+
+	perpage := make(map[int]int64)
+
+	for _, size := range sizes {
+		b.Run(fmt.Sprintf("%d orders", size), func(b *testing.B) {
+			atomic.StoreInt64(&queries, 0)
+			atomic.StoreInt64(&counting, 1)
+
+			for i := 0; i < b.N; i++ {
+				page, err := service.GetOrders(ctx, &lib.OrderConditions{
+					Customer: customer,
+					Limit:    size,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				if len(page.Orders) != size || page.Orders[0].Total != 36 {
+					b.Fatalf("expected %d orders with a total of 36, got %d", size, len(page.Orders))
+				}
+			}
+
+			atomic.StoreInt64(&counting, 0)
+
+			perpage[size] = atomic.LoadInt64(&queries) / int64(b.N)
+			b.ReportMetric(float64(perpage[size]), "queries/op")
+		})
+	}
+
+	for _, size := range sizes[1:] {
+		if perpage[size] != perpage[sizes[0]] {
+			b.Errorf("a page of %d orders took %d queries while a page of %d took %d", size, perpage[size], sizes[0], perpage[sizes[0]])
+		}
+	}
+}
+
 func seed[T *lib.Order | *lib.Inquiry](models []T) error {
 	for _, model := range models {
 		switch model := any(model).(type) {
@@ -6,6 +6,8 @@ import "context"
 type PaypalService interface {
 	GenerateClientToken(context.Context) (*GenerateClientTokenResponse, error)
 	CreateOrder(context.Context, *Order) (*Order, error)
+	Captured(context.Context, *Order) (bool, error)
+	VoidOrder(context.Context, *Order) error
 }
 
 //GenerateClientTokenResponse ...
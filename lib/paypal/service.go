@@ -10,6 +10,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/cryptnode-software/pisces/lib"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
 	"github.com/plutov/paypal"
 )
 
@@ -73,6 +74,102 @@ func (service *Service) CreateOrder(ctx context.Context, order *lib.Order) (*lib
 	return order, nil
 }
 
+//payments the payments of a paypal order, the version of the paypal client we use doesn't
+//expose them so the order is read through the v2 api directly
+type payments struct {
+	PurchaseUnits []struct {
+		Payments struct {
+			Authorizations []struct {
+				ID     string `json:"id"`
+				Status string `json:"status"`
+			} `json:"authorizations"`
+			Captures []struct {
+				ID     string `json:"id"`
+				Status string `json:"status"`
+			} `json:"captures"`
+		} `json:"payments"`
+	} `json:"purchase_units"`
+}
+
+//Captured reports whether the payment of the paypal order of the provided order has been
+//captured, an order the buyer never approved hasn't been
+func (service *Service) Captured(ctx context.Context, order *lib.Order) (bool, error) {
+	if order.ExtID == "" {
+		return false, nil
+	}
+
+	porder, err := service.payments(ctx, order)
+	if err != nil {
+		return false, err
+	}
+
+	return porder.captured(), nil
+}
+
+//VoidOrder voids every authorization of the paypal order of the provided order, so the funds
+//held for it are released. An order the buyer never approved holds nothing and is dropped by
+//paypal on its own. Orders whose payment has already been captured can't be voided.
+func (service *Service) VoidOrder(ctx context.Context, order *lib.Order) error {
+	if order.ExtID == "" {
+		return nil
+	}
+
+	porder, err := service.payments(ctx, order)
+	if err != nil {
+		return err
+	}
+
+	if porder.captured() {
+		return &perrors.ErrPaymentCaptured{
+			OrderID: order.ID,
+		}
+	}
+
+	for _, unit := range porder.PurchaseUnits {
+		for _, authorization := range unit.Payments.Authorizations {
+			if authorization.Status != "CREATED" && authorization.Status != "PENDING" {
+				continue
+			}
+
+			req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/v2/payments/authorizations/%s/void", service.client.APIBase, authorization.ID), nil)
+			if err != nil {
+				return err
+			}
+
+			if err := service.client.SendWithAuth(req, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (service *Service) payments(ctx context.Context, order *lib.Order) (*payments, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v2/checkout/orders/%s", service.client.APIBase, order.ExtID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	porder := new(payments)
+	if err := service.client.SendWithAuth(req, porder); err != nil {
+		return nil, err
+	}
+
+	return porder, nil
+}
+
+func (porder *payments) captured() bool {
+	for _, unit := range porder.PurchaseUnits {
+		for _, capture := range unit.Payments.Captures {
+			if capture.Status == "COMPLETED" || capture.Status == "PENDING" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 //GenerateClientToken generates a client token for frontend rendering
 func (service *Service) GenerateClientToken(ctx context.Context) (*lib.GenerateClientTokenResponse, error) {
 	buf := bytes.NewBuffer([]byte{})
@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"google.golang.org/grpc"
+)
+
+type userctx struct{}
+
+// Access the primitive type for who is allowed to reach a route
+type Access string
+
+const (
+	//AccessPublic routes can be reached without any credentials, when a valid
+	//JWT is provided the user is still made available to the handler.
+	AccessPublic Access = "PUBLIC"
+	//AccessUser routes require a valid user JWT
+	AccessUser Access = "USER"
+	//AccessAdmin routes require a valid JWT of an admin, checked against the
+	//database and not only the token
+	AccessAdmin Access = "ADMIN"
+)
+
+// Policy defines who can reach a route. Permission is the permission an api key must hold
+// to reach the route, routes without one can't be reached with an api key at all.
+type Policy struct {
+	Access     Access
+	Permission Permission
+	//Idempotent routes honor the `idempotency-key` header, see IdempotencyInterceptor
+	Idempotent bool
+}
+
+// method returns the full grpc method name of a Pisces rpc
+func method(name string) string {
+	return "/pisces.Pisces/" + name
+}
+
+// policies is the single place every rpc of the gateway declares who can reach it. Any rpc
+// that isn't registered here is denied, and the server refuses to start with one missing
+// (see CheckPolicies).
+var policies = map[string]Policy{
+	method("Login"): {
+		Access: AccessPublic,
+	},
+	method("CheckJWT"): {
+		Access: AccessPublic,
+	},
+	method("GeneratePaypalClientToken"): {
+		Access: AccessPublic,
+	},
+	//guests place orders and inquiries, the handlers only return them to
+	//their owner, staff or a guest holding their access token
+	method("SaveInquiry"): {
+		Access:     AccessPublic,
+		Permission: PermissionWriteInquiries,
+		Idempotent: true,
+	},
+	method("GetInquires"): {
+		Access:     AccessPublic,
+		Permission: PermissionReadInquiries,
+	},
+	method("SaveOrder"): {
+		Access:     AccessPublic,
+		Permission: PermissionWriteOrders,
+		Idempotent: true,
+	},
+	method("GetOrders"): {
+		Access:     AccessPublic,
+		Permission: PermissionReadOrders,
+	},
+	method("SaveCart"): {
+		Access:     AccessPublic,
+		Permission: PermissionWriteCarts,
+		Idempotent: true,
+	},
+	method("StartUpload"): {
+		Access:     AccessPublic,
+		Permission: PermissionWriteUploads,
+	},
+	method("GetProducts"): {
+		Access:     AccessPublic,
+		Permission: PermissionReadProducts,
+	},
+	method("SaveProduct"): {
+		Access:     AccessAdmin,
+		Permission: PermissionWriteProducts,
+		Idempotent: true,
+	},
+	//the standard grpc health service, probes and load balancers call it without
+	//any credentials
+	"/grpc.health.v1.Health/Check": {
+		Access: AccessPublic,
+	},
+	"/grpc.health.v1.Health/Watch": {
+		Access: AccessPublic,
+	},
+}
+
+// GetPolicy returns the policy registered for the provided full grpc method name
+func GetPolicy(method string) (Policy, bool) {
+	policy, ok := policies[method]
+	return policy, ok
+}
+
+// CheckPolicies makes sure every method of the provided services (typically the result of
+// `grpc.Server.GetServiceInfo`) has a policy registered, and that there is no policy left
+// for a method that doesn't exist anymore.
+func CheckPolicies(services map[string]grpc.ServiceInfo) error {
+	missing := []string{}
+	known := map[string]bool{}
+
+	for name, info := range services {
+		for _, m := range info.Methods {
+			full := fmt.Sprintf("/%s/%s", name, m.Name)
+			known[full] = true
+
+			if _, ok := policies[full]; !ok {
+				missing = append(missing, full)
+			}
+		}
+	}
+
+	stale := []string{}
+
+	for full := range policies {
+		if !known[full] {
+			stale = append(stale, full)
+		}
+	}
+
+	if len(missing) > 0 || len(stale) > 0 {
+		sort.Strings(missing)
+		sort.Strings(stale)
+
+		return &errors.ErrInvalidPolicies{
+			Missing: missing,
+			Stale:   stale,
+		}
+	}
+
+	return nil
+}
+
+// WithUser stores the authenticated user on the context
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userctx{}, user)
+}
+
+// UserFromContext returns the user that was authenticated by the AuthorizeInterceptor
+func UserFromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(userctx{}).(*User)
+	return user, ok && user != nil
+}
@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	proto "go.buf.build/grpc/go/thenewlebowski/pisces/general/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestCheckPolicies(t *testing.T) {
+	//every rpc of the generated server interface must have a policy
+	server := reflect.TypeOf((*proto.PiscesServer)(nil)).Elem()
+	for i := 0; i < server.NumMethod(); i++ {
+		m := server.Method(i)
+		if m.PkgPath != "" {
+			continue
+		}
+
+		_, ok := GetPolicy(method(m.Name))
+		assert.True(t, ok, "no policy registered for %s", m.Name)
+	}
+
+	s := grpc.NewServer()
+	proto.RegisterPiscesServer(s, new(Gateway))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+
+	assert.Nil(t, CheckPolicies(s.GetServiceInfo()))
+
+	err := CheckPolicies(map[string]grpc.ServiceInfo{
+		"pisces.Pisces": {
+			Methods: []grpc.MethodInfo{
+				{Name: "Login"},
+				{Name: "DropEverything"},
+			},
+		},
+	})
+
+	invalid, ok := err.(*errors.ErrInvalidPolicies)
+	if assert.True(t, ok) {
+		assert.Equal(t, []string{"/pisces.Pisces/DropEverything"}, invalid.Missing)
+		assert.Contains(t, invalid.Stale, "/pisces.Pisces/SaveProduct")
+	}
+}
+
+//authservice authenticates the tokens "user", "other" and "admin", "forged" is a
+//token that claims to be an admin that the database doesn't agree with
+type authservice struct {
+	AuthService
+}
+
+var users = map[string]*User{
+	"user":   {Username: "user"},
+	"other":  {Username: "other"},
+	"admin":  {Username: "admin", Admin: true},
+	"forged": {Username: "forged", Admin: true},
+}
+
+func init() {
+	for _, user := range users {
+		user.ID = uuid.New()
+	}
+}
+
+func (s *authservice) AuthenticateToken(ctx context.Context) (*User, error) {
+	token, err := GetAuthFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, ok := users[token]
+	if !ok {
+		return nil, errors.ErrNoUserFound
+	}
+
+	//hand out copies so the interceptor can't change the fixtures
+	result := *user
+	return &result, nil
+}
+
+func (s *authservice) AuthenticateAdmin(ctx context.Context) (*User, error) {
+	user, err := s.AuthenticateToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Username != "admin" {
+		return nil, errors.ErrNoAdminAccess{Username: user.Username}
+	}
+
+	return user, nil
+}
+
+func TestAuthorizeInterceptor(t *testing.T) {
+	gateway := &Gateway{
+		services: &Services{
+			AuthService: new(authservice),
+		},
+	}
+
+	tables := []struct {
+		method string
+		token  string
+		err    bool
+		user   string
+		admin  bool
+	}{
+		{method: method("GetProducts")},
+		{method: method("GetProducts"), token: "user", user: "user"},
+		{method: method("GetProducts"), token: "forged", user: "forged"},
+		{method: method("GetProducts"), token: "bogus"},
+		{method: method("SaveProduct"), err: true},
+		{method: method("SaveProduct"), token: "user", err: true},
+		{method: method("SaveProduct"), token: "forged", err: true},
+		{method: method("SaveProduct"), token: "admin", user: "admin", admin: true},
+		{method: method("GetTotalCost"), token: "admin", err: true},
+	}
+
+	for _, table := range tables {
+		ctx := context.Background()
+		if table.token != "" {
+			ctx = SetAuthContext(ctx, table.token)
+		}
+
+		var user *User
+
+		_, err := gateway.AuthorizeInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: table.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			user, _ = UserFromContext(ctx)
+			return nil, nil
+		})
+
+		if table.err {
+			assert.NotNil(t, err, "%s with %q", table.method, table.token)
+			continue
+		}
+
+		if !assert.Nil(t, err, "%s with %q", table.method, table.token) {
+			continue
+		}
+
+		if table.user == "" {
+			assert.Nil(t, user)
+			continue
+		}
+
+		if assert.NotNil(t, user) {
+			assert.Equal(t, table.user, user.Username)
+			assert.Equal(t, table.admin, user.Admin)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"context"
+	"time"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PricingService keeps the history of the prices of products and the price changes that are
+// scheduled for them. A scheduled price without an end is a permanent change, it becomes the
+// cost of the product once it is applied by the scheduler. One with an end is a sale, it never
+// changes the cost and is only taken into account by EffectivePrice while it runs.
+type PricingService interface {
+	//GetPriceHistory returns every change of the price of the product and its variants, the
+	//most recent first
+	GetPriceHistory(ctx context.Context, product uuid.UUID) ([]*PriceChange, error)
+	//GetScheduledPrices returns the prices scheduled for the product and its variants that
+	//haven't ended or been applied yet, the earliest first
+	GetScheduledPrices(ctx context.Context, product uuid.UUID) ([]*ScheduledPrice, error)
+	SchedulePrice(ctx context.Context, price *ScheduledPrice) (*ScheduledPrice, error)
+	//CancelScheduledPrice cancels a price that hasn't been applied yet, a sale that already
+	//started ends right away
+	CancelScheduledPrice(ctx context.Context, id uuid.UUID) error
+	//PriceAt returns what a unit of the product, or of the variant when one is provided, sells
+	//for at the instant. It is meant for the present and the future, the price history tells
+	//what something sold for in the past.
+	PriceAt(ctx context.Context, product uuid.UUID, variant *uuid.UUID, at time.Time) (float32, error)
+	//ApplyScheduledPrices applies the permanent price changes that are due by now, it returns
+	//how many were applied
+	ApplyScheduledPrices(ctx context.Context, now time.Time) (int64, error)
+}
+
+// PriceChange a change of the price of a product, or of one of its variants when it has a
+// variant id. A nil price is a variant that sells at the cost of its product, Before is nil as
+// well for a product that was just created. The actor is taken from the audit context, changes
+// applied by the scheduler are made by pisces itself.
+type PriceChange struct {
+	ID               uuid.UUID      `json:"id" gorm:"type:varchar(36);primaryKey"`
+	ProductID        uuid.UUID      `json:"product_id"`
+	VariantID        *uuid.UUID     `json:"variant_id"`
+	Before           *float32       `json:"before"`
+	After            *float32       `json:"after"`
+	ScheduledPriceID *uuid.UUID     `json:"scheduled_price_id"`
+	ActorID          uuid.UUID      `json:"actor_id"`
+	ActorType        AuditActorType `json:"actor_type"`
+	Actor            string         `json:"actor"`
+	CreatedAt        time.Time      `json:"created_at"`
+}
+
+// NewPriceChange returns the change from before to after made by the actor of the context,
+// nil when the price didn't change
+func NewPriceChange(ctx context.Context, product uuid.UUID, variant *uuid.UUID, before, after *float32) *PriceChange {
+	if before == after || (before != nil && after != nil && *before == *after) {
+		return nil
+	}
+
+	audit := AuditContextFromContext(ctx)
+
+	return &PriceChange{
+		ID:        uuid.New(),
+		ProductID: product,
+		VariantID: variant,
+		Before:    before,
+		After:     after,
+		ActorID:   audit.ActorID,
+		ActorType: audit.ActorType,
+		Actor:     audit.Actor,
+		CreatedAt: time.Now(),
+	}
+}
+
+// ScheduledPrice a price that takes effect at StartsAt, for the product or only for one of its
+// variants when it has a variant id. With an end it is a sale that runs until EndsAt, without
+// one it is a permanent change that the scheduler applies once it is due.
+type ScheduledPrice struct {
+	ProductID uuid.UUID  `json:"product_id"`
+	VariantID *uuid.UUID `json:"variant_id"`
+	Price     float32    `json:"price"`
+	StartsAt  time.Time  `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at"`
+	AppliedAt *time.Time `json:"applied_at"`
+	commons.Model
+}
+
+// Sale reports whether the price is a sale rather than a permanent change
+func (price *ScheduledPrice) Sale() bool {
+	return price.EndsAt != nil
+}
+
+// Active reports whether the price is in effect at the instant without being the cost of the
+// product yet, i.e. a running sale or a change that is due but wasn't applied
+func (price *ScheduledPrice) Active(at time.Time) bool {
+	if price.StartsAt.After(at) {
+		return false
+	}
+
+	if price.Sale() {
+		return price.EndsAt.After(at)
+	}
+
+	return price.AppliedAt == nil
+}
+
+// ScheduledPricesAt only keeps the scheduled prices that are active at the instant, it is the
+// query of Active
+func ScheduledPricesAt(at time.Time) func(tx *gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("starts_at <= ?", at).
+			Where("(ends_at IS NOT NULL AND ends_at > ?) OR (ends_at IS NULL AND applied_at IS NULL)", at)
+	}
+}
+
+// EffectivePrice returns what a unit of the product, or of the variant when one is provided,
+// sells for at the instant given the prices scheduled for the product. A change of the price of
+// the product is inherited by the variants that don't have a cost of their own, as are its
+// sales. Among the prices that apply the one that started last wins, a sale is taken over
+// the permanent price and a price of the variant over the one of the product.
+func EffectivePrice(product *Product, variant *ProductVariant, prices []*ScheduledPrice, at time.Time) float32 {
+	if product == nil {
+		return variant.Price(product)
+	}
+
+	var changes, sales [2]*ScheduledPrice
+
+	for _, price := range prices {
+		if price.ProductID != product.ID || !price.Active(at) {
+			continue
+		}
+
+		//0 is the product and 1 the variant
+		i := 0
+		if price.VariantID != nil {
+			if variant == nil || *price.VariantID != variant.ID {
+				continue
+			}
+			i = 1
+		}
+
+		latest := &changes
+		if price.Sale() {
+			latest = &sales
+		}
+
+		if latest[i] == nil || price.StartsAt.After(latest[i].StartsAt) {
+			latest[i] = price
+		}
+	}
+
+	cost := product.Cost
+	if changes[0] != nil {
+		cost = changes[0].Price
+	}
+
+	var own *float32
+	if variant != nil {
+		own = variant.Cost
+	}
+
+	if changes[1] != nil {
+		own = &changes[1].Price
+	}
+
+	switch {
+	case sales[1] != nil:
+		return sales[1].Price
+	case own != nil:
+		return *own
+	case sales[0] != nil:
+		return sales[0].Price
+	default:
+		return cost
+	}
+}
+
+// GetPriceHistory returns every change of the price of the product
+func (g *Gateway) GetPriceHistory(ctx context.Context, product uuid.UUID) ([]*PriceChange, error) {
+	if err := g.authorize(ctx, PermissionReadProducts); err != nil {
+		return nil, err
+	}
+
+	return g.services.PricingService.GetPriceHistory(ctx, product)
+}
+
+// GetScheduledPrices returns the prices that are scheduled for the product
+func (g *Gateway) GetScheduledPrices(ctx context.Context, product uuid.UUID) ([]*ScheduledPrice, error) {
+	if err := g.authorize(ctx, PermissionReadProducts); err != nil {
+		return nil, err
+	}
+
+	return g.services.PricingService.GetScheduledPrices(ctx, product)
+}
+
+// SchedulePrice schedules a change of the price of a product or a sale
+func (g *Gateway) SchedulePrice(ctx context.Context, price *ScheduledPrice) (*ScheduledPrice, error) {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return nil, err
+	}
+
+	return g.services.PricingService.SchedulePrice(ctx, price)
+}
+
+// CancelScheduledPrice cancels a scheduled price that wasn't applied yet
+func (g *Gateway) CancelScheduledPrice(ctx context.Context, id uuid.UUID) error {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return err
+	}
+
+	return g.services.PricingService.CancelScheduledPrice(ctx, id)
+}
+
+// GetProductPrice returns what a unit of the product (or of its variant) sells for at the
+// instant, sales included
+func (g *Gateway) GetProductPrice(ctx context.Context, product uuid.UUID, variant *uuid.UUID, at time.Time) (float32, error) {
+	return g.services.PricingService.PriceAt(ctx, product, variant, at)
+}
+
+// ApplyScheduledPrices applies the price changes that are due, the search index is rebuilt when
+// any were applied since the prices of the products it holds changed
+func (g *Gateway) ApplyScheduledPrices(ctx context.Context) (int64, error) {
+	applied, err := g.services.PricingService.ApplyScheduledPrices(ctx, time.Now())
+	if err != nil || applied == 0 {
+		return applied, err
+	}
+
+	if err := g.services.SearchService.Rebuild(ctx); err != nil {
+		g.Env.Log.Error(err.Error())
+	}
+
+	return applied, nil
+}
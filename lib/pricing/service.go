@@ -0,0 +1,427 @@
+package pricing
+
+import (
+	"context"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//Service the pricing service, keeps the price history of products and applies the prices that
+//are scheduled for them
+type Service struct {
+	*lib.Env
+	repo repoi
+}
+
+//NewService returns a new pricing service
+func NewService(env *lib.Env) (lib.PricingService, error) {
+	return &Service{
+		env,
+		&repo{
+			env.GormDB,
+		},
+	}, nil
+}
+
+//GetPriceHistory returns every change of the price of the product and its variants, the most
+//recent first
+func (s *Service) GetPriceHistory(ctx context.Context, product uuid.UUID) ([]*lib.PriceChange, error) {
+	return s.repo.GetPriceChanges(ctx, product)
+}
+
+//GetScheduledPrices returns the prices of the product that are yet to be applied or end
+func (s *Service) GetScheduledPrices(ctx context.Context, product uuid.UUID) ([]*lib.ScheduledPrice, error) {
+	return s.repo.GetScheduledPrices(ctx, product, time.Now())
+}
+
+//SchedulePrice schedules the price, a price without a start takes effect right away. The
+//product has to exist, as does the variant when the price is only for one of them.
+func (s *Service) SchedulePrice(ctx context.Context, price *lib.ScheduledPrice) (*lib.ScheduledPrice, error) {
+	if price == nil {
+		return nil, errors.ErrProductNotProvided
+	}
+
+	if price.StartsAt.IsZero() {
+		price.StartsAt = time.Now()
+	}
+
+	if price.Price < 0 || (price.EndsAt != nil && !price.EndsAt.After(price.StartsAt)) {
+		return nil, &errors.ErrInvalidScheduledPrice{
+			Price:    price.Price,
+			StartsAt: price.StartsAt,
+			EndsAt:   price.EndsAt,
+		}
+	}
+
+	if _, err := s.repo.GetProduct(ctx, price.ProductID); err != nil {
+		return nil, err
+	}
+
+	if price.VariantID != nil {
+		if _, err := s.repo.GetProductVariant(ctx, price.ProductID, *price.VariantID); err != nil {
+			return nil, err
+		}
+	}
+
+	price.ID = uuid.Nil
+	price.AppliedAt = nil
+
+	err := s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.CreateScheduledPrice(ctx, price); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityScheduledPrice, price.ID, nil, price)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return price, nil
+}
+
+//CancelScheduledPrice removes a price that wasn't applied yet, or a sale that hasn't ended
+func (s *Service) CancelScheduledPrice(ctx context.Context, id uuid.UUID) error {
+	price, err := s.repo.GetScheduledPrice(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if price.AppliedAt != nil || (price.Sale() && !price.EndsAt.After(time.Now())) {
+		return errors.ErrScheduledPriceOver
+	}
+
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.DeleteScheduledPrice(ctx, price); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionDelete, lib.AuditEntityScheduledPrice, price.ID, price, nil)
+	})
+}
+
+//PriceAt returns what a unit of the product, or of the variant, sells for at the instant
+func (s *Service) PriceAt(ctx context.Context, id uuid.UUID, variantid *uuid.UUID, at time.Time) (float32, error) {
+	product, err := s.repo.GetProduct(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	var variant *lib.ProductVariant
+	if variantid != nil {
+		if variant, err = s.repo.GetProductVariant(ctx, id, *variantid); err != nil {
+			return 0, err
+		}
+	}
+
+	prices, err := s.repo.GetScheduledPricesAt(ctx, id, at)
+	if err != nil {
+		return 0, err
+	}
+
+	return lib.EffectivePrice(product, variant, prices, at), nil
+}
+
+//applied a change of the cost of a product or variant made by a scheduled price
+type applied struct {
+	entity        lib.AuditEntity
+	id            uuid.UUID
+	before, after interface{}
+}
+
+//ApplyScheduledPrices makes the permanent prices that are due the cost of their product or
+//variant, in the order they start in so the latest one is what's left. A price of a product
+//or variant that was deleted since is marked as applied without changing anything.
+func (s *Service) ApplyScheduledPrices(ctx context.Context, now time.Time) (int64, error) {
+	var count int64
+
+	err := s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		due, err := repo.LockDueScheduledPrices(ctx, now)
+		if err != nil {
+			return err
+		}
+
+		for _, price := range due {
+			change, err := apply(ctx, repo, price)
+			if err != nil {
+				return err
+			}
+
+			if change != nil {
+				if err := s.Audit(ctx, lib.AuditActionUpdate, change.entity, change.id, change.before, change.after); err != nil {
+					return err
+				}
+			}
+
+			if err := repo.MarkApplied(ctx, price, now); err != nil {
+				return err
+			}
+		}
+
+		count = int64(len(due))
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+//apply makes the price the cost of its product or variant and records the change, it must be
+//called within a transaction
+func apply(ctx context.Context, repo repoi, price *lib.ScheduledPrice) (*applied, error) {
+	cost := price.Price
+
+	if price.VariantID == nil {
+		before, err := repo.LockProduct(ctx, price.ProductID)
+		if err != nil || before == nil {
+			return nil, err
+		}
+
+		after := *before
+		after.Cost = cost
+
+		if err := repo.UpdateProductCost(ctx, before.ID, cost); err != nil {
+			return nil, err
+		}
+
+		previous := before.Cost
+		if err := record(ctx, repo, price, &previous); err != nil {
+			return nil, err
+		}
+
+		return &applied{lib.AuditEntityProduct, before.ID, before, &after}, nil
+	}
+
+	before, err := repo.LockProductVariant(ctx, *price.VariantID)
+	if err != nil || before == nil {
+		return nil, err
+	}
+
+	after := *before
+	after.Cost = &cost
+
+	if err := repo.UpdateProductVariantCost(ctx, before.ID, cost); err != nil {
+		return nil, err
+	}
+
+	if err := record(ctx, repo, price, before.Cost); err != nil {
+		return nil, err
+	}
+
+	return &applied{lib.AuditEntityProductVariant, before.ID, before, &after}, nil
+}
+
+//record records the change the scheduled price made, previous is nil for a variant that sold
+//at the cost of its product
+func record(ctx context.Context, repo repoi, price *lib.ScheduledPrice, previous *float32) error {
+	after := price.Price
+	change := lib.NewPriceChange(ctx, price.ProductID, price.VariantID, previous, &after)
+	if change == nil {
+		return nil
+	}
+
+	change.ScheduledPriceID = &price.ID
+	return repo.CreatePriceChange(ctx, change)
+}
+
+type repoi interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error
+	GetProduct(ctx context.Context, id uuid.UUID) (*lib.Product, error)
+	GetProductVariant(ctx context.Context, product, id uuid.UUID) (*lib.ProductVariant, error)
+	LockProduct(ctx context.Context, id uuid.UUID) (*lib.Product, error)
+	LockProductVariant(ctx context.Context, id uuid.UUID) (*lib.ProductVariant, error)
+	UpdateProductCost(ctx context.Context, id uuid.UUID, cost float32) error
+	UpdateProductVariantCost(ctx context.Context, id uuid.UUID, cost float32) error
+	GetPriceChanges(ctx context.Context, product uuid.UUID) ([]*lib.PriceChange, error)
+	CreatePriceChange(ctx context.Context, change *lib.PriceChange) error
+	GetScheduledPrice(ctx context.Context, id uuid.UUID) (*lib.ScheduledPrice, error)
+	GetScheduledPrices(ctx context.Context, product uuid.UUID, now time.Time) ([]*lib.ScheduledPrice, error)
+	GetScheduledPricesAt(ctx context.Context, product uuid.UUID, at time.Time) ([]*lib.ScheduledPrice, error)
+	LockDueScheduledPrices(ctx context.Context, now time.Time) ([]*lib.ScheduledPrice, error)
+	CreateScheduledPrice(ctx context.Context, price *lib.ScheduledPrice) error
+	MarkApplied(ctx context.Context, price *lib.ScheduledPrice, now time.Time) error
+	DeleteScheduledPrice(ctx context.Context, price *lib.ScheduledPrice) error
+}
+
+type repo struct {
+	*gorm.DB
+}
+
+//Transaction runs the provided function with a repo that is bound to a single transaction,
+//the transaction is rolled back if the function returns an error. The context the function is
+//given is bound to the transaction as well, so the mutations are audited within it.
+func (r *repo) Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(lib.WithAuditTx(ctx, tx), &repo{tx})
+	})
+}
+
+func (r *repo) GetProduct(ctx context.Context, id uuid.UUID) (*lib.Product, error) {
+	product := new(lib.Product)
+
+	err := r.DB.WithContext(ctx).First(product, "id = ?", id).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, &errors.ErrNoProductFound{
+			ID: id,
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+//GetProductVariant returns the variant of the product, a variant of another product isn't
+//found
+func (r *repo) GetProductVariant(ctx context.Context, product, id uuid.UUID) (*lib.ProductVariant, error) {
+	variant := new(lib.ProductVariant)
+
+	err := r.DB.WithContext(ctx).First(variant, "id = ? AND product_id = ?", id, product).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrVariantNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
+
+//LockProduct returns the product locked until the transaction ends, nil when it was deleted
+func (r *repo) LockProduct(ctx context.Context, id uuid.UUID) (*lib.Product, error) {
+	product := new(lib.Product)
+
+	err := r.DB.Clauses(clause.Locking{Strength: "UPDATE"}).First(product, "id = ?", id).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+//LockProductVariant returns the variant locked until the transaction ends, nil when it was
+//deleted
+func (r *repo) LockProductVariant(ctx context.Context, id uuid.UUID) (*lib.ProductVariant, error) {
+	variant := new(lib.ProductVariant)
+
+	err := r.DB.Clauses(clause.Locking{Strength: "UPDATE"}).First(variant, "id = ?", id).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
+
+func (r *repo) UpdateProductCost(ctx context.Context, id uuid.UUID, cost float32) error {
+	return r.DB.Model(new(lib.Product)).
+		Where("id = ?", id).
+		Update("cost", cost).Error
+}
+
+func (r *repo) UpdateProductVariantCost(ctx context.Context, id uuid.UUID, cost float32) error {
+	return r.DB.Model(new(lib.ProductVariant)).
+		Where("id = ?", id).
+		Update("cost", cost).Error
+}
+
+func (r *repo) GetPriceChanges(ctx context.Context, product uuid.UUID) (changes []*lib.PriceChange, err error) {
+	changes = make([]*lib.PriceChange, 0)
+	err = r.DB.WithContext(ctx).
+		Order("created_at DESC, id ASC").
+		Find(&changes, "product_id = ?", product).Error
+	return
+}
+
+//CreatePriceChange records the change of a price, a nil change is a price that stayed the same
+func (r *repo) CreatePriceChange(ctx context.Context, change *lib.PriceChange) error {
+	if change == nil {
+		return nil
+	}
+	return r.DB.WithContext(ctx).Create(change).Error
+}
+
+func (r *repo) GetScheduledPrice(ctx context.Context, id uuid.UUID) (*lib.ScheduledPrice, error) {
+	price := new(lib.ScheduledPrice)
+
+	err := r.DB.WithContext(ctx).First(price, "id = ?", id).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrScheduledPriceNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return price, nil
+}
+
+//GetScheduledPrices returns the prices of the product that weren't applied and the sales
+//that haven't ended by now
+func (r *repo) GetScheduledPrices(ctx context.Context, product uuid.UUID, now time.Time) (prices []*lib.ScheduledPrice, err error) {
+	prices = make([]*lib.ScheduledPrice, 0)
+	err = r.DB.WithContext(ctx).
+		Where("product_id = ?", product).
+		Where("(ends_at IS NOT NULL AND ends_at > ?) OR (ends_at IS NULL AND applied_at IS NULL)", now).
+		Order("starts_at ASC, id ASC").
+		Find(&prices).Error
+	return
+}
+
+//GetScheduledPricesAt returns the prices of the product that are active at the instant
+func (r *repo) GetScheduledPricesAt(ctx context.Context, product uuid.UUID, at time.Time) (prices []*lib.ScheduledPrice, err error) {
+	prices = make([]*lib.ScheduledPrice, 0)
+	err = r.DB.WithContext(ctx).
+		Where("product_id = ?", product).
+		Scopes(lib.ScheduledPricesAt(at)).
+		Find(&prices).Error
+	return
+}
+
+//LockDueScheduledPrices returns the permanent prices that are due and weren't applied yet,
+//locked until the transaction ends so no other replica applies them as well
+func (r *repo) LockDueScheduledPrices(ctx context.Context, now time.Time) (prices []*lib.ScheduledPrice, err error) {
+	prices = make([]*lib.ScheduledPrice, 0)
+	err = r.DB.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("ends_at IS NULL AND applied_at IS NULL AND starts_at <= ?", now).
+		Order("starts_at ASC, id ASC").
+		Find(&prices).Error
+	return
+}
+
+func (r *repo) CreateScheduledPrice(ctx context.Context, price *lib.ScheduledPrice) error {
+	return r.DB.WithContext(ctx).Create(price).Error
+}
+
+func (r *repo) MarkApplied(ctx context.Context, price *lib.ScheduledPrice, now time.Time) error {
+	price.AppliedAt = &now
+	return r.DB.Model(price).Update("applied_at", now).Error
+}
+
+func (r *repo) DeleteScheduledPrice(ctx context.Context, price *lib.ScheduledPrice) error {
+	return r.DB.WithContext(ctx).Delete(price).Error
+}
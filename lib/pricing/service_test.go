@@ -0,0 +1,103 @@
+package pricing_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/cryptnode-software/pisces/lib/pricing"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	env = lib.NewEnv(
+		commons.NewLogger(commons.EnvDev),
+	)
+
+	service, err = pricing.NewService(env)
+
+	ctx = context.Background()
+)
+
+func TestScheduledPrices(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product := &lib.Product{Name: "Scheduled product", Cost: 20}
+	if !assert.Nil(t, env.GormDB.Create(product).Error) {
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(product)
+
+	now := time.Now()
+
+	_, err := service.SchedulePrice(ctx, &lib.ScheduledPrice{ProductID: product.ID, Price: -1})
+	assert.IsType(t, new(perrors.ErrInvalidScheduledPrice), err)
+
+	end := now.Add(-time.Minute)
+	_, err = service.SchedulePrice(ctx, &lib.ScheduledPrice{ProductID: product.ID, Price: 10, StartsAt: now, EndsAt: &end})
+	assert.IsType(t, new(perrors.ErrInvalidScheduledPrice), err)
+
+	//a change that is due is the price before it is applied
+	change, err := service.SchedulePrice(ctx, &lib.ScheduledPrice{ProductID: product.ID, Price: 18, StartsAt: now.Add(-time.Minute)})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	price, err := service.PriceAt(ctx, product.ID, nil, now)
+	assert.Nil(t, err)
+	assert.Equal(t, float32(18), price)
+
+	//a sale takes over the price while it runs
+	end = now.Add(time.Hour)
+	sale, err := service.SchedulePrice(ctx, &lib.ScheduledPrice{ProductID: product.ID, Price: 15, StartsAt: now.Add(-time.Minute), EndsAt: &end})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	price, err = service.PriceAt(ctx, product.ID, nil, now)
+	assert.Nil(t, err)
+	assert.Equal(t, float32(15), price)
+
+	price, err = service.PriceAt(ctx, product.ID, nil, end)
+	assert.Nil(t, err)
+	assert.Equal(t, float32(18), price)
+
+	applied, err := service.ApplyScheduledPrices(ctx, now)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, applied, int64(1))
+
+	saved := new(lib.Product)
+	if assert.Nil(t, env.GormDB.First(saved, "id = ?", product.ID).Error) {
+		assert.Equal(t, float32(18), saved.Cost)
+	}
+
+	//the change is recorded as made by pisces, the sale never changes the cost
+	history, err := service.GetPriceHistory(ctx, product.ID)
+	if assert.Nil(t, err) && assert.Len(t, history, 1) {
+		assert.Equal(t, float32(20), *history[0].Before)
+		assert.Equal(t, float32(18), *history[0].After)
+		assert.Equal(t, lib.AuditActorSystem, history[0].ActorType)
+		assert.Equal(t, change.ID, *history[0].ScheduledPriceID)
+	}
+
+	assert.Equal(t, perrors.ErrScheduledPriceOver, service.CancelScheduledPrice(ctx, change.ID))
+
+	//cancelling the sale ends it right away
+	assert.Nil(t, service.CancelScheduledPrice(ctx, sale.ID))
+
+	price, err = service.PriceAt(ctx, product.ID, nil, now)
+	assert.Nil(t, err)
+	assert.Equal(t, float32(18), price)
+
+	scheduled, err := service.GetScheduledPrices(ctx, product.ID)
+	assert.Nil(t, err)
+	assert.Len(t, scheduled, 0)
+
+	assert.Equal(t, perrors.ErrScheduledPriceNotFound, service.CancelScheduledPrice(ctx, sale.ID))
+}
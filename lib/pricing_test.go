@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEffectivePrice(t *testing.T) {
+	now := time.Now()
+	hour := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	product := &Product{Cost: 20, Model: commons.Model{ID: uuid.New()}}
+	variant := &ProductVariant{ProductID: product.ID, Model: commons.Model{ID: uuid.New()}}
+
+	own := float32(30)
+	priced := &ProductVariant{ProductID: product.ID, Cost: &own, Model: commons.Model{ID: uuid.New()}}
+
+	assert.Equal(t, float32(20), EffectivePrice(product, nil, nil, now))
+	assert.Equal(t, float32(30), EffectivePrice(product, priced, nil, now))
+
+	//a change that is due but wasn't applied yet is the price, a later one isn't yet
+	changes := []*ScheduledPrice{
+		{ProductID: product.ID, Price: 18, StartsAt: earlier},
+		{ProductID: product.ID, Price: 16, StartsAt: hour},
+	}
+	assert.Equal(t, float32(18), EffectivePrice(product, nil, changes, now))
+	assert.Equal(t, float32(18), EffectivePrice(product, variant, changes, now))
+	assert.Equal(t, float32(30), EffectivePrice(product, priced, changes, now))
+	assert.Equal(t, float32(16), EffectivePrice(product, nil, changes, hour))
+
+	//once applied the change is the cost of the product
+	changes[0].AppliedAt = &now
+	assert.Equal(t, float32(20), EffectivePrice(product, nil, changes, now))
+
+	//sales of the product are inherited by the variants without a cost of their own, a sale of
+	//the variant wins over the one of the product
+	end := now.Add(2 * time.Hour)
+	sales := []*ScheduledPrice{
+		{ProductID: product.ID, Price: 15, StartsAt: earlier, EndsAt: &end},
+		{ProductID: product.ID, VariantID: &priced.ID, Price: 25, StartsAt: earlier, EndsAt: &hour},
+	}
+	assert.Equal(t, float32(15), EffectivePrice(product, nil, sales, now))
+	assert.Equal(t, float32(15), EffectivePrice(product, variant, sales, now))
+	assert.Equal(t, float32(25), EffectivePrice(product, priced, sales, now))
+
+	//the sale of the variant ended, it sells at its own cost again
+	assert.Equal(t, float32(30), EffectivePrice(product, priced, sales, hour))
+	assert.Equal(t, float32(20), EffectivePrice(product, nil, sales, end))
+
+	//prices of other products are ignored
+	other := []*ScheduledPrice{{ProductID: uuid.New(), Price: 1, StartsAt: earlier}}
+	assert.Equal(t, float32(20), EffectivePrice(product, nil, other, now))
+}
+
+func TestNewPriceChange(t *testing.T) {
+	before, after := float32(10), float32(12)
+	same := float32(10)
+
+	assert.Nil(t, NewPriceChange(context.Background(), uuid.New(), nil, &before, &same))
+	assert.Nil(t, NewPriceChange(context.Background(), uuid.New(), nil, nil, nil))
+
+	id := uuid.New()
+	ctx := WithAuditContext(context.Background(), &AuditContext{
+		ActorID:   id,
+		ActorType: AuditActorUser,
+		Actor:     "jane",
+	})
+
+	change := NewPriceChange(ctx, uuid.New(), nil, &before, &after)
+	if assert.NotNil(t, change) {
+		assert.Equal(t, id, change.ActorID)
+		assert.Equal(t, AuditActorUser, change.ActorType)
+		assert.Equal(t, float32(12), *change.After)
+	}
+
+	//a variant that starts selling at the cost of its product
+	change = NewPriceChange(context.Background(), uuid.New(), &id, &before, nil)
+	if assert.NotNil(t, change) {
+		assert.Equal(t, AuditActorSystem, change.ActorType)
+		assert.Nil(t, change.After)
+	}
+}
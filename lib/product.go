@@ -2,18 +2,47 @@ package lib
 
 import (
 	"context"
+	"time"
 
 	commons "github.com/cryptnode-software/commons/pkg"
 	"github.com/google/uuid"
 )
 
 type GetProductsOption struct {
-	ID       *uuid.UUID
-	Sort     *SortBy
-	Name     *string
-	Archived bool
+	ID  *uuid.UUID
+	IDs []uuid.UUID
+	//Sorts are applied in order, the id of the product breaks the ties that are left
+	Sorts      []*SortBy
+	Name       *string
+	Archived   bool
+	CategoryID *uuid.UUID
+	Tag        *string
+	Catalog    bool
+	//Cursor and Limit page the products, they are only used by GetProductsPage
+	Cursor string
+	Limit  int
 }
 
+// ProductPage a single page of products, Total is the number of products that match the
+// options across every page
+type ProductPage struct {
+	Products []*Product
+	Total    int64
+	//NextCursor continues with the next page, it is empty on the last one
+	NextCursor string
+}
+
+// The fields products can be sorted by, no other field is accepted
+const (
+	ProductSortName      = "name"
+	ProductSortCost      = "cost"
+	ProductSortInventory = "inventory"
+	ProductSortCreatedAt = "created_at"
+	//ProductSortPopularity sorts by the number of units of the product that were sold in
+	//accepted and completed orders
+	ProductSortPopularity = "popularity"
+)
+
 type SortBy struct {
 	Direction SortDirection
 	Field     string
@@ -28,15 +57,27 @@ const (
 
 type WithGetProductsOptions func(o *GetProductsOption) error
 
+// WithProductSort sorts the products by the field, it can be provided more than once to sort
+// by several fields
 func WithProductSort(field string, direction SortDirection) WithGetProductsOptions {
 	return func(o *GetProductsOption) error {
 		if field == "" || direction == "" {
 			return nil
 		}
-		o.Sort = &SortBy{
+		o.Sorts = append(o.Sorts, &SortBy{
 			Direction: direction,
 			Field:     field,
-		}
+		})
+		return nil
+	}
+}
+
+// WithProductPage continues the listing from the cursor of a previous page, limit is the
+// size of the page
+func WithProductPage(cursor string, limit int) WithGetProductsOptions {
+	return func(o *GetProductsOption) error {
+		o.Cursor = cursor
+		o.Limit = limit
 		return nil
 	}
 }
@@ -71,9 +112,22 @@ func WithProductArchive() WithGetProductsOptions {
 // ProductService ...
 type ProductService interface {
 	GetProduct(ctx context.Context, opts ...WithGetProductsOptions) (*Product, error)
+	//DeleteProduct moves the product to the trash, a hard delete removes it for good as long
+	//as no cart line references it
 	DeleteProduct(ctx context.Context, product *Product, conditions *DeleteConditions) error
+	//GetDeletedProducts returns the products in the trash that were deleted before the time,
+	//the most recently deleted first
+	GetDeletedProducts(ctx context.Context, before time.Time) ([]*Product, error)
+	//RestoreProduct takes the product out of the trash, a product that isn't in the trash is
+	//returned as it is
+	RestoreProduct(ctx context.Context, id uuid.UUID) (*Product, error)
 	GetProducts(ctx context.Context, opts ...WithGetProductsOptions) ([]*Product, error)
+	GetProductsPage(ctx context.Context, opts ...WithGetProductsOptions) (*ProductPage, error)
 	SaveProduct(ctx context.Context, product *Product) (*Product, error)
+	SaveProductOption(ctx context.Context, option *ProductOption) (*ProductOption, error)
+	DeleteProductOption(ctx context.Context, option *ProductOption) error
+	SaveProductVariant(ctx context.Context, variant *ProductVariant) (*ProductVariant, error)
+	DeleteProductVariant(ctx context.Context, variant *ProductVariant) error
 }
 
 // Product ...
@@ -81,7 +135,22 @@ type Product struct {
 	Cost        float32
 	Description string
 	Name        string
-	Inventory   int
+	//Inventory is what the inventory ledger of the product adds up to, it is moved through
+	//inventory movements rather than set
+	Inventory int
+	//LowStockThreshold is the stock below which the product is alerted about, the threshold
+	//of the env is used when it isn't set
+	LowStockThreshold *int
+	//Categories and Tags are only loaded along with the product when it is asked for,
+	//see WithProductCatalog
+	Categories []*Category `gorm:"many2many:product_categories"`
+	Tags       []*Tag      `gorm:"many2many:product_tags"`
+	//Options and Variants are always loaded along with the product, a product with variants
+	//is sold and stocked by variant rather than by itself
+	Options  []*ProductOption
+	Variants []*ProductVariant
+	//Images is the gallery of the product in order, it is always loaded along with it
+	Images []*ProductImage
 	commons.Model
 }
 
@@ -2,11 +2,14 @@ package product
 
 import (
 	"context"
-	"fmt"
+	"strings"
+	"time"
 
 	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
@@ -54,35 +57,546 @@ func (s *Service) GetProducts(ctx context.Context, opts ...lib.WithGetProductsOp
 	return s.repo.GetProducts(ctx, opts...)
 }
 
+//GetProductsPage returns a single page of the products that match the options, sorted by
+//the sorts of the options or by name when there are none
+func (s *Service) GetProductsPage(ctx context.Context, opts ...lib.WithGetProductsOptions) (*lib.ProductPage, error) {
+	options := new(lib.GetProductsOption)
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	sortkeys, after, limit, err := page(options)
+	if err != nil {
+		return nil, err
+	}
+
+	//one more than the limit is read to know whether there is another page
+	products, err := s.repo.GetProductsPage(ctx, options, sortkeys, after, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := s.repo.CountProducts(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &lib.ProductPage{
+		Products: products,
+		Total:    total,
+	}
+
+	if len(products) > limit {
+		result.Products = products[:limit]
+		last := result.Products[limit-1]
+
+		values := make([]interface{}, 0, len(sortkeys))
+		for _, key := range sortkeys {
+			if key.value != nil {
+				values = append(values, key.value(last))
+				continue
+			}
+
+			popularity, err := s.repo.GetPopularity(ctx, last.ID)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, popularity)
+		}
+
+		result.NextCursor = encodecursor(sortkeys, values, last.ID)
+	}
+
+	return result, nil
+}
+
 func (s *Service) SaveProduct(ctx context.Context, product *lib.Product) (result *lib.Product, err error) {
 	if product.ID == uuid.Nil {
-		return s.repo.CreateProduct(ctx, product)
+		//the stock of a new product comes in through the ledger, like any other stock
+		stock := product.Inventory
+		product.Inventory = 0
+
+		err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+			if result, err = repo.CreateProduct(ctx, product); err != nil {
+				return err
+			}
+
+			cost := result.Cost
+			if err := repo.CreatePriceChange(ctx, lib.NewPriceChange(ctx, result.ID, nil, nil, &cost)); err != nil {
+				return err
+			}
+
+			result.Inventory = stock
+			if err := repo.MoveInventory(ctx, lib.NewInventoryMovement(ctx, lib.InventoryMovementReceipt, result.ID, nil, stock, "product created")); err != nil {
+				return err
+			}
+
+			return s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityProduct, result.ID, nil, result)
+		})
+
+		return
+	}
+
+	before, err := s.repo.GetProduct(ctx, lib.WithProductID(product.ID), lib.WithProductArchive())
+	if err != nil {
+		return nil, err
 	}
-	return s.repo.UpdateProduct(ctx, product)
+
+	//zero values aren't updated, so a cost of zero leaves the cost as it was
+	cost := before.Cost
+	if product.Cost != 0 {
+		cost = product.Cost
+	}
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+		if result, err = repo.UpdateProduct(ctx, product); err != nil {
+			return err
+		}
+
+		if err := repo.CreatePriceChange(ctx, lib.NewPriceChange(ctx, before.ID, nil, &before.Cost, &cost)); err != nil {
+			return err
+		}
+
+		//the inventory is set like the other fields are, which is recorded as an adjustment
+		//of the stock by the difference
+		if product.Inventory != 0 {
+			stock, err := repo.LockInventory(ctx, before.ID, nil)
+			if err != nil {
+				return err
+			}
+
+			if err := repo.MoveInventory(ctx, lib.NewInventoryMovement(ctx, lib.InventoryMovementAdjustment, before.ID, nil, product.Inventory-stock, "product saved")); err != nil {
+				return err
+			}
+		}
+
+		return s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityProduct, result.ID, before, result)
+	})
+
+	return
 }
 
-func (s *Service) DeleteProduct(ctx context.Context, product *lib.Product, conditions *lib.DeleteConditions) error {
+func (s *Service) DeleteProduct(ctx context.Context, product *lib.Product, conditions *lib.DeleteConditions) (err error) {
 
 	if conditions != nil && conditions.HardDelete {
-		return s.repo.HardDelete(ctx, product)
+		//the lines of orders keep referencing the product, it can only be trashed once sold
+		var lines int64
+		if lines, err = s.repo.CountCartLines(ctx, product.ID); err != nil {
+			return
+		}
+
+		if lines > 0 {
+			return &errors.ErrProductInUse{
+				ID:    product.ID,
+				Lines: lines,
+			}
+		}
+
+		return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+			if err := repo.HardDelete(ctx, product); err != nil {
+				return err
+			}
+
+			return s.Audit(ctx, lib.AuditActionHardDelete, lib.AuditEntityProduct, product.ID, product, nil)
+		})
+	}
+
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.SoftDelete(ctx, product); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionDelete, lib.AuditEntityProduct, product.ID, product, nil)
+	})
+}
+
+//GetDeletedProducts returns the products in the trash that were deleted before the time
+func (s *Service) GetDeletedProducts(ctx context.Context, before time.Time) ([]*lib.Product, error) {
+	return s.repo.GetDeletedProducts(ctx, before)
+}
+
+//RestoreProduct takes the product out of the trash along with everything that was left with
+//it, its variants and images were never deleted
+func (s *Service) RestoreProduct(ctx context.Context, id uuid.UUID) (*lib.Product, error) {
+	before, err := s.repo.GetProduct(ctx, lib.WithProductID(id), lib.WithProductArchive())
+	if err != nil {
+		return nil, err
+	}
+
+	if !before.DeletedAt.Valid {
+		return before, nil
+	}
+
+	var after *lib.Product
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+		if err := repo.RestoreProduct(ctx, id); err != nil {
+			return err
+		}
+
+		if after, err = repo.GetProduct(ctx, lib.WithProductID(id)); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionRestore, lib.AuditEntityProduct, id, before, after)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return after, nil
+}
+
+//SaveProductOption creates a new option of a product when it doesn't have an id yet and
+//updates the existing one otherwise. An option can't be renamed, or lose a value, while
+//variants of its product still use it.
+func (s *Service) SaveProductOption(ctx context.Context, option *lib.ProductOption) (*lib.ProductOption, error) {
+	option.Name = strings.TrimSpace(option.Name)
+	option.Values = values(option.Values)
+
+	if option.Name == "" || len(option.Values) == 0 {
+		return nil, errors.ErrNoOptionName
+	}
+
+	var before *lib.ProductOption
+
+	if option.ID != uuid.Nil {
+		var err error
+		if before, err = s.repo.GetProductOption(ctx, option.ID); err != nil {
+			return nil, err
+		}
+
+		//an option can't be moved to another product
+		option.ProductID = before.ProductID
+	}
+
+	product, err := s.product(ctx, option.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, other := range product.Options {
+		if other.ID != option.ID && strings.EqualFold(other.Name, option.Name) {
+			return nil, &errors.ErrOptionTaken{
+				ProductID: product.ID,
+				Name:      option.Name,
+			}
+		}
+	}
+
+	if before == nil {
+		err := s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+			if err := repo.CreateProductOption(ctx, option); err != nil {
+				return err
+			}
+
+			return s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityProductOption, option.ID, nil, option)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return option, nil
+	}
+
+	for _, variant := range product.Variants {
+		value, ok := variant.Options[before.Name]
+		if !ok {
+			continue
+		}
+
+		if before.Name != option.Name {
+			return nil, &errors.ErrOptionInUse{
+				OptionID: before.ID,
+			}
+		}
+
+		if !option.Values.Contains(value) {
+			return nil, &errors.ErrOptionInUse{
+				OptionID: before.ID,
+				Value:    value,
+			}
+		}
+	}
+
+	var after *lib.ProductOption
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+		if err := repo.UpdateProductOption(ctx, option); err != nil {
+			return err
+		}
+
+		if after, err = repo.GetProductOption(ctx, option.ID); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityProductOption, after.ID, before, after)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return after, nil
+}
+
+//DeleteProductOption deletes the option as long as none of the variants of its product
+//use it
+func (s *Service) DeleteProductOption(ctx context.Context, option *lib.ProductOption) error {
+	before, err := s.repo.GetProductOption(ctx, option.ID)
+	if err != nil {
+		return err
+	}
+
+	product, err := s.product(ctx, before.ProductID)
+	if err != nil {
+		return err
+	}
+
+	for _, variant := range product.Variants {
+		if _, ok := variant.Options[before.Name]; ok {
+			return &errors.ErrOptionInUse{
+				OptionID: before.ID,
+			}
+		}
+	}
+
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.DeleteProductOption(ctx, before); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionDelete, lib.AuditEntityProductOption, before.ID, before, nil)
+	})
+}
+
+//SaveProductVariant creates a new variant of a product when it doesn't have an id yet and
+//updates the existing one otherwise. The variant has to pick a value for every option of
+//its product, a combination that another variant already has can't be picked again.
+func (s *Service) SaveProductVariant(ctx context.Context, variant *lib.ProductVariant) (*lib.ProductVariant, error) {
+	if variant.SKU = strings.TrimSpace(variant.SKU); variant.SKU == "" {
+		return nil, errors.ErrNoVariantSKU
+	}
+
+	var before *lib.ProductVariant
+
+	if variant.ID != uuid.Nil {
+		var err error
+		if before, err = s.repo.GetProductVariant(ctx, variant.ID); err != nil {
+			return nil, err
+		}
+
+		//a variant can't be moved to another product
+		variant.ProductID = before.ProductID
+	}
+
+	product, err := s.product(ctx, variant.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validate(product, variant); err != nil {
+		return nil, err
+	}
+
+	if existing, err := s.repo.GetProductVariantBySKU(ctx, variant.SKU); err != nil {
+		return nil, err
+	} else if existing != nil && existing.ID != variant.ID {
+		return nil, &errors.ErrSKUTaken{
+			SKU: variant.SKU,
+		}
+	}
+
+	if before == nil {
+		stock := variant.Inventory
+		variant.Inventory = 0
+
+		err := s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+			if err := repo.CreateProductVariant(ctx, variant); err != nil {
+				return err
+			}
+
+			if err := repo.CreatePriceChange(ctx, lib.NewPriceChange(ctx, variant.ProductID, &variant.ID, nil, variant.Cost)); err != nil {
+				return err
+			}
+
+			variant.Inventory = stock
+			if err := repo.MoveInventory(ctx, lib.NewInventoryMovement(ctx, lib.InventoryMovementReceipt, variant.ProductID, &variant.ID, stock, "variant created")); err != nil {
+				return err
+			}
+
+			return s.Audit(ctx, lib.AuditActionCreate, lib.AuditEntityProductVariant, variant.ID, nil, variant)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return variant, nil
+	}
+
+	var after *lib.ProductVariant
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) (err error) {
+		if err := repo.UpdateProductVariant(ctx, variant); err != nil {
+			return err
+		}
+
+		if err := repo.CreatePriceChange(ctx, lib.NewPriceChange(ctx, before.ProductID, &before.ID, before.Cost, variant.Cost)); err != nil {
+			return err
+		}
+
+		//like the inventory of a product, a variant that is saved without one keeps its stock,
+		//it is brought down to nothing through an inventory movement instead
+		if variant.Inventory != 0 {
+			stock, err := repo.LockInventory(ctx, before.ProductID, &before.ID)
+			if err != nil {
+				return err
+			}
+
+			if err := repo.MoveInventory(ctx, lib.NewInventoryMovement(ctx, lib.InventoryMovementAdjustment, before.ProductID, &before.ID, variant.Inventory-stock, "variant saved")); err != nil {
+				return err
+			}
+		}
+
+		if after, err = repo.GetProductVariant(ctx, variant.ID); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionUpdate, lib.AuditEntityProductVariant, after.ID, before, after)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return after, nil
+}
+
+//DeleteProductVariant deletes the variant, lines that already reference it are left alone
+//but it can't be added to a cart anymore. The product of the variant is filled in on the
+//provided variant.
+func (s *Service) DeleteProductVariant(ctx context.Context, variant *lib.ProductVariant) error {
+	before, err := s.repo.GetProductVariant(ctx, variant.ID)
+	if err != nil {
+		return err
+	}
+
+	variant.ProductID = before.ProductID
+
+	return s.repo.Transaction(ctx, func(ctx context.Context, repo repoi) error {
+		if err := repo.DeleteProductVariant(ctx, before); err != nil {
+			return err
+		}
+
+		return s.Audit(ctx, lib.AuditActionDelete, lib.AuditEntityProductVariant, before.ID, before, nil)
+	})
+}
+
+//product returns the product along with its options and variants
+func (s *Service) product(ctx context.Context, id uuid.UUID) (*lib.Product, error) {
+	return s.repo.GetProduct(ctx, lib.WithProductID(id))
+}
+
+//validate makes sure the variant has one of the values of every option of the product, and
+//nothing else, and that no other variant of the product has the same values
+func validate(product *lib.Product, variant *lib.ProductVariant) error {
+	options := make(lib.VariantOptions, len(variant.Options))
+	for name, value := range variant.Options {
+		options[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	variant.Options = options
+
+	known := make(map[string]bool, len(product.Options))
+
+	for _, option := range product.Options {
+		known[option.Name] = true
+
+		value := options[option.Name]
+		if value == "" || !option.Values.Contains(value) {
+			return &errors.ErrInvalidVariantOptions{
+				Option: option.Name,
+				Value:  value,
+			}
+		}
+	}
+
+	for name, value := range options {
+		if !known[name] {
+			return &errors.ErrInvalidVariantOptions{
+				Option: name,
+				Value:  value,
+			}
+		}
+	}
+
+	for _, other := range product.Variants {
+		if other.ID != variant.ID && other.Options.Equal(options) {
+			return &errors.ErrVariantExists{
+				VariantID: other.ID,
+			}
+		}
+	}
+
+	return nil
+}
+
+//values trims the values of an option and drops the empty and repeated ones
+func values(raw lib.OptionValues) lib.OptionValues {
+	result := make(lib.OptionValues, 0, len(raw))
+
+	for _, value := range raw {
+		if value = strings.TrimSpace(value); value != "" && !result.Contains(value) {
+			result = append(result, value)
+		}
 	}
 
-	return s.repo.SoftDelete(ctx, product)
+	return result
 }
 
 type repoi interface {
+	Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error
 	GetProducts(ctx context.Context, opts ...lib.WithGetProductsOptions) (products []*lib.Product, err error)
+	GetProductsPage(ctx context.Context, options *lib.GetProductsOption, sortkeys []*sortkey, after *cursor, limit int) ([]*lib.Product, error)
+	CountProducts(ctx context.Context, options *lib.GetProductsOption) (int64, error)
+	GetPopularity(ctx context.Context, id uuid.UUID) (int64, error)
 	UpdateProduct(ctx context.Context, product *lib.Product) (*lib.Product, error)
 	CreateProduct(ctx context.Context, product *lib.Product) (*lib.Product, error)
 	GetProduct(ctx context.Context, opts ...lib.WithGetProductsOptions) (*lib.Product, error)
 	HardDelete(ctx context.Context, product *lib.Product) error
 	SoftDelete(ctx context.Context, product *lib.Product) error
+	GetProductOption(ctx context.Context, id uuid.UUID) (*lib.ProductOption, error)
+	CreateProductOption(ctx context.Context, option *lib.ProductOption) error
+	UpdateProductOption(ctx context.Context, option *lib.ProductOption) error
+	DeleteProductOption(ctx context.Context, option *lib.ProductOption) error
+	GetProductVariant(ctx context.Context, id uuid.UUID) (*lib.ProductVariant, error)
+	GetProductVariantBySKU(ctx context.Context, sku string) (*lib.ProductVariant, error)
+	CreateProductVariant(ctx context.Context, variant *lib.ProductVariant) error
+	UpdateProductVariant(ctx context.Context, variant *lib.ProductVariant) error
+	DeleteProductVariant(ctx context.Context, variant *lib.ProductVariant) error
+	CreatePriceChange(ctx context.Context, change *lib.PriceChange) error
+	CountCartLines(ctx context.Context, product uuid.UUID) (int64, error)
+	GetDeletedProducts(ctx context.Context, before time.Time) ([]*lib.Product, error)
+	RestoreProduct(ctx context.Context, id uuid.UUID) error
+	LockInventory(ctx context.Context, product uuid.UUID, variant *uuid.UUID) (int, error)
+	MoveInventory(ctx context.Context, movement *lib.InventoryMovement) error
 }
 
 type repo struct {
 	*gorm.DB
 }
 
+//Transaction runs the provided function with a repo that is bound to a single transaction,
+//the transaction is rolled back if the function returns an error. The context the function is
+//given is bound to the transaction as well, so the mutations are audited within it.
+func (r *repo) Transaction(ctx context.Context, fn func(ctx context.Context, repo repoi) error) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(lib.WithAuditTx(ctx, tx), &repo{tx})
+	})
+}
+
 func (r *repo) GetProduct(ctx context.Context, opts ...lib.WithGetProductsOptions) (product *lib.Product, err error) {
 	product = new(lib.Product)
 
@@ -98,10 +612,16 @@ func (r *repo) GetProduct(ctx context.Context, opts ...lib.WithGetProductsOption
 			tx = tx.Unscoped()
 		}
 
-		err = tx.First(product, "id = ?", options.ID).Error
+		if options.Catalog {
+			tx = tx.Preload("Categories").Preload("Tags")
+		}
+
+		err = nested(tx).First(product, "id = ?", options.ID).Error
 
 		if err == gorm.ErrRecordNotFound {
-			return nil, nil
+			return nil, &errors.ErrNoProductFound{
+				ID: *options.ID,
+			}
 		}
 
 		return
@@ -114,16 +634,22 @@ func (r *repo) GetProduct(ctx context.Context, opts ...lib.WithGetProductsOption
 			tx = tx.Unscoped()
 		}
 
-		err = tx.First(product, "name = ?", options.Name).Error
+		if options.Catalog {
+			tx = tx.Preload("Categories").Preload("Tags")
+		}
+
+		err = nested(tx).First(product, "name = ?", options.Name).Error
 
 		if err == gorm.ErrRecordNotFound {
-			return nil, nil
+			return nil, &errors.ErrNoProductFound{
+				Name: *options.Name,
+			}
 		}
 
 		return
 	}
 
-	return
+	return nil, errors.ErrProductNotProvided
 }
 
 func (r *repo) GetProducts(ctx context.Context, opts ...lib.WithGetProductsOptions) (products []*lib.Product, err error) {
@@ -134,18 +660,127 @@ func (r *repo) GetProducts(ctx context.Context, opts ...lib.WithGetProductsOptio
 
 	products = make([]*lib.Product, 0)
 
-	if options.Sort != nil {
-		err = r.DB.Order(fmt.Sprintf("%s %s", options.Sort.Field, options.Sort.Direction)).Find(&products).Error
-		return
+	//only the sortable fields are ever put in the order, the field of the request is just
+	//used to look them up
+	sortkeys, err := keys(options.Sorts)
+	if err != nil {
+		return nil, err
 	}
 
-	err = r.DB.Find(&products).Error
+	tx, err := r.filter(ctx, r.DB.WithContext(ctx), options)
+	if err != nil {
+		return nil, err
+	}
+
+	tx = preload(tx, options)
+
+	if len(sortkeys) > 0 {
+		tx = tx.Order(orderby(sortkeys))
+	}
+
+	err = tx.Find(&products).Error
 
 	return
 }
 
+func (r *repo) GetProductsPage(ctx context.Context, options *lib.GetProductsOption, sortkeys []*sortkey, c *cursor, limit int) ([]*lib.Product, error) {
+	products := make([]*lib.Product, 0)
+
+	tx, err := r.filter(ctx, r.DB.WithContext(ctx), options)
+	if err != nil {
+		return nil, err
+	}
+
+	if c != nil {
+		condition, args := after(sortkeys, c)
+		tx = tx.Where(condition, args...)
+	}
+
+	err = preload(tx, options).
+		Order(orderby(sortkeys)).
+		Limit(limit).
+		Find(&products).Error
+
+	return products, err
+}
+
+func (r *repo) CountProducts(ctx context.Context, options *lib.GetProductsOption) (total int64, err error) {
+	tx, err := r.filter(ctx, r.DB.WithContext(ctx).Model(new(lib.Product)), options)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Count(&total).Error
+	return
+}
+
+//GetPopularity returns the number of units of the product that were sold
+func (r *repo) GetPopularity(ctx context.Context, id uuid.UUID) (sold int64, err error) {
+	err = r.DB.WithContext(ctx).
+		Model(new(lib.Product)).
+		Select(popularity+" AS popularity").
+		Where("products.id = ?", id).
+		Scan(&sold).Error
+	return
+}
+
+//filter narrows the products down to the ids, the category (along with the categories
+//below it) and the tag of the options
+func (r *repo) filter(ctx context.Context, tx *gorm.DB, options *lib.GetProductsOption) (*gorm.DB, error) {
+	if options.CategoryID != nil {
+		categories := make([]*lib.Category, 0)
+		if err := r.DB.WithContext(ctx).Select("id", "parent_id").Find(&categories).Error; err != nil {
+			return nil, err
+		}
+
+		tx = tx.Where("products.id IN (?)", r.DB.Table("product_categories").
+			Select("product_id").
+			Where("category_id IN ?", lib.CategoryDescendants(categories, *options.CategoryID)))
+	}
+
+	if len(options.IDs) > 0 {
+		tx = tx.Where("products.id IN ?", options.IDs)
+	}
+
+	if options.Tag != nil {
+		tx = tx.Where("products.id IN (?)", r.DB.Table("product_tags").
+			Select("product_id").
+			Where("tag_name = ?", *options.Tag))
+	}
+
+	return tx, nil
+}
+
+//preload loads the catalog of the products when the options ask for it, along with their
+//options, variants and images
+func preload(tx *gorm.DB, options *lib.GetProductsOption) *gorm.DB {
+	if options.Catalog {
+		tx = tx.Preload("Categories").Preload("Tags")
+	}
+
+	return nested(tx)
+}
+
+//nested preloads the options, variants and images of the products in the order of their
+//position
+func nested(tx *gorm.DB) *gorm.DB {
+	return tx.
+		Preload("Options", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC, created_at ASC")
+		}).
+		Preload("Variants", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC, created_at ASC")
+		}).
+		Preload("Images", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC, created_at ASC")
+		}).
+		Preload("Images.Attachment")
+}
+
+//CreateProduct creates the product by itself, its catalog, options, variants and images are
+//saved through their own methods so they are validated
 func (r *repo) CreateProduct(ctx context.Context, product *lib.Product) (*lib.Product, error) {
-	err := r.DB.Save(product).Error
+	err := r.DB.Omit(clause.Associations).Save(product).Error
 	return product, err
 }
 
@@ -153,7 +788,6 @@ func (r *repo) UpdateProduct(ctx context.Context, product *lib.Product) (*lib.Pr
 
 	err := r.DB.Model(product).Updates(lib.Product{
 		Description: product.Description,
-		Inventory:   product.Inventory,
 		Cost:        product.Cost,
 		Name:        product.Name,
 	}).Error
@@ -168,3 +802,136 @@ func (r *repo) HardDelete(ctx context.Context, product *lib.Product) error {
 func (r *repo) SoftDelete(ctx context.Context, product *lib.Product) error {
 	return r.DB.Delete(product).Error
 }
+
+//CountCartLines returns the number of cart lines that reference the product, whether they
+//belong to an order or not. Deleted lines are counted too since they are still there.
+func (r *repo) CountCartLines(ctx context.Context, product uuid.UUID) (lines int64, err error) {
+	err = r.DB.WithContext(ctx).Unscoped().Model(new(lib.Cart)).Where("product_id = ?", product).Count(&lines).Error
+	return
+}
+
+func (r *repo) GetDeletedProducts(ctx context.Context, before time.Time) (products []*lib.Product, err error) {
+	products = make([]*lib.Product, 0)
+	err = nested(r.DB.WithContext(ctx).Unscoped()).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Order("deleted_at DESC, id ASC").
+		Find(&products).Error
+	return
+}
+
+func (r *repo) RestoreProduct(ctx context.Context, id uuid.UUID) error {
+	return r.DB.WithContext(ctx).Unscoped().Model(new(lib.Product)).
+		Where("id = ?", id).
+		Update("deleted_at", nil).Error
+}
+
+func (r *repo) GetProductOption(ctx context.Context, id uuid.UUID) (*lib.ProductOption, error) {
+	option := new(lib.ProductOption)
+
+	err := r.DB.WithContext(ctx).First(option, "id = ?", id).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrOptionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return option, nil
+}
+
+func (r *repo) CreateProductOption(ctx context.Context, option *lib.ProductOption) error {
+	return r.DB.WithContext(ctx).Create(option).Error
+}
+
+func (r *repo) UpdateProductOption(ctx context.Context, option *lib.ProductOption) error {
+	return r.DB.WithContext(ctx).Model(option).
+		Select("Name", "Values", "Position").
+		Updates(option).Error
+}
+
+func (r *repo) DeleteProductOption(ctx context.Context, option *lib.ProductOption) error {
+	return r.DB.WithContext(ctx).Delete(option).Error
+}
+
+func (r *repo) GetProductVariant(ctx context.Context, id uuid.UUID) (*lib.ProductVariant, error) {
+	variant := new(lib.ProductVariant)
+
+	err := r.DB.WithContext(ctx).First(variant, "id = ?", id).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.ErrVariantNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
+
+//GetProductVariantBySKU returns the variant with the sku, nil when there is none. Deleted
+//variants give their sku up.
+func (r *repo) GetProductVariantBySKU(ctx context.Context, sku string) (*lib.ProductVariant, error) {
+	variant := new(lib.ProductVariant)
+
+	err := r.DB.WithContext(ctx).First(variant, "sku = ?", sku).Error
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
+
+func (r *repo) CreateProductVariant(ctx context.Context, variant *lib.ProductVariant) error {
+	return r.DB.WithContext(ctx).Create(variant).Error
+}
+
+//UpdateProductVariant updates every field of the variant, zero values included, so a cost
+//can be taken away. The inventory is moved through the ledger instead.
+func (r *repo) UpdateProductVariant(ctx context.Context, variant *lib.ProductVariant) error {
+	return r.DB.WithContext(ctx).Model(variant).
+		Select("SKU", "Options", "Cost", "Weight", "Position").
+		Updates(variant).Error
+}
+
+func (r *repo) DeleteProductVariant(ctx context.Context, variant *lib.ProductVariant) error {
+	return r.DB.WithContext(ctx).Delete(variant).Error
+}
+
+//CreatePriceChange records the change of a price, a nil change is a price that stayed the same
+func (r *repo) CreatePriceChange(ctx context.Context, change *lib.PriceChange) error {
+	if change == nil {
+		return nil
+	}
+	return r.DB.WithContext(ctx).Create(change).Error
+}
+
+//LockInventory returns the stock of the product, or of the variant when one is provided,
+//locked until the transaction ends
+func (r *repo) LockInventory(ctx context.Context, product uuid.UUID, variant *uuid.UUID) (stock int, err error) {
+	tx := r.DB.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Select("COALESCE(inventory, 0)")
+
+	if variant != nil {
+		err = tx.Model(new(lib.ProductVariant)).Where("id = ?", *variant).Scan(&stock).Error
+		return
+	}
+
+	err = tx.Model(new(lib.Product)).Where("id = ?", product).Scan(&stock).Error
+	return
+}
+
+//MoveInventory records the movement, which moves the stock of its product or variant along
+//with it. A nil movement is one where nothing moved.
+func (r *repo) MoveInventory(ctx context.Context, movement *lib.InventoryMovement) error {
+	if movement == nil {
+		return nil
+	}
+	return r.DB.WithContext(ctx).Create(movement).Error
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	commons "github.com/cryptnode-software/commons/pkg"
 	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/catalog"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
 	"github.com/cryptnode-software/pisces/lib/product"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,6 +20,8 @@ var (
 
 	service, err = product.NewService(env)
 
+	catalogs, _ = catalog.NewService(env)
+
 	ctx = context.Background()
 
 	products = []*lib.Product{
@@ -66,6 +72,10 @@ func TestGetProduct(t *testing.T) {
 
 		p.Model = product.Model
 
+		//options and variants are always loaded, even when there aren't any
+		assert.Empty(t, product.Variants)
+		p.Options, p.Variants, p.Images = product.Options, product.Variants, product.Images
+
 		assert.Equal(t, p, product)
 	}
 
@@ -95,15 +105,8 @@ func TestGetProductFailure(t *testing.T) {
 			lib.WithProductID(p.ID),
 		)
 
-		if err != nil {
-			t.Error(err)
-			return
-		}
-
-		if product != nil {
-			t.Error("product was successfully found when it should have failed")
-			return
-		}
+		assert.Nil(t, product)
+		assert.Equal(t, &perrors.ErrNoProductFound{ID: p.ID}, err)
 	}
 
 }
@@ -144,6 +147,7 @@ func TestSoftDeleteProduct(t *testing.T) {
 
 		p.Model = product.Model
 		p.Model = product.Model
+		p.Options, p.Variants, p.Images = product.Options, product.Variants, product.Images
 
 		assert.Equal(t, p, product)
 
@@ -156,6 +160,61 @@ func TestSoftDeleteProduct(t *testing.T) {
 	}
 }
 
+func TestRestoreProduct(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	trashed := &lib.Product{Name: "Trashed product", Cost: 5}
+	if !assert.Nil(t, env.GormDB.Create(trashed).Error) {
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(trashed)
+
+	if !assert.Nil(t, service.DeleteProduct(ctx, trashed, nil)) {
+		return
+	}
+
+	deleted, err := service.GetDeletedProducts(ctx, time.Now().Add(time.Second))
+	if assert.Nil(t, err) {
+		assert.True(t, contains(deleted, trashed.ID))
+	}
+
+	//products only show up once they were deleted before the time
+	deleted, err = service.GetDeletedProducts(ctx, time.Now().Add(-time.Hour))
+	if assert.Nil(t, err) {
+		assert.False(t, contains(deleted, trashed.ID))
+	}
+
+	restored, err := service.RestoreProduct(ctx, trashed.ID)
+	if assert.Nil(t, err) {
+		assert.False(t, restored.DeletedAt.Valid)
+	}
+
+	_, err = service.RestoreProduct(ctx, uuid.New())
+	assert.IsType(t, new(perrors.ErrNoProductFound), err)
+
+	//a product that was sold can't be deleted for good
+	line := &lib.Cart{ProductID: trashed.ID, Quantity: 1}
+	if !assert.Nil(t, env.GormDB.Create(line).Error) {
+		return
+	}
+	defer env.GormDB.Unscoped().Delete(line)
+
+	err = service.DeleteProduct(ctx, trashed, &lib.DeleteConditions{HardDelete: true})
+	assert.IsType(t, new(perrors.ErrProductInUse), err)
+}
+
+func contains(products []*lib.Product, id uuid.UUID) bool {
+	for _, product := range products {
+		if product.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
 func TestSaveProduct(t *testing.T) {
 	if err != nil {
 		t.Error(err)
@@ -231,6 +290,221 @@ func TestGetProducts(t *testing.T) {
 	}
 }
 
+func TestProductVariants(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	product, err := service.SaveProduct(ctx, &lib.Product{
+		Name: "A t-shirt",
+		Cost: 20,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer deseed([]*lib.Product{product})
+
+	size, err := service.SaveProductOption(ctx, &lib.ProductOption{
+		ProductID: product.ID,
+		Name:      " Size ",
+		Values:    lib.OptionValues{"S", "M", " M", ""},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "Size", size.Name)
+	assert.Equal(t, lib.OptionValues{"S", "M"}, size.Values)
+
+	_, err = service.SaveProductOption(ctx, &lib.ProductOption{
+		ProductID: product.ID,
+		Name:      "size",
+		Values:    lib.OptionValues{"XL"},
+	})
+	assert.Equal(t, &perrors.ErrOptionTaken{ProductID: product.ID, Name: "size"}, err)
+
+	color, err := service.SaveProductOption(ctx, &lib.ProductOption{
+		ProductID: product.ID,
+		Name:      "Color",
+		Values:    lib.OptionValues{"Red", "Blue"},
+		Position:  1,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	sku := "SHIRT-" + uuid.New().String()[:8]
+	cost := float32(25)
+
+	variant, err := service.SaveProductVariant(ctx, &lib.ProductVariant{
+		ProductID: product.ID,
+		SKU:       sku + "-M-RED",
+		Options:   lib.VariantOptions{"Size": "M", "Color": "Red"},
+		Cost:      &cost,
+		Inventory: 4,
+		Weight:    180,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	tables := []struct {
+		variant *lib.ProductVariant
+		err     error
+	}{
+		{
+			variant: &lib.ProductVariant{ProductID: product.ID, SKU: sku + "-M",
+				Options: lib.VariantOptions{"Size": "M"}},
+			err: &perrors.ErrInvalidVariantOptions{Option: "Color"},
+		},
+		{
+			variant: &lib.ProductVariant{ProductID: product.ID, SKU: sku + "-XL-RED",
+				Options: lib.VariantOptions{"Size": "XL", "Color": "Red"}},
+			err: &perrors.ErrInvalidVariantOptions{Option: "Size", Value: "XL"},
+		},
+		{
+			variant: &lib.ProductVariant{ProductID: product.ID, SKU: sku + "-S-RED-COTTON",
+				Options: lib.VariantOptions{"Size": "S", "Color": "Red", "Fabric": "Cotton"}},
+			err: &perrors.ErrInvalidVariantOptions{Option: "Fabric", Value: "Cotton"},
+		},
+		{
+			variant: &lib.ProductVariant{ProductID: product.ID, SKU: sku + "-M-RED-2",
+				Options: lib.VariantOptions{"Size": "M", "Color": "Red"}},
+			err: &perrors.ErrVariantExists{VariantID: variant.ID},
+		},
+		{
+			variant: &lib.ProductVariant{ProductID: product.ID, SKU: sku + "-M-RED",
+				Options: lib.VariantOptions{"Size": "M", "Color": "Blue"}},
+			err: &perrors.ErrSKUTaken{SKU: sku + "-M-RED"},
+		},
+		{
+			variant: &lib.ProductVariant{ProductID: product.ID, SKU: " ",
+				Options: lib.VariantOptions{"Size": "M", "Color": "Blue"}},
+			err: perrors.ErrNoVariantSKU,
+		},
+		{
+			variant: &lib.ProductVariant{ProductID: uuid.Nil, SKU: sku},
+			err:     &perrors.ErrNoProductFound{ID: uuid.Nil},
+		},
+	}
+
+	for _, table := range tables {
+		_, err := service.SaveProductVariant(ctx, table.variant)
+		assert.Equal(t, table.err, err, table.variant.SKU)
+	}
+
+	//values that variants use can't be taken away from their option
+	size.Values = lib.OptionValues{"S"}
+	_, err = service.SaveProductOption(ctx, size)
+	assert.Equal(t, &perrors.ErrOptionInUse{OptionID: size.ID, Value: "M"}, err)
+
+	assert.Equal(t, &perrors.ErrOptionInUse{OptionID: color.ID}, service.DeleteProductOption(ctx, color))
+
+	//the variants are nested in the products they belong to
+	result, err := service.GetProducts(ctx)
+	if assert.Nil(t, err) {
+		found := false
+		for _, p := range result {
+			if p.ID != product.ID {
+				continue
+			}
+
+			found = true
+			if assert.Len(t, p.Options, 2) && assert.Len(t, p.Variants, 1) {
+				assert.Equal(t, "Size", p.Options[0].Name)
+				assert.Equal(t, variant.ID, p.Variants[0].ID)
+				assert.Equal(t, lib.VariantOptions{"Size": "M", "Color": "Red"}, p.Variants[0].Options)
+				assert.Equal(t, &cost, p.Variants[0].Cost)
+			}
+		}
+		assert.True(t, found)
+	}
+
+	//taking the cost away makes the variant sell at the cost of its product, saving it without
+	//an inventory leaves its stock alone
+	variant.Cost = nil
+	variant.Inventory = 0
+	variant, err = service.SaveProductVariant(ctx, variant)
+	if assert.Nil(t, err) {
+		assert.Nil(t, variant.Cost)
+		assert.Equal(t, float32(20), variant.Price(product))
+		assert.Equal(t, 4, variant.Inventory)
+	}
+
+	assert.Nil(t, service.DeleteProductVariant(ctx, variant))
+	assert.Nil(t, service.DeleteProductOption(ctx, color))
+
+	loaded, err := service.GetProduct(ctx, lib.WithProductID(product.ID))
+	if assert.Nil(t, err) {
+		assert.Len(t, loaded.Options, 1)
+		assert.Len(t, loaded.Variants, 0)
+	}
+}
+
+func TestGetProductsPage(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//the tag keeps the listing to the products of this run
+	tag := "page " + uuid.New().String()[:8]
+
+	seeded := []*lib.Product{
+		{Name: "Paged A", Cost: 5, Inventory: 1},
+		{Name: "Paged B", Cost: 9.99, Inventory: 2},
+		{Name: "Paged C", Cost: 5, Inventory: 3},
+	}
+
+	if err := seed(seeded); err != nil {
+		t.Error(err)
+		return
+	}
+	defer deseed(seeded)
+
+	for _, p := range seeded {
+		if err := catalogs.SetProductTags(ctx, p.ID, []string{tag}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	defer env.GormDB.Delete(&lib.Tag{Name: tag})
+
+	//by cost first, the name breaks the tie of the products that cost the same
+	listed := make([]uuid.UUID, 0)
+	cursor := ""
+
+	for {
+		page, err := service.GetProductsPage(ctx,
+			lib.WithProductTag(tag),
+			lib.WithProductSort(lib.ProductSortCost, lib.Ascending),
+			lib.WithProductSort(lib.ProductSortName, lib.Descending),
+			lib.WithProductPage(cursor, 1),
+		)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, int64(3), page.Total)
+		for _, p := range page.Products {
+			listed = append(listed, p.ID)
+		}
+
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+
+	assert.Equal(t, []uuid.UUID{seeded[2].ID, seeded[0].ID, seeded[1].ID}, listed)
+
+	_, err := service.GetProducts(ctx, lib.WithProductSort("cost; DROP TABLE products", lib.Ascending))
+	assert.Equal(t, &perrors.ErrInvalidProductSort{Field: "cost; DROP TABLE products", Direction: "ASC"}, err)
+
+	_, err = service.GetProductsPage(ctx, lib.WithProductPage("", -1))
+	assert.Equal(t, &perrors.ErrInvalidProductPage{Limit: -1}, err)
+}
+
 func seed(products []*lib.Product) error {
 	for i, p := range products {
 		product, err := service.SaveProduct(ctx, p)
@@ -0,0 +1,250 @@
+package product
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+)
+
+const (
+	//defaultlimit is the size of a page of products when no limit is provided
+	defaultlimit = 50
+
+	//maxlimit is the largest page of products that is read at once
+	maxlimit = 500
+
+	//popularity is the number of units of a product that were sold in accepted and
+	//completed orders
+	popularity = "(SELECT COALESCE(SUM(carts.quantity), 0) FROM carts " +
+		"JOIN orders ON orders.id = carts.order_id " +
+		"WHERE carts.product_id = products.id AND carts.deleted_at IS NULL " +
+		"AND orders.status IN ('" + string(lib.OrderStatusAccepted) + "', '" + string(lib.OrderStatusCompleted) + "'))"
+)
+
+//productsort a field products can be sorted by
+type productsort struct {
+	//column is what the sort is made on, it is never taken from the request itself. Columns
+	//that can be null are coalesced so the cursor can compare them.
+	column string
+	//placeholder is how the value of a cursor is compared to the column
+	placeholder string
+	//value returns the value of the field of the product, it is nil for fields that aren't
+	//kept on the product and are read by the repo instead
+	value func(product *lib.Product) interface{}
+	//kind is the type the value is decoded into from a cursor
+	kind reflect.Type
+}
+
+//sorts are the only fields products can be sorted by
+var sorts = map[string]*productsort{
+	lib.ProductSortName: {
+		"COALESCE(products.name, '')", "?",
+		func(p *lib.Product) interface{} { return p.Name },
+		reflect.TypeOf(""),
+	},
+	//costs are kept as decimals, they are compared as decimals rather than floats so the
+	//cursor doesn't skip or repeat products because of rounding
+	lib.ProductSortCost: {
+		"COALESCE(products.cost, 0)", "CAST(? AS DECIMAL(13,2))",
+		func(p *lib.Product) interface{} { return strconv.FormatFloat(float64(p.Cost), 'f', 2, 32) },
+		reflect.TypeOf(""),
+	},
+	lib.ProductSortInventory: {
+		"COALESCE(products.inventory, 0)", "?",
+		func(p *lib.Product) interface{} { return p.Inventory },
+		reflect.TypeOf(0),
+	},
+	lib.ProductSortCreatedAt: {
+		"products.created_at", "?",
+		func(p *lib.Product) interface{} { return p.CreatedAt },
+		reflect.TypeOf(time.Time{}),
+	},
+	lib.ProductSortPopularity: {
+		popularity, "?",
+		nil,
+		reflect.TypeOf(int64(0)),
+	},
+}
+
+//defaultsort is the sort of a page when none is provided
+var defaultsort = []*lib.SortBy{
+	{Field: lib.ProductSortName, Direction: lib.Ascending},
+}
+
+//sortkey a single sort of a listing along with its direction
+type sortkey struct {
+	*productsort
+	field      string
+	descending bool
+}
+
+//keys validates the sorts and returns the keys they are made of, in order
+func keys(sortby []*lib.SortBy) ([]*sortkey, error) {
+	result := make([]*sortkey, 0, len(sortby))
+	seen := make(map[string]bool, len(sortby))
+
+	for _, s := range sortby {
+		sort, ok := sorts[s.Field]
+		if !ok || seen[s.Field] || (s.Direction != lib.Ascending && s.Direction != lib.Descending) {
+			return nil, &errors.ErrInvalidProductSort{
+				Field:     s.Field,
+				Direction: string(s.Direction),
+			}
+		}
+
+		seen[s.Field] = true
+		result = append(result, &sortkey{sort, s.Field, s.Direction == lib.Descending})
+	}
+
+	return result, nil
+}
+
+//orderby returns the order the keys sort products in, the id of the product breaks the ties
+//so the order is the same every time
+func orderby(keys []*sortkey) string {
+	parts := make([]string, 0, len(keys)+1)
+
+	for _, key := range keys {
+		direction := "ASC"
+		if key.descending {
+			direction = "DESC"
+		}
+		parts = append(parts, key.column+" "+direction)
+	}
+
+	return strings.Join(append(parts, "products.id ASC"), ", ")
+}
+
+//cursor is the position of the last product of a page, the next page starts right after it
+type cursor struct {
+	Sort   string            `json:"s"`
+	Values []json.RawMessage `json:"v"`
+	ID     uuid.UUID         `json:"id"`
+
+	//values are the decoded values, in the order of the keys
+	values []interface{}
+}
+
+//signature identifies the sort of the keys, a cursor only continues the sort it was made with
+func signature(keys []*sortkey) string {
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		direction := lib.Ascending
+		if key.descending {
+			direction = lib.Descending
+		}
+		parts = append(parts, key.field+":"+string(direction))
+	}
+	return strings.Join(parts, ",")
+}
+
+func encodecursor(keys []*sortkey, values []interface{}, id uuid.UUID) string {
+	c := &cursor{
+		Sort: signature(keys),
+		ID:   id,
+	}
+
+	for _, value := range values {
+		raw, _ := json.Marshal(value)
+		c.Values = append(c.Values, raw)
+	}
+
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodecursor(keys []*sortkey, value string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.ErrInvalidProductCursor
+	}
+
+	c := new(cursor)
+	if err := json.Unmarshal(raw, c); err != nil || c.Sort != signature(keys) || c.ID == uuid.Nil || len(c.Values) != len(keys) {
+		return nil, errors.ErrInvalidProductCursor
+	}
+
+	for i, key := range keys {
+		v := reflect.New(key.kind)
+		if err := json.Unmarshal(c.Values[i], v.Interface()); err != nil {
+			return nil, errors.ErrInvalidProductCursor
+		}
+		c.values = append(c.values, v.Elem().Interface())
+	}
+
+	return c, nil
+}
+
+//after returns the condition that only matches the products that are sorted after the
+//cursor, i.e. for a sort by name and cost the ones with a greater name, or the same name and
+//a greater cost, or the same name and cost and a greater id
+func after(keys []*sortkey, c *cursor) (string, []interface{}) {
+	clauses := make([]string, 0, len(keys)+1)
+	args := make([]interface{}, 0)
+
+	for i := 0; i <= len(keys); i++ {
+		parts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			parts = append(parts, keys[j].column+" = "+keys[j].placeholder)
+			args = append(args, c.values[j])
+		}
+
+		if i == len(keys) {
+			parts = append(parts, "products.id > ?")
+			args = append(args, c.ID)
+		} else {
+			comparison := " > "
+			if keys[i].descending {
+				comparison = " < "
+			}
+			parts = append(parts, keys[i].column+comparison+keys[i].placeholder)
+			args = append(args, c.values[i])
+		}
+
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+	}
+
+	return "(" + strings.Join(clauses, " OR ") + ")", args
+}
+
+//page validates the sort, cursor and limit of the options, the defaults are used for the
+//ones that weren't provided
+func page(options *lib.GetProductsOption) (sortkeys []*sortkey, c *cursor, limit int, err error) {
+	if options.Limit < 0 {
+		return nil, nil, 0, &errors.ErrInvalidProductPage{
+			Limit: options.Limit,
+		}
+	}
+
+	sortby := options.Sorts
+	if len(sortby) == 0 {
+		sortby = defaultsort
+	}
+
+	if sortkeys, err = keys(sortby); err != nil {
+		return nil, nil, 0, err
+	}
+
+	if limit = options.Limit; limit == 0 {
+		limit = defaultlimit
+	}
+
+	if limit > maxlimit {
+		limit = maxlimit
+	}
+
+	if options.Cursor != "" {
+		if c, err = decodecursor(sortkeys, options.Cursor); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	return
+}
@@ -0,0 +1,74 @@
+package product
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeys(t *testing.T) {
+	sortkeys, err := keys([]*lib.SortBy{
+		{Field: lib.ProductSortCost, Direction: lib.Descending},
+		{Field: lib.ProductSortName, Direction: lib.Ascending},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, "COALESCE(products.cost, 0) DESC, COALESCE(products.name, '') ASC, products.id ASC", orderby(sortkeys))
+
+	for _, sortby := range [][]*lib.SortBy{
+		{{Field: "name; DROP TABLE products", Direction: lib.Ascending}},
+		{{Field: lib.ProductSortName, Direction: "SIDEWAYS"}},
+		{{Field: lib.ProductSortName, Direction: lib.Ascending}, {Field: lib.ProductSortName, Direction: lib.Descending}},
+	} {
+		_, err := keys(sortby)
+		assert.IsType(t, new(perrors.ErrInvalidProductSort), err)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	sortkeys, err := keys([]*lib.SortBy{
+		{Field: lib.ProductSortCost, Direction: lib.Descending},
+		{Field: lib.ProductSortCreatedAt, Direction: lib.Ascending},
+		{Field: lib.ProductSortPopularity, Direction: lib.Descending},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	product := &lib.Product{Cost: 12.99}
+	product.ID = uuid.New()
+	product.CreatedAt = time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+	value := encodecursor(sortkeys, []interface{}{
+		sortkeys[0].value(product),
+		sortkeys[1].value(product),
+		int64(7),
+	}, product.ID)
+
+	c, err := decodecursor(sortkeys, value)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, product.ID, c.ID)
+	assert.Equal(t, []interface{}{"12.99", product.CreatedAt, int64(7)}, c.values)
+
+	condition, args := after(sortkeys[:2], c)
+	assert.Equal(t, "((COALESCE(products.cost, 0) < CAST(? AS DECIMAL(13,2))) OR "+
+		"(COALESCE(products.cost, 0) = CAST(? AS DECIMAL(13,2)) AND products.created_at > ?) OR "+
+		"(COALESCE(products.cost, 0) = CAST(? AS DECIMAL(13,2)) AND products.created_at = ? AND products.id > ?))", condition)
+	assert.Len(t, args, 6)
+
+	//a cursor only continues the sort it was made with
+	_, err = decodecursor(sortkeys[:1], value)
+	assert.Equal(t, perrors.ErrInvalidProductCursor, err)
+
+	_, err = decodecursor(sortkeys, "not a cursor")
+	assert.Equal(t, perrors.ErrInvalidProductCursor, err)
+}
@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SchedulerService runs the background jobs of pisces. Every replica runs the scheduler but only
+// the one holding the scheduler lease (the leader) runs any jobs, every run is recorded so it
+// can be looked up with GetJobRuns.
+type SchedulerService interface {
+	//Run runs the provided jobs whenever they are due until the context is done
+	Run(ctx context.Context, jobs ...*Job) error
+	GetJobRuns(ctx context.Context, job string, limit int) ([]*JobRun, error)
+}
+
+// Job a background job, Run returns how many records the run affected
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) (int64, error)
+}
+
+// JobRunStatus the primitive type for the outcome of a job run
+type JobRunStatus string
+
+const (
+	//JobRunStatusRunning is the status of a run that hasn't finished yet, runs that stay
+	//in it were interrupted i.e. by the replica being shut down
+	JobRunStatusRunning JobRunStatus = "RUNNING"
+	//JobRunStatusSucceeded is the status of a run that finished without an error
+	JobRunStatusSucceeded JobRunStatus = "SUCCEEDED"
+	//JobRunStatusFailed is the status of a run that returned an error
+	JobRunStatusFailed JobRunStatus = "FAILED"
+)
+
+// JobRun the record of a single run of a job. Holder is the replica that ran it and Affected
+// how many records the run affected.
+type JobRun struct {
+	ID         uuid.UUID    `json:"id" gorm:"type:varchar(36);primaryKey"`
+	Job        string       `json:"job"`
+	Holder     string       `json:"holder"`
+	Status     JobRunStatus `json:"status"`
+	Affected   int64        `json:"affected"`
+	Error      string       `json:"error"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at"`
+}
+
+// SchedulerLease the lease that makes a replica the leader of the scheduler, it has to be
+// renewed before it expires or another replica takes over
+type SchedulerLease struct {
+	Name      string `gorm:"primaryKey"`
+	Holder    string
+	ExpiresAt time.Time
+	UpdatedAt time.Time
+}
+
+// RunScheduler runs the background jobs of pisces until the context is done, every replica
+// runs it and the scheduler makes sure only one of them runs the jobs
+func (g *Gateway) RunScheduler(ctx context.Context) error {
+	return g.services.SchedulerService.Run(ctx, g.jobs()...)
+}
+
+// GetJobRuns returns the most recent runs of the job (of every job when none is provided), it
+// is only available to admins
+func (g *Gateway) GetJobRuns(ctx context.Context, job string, limit int) ([]*JobRun, error) {
+	if _, err := g.admin(ctx); err != nil {
+		return nil, err
+	}
+
+	return g.services.SchedulerService.GetJobRuns(ctx, job, limit)
+}
+
+// jobs are the background jobs of pisces
+func (g *Gateway) jobs() []*Job {
+	return []*Job{
+		{
+			Name:     "expire-orders",
+			Interval: 15 * time.Minute,
+			Run: func(ctx context.Context) (int64, error) {
+				return g.services.CheckoutService.ExpireOrders(ctx, time.Now().Add(-g.Env.OrderExpiry))
+			},
+		},
+		{
+			Name:     "delete-expired-carts",
+			Interval: time.Hour,
+			Run:      g.DeleteExpiredCarts,
+		},
+		{
+			Name:     "delete-expired-idempotency-records",
+			Interval: time.Hour,
+			Run:      g.DeleteExpiredIdempotencyRecords,
+		},
+		{
+			//checkout prices the changes that are due but weren't applied yet on its own, so
+			//the interval only bounds how long the cost of a product is out of date
+			Name:     "apply-scheduled-prices",
+			Interval: 5 * time.Minute,
+			Run:      g.ApplyScheduledPrices,
+		},
+		{
+			//stock that runs low is only alerted about once until it is restocked, so the
+			//interval only bounds how late the alert is
+			Name:     "alert-low-stock",
+			Interval: 15 * time.Minute,
+			Run:      g.AlertLowStock,
+		},
+		{
+			Name:     "purge-deleted-products",
+			Interval: 24 * time.Hour,
+			Run:      g.PurgeDeletedProducts,
+		},
+		{
+			Name:     "reconcile-inventory",
+			Interval: 24 * time.Hour,
+			Run: func(ctx context.Context) (int64, error) {
+				drifts, err := g.services.InventoryService.ReconcileInventory(ctx)
+				return int64(len(drifts)), err
+			},
+		},
+	}
+}
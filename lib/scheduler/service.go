@@ -0,0 +1,243 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	//lease is the name of the lease the leader holds
+	lease = "scheduler"
+
+	//tick is how often every replica checks for due jobs, and renews its lease when it is
+	//the leader
+	tick = 30 * time.Second
+
+	//ttl is how long the lease is held without being renewed, once it runs out another
+	//replica takes over
+	ttl = 3 * tick
+)
+
+//Service runs the background jobs of pisces, only the replica that holds the scheduler lease
+//runs any of them
+type Service struct {
+	*lib.Env
+	holder string
+	repo   repoi
+}
+
+//NewService returns a new scheduler, it is identified as the holder of the lease by the host
+//name (the pod name in kubernetes) along with a random suffix
+func NewService(env *lib.Env) (*Service, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "pisces"
+	}
+
+	return &Service{
+		env,
+		fmt.Sprintf("%s-%s", host, uuid.New().String()[:8]),
+		&repo{
+			env.GormDB,
+		},
+	}, nil
+}
+
+//Run runs the provided jobs whenever they are due until the context is done, the lease is
+//given up when it returns so another replica can take over right away
+func (s *Service) Run(ctx context.Context, jobs ...*lib.Job) error {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	defer func() {
+		if err := s.repo.ReleaseLease(context.Background(), lease, s.holder); err != nil {
+			s.Log.Error(err.Error())
+		}
+	}()
+
+	for {
+		if _, err := s.Tick(ctx, jobs...); err != nil && ctx.Err() == nil {
+			s.Log.Error(err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+//Tick acquires (or renews) the lease and runs the jobs that are due when it is held, it
+//reports whether this replica is the leader
+func (s *Service) Tick(ctx context.Context, jobs ...*lib.Job) (bool, error) {
+	leader, err := s.repo.AcquireLease(ctx, lease, s.holder, time.Now().Add(ttl))
+	if err != nil || !leader {
+		return false, err
+	}
+
+	for _, job := range jobs {
+		if err := ctx.Err(); err != nil {
+			return true, err
+		}
+
+		last, err := s.repo.GetLastRun(ctx, job.Name)
+		if err != nil {
+			return true, err
+		}
+
+		if last != nil && last.StartedAt.Add(job.Interval).After(time.Now()) {
+			continue
+		}
+
+		s.run(ctx, job)
+
+		//a long job shouldn't cost us the lease
+		if leader, err = s.repo.AcquireLease(ctx, lease, s.holder, time.Now().Add(ttl)); err != nil || !leader {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+//run runs the job and records how it went, both in the job runs and the log
+func (s *Service) run(ctx context.Context, job *lib.Job) {
+	run := &lib.JobRun{
+		ID:        uuid.New(),
+		Job:       job.Name,
+		Holder:    s.holder,
+		Status:    lib.JobRunStatusRunning,
+		StartedAt: time.Now(),
+	}
+
+	if err := s.repo.CreateRun(ctx, run); err != nil {
+		s.Log.Error(err.Error())
+		return
+	}
+
+	affected, err := job.Run(ctx)
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Affected = affected
+	run.Status = lib.JobRunStatusSucceeded
+
+	if err != nil {
+		run.Status = lib.JobRunStatusFailed
+		run.Error = err.Error()
+		s.Log.Error(fmt.Sprintf("job %s failed after %s, %d records affected: %s", job.Name, finished.Sub(run.StartedAt), affected, err))
+	} else {
+		s.Log.Info(fmt.Sprintf("job %s finished in %s, %d records affected", job.Name, finished.Sub(run.StartedAt), affected))
+	}
+
+	//the run is recorded even when the job was interrupted by the context
+	if err := s.repo.FinishRun(context.Background(), run); err != nil {
+		s.Log.Error(err.Error())
+	}
+}
+
+//GetJobRuns returns the most recent runs of the job (of every job when none is provided)
+func (s *Service) GetJobRuns(ctx context.Context, job string, limit int) ([]*lib.JobRun, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	return s.repo.GetRuns(ctx, job, limit)
+}
+
+type repoi interface {
+	AcquireLease(ctx context.Context, name, holder string, expires time.Time) (bool, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+	GetLastRun(ctx context.Context, job string) (*lib.JobRun, error)
+	GetRuns(ctx context.Context, job string, limit int) ([]*lib.JobRun, error)
+	CreateRun(ctx context.Context, run *lib.JobRun) error
+	FinishRun(ctx context.Context, run *lib.JobRun) error
+}
+
+type repo struct {
+	*gorm.DB
+}
+
+//AcquireLease takes the lease when nobody holds it or when it expired, and renews it when
+//the holder already holds it
+func (r *repo) AcquireLease(ctx context.Context, name, holder string, expires time.Time) (bool, error) {
+	result := r.DB.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&lib.SchedulerLease{
+			Name:      name,
+			Holder:    holder,
+			ExpiresAt: expires,
+		})
+
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	if result.RowsAffected == 1 {
+		return true, nil
+	}
+
+	if err := r.DB.WithContext(ctx).Model(new(lib.SchedulerLease)).
+		Where("name = ?", name).
+		Where(r.DB.Where("holder = ?", holder).Or("expires_at < ?", time.Now())).
+		Updates(map[string]interface{}{
+			"holder":     holder,
+			"expires_at": expires,
+		}).Error; err != nil {
+		return false, err
+	}
+
+	//mysql doesn't count the rows an update didn't change as affected, i.e. when the holder
+	//renews the lease within the same second, so who holds it is read back instead
+	var current string
+	err := r.DB.WithContext(ctx).Model(new(lib.SchedulerLease)).
+		Select("holder").
+		Where("name = ?", name).
+		Scan(&current).Error
+
+	return current == holder, err
+}
+
+func (r *repo) ReleaseLease(ctx context.Context, name, holder string) error {
+	return r.DB.WithContext(ctx).
+		Where("name = ? AND holder = ?", name, holder).
+		Delete(new(lib.SchedulerLease)).Error
+}
+
+func (r *repo) GetLastRun(ctx context.Context, job string) (*lib.JobRun, error) {
+	runs, err := r.GetRuns(ctx, job, 1)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return runs[0], nil
+}
+
+func (r *repo) GetRuns(ctx context.Context, job string, limit int) (runs []*lib.JobRun, err error) {
+	tx := r.DB.WithContext(ctx)
+
+	if job != "" {
+		tx = tx.Where("job = ?", job)
+	}
+
+	runs = make([]*lib.JobRun, 0)
+	err = tx.Order("started_at DESC").Limit(limit).Find(&runs).Error
+	return
+}
+
+func (r *repo) CreateRun(ctx context.Context, run *lib.JobRun) error {
+	return r.DB.WithContext(ctx).Create(run).Error
+}
+
+func (r *repo) FinishRun(ctx context.Context, run *lib.JobRun) error {
+	return r.DB.WithContext(ctx).Model(run).
+		Select("status", "affected", "error", "finished_at").
+		Updates(run).Error
+}
@@ -0,0 +1,102 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/scheduler"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	env = lib.NewEnv(
+		commons.NewLogger(commons.EnvDev),
+	)
+
+	ctx = context.Background()
+)
+
+func TestTick(t *testing.T) {
+	leader, err := scheduler.NewService(env)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	follower, err := scheduler.NewService(env)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer env.GormDB.Where("name = ?", "scheduler").Delete(new(lib.SchedulerLease))
+
+	runs := 0
+	job := &lib.Job{
+		Name:     "test-job",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) (int64, error) {
+			runs++
+			return 3, nil
+		},
+	}
+
+	failing := &lib.Job{
+		Name:     "test-failing-job",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) (int64, error) {
+			return 0, errors.New("job failed")
+		},
+	}
+
+	defer env.GormDB.Where("job IN ?", []string{job.Name, failing.Name}).Delete(new(lib.JobRun))
+
+	led, err := leader.Tick(ctx, job, failing)
+	assert.Nil(t, err)
+	assert.True(t, led)
+	assert.Equal(t, 1, runs)
+
+	//only one replica holds the lease at a time
+	led, err = follower.Tick(ctx, job, failing)
+	assert.Nil(t, err)
+	assert.False(t, led)
+
+	//the job isn't run again before its interval is up
+	led, err = leader.Tick(ctx, job, failing)
+	assert.Nil(t, err)
+	assert.True(t, led)
+	assert.Equal(t, 1, runs)
+
+	//renewing the lease within the same second doesn't lose it
+	for i := 0; i < 3; i++ {
+		led, err = leader.Tick(ctx)
+		assert.Nil(t, err)
+		assert.True(t, led)
+	}
+
+	result, err := leader.GetJobRuns(ctx, job.Name, 0)
+	if assert.Nil(t, err) && assert.Len(t, result, 1) {
+		assert.Equal(t, lib.JobRunStatusSucceeded, result[0].Status)
+		assert.Equal(t, int64(3), result[0].Affected)
+		assert.NotNil(t, result[0].FinishedAt)
+	}
+
+	result, err = leader.GetJobRuns(ctx, failing.Name, 0)
+	if assert.Nil(t, err) && assert.Len(t, result, 1) {
+		assert.Equal(t, lib.JobRunStatusFailed, result[0].Status)
+		assert.Equal(t, "job failed", result[0].Error)
+	}
+
+	//another replica takes over once the lease has expired
+	env.GormDB.Model(new(lib.SchedulerLease)).
+		Where("name = ?", "scheduler").
+		Update("expires_at", time.Now().Add(-time.Minute))
+
+	led, err = follower.Tick(ctx)
+	assert.Nil(t, err)
+	assert.True(t, led)
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// SearchService finds products by the words of their name, description, tags and the skus of
+// their variants. Searches are case-insensitive, tolerate small typos and return the best
+// matches first.
+type SearchService interface {
+	SearchProducts(ctx context.Context, query *SearchQuery) (*SearchPage, error)
+	//IndexProduct brings the product up to date in the index, a product that no longer
+	//exists is taken out of it
+	IndexProduct(ctx context.Context, id uuid.UUID) error
+	RemoveProduct(ctx context.Context, id uuid.UUID) error
+	//Rebuild indexes every product from scratch
+	Rebuild(ctx context.Context) error
+}
+
+// SearchQuery the words to search products by along with the facets to narrow them down to.
+// A query without any words matches every product.
+type SearchQuery struct {
+	Query string
+	//CategoryID only matches the products of the category, or of any category below it
+	CategoryID  *uuid.UUID
+	PriceBucket PriceBucket
+	//InStock only matches the products that are, or aren't, in stock
+	InStock *bool
+	//Cursor continues the search from the page it was returned with, it has to be used
+	//with the same query. Limit is the size of the page.
+	Cursor string
+	Limit  int
+}
+
+// SearchPage a single page of the products that matched a search, best matches first. Total
+// is the number of products that matched across every page.
+type SearchPage struct {
+	Products []*Product
+	Total    int64
+	Facets   *SearchFacets
+	//NextCursor continues with the next page, it is empty on the last one
+	NextCursor string
+}
+
+// SearchFacets how many of the products that matched a search fall in every facet
+type SearchFacets struct {
+	//Categories counts the products of every category, the products of the categories
+	//below a category are counted for it as well
+	Categories   map[uuid.UUID]int64
+	PriceBuckets map[PriceBucket]int64
+	InStock      int64
+	OutOfStock   int64
+}
+
+// PriceBucket a range of prices products are grouped in by search
+type PriceBucket string
+
+const (
+	PriceBucketUnder10    PriceBucket = "UNDER_10"
+	PriceBucket10To25     PriceBucket = "10_TO_25"
+	PriceBucket25To50     PriceBucket = "25_TO_50"
+	PriceBucket50To100    PriceBucket = "50_TO_100"
+	PriceBucket100AndOver PriceBucket = "100_AND_OVER"
+)
+
+// PriceBuckets are the buckets prices fall in, from the lowest to the highest
+var PriceBuckets = []PriceBucket{
+	PriceBucketUnder10,
+	PriceBucket10To25,
+	PriceBucket25To50,
+	PriceBucket50To100,
+	PriceBucket100AndOver,
+}
+
+// NewPriceBucket returns the bucket the price falls in, the lower bound of a bucket is
+// inclusive and the upper one is not
+func NewPriceBucket(price float32) PriceBucket {
+	switch {
+	case price < 10:
+		return PriceBucketUnder10
+	case price < 25:
+		return PriceBucket10To25
+	case price < 50:
+		return PriceBucket25To50
+	case price < 100:
+		return PriceBucket50To100
+	default:
+		return PriceBucket100AndOver
+	}
+}
+
+// Valid reports whether the bucket is one of the PriceBuckets
+func (bucket PriceBucket) Valid() bool {
+	for _, b := range PriceBuckets {
+		if b == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// WithProductIDs only returns the products with the provided ids
+func WithProductIDs(ids []uuid.UUID) WithGetProductsOptions {
+	return func(o *GetProductsOption) error {
+		if len(ids) == 0 {
+			return nil
+		}
+		o.IDs = ids
+		return nil
+	}
+}
+
+// SearchProducts finds the products that match the query, it is public so the storefront can
+// offer search
+func (g *Gateway) SearchProducts(ctx context.Context, query *SearchQuery) (*SearchPage, error) {
+	return g.services.SearchService.SearchProducts(ctx, query)
+}
+
+// reindex brings the product up to date in the search index after it was changed through
+// the gateway. The change itself was made, so an index that can't be updated is only logged;
+// it catches up the next time it is rebuilt.
+func (g *Gateway) reindex(ctx context.Context, id uuid.UUID) {
+	if err := g.services.SearchService.IndexProduct(ctx, id); err != nil {
+		g.Env.Log.Error(err.Error())
+	}
+}
@@ -0,0 +1,423 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/google/uuid"
+)
+
+//field a field of a product the index searches, a match counts for the weight of the field
+type field uint8
+
+const (
+	fieldname field = 1 << iota
+	fieldsku
+	fieldtag
+	fielddescription
+)
+
+//weights is how much a match in every field counts, a match in the name or the sku says
+//more about a product than one somewhere in its description
+var weights = map[field]float64{
+	fieldname:        3,
+	fieldsku:         3,
+	fieldtag:         2,
+	fielddescription: 1,
+}
+
+const (
+	//exact, prefix and typo are how much a term counts when it is the word of the query, when
+	//it starts with it and when it is only a typo or two away from it
+	exact  = 1
+	prefix = 0.75
+	typo   = 0.5
+)
+
+//document the product as the index knows it
+type document struct {
+	id   uuid.UUID
+	name string
+	//price is the lowest price the product sells at, the price of its cheapest variant when
+	//it has variants
+	price   float32
+	instock bool
+	//categories are the categories of the product along with every category above them
+	categories []uuid.UUID
+	//terms are the words of the product along with the fields they are found in
+	terms map[string]field
+}
+
+func newdocument(product *lib.Product, parents map[uuid.UUID]*uuid.UUID) *document {
+	doc := &document{
+		id:      product.ID,
+		name:    strings.ToLower(product.Name),
+		price:   product.Cost,
+		instock: product.Inventory > 0,
+		terms:   make(map[string]field),
+	}
+
+	if len(product.Variants) > 0 {
+		doc.price, doc.instock = 0, false
+
+		for i, variant := range product.Variants {
+			if price := variant.Price(product); i == 0 || price < doc.price {
+				doc.price = price
+			}
+			doc.instock = doc.instock || variant.Inventory > 0
+		}
+	}
+
+	add := func(f field, text string) {
+		for _, term := range tokenize(text) {
+			doc.terms[term] |= f
+		}
+	}
+
+	add(fieldname, product.Name)
+	add(fielddescription, product.Description)
+
+	for _, tag := range product.Tags {
+		add(fieldtag, tag.Name)
+	}
+
+	for _, variant := range product.Variants {
+		add(fieldsku, variant.SKU)
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	for _, category := range product.Categories {
+		for id := &category.ID; id != nil && !seen[*id]; id = parents[*id] {
+			seen[*id] = true
+			doc.categories = append(doc.categories, *id)
+		}
+	}
+
+	return doc
+}
+
+//index an inverted index of the products kept in memory, every replica keeps its own
+type index struct {
+	mu        sync.RWMutex
+	documents map[uuid.UUID]*document
+	//terms holds the documents every term is found in
+	terms map[string]map[uuid.UUID]field
+	//built is when the index was last built from scratch, it is zero until it first is
+	built time.Time
+}
+
+func newindex() *index {
+	return &index{
+		documents: make(map[uuid.UUID]*document),
+		terms:     make(map[string]map[uuid.UUID]field),
+	}
+}
+
+//replace swaps every document of the index for the provided ones
+func (ix *index) replace(docs []*document, built time.Time) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.documents = make(map[uuid.UUID]*document, len(docs))
+	ix.terms = make(map[string]map[uuid.UUID]field)
+
+	for _, doc := range docs {
+		ix.add(doc)
+	}
+
+	ix.built = built
+}
+
+//put adds the document to the index, taking the place of the previous one of the product
+func (ix *index) put(doc *document) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(doc.id)
+	ix.add(doc)
+}
+
+//delete takes the product out of the index
+func (ix *index) delete(id uuid.UUID) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(id)
+}
+
+//age returns how long ago the index was built, ok is false when it hasn't been built yet
+func (ix *index) age(now time.Time) (age time.Duration, ok bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return now.Sub(ix.built), !ix.built.IsZero()
+}
+
+func (ix *index) add(doc *document) {
+	ix.documents[doc.id] = doc
+
+	for term, f := range doc.terms {
+		if ix.terms[term] == nil {
+			ix.terms[term] = make(map[uuid.UUID]field)
+		}
+		ix.terms[term][doc.id] = f
+	}
+}
+
+func (ix *index) remove(id uuid.UUID) {
+	doc, ok := ix.documents[id]
+	if !ok {
+		return
+	}
+
+	for term := range doc.terms {
+		delete(ix.terms[term], id)
+		if len(ix.terms[term]) == 0 {
+			delete(ix.terms, term)
+		}
+	}
+
+	delete(ix.documents, id)
+}
+
+//hit a document that matched a search along with how well it did
+type hit struct {
+	doc   *document
+	score float64
+}
+
+//search returns the documents that match every word and every filter of the query, best
+//matches first, along with the facets of them. Without any words every document matches
+//and they are returned by name.
+func (ix *index) search(words []string, query *lib.SearchQuery) ([]*hit, *lib.SearchFacets) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var scores map[uuid.UUID]float64
+
+	if len(words) == 0 {
+		scores = make(map[uuid.UUID]float64, len(ix.documents))
+		for id := range ix.documents {
+			scores[id] = 0
+		}
+	}
+
+	for i, word := range words {
+		best := ix.match(word)
+
+		if i == 0 {
+			scores = best
+			continue
+		}
+
+		//a document has to match every word of the query
+		for id, score := range scores {
+			if s, ok := best[id]; ok {
+				scores[id] = score + s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	facets := &lib.SearchFacets{
+		Categories:   make(map[uuid.UUID]int64),
+		PriceBuckets: make(map[lib.PriceBucket]int64),
+	}
+
+	hits := make([]*hit, 0, len(scores))
+
+	for id, score := range scores {
+		doc := ix.documents[id]
+		if !filter(doc, query) {
+			continue
+		}
+
+		hits = append(hits, &hit{doc, score})
+
+		for _, category := range doc.categories {
+			facets.Categories[category]++
+		}
+
+		facets.PriceBuckets[lib.NewPriceBucket(doc.price)]++
+
+		if doc.instock {
+			facets.InStock++
+		} else {
+			facets.OutOfStock++
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.doc.name != b.doc.name {
+			return a.doc.name < b.doc.name
+		}
+		return a.doc.id.String() < b.doc.id.String()
+	})
+
+	return hits, facets
+}
+
+//match returns the score of every document the word matches, the best match of the word
+//in a document is the one that counts
+func (ix *index) match(word string) map[uuid.UUID]float64 {
+	best := make(map[uuid.UUID]float64)
+	allowed := tolerance(word)
+
+	for term, docs := range ix.terms {
+		var quality float64
+
+		switch {
+		case term == word:
+			quality = exact
+		case utf8.RuneCountInString(word) > 1 && strings.HasPrefix(term, word):
+			quality = prefix
+		case allowed > 0 && distance(word, term, allowed) <= allowed:
+			quality = typo
+		default:
+			continue
+		}
+
+		for id, fields := range docs {
+			for f, weight := range weights {
+				if fields&f != 0 && quality*weight > best[id] {
+					best[id] = quality * weight
+				}
+			}
+		}
+	}
+
+	return best
+}
+
+//filter reports whether the document matches the facets of the query
+func filter(doc *document, query *lib.SearchQuery) bool {
+	if query.CategoryID != nil {
+		found := false
+		for _, category := range doc.categories {
+			if category == *query.CategoryID {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if query.PriceBucket != "" && lib.NewPriceBucket(doc.price) != query.PriceBucket {
+		return false
+	}
+
+	if query.InStock != nil && doc.instock != *query.InStock {
+		return false
+	}
+
+	return true
+}
+
+//tokenize returns the lower cased words of the text, anything that isn't a letter or a
+//digit separates words
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	result := make([]string, 0, len(words))
+	seen := make(map[string]bool, len(words))
+
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			result = append(result, word)
+		}
+	}
+
+	return result
+}
+
+//tolerance is how many typos a word may have, short words have to be spelled right or
+//they would match nearly anything
+func tolerance(word string) int {
+	switch n := utf8.RuneCountInString(word); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+//distance returns the number of edits (insertions, deletions, substitutions and swaps of
+//adjacent letters) that turn a into b. It gives up once the distance exceeds max, in which
+//case any distance above max is returned.
+func distance(a, b string, max int) int {
+	s, t := []rune(a), []rune(b)
+
+	if d := len(s) - len(t); d > max || -d > max {
+		return max + 1
+	}
+
+	//rows keeps the last three rows of the matrix, the swap of adjacent letters looks two
+	//rows back
+	rows := [3][]int{
+		make([]int, len(t)+1),
+		make([]int, len(t)+1),
+		make([]int, len(t)+1),
+	}
+
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		prev2, prev, cur := rows[(i+1)%3], rows[(i+2)%3], rows[i%3]
+
+		cur[0] = i
+		lowest := cur[0]
+
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+
+			cur[j] = minimum(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				cur[j] = minimum(cur[j], prev2[j-2]+1)
+			}
+
+			if cur[j] < lowest {
+				lowest = cur[j]
+			}
+		}
+
+		if lowest > max {
+			return max + 1
+		}
+	}
+
+	return rows[len(s)%3][len(t)]
+}
+
+func minimum(values ...int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
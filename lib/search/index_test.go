@@ -0,0 +1,113 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"chocolate", "chip", "cookies", "12"}, tokenize("Chocolate-Chip COOKIES (12), chocolate"))
+	assert.Empty(t, tokenize(" -- "))
+}
+
+func TestDistance(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"cookie", "cookie", 0},
+		{"cookie", "cookies", 1},
+		{"cookie", "cokie", 1},
+		{"cookie", "cookei", 1},
+		{"cookie", "cooking", 2},
+	} {
+		assert.Equal(t, c.expected, distance(c.a, c.b, 3), c.a+" "+c.b)
+	}
+
+	//it gives up as soon as the distance is out of reach
+	assert.Equal(t, 2, distance("cookie", "biscuit", 1))
+	assert.Equal(t, 2, distance("cookie", "cookie jar", 1))
+}
+
+func TestSearch(t *testing.T) {
+	cookies, chocolate := uuid.New(), uuid.New()
+
+	parents := map[uuid.UUID]*uuid.UUID{
+		cookies:   nil,
+		chocolate: &cookies,
+	}
+
+	oatmeal := &lib.Product{
+		Name:        "Oatmeal cookies",
+		Description: "A dozen chewy cookies",
+		Cost:        12,
+		Inventory:   3,
+	}
+	oatmeal.ID = uuid.New()
+
+	chip := &lib.Product{
+		Name:        "Chocolate chip cookies",
+		Description: "Baked with dark chocolate",
+		Cost:        8,
+		Categories:  []*lib.Category{{}},
+		Tags:        []*lib.Tag{{Name: "bestseller"}},
+	}
+	chip.ID = uuid.New()
+	chip.Categories[0].ID = chocolate
+
+	cost := float32(30)
+	box := &lib.Product{
+		Name: "Gift box",
+		Cost: 5,
+		Variants: []*lib.ProductVariant{
+			{SKU: "BOX-COOKIE-L", Cost: &cost},
+			{SKU: "BOX-COOKIE-S", Inventory: 1},
+		},
+	}
+	box.ID = uuid.New()
+
+	ix := newindex()
+	for _, product := range []*lib.Product{oatmeal, chip, box} {
+		ix.put(newdocument(product, parents))
+	}
+
+	ids := func(hits []*hit) (result []uuid.UUID) {
+		for _, hit := range hits {
+			result = append(result, hit.doc.id)
+		}
+		return
+	}
+
+	//a match in the name ranks above one in the sku, both of them above a typo
+	hits, facets := ix.search(tokenize("cookies"), new(lib.SearchQuery))
+	assert.Equal(t, []uuid.UUID{chip.ID, oatmeal.ID, box.ID}, ids(hits))
+	assert.Equal(t, int64(2), facets.InStock)
+	assert.Equal(t, int64(1), facets.OutOfStock)
+	assert.Equal(t, int64(1), facets.Categories[cookies])
+	assert.Equal(t, int64(2), facets.PriceBuckets[lib.PriceBucketUnder10])
+	assert.Equal(t, int64(1), facets.PriceBuckets[lib.PriceBucket10To25])
+
+	hits, _ = ix.search(tokenize("chocolat cokies"), new(lib.SearchQuery))
+	assert.Equal(t, []uuid.UUID{chip.ID}, ids(hits))
+
+	hits, _ = ix.search(tokenize("box-cookie"), new(lib.SearchQuery))
+	assert.Equal(t, []uuid.UUID{box.ID}, ids(hits))
+
+	hits, _ = ix.search(tokenize("bestsel"), new(lib.SearchQuery))
+	assert.Equal(t, []uuid.UUID{chip.ID}, ids(hits))
+
+	//the products of a category include the ones of the categories below it
+	hits, _ = ix.search(nil, &lib.SearchQuery{CategoryID: &cookies})
+	assert.Equal(t, []uuid.UUID{chip.ID}, ids(hits))
+
+	instock := true
+	hits, _ = ix.search(nil, &lib.SearchQuery{InStock: &instock, PriceBucket: lib.PriceBucketUnder10})
+	assert.Equal(t, []uuid.UUID{box.ID}, ids(hits))
+
+	ix.delete(chip.ID)
+	hits, _ = ix.search(tokenize("chocolate"), new(lib.SearchQuery))
+	assert.Empty(t, hits)
+}
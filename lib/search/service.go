@@ -0,0 +1,272 @@
+package search
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	//defaultlimit is the size of a page of products when no limit is provided
+	defaultlimit = 20
+
+	//maxlimit is the largest page of products that is returned at once
+	maxlimit = 100
+
+	//maxage is how long the index is used before it is built from scratch again. Products
+	//are indexed as they are changed through the gateway of the replica, the rebuild picks up
+	//the changes made through other replicas and the stock that was sold at checkout.
+	maxage = 5 * time.Minute
+)
+
+//Service the search service, it keeps an index of the products in memory so products can be
+//searched without an external search service
+type Service struct {
+	*lib.Env
+	products lib.ProductService
+	repo     repoi
+	index    *index
+	//rebuilding keeps the index from being rebuilt by several searches at once
+	rebuilding sync.Mutex
+}
+
+//NewService returns a new search service, the products are read through the provided
+//product service
+func NewService(env *lib.Env, products lib.ProductService) (lib.SearchService, error) {
+	if products == nil {
+		return nil, errors.ErrNoProductService
+	}
+
+	return &Service{
+		Env:      env,
+		products: products,
+		repo: &repo{
+			env.GormDB,
+		},
+		index: newindex(),
+	}, nil
+}
+
+//SearchProducts returns a page of the products that match the query, best matches first. The
+//index is built on the first search and whenever it is older than maxage.
+func (s *Service) SearchProducts(ctx context.Context, query *lib.SearchQuery) (*lib.SearchPage, error) {
+	if query == nil {
+		query = new(lib.SearchQuery)
+	}
+
+	if query.Limit < 0 || (query.PriceBucket != "" && !query.PriceBucket.Valid()) {
+		return nil, &errors.ErrInvalidSearchPage{
+			Limit:       query.Limit,
+			PriceBucket: string(query.PriceBucket),
+		}
+	}
+
+	limit := query.Limit
+	if limit == 0 {
+		limit = defaultlimit
+	}
+
+	if limit > maxlimit {
+		limit = maxlimit
+	}
+
+	words := tokenize(query.Query)
+
+	offset := 0
+	if query.Cursor != "" {
+		var err error
+		if offset, err = decodecursor(words, query.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.fresh(ctx); err != nil {
+		return nil, err
+	}
+
+	hits, facets := s.index.search(words, query)
+
+	page := &lib.SearchPage{
+		Products: make([]*lib.Product, 0),
+		Total:    int64(len(hits)),
+		Facets:   facets,
+	}
+
+	if offset >= len(hits) {
+		return page, nil
+	}
+
+	end := offset + limit
+	if end < len(hits) {
+		page.NextCursor = encodecursor(words, end)
+	} else {
+		end = len(hits)
+	}
+
+	ids := make([]uuid.UUID, 0, end-offset)
+	for _, hit := range hits[offset:end] {
+		ids = append(ids, hit.doc.id)
+	}
+
+	products, err := s.products.GetProducts(ctx, lib.WithProductIDs(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	byid := make(map[uuid.UUID]*lib.Product, len(products))
+	for _, product := range products {
+		byid[product.ID] = product
+	}
+
+	//products that were deleted since the index was built are left out of the page
+	for _, id := range ids {
+		if product, ok := byid[id]; ok {
+			page.Products = append(page.Products, product)
+		}
+	}
+
+	return page, nil
+}
+
+//IndexProduct reads the product again and puts it in the index, a product that was deleted
+//is taken out of it
+func (s *Service) IndexProduct(ctx context.Context, id uuid.UUID) error {
+	product, err := s.products.GetProduct(ctx, lib.WithProductID(id), lib.WithProductCatalog())
+	if _, ok := err.(*errors.ErrNoProductFound); ok {
+		s.index.delete(id)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	parents, err := s.parents(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.index.put(newdocument(product, parents))
+	return nil
+}
+
+//RemoveProduct takes the product out of the index
+func (s *Service) RemoveProduct(ctx context.Context, id uuid.UUID) error {
+	s.index.delete(id)
+	return nil
+}
+
+//Rebuild reads every product and replaces the index with them
+func (s *Service) Rebuild(ctx context.Context) error {
+	s.rebuilding.Lock()
+	defer s.rebuilding.Unlock()
+
+	return s.rebuild(ctx)
+}
+
+//fresh rebuilds the index when it wasn't built yet or has grown too old, searches that wait
+//on a rebuild that was already under way use its result
+func (s *Service) fresh(ctx context.Context) error {
+	if age, ok := s.index.age(time.Now()); ok && age < maxage {
+		return nil
+	}
+
+	s.rebuilding.Lock()
+	defer s.rebuilding.Unlock()
+
+	if age, ok := s.index.age(time.Now()); ok && age < maxage {
+		return nil
+	}
+
+	return s.rebuild(ctx)
+}
+
+func (s *Service) rebuild(ctx context.Context) error {
+	built := time.Now()
+
+	products, err := s.products.GetProducts(ctx, lib.WithProductCatalog())
+	if err != nil {
+		return err
+	}
+
+	parents, err := s.parents(ctx)
+	if err != nil {
+		return err
+	}
+
+	docs := make([]*document, 0, len(products))
+	for _, product := range products {
+		docs = append(docs, newdocument(product, parents))
+	}
+
+	s.index.replace(docs, built)
+	return nil
+}
+
+//parents returns the parent of every category, categories at the top of the tree don't have
+//one
+func (s *Service) parents(ctx context.Context) (map[uuid.UUID]*uuid.UUID, error) {
+	categories, err := s.repo.GetCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make(map[uuid.UUID]*uuid.UUID, len(categories))
+	for _, category := range categories {
+		parents[category.ID] = category.ParentID
+	}
+
+	return parents, nil
+}
+
+//cursor is where the next page of a search starts, it only continues the search it was
+//returned by
+type cursor struct {
+	Query  string `json:"q"`
+	Offset int    `json:"o"`
+}
+
+func encodecursor(words []string, offset int) string {
+	raw, _ := json.Marshal(&cursor{
+		Query:  strings.Join(words, " "),
+		Offset: offset,
+	})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodecursor(words []string, value string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return 0, errors.ErrInvalidSearchCursor
+	}
+
+	c := new(cursor)
+	if err := json.Unmarshal(raw, c); err != nil || c.Offset < 0 || c.Query != strings.Join(words, " ") {
+		return 0, errors.ErrInvalidSearchCursor
+	}
+
+	return c.Offset, nil
+}
+
+type repoi interface {
+	GetCategories(ctx context.Context) ([]*lib.Category, error)
+}
+
+type repo struct {
+	*gorm.DB
+}
+
+//GetCategories returns every category with only its id and parent
+func (r *repo) GetCategories(ctx context.Context) (categories []*lib.Category, err error) {
+	categories = make([]*lib.Category, 0)
+	err = r.DB.WithContext(ctx).Select("id", "parent_id").Find(&categories).Error
+	return
+}
@@ -0,0 +1,94 @@
+package search_test
+
+import (
+	"context"
+	"testing"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/cryptnode-software/pisces/lib"
+	perrors "github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/cryptnode-software/pisces/lib/product"
+	"github.com/cryptnode-software/pisces/lib/search"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	env = lib.NewEnv(
+		commons.NewLogger(commons.EnvDev),
+	)
+
+	products, _ = product.NewService(env)
+
+	service, err = search.NewService(env, products)
+
+	ctx = context.Background()
+)
+
+func TestSearchProducts(t *testing.T) {
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//the word keeps the products apart from the ones of other runs
+	word := "zq" + uuid.New().String()[:8]
+
+	created := make([]*lib.Product, 0)
+	for _, name := range []string{"Shortbread", "Snickerdoodle", "Gingersnap"} {
+		p, err := products.SaveProduct(ctx, &lib.Product{
+			Name:        name + " " + word,
+			Description: "Freshly baked cookies",
+			Cost:        4,
+			Inventory:   1,
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer env.GormDB.Unscoped().Delete(p)
+		created = append(created, p)
+	}
+
+	if !assert.Nil(t, service.Rebuild(ctx)) {
+		return
+	}
+
+	page, err := service.SearchProducts(ctx, &lib.SearchQuery{Query: word, Limit: 2})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, int64(3), page.Total)
+	assert.Equal(t, int64(3), page.Facets.PriceBuckets[lib.PriceBucketUnder10])
+	if assert.Len(t, page.Products, 2) {
+		assert.Equal(t, created[2].ID, page.Products[0].ID)
+		assert.Equal(t, created[0].ID, page.Products[1].ID)
+	}
+
+	cursor := page.NextCursor
+
+	next, err := service.SearchProducts(ctx, &lib.SearchQuery{Query: word, Limit: 2, Cursor: cursor})
+	if assert.Nil(t, err) && assert.Len(t, next.Products, 1) {
+		assert.Equal(t, created[1].ID, next.Products[0].ID)
+		assert.Empty(t, next.NextCursor)
+	}
+
+	//typos are tolerated, and the product is out of the index once it is deleted
+	page, err = service.SearchProducts(ctx, &lib.SearchQuery{Query: "snikerdoodle " + word})
+	if assert.Nil(t, err) && assert.Len(t, page.Products, 1) {
+		assert.Equal(t, created[1].ID, page.Products[0].ID)
+	}
+
+	assert.Nil(t, products.DeleteProduct(ctx, created[1], nil))
+	assert.Nil(t, service.IndexProduct(ctx, created[1].ID))
+
+	page, err = service.SearchProducts(ctx, &lib.SearchQuery{Query: "snickerdoodle " + word})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), page.Total)
+
+	_, err = service.SearchProducts(ctx, &lib.SearchQuery{Query: "shortbread", Cursor: cursor})
+	assert.Equal(t, perrors.ErrInvalidSearchCursor, err)
+
+	_, err = service.SearchProducts(ctx, &lib.SearchQuery{Limit: -1, PriceBucket: "CHEAP"})
+	assert.Equal(t, &perrors.ErrInvalidSearchPage{Limit: -1, PriceBucket: "CHEAP"}, err)
+}
@@ -6,11 +6,28 @@ import (
 
 //Services ...
 type Services struct {
-	ProductService ProductService
-	UploadService  UploadService
-	PaypalService  PaypalService
-	OrderService   OrderService
-	AuthService    AuthService
-	CartService    CartService
-	S3Client       *s3.Client
+	ProductService  ProductService
+	UploadService   UploadService
+	PaypalService   PaypalService
+	OrderService    OrderService
+	AuthService     AuthService
+	CartService     CartService
+	CheckoutService CheckoutService
+	APIKeyService   APIKeyService
+	AuditService    AuditService
+	//IdempotencyStore keeps the results of requests made with an idempotency key
+	IdempotencyStore IdempotencyStore
+	SchedulerService SchedulerService
+	CatalogService   CatalogService
+	MediaService     MediaService
+	SearchService    SearchService
+	BulkService      BulkService
+	PricingService   PricingService
+	InventoryService InventoryService
+	//AccessNotifier sends guests the access tokens of what they placed
+	AccessNotifier AccessNotifier
+	Bucket         Bucket
+	//OIDCService is optional and only set when single sign-on is configured
+	OIDCService OIDCService
+	S3Client    *s3.Client
 }
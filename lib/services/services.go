@@ -6,22 +6,58 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/cryptnode-software/pisces/lib"
+	"github.com/cryptnode-software/pisces/lib/apikey"
+	"github.com/cryptnode-software/pisces/lib/audit"
 	"github.com/cryptnode-software/pisces/lib/auth"
+	"github.com/cryptnode-software/pisces/lib/bulk"
 	"github.com/cryptnode-software/pisces/lib/cart"
+	"github.com/cryptnode-software/pisces/lib/catalog"
+	"github.com/cryptnode-software/pisces/lib/checkout"
+	"github.com/cryptnode-software/pisces/lib/idempotency"
+	"github.com/cryptnode-software/pisces/lib/inventory"
+	"github.com/cryptnode-software/pisces/lib/media"
+	"github.com/cryptnode-software/pisces/lib/oidc"
 	"github.com/cryptnode-software/pisces/lib/orders"
 	"github.com/cryptnode-software/pisces/lib/paypal"
+	"github.com/cryptnode-software/pisces/lib/pricing"
 	"github.com/cryptnode-software/pisces/lib/product"
+	"github.com/cryptnode-software/pisces/lib/scheduler"
+	"github.com/cryptnode-software/pisces/lib/search"
 )
 
 func New(env *lib.Env) (services *lib.Services) {
-	return &lib.Services{
-		ProductService: productservice(env),
-		PaypalService:  paypalservice(env),
-		OrderService:   orderservice(env),
-		CartService:    cartservice(env),
-		AuthService:    authservice(env),
-		S3Client:       s3client(env),
+	//every other service records its mutations through the audit
+	//service of the env, so it has to be initialized first
+	env.AuditService = auditservice(env)
+
+	services = &lib.Services{
+		AuditService:     env.AuditService,
+		ProductService:   productservice(env),
+		PaypalService:    paypalservice(env),
+		OrderService:     orderservice(env),
+		CartService:      cartservice(env),
+		AuthService:      authservice(env),
+		APIKeyService:    apikeyservice(env),
+		IdempotencyStore: idempotencystore(env),
+		SchedulerService: schedulerservice(env),
+		CatalogService:   catalogservice(env),
+		BulkService:      bulkservice(env),
+		PricingService:   pricingservice(env),
+		InventoryService: inventoryservice(env),
+		AccessNotifier:   orders.NewNotifier(env),
+		S3Client:         s3client(env),
 	}
+
+	services.CheckoutService = checkoutservice(env, services.PaypalService)
+	services.Bucket = media.NewBucket(env, services.S3Client)
+	services.MediaService = mediaservice(env, services.Bucket)
+	services.SearchService = searchservice(env, services.ProductService)
+
+	if env.OIDCEnv != nil {
+		services.OIDCService = oidcservice(env, services.AuthService)
+	}
+
+	return
 }
 
 //NewPaypalService returns a service that satisfies the clib.PaypalService interface
@@ -69,6 +105,118 @@ func cartservice(env *lib.Env) lib.CartService {
 	return service
 }
 
+//NewCheckoutService returns a service that satisfies the lib.CheckoutService interface
+func checkoutservice(env *lib.Env, paypal lib.PaypalService) lib.CheckoutService {
+	service, err := checkout.NewService(env, paypal)
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
+//NewAPIKeyService returns a service that satisfies the lib.APIKeyService interface
+func apikeyservice(env *lib.Env) lib.APIKeyService {
+	service, err := apikey.NewService(env)
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
+//NewOIDCService returns a service that satisfies the lib.OIDCService interface
+func oidcservice(env *lib.Env, auth lib.AuthService) lib.OIDCService {
+	service, err := oidc.NewService(env, auth)
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
+//NewAuditService returns a service that satisfies the lib.AuditService interface
+func auditservice(env *lib.Env) lib.AuditService {
+	service, err := audit.NewService(env)
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
+//NewIdempotencyStore returns the lib.IdempotencyStore that was configured for the env
+func idempotencystore(env *lib.Env) lib.IdempotencyStore {
+	if env.IdempotencyStore == lib.IdempotencyStoreMemory {
+		return idempotency.NewMemoryStore()
+	}
+
+	store, err := idempotency.NewStore(env)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+//NewSchedulerService returns a service that satisfies the lib.SchedulerService interface
+func schedulerservice(env *lib.Env) lib.SchedulerService {
+	service, err := scheduler.NewService(env)
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
+//NewCatalogService returns a service that satisfies the lib.CatalogService interface
+func catalogservice(env *lib.Env) lib.CatalogService {
+	service, err := catalog.NewService(env)
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
+//NewMediaService returns a service that satisfies the lib.MediaService interface
+func mediaservice(env *lib.Env, bucket lib.Bucket) lib.MediaService {
+	service, err := media.NewService(env, bucket)
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
+//NewSearchService returns a service that satisfies the lib.SearchService interface
+func searchservice(env *lib.Env, products lib.ProductService) lib.SearchService {
+	service, err := search.NewService(env, products)
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
+//NewBulkService returns a service that satisfies the lib.BulkService interface
+func bulkservice(env *lib.Env) lib.BulkService {
+	service, err := bulk.NewService(env)
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
+//NewPricingService returns a service that satisfies the lib.PricingService interface
+func pricingservice(env *lib.Env) lib.PricingService {
+	service, err := pricing.NewService(env)
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
+//NewInventoryService returns a service that satisfies the lib.InventoryService interface
+func inventoryservice(env *lib.Env) lib.InventoryService {
+	service, err := inventory.NewService(env, inventory.NewNotifier(env))
+	if err != nil {
+		panic(err)
+	}
+	return service
+}
+
 func s3client(env *lib.Env) (client *s3.Client) {
 	client = s3.NewFromConfig(aws.Config{
 		Region: env.AWSEnv.Region,
@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"context"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+)
+
+// GetDeletedProducts returns the products in the trash, the most recently deleted first
+func (g *Gateway) GetDeletedProducts(ctx context.Context) ([]*Product, error) {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return nil, err
+	}
+
+	return g.services.ProductService.GetDeletedProducts(ctx, time.Now())
+}
+
+// RestoreProduct takes the product out of the trash and puts it back in search
+func (g *Gateway) RestoreProduct(ctx context.Context, id uuid.UUID) (*Product, error) {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return nil, err
+	}
+
+	product, err := g.services.ProductService.RestoreProduct(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	g.reindex(ctx, product.ID)
+	return product, nil
+}
+
+// PurgeDeletedProducts deletes the products that have been in the trash for longer than the
+// retention of the env for good, it is run by the scheduler. Products that cart lines still
+// reference are left in the trash.
+func (g *Gateway) PurgeDeletedProducts(ctx context.Context) (int64, error) {
+	products, err := g.services.ProductService.GetDeletedProducts(ctx, time.Now().Add(-g.Env.ProductRetention))
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int64
+
+	for _, product := range products {
+		err := g.purge(ctx, product)
+
+		if _, ok := err.(*errors.ErrProductInUse); ok {
+			continue
+		}
+
+		if err != nil {
+			return purged, err
+		}
+
+		purged++
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,396 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/google/uuid"
+	proto "go.buf.build/grpc/go/thenewlebowski/pisces/general/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Rule checks a single value of a request, it returns why the value is invalid or nothing when
+// it is valid. Only Required rejects values that weren't provided, every other rule lets them
+// through so optional fields are only checked when they are set.
+type Rule func(value interface{}) string
+
+// validations is the single place the rules of every rpc are declared, they are checked by
+// ValidateInterceptor before the handler runs. Rpcs that take nothing worth checking aren't
+// registered.
+var validations = map[string]func(v *validator, req interface{}){
+	method("Login"): func(v *validator, req interface{}) {
+		if r, ok := req.(*proto.LoginRequest); ok {
+			v.check("username", r.Username, Required(), MaxLength(255))
+			v.check("password", r.Password, Required(), MaxLength(255))
+		}
+	},
+	method("CheckJWT"): func(v *validator, req interface{}) {
+		if r, ok := req.(*proto.JWT); ok {
+			v.check("jwt", r.Jwt, Required())
+		}
+	},
+	method("SaveInquiry"): func(v *validator, req interface{}) {
+		if r, ok := req.(*proto.Inquiry); ok {
+			validateinquiry(v, "", r)
+		}
+	},
+	method("GetInquires"): func(v *validator, req interface{}) {
+		if r, ok := req.(*proto.GetInquiresRequest); ok {
+			v.check("inquiry_id", r.InquiryId, UUID())
+		}
+	},
+	method("SaveOrder"): func(v *validator, req interface{}) {
+		if r, ok := req.(*proto.SaveOrderRequest); ok {
+			validateorder(v, "order", r.Order)
+		}
+	},
+	method("GetOrders"): func(v *validator, req interface{}) {
+		if r, ok := req.(*proto.GetOrdersRequest); ok {
+			v.check("order_id", r.OrderId, UUID())
+			v.check("status", r.Status, orderstatuses)
+		}
+	},
+	method("SaveCart"): func(v *validator, req interface{}) {
+		if r, ok := req.(*proto.SaveCartRequest); ok {
+			v.check("cart", r.Cart, Required())
+			validatecart(v, "cart", r.Cart)
+		}
+	},
+	method("GetProducts"): func(v *validator, req interface{}) {
+		if r, ok := req.(*proto.GetProductsRequest); ok {
+			v.check("id", r.Id, UUID())
+			v.check("name", r.Name, MaxLength(255))
+
+			if r.SortBy != nil {
+				v.check("sort_by.field_name", r.SortBy.FieldName, Required(), OneOf(
+					ProductSortName, ProductSortCost, ProductSortInventory, ProductSortCreatedAt, ProductSortPopularity,
+				))
+				v.check("sort_by.direction", r.SortBy.Direction, OneOf(proto.SortDirection_ASC, proto.SortDirection_DSC))
+			}
+		}
+	},
+	method("SaveProduct"): func(v *validator, req interface{}) {
+		if r, ok := req.(*proto.SaveProductRequest); ok {
+			if !v.check("product", r.Product, Required()) {
+				return
+			}
+
+			v.check("product.id", r.Product.Id, UUID())
+			v.check("product.name", r.Product.Name, Required(), MaxLength(255))
+			v.check("product.description", r.Product.Description, MaxLength(65535))
+			v.check("product.cost", r.Product.Cost, Min(0))
+			v.check("product.inventory", r.Product.Inventory, Min(0))
+		}
+	},
+	method("StartUpload"): func(v *validator, req interface{}) {
+		if r, ok := req.(*proto.StartUploadRequest); ok {
+			v.check("key", r.Key, Required(), MaxLength(255), Pattern(objectkey, "a file name made of letters, digits, '.', '-', '_' and '/'"), Excludes(".."))
+		}
+	},
+}
+
+var (
+	//objectkey is what the key of an upload may look like, it ends up in the key of the object
+	//in the bucket so it is kept to characters that don't need escaping
+	objectkey = regexp.MustCompile(`^[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)*$`)
+
+	//phone is what a phone number may look like once its separators are removed, an optional
+	//leading + and 7 to 15 digits
+	phone = regexp.MustCompile(`^\+?[0-9]{7,15}$`)
+
+	orderstatuses = OneOf(
+		proto.OrderStatus_NotImplemented, proto.OrderStatus_AdminPending, proto.OrderStatus_UserPending, proto.OrderStatus_Accepted,
+	)
+)
+
+func validateinquiry(v *validator, prefix string, inquiry *proto.Inquiry) {
+	v.check(prefix+"id", inquiry.Id, UUID())
+	v.check(prefix+"email", inquiry.Email, Required(), MaxLength(255), Email())
+	v.check(prefix+"phone_number", inquiry.PhoneNumber, Phone())
+	v.check(prefix+"first_name", inquiry.FirstName, MaxLength(100))
+	v.check(prefix+"last_name", inquiry.LastName, MaxLength(100))
+	v.check(prefix+"body", inquiry.Body, MaxLength(5000))
+
+	for i, attachment := range inquiry.Attachments {
+		field := fmt.Sprintf("%sattachments[%d]", prefix, i)
+		if !v.check(field, attachment, Required()) {
+			continue
+		}
+
+		v.check(field+".url", attachment.Url, Required(), MaxLength(2048), URL())
+		v.check(field+".type", attachment.Type, OneOf(proto.AttachmentType_AttachmentTypeImage, proto.AttachmentType_AttachmentTypeFile))
+	}
+}
+
+func validateorder(v *validator, prefix string, order *proto.Order) {
+	if !v.check(prefix, order, Required()) {
+		return
+	}
+
+	prefix += "."
+
+	v.check(prefix+"id", order.Id, UUID())
+	v.check(prefix+"inquiry_id", order.InquiryId, UUID())
+	v.check(prefix+"status", order.Status, orderstatuses)
+	v.check(prefix+"payment_method", order.PaymentMethod, OneOf(proto.PaymentMethod_PaymentMethodNotImplemented, proto.PaymentMethod_PaymentMethodPaypal))
+	v.check(prefix+"total", order.Total, Min(0))
+
+	//orders that already exist keep the due date they were placed with, even once it passed
+	if order.Id == "" {
+		v.check(prefix+"due", order.Due, Required(), NotPast())
+	} else {
+		v.check(prefix+"due", order.Due, Required())
+	}
+
+	if order.InquiryId == "" {
+		v.check(prefix+"inquiry", order.Inquiry, Required())
+	}
+
+	if order.Inquiry != nil {
+		validateinquiry(v, prefix+"inquiry.", order.Inquiry)
+	}
+
+	validatecart(v, prefix+"cart", order.Cart)
+}
+
+func validatecart(v *validator, prefix string, cart []*proto.CartContents) {
+	for i, line := range cart {
+		field := fmt.Sprintf("%s[%d]", prefix, i)
+		if !v.check(field, line, Required()) {
+			continue
+		}
+
+		v.check(field+".id", line.Id, UUID())
+		v.check(field+".product_id", line.ProductId, Required(), UUID())
+		v.check(field+".order_id", line.OrderId, UUID())
+		v.check(field+".quantity", line.Quantity, Min(0), Max(MaxCartQuantity))
+	}
+}
+
+// ValidateInterceptor checks the request against the rules its rpc declares in validations
+// before the handler runs, every field that is invalid is returned at once as
+// ErrInvalidRequest
+func (g *Gateway) ValidateInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	validate, ok := validations[info.FullMethod]
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	v := &validator{
+		fields: make(map[string]string),
+	}
+
+	validate(v, req)
+
+	if len(v.fields) > 0 {
+		return nil, &errors.ErrInvalidRequest{
+			Fields: v.fields,
+		}
+	}
+
+	return handler(ctx, req)
+}
+
+// validator collects the violations of a request, only the first violation of every field is
+// kept
+type validator struct {
+	fields map[string]string
+}
+
+// check runs the rules against the value of the field in order, it stops at the first one the
+// value violates and reports whether the value is valid
+func (v *validator) check(field string, value interface{}, rules ...Rule) bool {
+	for _, rule := range rules {
+		if violation := rule(value); violation != "" {
+			v.fields[field] = violation
+			return false
+		}
+	}
+	return true
+}
+
+// empty reports whether the value wasn't provided, numbers and enums are always provided since
+// their zero value can't be told apart from one that was set
+func empty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	if str, ok := value.(string); ok {
+		return strings.TrimSpace(str) == ""
+	}
+
+	switch rv := reflect.ValueOf(value); rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	case reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	}
+
+	return false
+}
+
+// number returns the value as a float when it is a number
+func number(value interface{}) (float64, bool) {
+	switch rv := reflect.ValueOf(value); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// Required rejects values that weren't provided, blank strings included
+func Required() Rule {
+	return func(value interface{}) string {
+		if empty(value) {
+			return "is required"
+		}
+		return ""
+	}
+}
+
+// MaxLength rejects strings of more than max characters
+func MaxLength(max int) Rule {
+	return func(value interface{}) string {
+		if str, ok := value.(string); ok && utf8.RuneCountInString(str) > max {
+			return fmt.Sprintf("must be at most %d characters", max)
+		}
+		return ""
+	}
+}
+
+// Min rejects numbers below min
+func Min(min float64) Rule {
+	return func(value interface{}) string {
+		if n, ok := number(value); ok && n < min {
+			return fmt.Sprintf("must be at least %v", min)
+		}
+		return ""
+	}
+}
+
+// Max rejects numbers above max
+func Max(max float64) Rule {
+	return func(value interface{}) string {
+		if n, ok := number(value); ok && n > max {
+			return fmt.Sprintf("must be at most %v", max)
+		}
+		return ""
+	}
+}
+
+// OneOf rejects values that aren't one of the allowed values, i.e. enums that don't exist
+func OneOf(allowed ...interface{}) Rule {
+	return func(value interface{}) string {
+		if empty(value) {
+			return ""
+		}
+
+		for _, a := range allowed {
+			if value == a {
+				return ""
+			}
+		}
+
+		options := make([]string, len(allowed))
+		for i, a := range allowed {
+			options[i] = fmt.Sprint(a)
+		}
+
+		return "must be one of " + strings.Join(options, ", ")
+	}
+}
+
+// UUID rejects strings that aren't an id
+func UUID() Rule {
+	return func(value interface{}) string {
+		if str, ok := value.(string); ok && !empty(str) {
+			if _, err := uuid.Parse(str); err != nil {
+				return "must be a valid id"
+			}
+		}
+		return ""
+	}
+}
+
+// Email rejects strings that aren't a bare email address, i.e. "Name <name@example.com>"
+func Email() Rule {
+	return func(value interface{}) string {
+		if str, ok := value.(string); ok && !empty(str) {
+			if address, err := mail.ParseAddress(str); err != nil || address.Address != str {
+				return "must be a valid email address"
+			}
+		}
+		return ""
+	}
+}
+
+// Phone rejects strings that aren't a phone number, spaces, dashes, dots and parentheses are
+// allowed between the digits
+func Phone() Rule {
+	separators := strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "")
+
+	return func(value interface{}) string {
+		if str, ok := value.(string); ok && !empty(str) && !phone.MatchString(separators.Replace(str)) {
+			return "must be a valid phone number"
+		}
+		return ""
+	}
+}
+
+// URL rejects strings that aren't an absolute http or https url
+func URL() Rule {
+	return func(value interface{}) string {
+		if str, ok := value.(string); ok && !empty(str) {
+			u, err := url.Parse(str)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return "must be a valid url"
+			}
+		}
+		return ""
+	}
+}
+
+// Pattern rejects strings that don't match the expression, description says what they should
+// look like instead
+func Pattern(expression *regexp.Regexp, description string) Rule {
+	return func(value interface{}) string {
+		if str, ok := value.(string); ok && !empty(str) && !expression.MatchString(str) {
+			return "must be " + description
+		}
+		return ""
+	}
+}
+
+// Excludes rejects strings that contain the substring
+func Excludes(substring string) Rule {
+	return func(value interface{}) string {
+		if str, ok := value.(string); ok && strings.Contains(str, substring) {
+			return fmt.Sprintf("must not contain %q", substring)
+		}
+		return ""
+	}
+}
+
+// NotPast rejects timestamps that already passed
+func NotPast() Rule {
+	return func(value interface{}) string {
+		if ts, ok := value.(*timestamppb.Timestamp); ok && ts != nil && ts.AsTime().Before(time.Now()) {
+			return "must not be in the past"
+		}
+		return ""
+	}
+}
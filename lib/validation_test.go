@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cryptnode-software/pisces/lib/errors"
+	"github.com/stretchr/testify/assert"
+	proto "go.buf.build/grpc/go/thenewlebowski/pisces/general/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestValidations(t *testing.T) {
+	//only rpcs that exist can declare rules
+	for m := range validations {
+		_, ok := GetPolicy(m)
+		assert.True(t, ok, "rules declared for %s which has no policy", m)
+	}
+}
+
+func TestValidateInterceptor(t *testing.T) {
+	gateway := new(Gateway)
+	ctx := context.Background()
+
+	handled := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handled = true
+		return req, nil
+	}
+
+	//every violation is returned at once, the handler isn't reached
+	_, err := gateway.ValidateInterceptor(ctx, &proto.SaveOrderRequest{
+		Order: &proto.Order{
+			Due:   timestamppb.New(time.Now().Add(-time.Hour)),
+			Total: -1,
+			Cart: []*proto.CartContents{
+				{ProductId: "not an id", Quantity: MaxCartQuantity + 1},
+			},
+		},
+	}, &grpc.UnaryServerInfo{FullMethod: method("SaveOrder")}, handler)
+
+	invalid, ok := err.(*errors.ErrInvalidRequest)
+	if assert.True(t, ok) {
+		assert.Equal(t, map[string]string{
+			"order.due":                "must not be in the past",
+			"order.total":              "must be at least 0",
+			"order.inquiry":            "is required",
+			"order.cart[0].product_id": "must be a valid id",
+			"order.cart[0].quantity":   "must be at most 99",
+		}, invalid.Fields)
+	}
+	assert.False(t, handled)
+
+	_, err = gateway.ValidateInterceptor(ctx, &proto.Inquiry{
+		Email:       "guest@example.com",
+		PhoneNumber: "+1 (555) 010-0000",
+	}, &grpc.UnaryServerInfo{FullMethod: method("SaveInquiry")}, handler)
+	assert.Nil(t, err)
+	assert.True(t, handled)
+
+	//rpcs without rules are passed through
+	handled = false
+	_, err = gateway.ValidateInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method("GeneratePaypalClientToken")}, handler)
+	assert.Nil(t, err)
+	assert.True(t, handled)
+}
+
+func TestRules(t *testing.T) {
+	for _, table := range []struct {
+		rule    Rule
+		valid   []interface{}
+		invalid []interface{}
+	}{
+		{Required(), []interface{}{"a", 0, []int{1}}, []interface{}{"", "  ", nil, (*proto.Order)(nil), []int{}}},
+		{Email(), []interface{}{"", "name@example.com"}, []interface{}{"name", "Name <name@example.com>", "@example.com"}},
+		{Phone(), []interface{}{"", "+15550100000", "555-010-0000"}, []interface{}{"call me", "12345", "+1 555 010 0000 0000 0"}},
+		{MaxLength(3), []interface{}{"", "abc", "äöü"}, []interface{}{"abcd"}},
+		{Min(0), []interface{}{0, float32(0.5), int64(3)}, []interface{}{-1, float32(-0.01)}},
+		{UUID(), []interface{}{"", "3f0e9a52-5d6b-4a8c-9a7e-2b1f3c4d5e6f"}, []interface{}{"42"}},
+		{URL(), []interface{}{"", "https://example.com/a.png"}, []interface{}{"example.com/a.png", "ftp://example.com/a.png"}},
+		{OneOf(proto.SortDirection_ASC, proto.SortDirection_DSC), []interface{}{proto.SortDirection_DSC}, []interface{}{proto.SortDirection(7)}},
+		{Pattern(objectkey, "a key"), []interface{}{"images/cookie.png"}, []interface{}{"/etc/passwd", "a cookie.png", "images//a.png"}},
+	} {
+		for _, value := range table.valid {
+			assert.Empty(t, table.rule(value), "%#v", value)
+		}
+		for _, value := range table.invalid {
+			assert.NotEmpty(t, table.rule(value), "%#v", value)
+		}
+	}
+}
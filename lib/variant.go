@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	commons "github.com/cryptnode-software/commons/pkg"
+	"github.com/google/uuid"
+)
+
+// ProductOption an option the variants of a product are made of, i.e. "Size" with the values
+// "S", "M" and "L". Position orders the options of a product.
+type ProductOption struct {
+	ProductID uuid.UUID
+	Name      string
+	Values    OptionValues `gorm:"column:option_values"`
+	Position  int
+	commons.Model
+}
+
+// ProductVariant a combination of option values of a product that is sold and stocked on its
+// own, i.e. a medium red shirt. A variant without a cost of its own sells at the cost of its
+// product, the weight is in grams. A variant without a low stock threshold of its own uses the
+// one of its product.
+type ProductVariant struct {
+	ProductID         uuid.UUID
+	SKU               string
+	Options           VariantOptions `gorm:"column:option_values"`
+	Cost              *float32
+	Inventory         int
+	LowStockThreshold *int
+	Weight            float32
+	Position          int
+	commons.Model
+}
+
+// Price returns what a single unit of the variant costs, a nil variant costs as much as the
+// product does
+func (variant *ProductVariant) Price(product *Product) float32 {
+	if variant != nil && variant.Cost != nil {
+		return *variant.Cost
+	}
+
+	if product == nil {
+		return 0
+	}
+
+	return product.Cost
+}
+
+// OptionValues the values of an option, stored as a json array
+type OptionValues []string
+
+// Value implements driver.Valuer
+func (values OptionValues) Value() (driver.Value, error) {
+	if values == nil {
+		values = OptionValues{}
+	}
+	b, err := json.Marshal(values)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner
+func (values *OptionValues) Scan(src interface{}) error {
+	return scanjson(src, values)
+}
+
+// Contains reports whether the value is one of the values of the option
+func (values OptionValues) Contains(value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// VariantOptions the value a variant has for every option of its product, by the name of the
+// option, stored as a json object
+type VariantOptions map[string]string
+
+// Value implements driver.Valuer
+func (options VariantOptions) Value() (driver.Value, error) {
+	if options == nil {
+		options = VariantOptions{}
+	}
+	b, err := json.Marshal(options)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner
+func (options *VariantOptions) Scan(src interface{}) error {
+	return scanjson(src, options)
+}
+
+// Equal reports whether both hold the same value for the same options
+func (options VariantOptions) Equal(other VariantOptions) bool {
+	if len(options) != len(other) {
+		return false
+	}
+
+	for name, value := range options {
+		if v, ok := other[name]; !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func scanjson(src interface{}, dest interface{}) error {
+	switch value := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(value, dest)
+	case string:
+		return json.Unmarshal([]byte(value), dest)
+	default:
+		return fmt.Errorf("can't scan %T into %T", src, dest)
+	}
+}
+
+// SaveProductOption creates or updates an option of a product
+func (g *Gateway) SaveProductOption(ctx context.Context, option *ProductOption) (*ProductOption, error) {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return nil, err
+	}
+
+	return g.services.ProductService.SaveProductOption(ctx, option)
+}
+
+// DeleteProductOption deletes an option of a product that none of its variants use
+func (g *Gateway) DeleteProductOption(ctx context.Context, option *ProductOption) error {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return err
+	}
+
+	return g.services.ProductService.DeleteProductOption(ctx, option)
+}
+
+// SaveProductVariant creates or updates a variant of a product
+func (g *Gateway) SaveProductVariant(ctx context.Context, variant *ProductVariant) (*ProductVariant, error) {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return nil, err
+	}
+
+	result, err := g.services.ProductService.SaveProductVariant(ctx, variant)
+	if err != nil {
+		return nil, err
+	}
+
+	g.reindex(ctx, result.ProductID)
+	return result, nil
+}
+
+// DeleteProductVariant deletes a variant of a product, it can no longer be added to a cart
+func (g *Gateway) DeleteProductVariant(ctx context.Context, variant *ProductVariant) error {
+	if err := g.authorize(ctx, PermissionWriteProducts); err != nil {
+		return err
+	}
+
+	if err := g.services.ProductService.DeleteProductVariant(ctx, variant); err != nil {
+		return err
+	}
+
+	g.reindex(ctx, variant.ProductID)
+	return nil
+}
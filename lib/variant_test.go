@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVariantOptions(t *testing.T) {
+	options := VariantOptions{"Size": "M", "Color": "Red"}
+
+	value, err := options.Value()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	scanned := make(VariantOptions)
+	assert.Nil(t, scanned.Scan([]byte(value.(string))))
+	assert.True(t, options.Equal(scanned))
+
+	assert.False(t, options.Equal(VariantOptions{"Size": "M"}))
+	assert.False(t, options.Equal(VariantOptions{"Size": "M", "Color": "Blue"}))
+
+	value, err = VariantOptions(nil).Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", value)
+
+	assert.NotNil(t, scanned.Scan(42))
+}
+
+func TestOptionValues(t *testing.T) {
+	var values OptionValues
+	assert.Nil(t, values.Scan(`["S","M","L"]`))
+	assert.Equal(t, OptionValues{"S", "M", "L"}, values)
+
+	assert.True(t, values.Contains("M"))
+	assert.False(t, values.Contains("XL"))
+
+	value, err := OptionValues(nil).Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "[]", value)
+}
+
+func TestProductVariantPrice(t *testing.T) {
+	product := &Product{Cost: 20}
+	cost := float32(25)
+
+	assert.Equal(t, float32(25), (&ProductVariant{Cost: &cost}).Price(product))
+	assert.Equal(t, float32(20), (&ProductVariant{}).Price(product))
+
+	var variant *ProductVariant
+	assert.Equal(t, float32(20), variant.Price(product))
+	assert.Equal(t, float32(0), variant.Price(nil))
+
+	line := &Cart{Product: product, Quantity: 2}
+	assert.Equal(t, float32(20), line.UnitCost())
+
+	line.Variant = &ProductVariant{Cost: &cost}
+	assert.Equal(t, float32(25), line.UnitCost())
+}